@@ -0,0 +1,44 @@
+package trimark
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// defaultAlphaBackground is the colour transparency is flattened onto unless AlphaBackgroundEnv sets one
+var defaultAlphaBackground = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+
+// parseHexColor reads an opaque colour written as #RRGGBB, the # is optional
+func parseHexColor(v string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(v), "#")
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("must be a colour such as #FFFFFF, got %q", v)
+	}
+	return color.RGBA{R: uint8(n >> 16), G: uint8(n >> 8), B: uint8(n), A: 0xff}, nil
+}
+
+// hasAlpha reports whether an image may have transparent pixels
+func hasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	return true
+}
+
+// flattenAlpha draws an image with transparency over an opaque background, as transparent
+// areas OCR unpredictably. Opaque images are returned as they are.
+func flattenAlpha(img image.Image, background color.Color) image.Image {
+	if !hasAlpha(img) {
+		return img
+	}
+	b := img.Bounds()
+	flat := image.NewRGBA(b)
+	draw.Draw(flat, b, &image.Uniform{C: background}, image.Point{}, draw.Src)
+	draw.Draw(flat, b, img, b.Min, draw.Over)
+	return flat
+}
@@ -0,0 +1,71 @@
+package trimark
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    color.RGBA
+		wantErr bool
+	}{
+		{v: "#FFFFFF", want: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+		{v: "102030", want: color.RGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff}},
+		{v: " #0a0B0c ", want: color.RGBA{R: 0x0a, G: 0x0b, B: 0x0c, A: 0xff}},
+		{v: "#FFF", wantErr: true},
+		{v: "#FFFFFFFF", wantErr: true},
+		{v: "#GGGGGG", wantErr: true},
+		{v: "white", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseHexColor(tt.v)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseHexColor(%q) error = %v, wantErr %v", tt.v, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseHexColor(%q) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestFlattenAlpha(t *testing.T) {
+	// A screenshot with a transparent half and a half-transparent black pixel
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff})
+	}
+	img.SetNRGBA(0, 1, color.NRGBA{A: 0x80})
+	if !hasAlpha(img) {
+		t.Fatal("hasAlpha = false for a transparent image")
+	}
+
+	flat := flattenAlpha(img, defaultAlphaBackground)
+	if hasAlpha(flat) {
+		t.Error("Flattened image still has transparency")
+	}
+	tests := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, color.RGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff}},
+		{1, 1, defaultAlphaBackground},
+		{0, 1, color.RGBA{R: 0x7f, G: 0x7f, B: 0x7f, A: 0xff}},
+	}
+	for _, tt := range tests {
+		if got := color.RGBAModel.Convert(flat.At(tt.x, tt.y)).(color.RGBA); got != tt.want {
+			t.Errorf("Pixel (%d, %d) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	opaque := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = 0xff
+	}
+	if got := flattenAlpha(opaque, defaultAlphaBackground); got != image.Image(opaque) {
+		t.Error("An opaque image was redrawn")
+	}
+}
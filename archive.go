@@ -0,0 +1,179 @@
+package trimark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// ErrArchiveExists is returned when the archive spreadsheet for a year already exists
+var ErrArchiveExists = errors.New("Archive already exists")
+
+// ErrArchiveMonthlySheets is returned with MonthlySheetsEnv, where each month is already a spreadsheet of its own
+var ErrArchiveMonthlySheets = errors.New("Reports are already split by month")
+
+// ArchiveCurrentYear moves the rows with an Echoes date in the given year into a new
+// "ISK Import Report YYYY" spreadsheet in the Report folder. Rows are only deleted
+// from the report once the archive has been written.
+func ArchiveCurrentYear(ctx context.Context, year int) error {
+	Initialize()
+
+	if config.MonthlySheets {
+		return ErrArchiveMonthlySheets
+	}
+
+	folders := foldersFrom(ctx)
+	name := fmt.Sprintf("%s %d", SheetName, year)
+	_, err := GetFileByNameInFolder(ctx, name, folders.Report)
+	if err == nil {
+		return ErrArchiveExists
+	}
+	if err != ErrNotFound {
+		return err
+	}
+
+	// Rows are copied as displayed, so the archive reads like the report, but are dated by
+	// their stored values
+	vr, err := sheetService.Spreadsheets.Values.Get(folders.Sheet, "Sheet1").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	stored, err := readStoredValues(ctx, folders.Sheet, "Sheet1")
+	if err != nil {
+		return err
+	}
+	if len(stored) != len(vr.Values) {
+		return fmt.Errorf("%s changed while it was being read, try again", SheetName)
+	}
+	header := int(headerRowIndex())
+	if len(vr.Values) < header+2 {
+		log.Printf("Nothing to archive for %d", year)
+		return nil
+	}
+
+	archived := [][]interface{}{vr.Values[header]}
+	var rows []int64
+	for i, row := range vr.Values[header+1:] {
+		if !isSummaryRow(row) && parseRecord(stored[header+1+i]).EchoesDate.Year() == year {
+			archived = append(archived, row)
+			// Zero-indexed sheet row, after the header
+			rows = append(rows, int64(header+1+i))
+		}
+	}
+	if len(rows) == 0 {
+		log.Printf("Nothing to archive for %d", year)
+		return nil
+	}
+
+	file, err := createSheet(name, folders.Report)
+	if err != nil {
+		return fmt.Errorf("Unable to create %s: %v", name, err)
+	}
+	valueRange := &sheets.ValueRange{Values: archived}
+	_, err = sheetService.Spreadsheets.Values.Update(file.Id, "Sheet1!A1", valueRange).ValueInputOption("USER_ENTERED").Context(ctx).Do()
+	if err != nil {
+		// An empty archive left behind would make every retry fail with ErrArchiveExists
+		if delErr := driveService.Files.Delete(file.Id).Context(ctx).Do(); delErr != nil {
+			log.Printf("ERROR: unable to delete the empty %s, delete it before archiving %d again: %v", name, year, delErr)
+		}
+		return fmt.Errorf("Unable to write %s: %v", name, err)
+	}
+
+	tabID, err := sheetTabID(ctx, folders.Sheet, "Sheet1")
+	if err != nil {
+		return err
+	}
+	_, err = sheetService.Spreadsheets.BatchUpdate(folders.Sheet, deleteRowsRequest(tabID, rows)).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Archived %d rows to %s but couldn't delete them from %s: %v", len(rows), name, SheetName, err)
+	}
+
+	log.Printf("Archived %d rows to %s", len(rows), name)
+	return nil
+}
+
+// deleteRowsRequest deletes the zero-indexed rows, grouping contiguous rows and working
+// from the bottom up so earlier deletions don't shift later ones
+func deleteRowsRequest(tabID int64, rows []int64) *sheets.BatchUpdateSpreadsheetRequest {
+	sort.Slice(rows, func(i, j int) bool { return rows[i] > rows[j] })
+
+	batch := &sheets.BatchUpdateSpreadsheetRequest{}
+	for i := 0; i < len(rows); {
+		end := rows[i] + 1
+		start := rows[i]
+		for i++; i < len(rows) && rows[i] == start-1; i++ {
+			start = rows[i]
+		}
+		batch.Requests = append(batch.Requests, &sheets.Request{DeleteDimension: &sheets.DeleteDimensionRequest{
+			Range: &sheets.DimensionRange{
+				SheetId:         tabID,
+				Dimension:       "ROWS",
+				StartIndex:      start,
+				EndIndex:        end,
+				ForceSendFields: []string{"SheetId", "StartIndex"},
+			},
+		}})
+	}
+	return batch
+}
+
+// sheetTabID looks up the numeric ID of a tab, which batch updates address tabs by
+func sheetTabID(ctx context.Context, spreadsheetID string, title string) (int64, error) {
+	ss, err := sheetService.Spreadsheets.Get(spreadsheetID).Context(ctx).Do()
+	if err != nil {
+		return 0, err
+	}
+	for _, sheet := range ss.Sheets {
+		if sheet.Properties != nil && sheet.Properties.Title == title {
+			return sheet.Properties.SheetId, nil
+		}
+	}
+	return 0, fmt.Errorf("Tab %s not found", title)
+}
+
+// HandleAnnualArchive archives the rows of ?year=2023 when POSTed to. It requires the
+// AdminTokenEnv bearer token, as the rows are deleted from the report.
+func HandleAnnualArchive(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if !requireWarmup(w, r) {
+		return
+	}
+
+	r = r.WithContext(withFolders(r.Context(), snapshotFolders()))
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	year, err := strconv.Atoi(r.URL.Query().Get("year"))
+	if err != nil || year < 2020 || year > 2100 {
+		http.Error(w, "year must be between 2020 and 2100", http.StatusBadRequest)
+		return
+	}
+
+	err = ArchiveCurrentYear(r.Context(), year)
+	if err == ErrArchiveExists {
+		http.Error(w, fmt.Sprintf("%s %d already exists", SheetName, year), http.StatusConflict)
+		return
+	}
+	if err == ErrArchiveMonthlySheets {
+		http.Error(w, fmt.Sprintf("%s is set, each month is already archived in its own spreadsheet", MonthlySheetsEnv), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to archive %d: %v", year, err)
+		http.Error(w, "Unable to archive", http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Archived %d\n", year)
+}
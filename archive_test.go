@@ -0,0 +1,71 @@
+package trimark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDeleteRowsRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []int64
+		// want are the [start, end) ranges deleted, in request order
+		want [][2]int64
+	}{
+		{"single row", []int64{4}, [][2]int64{{4, 5}}},
+		{"contiguous rows", []int64{3, 4, 5}, [][2]int64{{3, 6}}},
+		{"bottom up", []int64{1, 2, 7, 9, 10}, [][2]int64{{9, 11}, {7, 8}, {1, 3}}},
+		{"unsorted", []int64{10, 2, 9, 1}, [][2]int64{{9, 11}, {1, 3}}},
+		{"none", nil, nil},
+	}
+	for _, tt := range tests {
+		batch := deleteRowsRequest(7, tt.rows)
+		var got [][2]int64
+		for _, r := range batch.Requests {
+			dr := r.DeleteDimension.Range
+			if dr.SheetId != 7 || dr.Dimension != "ROWS" {
+				t.Errorf("%s: range %+v isn't rows of tab 7", tt.name, dr)
+			}
+			got = append(got, [2]int64{dr.StartIndex, dr.EndIndex})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: deleted %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandleAnnualArchiveRequiresAdminToken(t *testing.T) {
+	_, _, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.AdminToken = "secret" }),
+		WithExistingSheetRows([][]interface{}{{"id1", "06-18-2023 12:00:00", "2023-06-18 12:00:00", "Pilot One", "1,000", ""}}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", http.StatusUnauthorized},
+		// Authorized, so it gets as far as refusing the year
+		{"admin token", "Bearer secret", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/archive/annual?year=1999", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		HandleAnnualArchive(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: HandleAnnualArchive responded %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+
+	// An unauthorized archive of a real year leaves the report alone
+	HandleAnnualArchive(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/archive/annual?year=2023", nil))
+	if rows := fakeSheets.Values(testSheetID, "Sheet1"); len(rows) != 2 {
+		t.Errorf("Report has %d rows after an unauthorized archive, want the header and the donation", len(rows))
+	}
+}
@@ -0,0 +1,122 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/storage/v1"
+)
+
+// attemptsPropertyKey counts the failed attempts at processing an upload
+const attemptsPropertyKey = "trimark_attempts"
+
+// attemptRecordTimeout bounds recording a failed attempt, which may follow an expired deadline
+const attemptRecordTimeout = 30 * time.Second
+
+// maxErrorHistory caps the error history kept in an upload's description
+const maxErrorHistory = 4000
+
+// errorHistoryMetadataKey holds the error history of an object, which has no description
+const errorHistoryMetadataKey = "trimark_errors"
+
+// recordFailedAttempt counts a failure to process an upload, leaving it in folderID, the Upload
+// folder or a subfolder of it, to be retried by the next run until MaxAttemptsEnv is reached,
+// when it is moved to the Failed folder of folders. Each failure is appended to the file's
+// description so the history travels with it.
+func recordFailedAttempt(file *drive.File, folders folderSnapshot, folderID string, cause error) error {
+	ctx, cancel := context.WithTimeout(withFolders(context.Background(), folders), attemptRecordTimeout)
+	defer cancel()
+
+	attempts := 1
+	for _, p := range file.Properties {
+		if p.Key == attemptsPropertyKey {
+			if n, err := strconv.Atoi(p.Value); err == nil {
+				attempts = n + 1
+			}
+		}
+	}
+
+	property := &drive.Property{Key: attemptsPropertyKey, Value: strconv.Itoa(attempts), Visibility: "PRIVATE"}
+	_, err := driveService.Properties.Insert(file.Id, property).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to record attempt %d at %s after %v: %v", attempts, file.Title, cause, err)
+	}
+
+	history := appendErrorHistory(file.Description, attempts, cause)
+	_, err = driveService.Files.Patch(file.Id, &drive.File{Description: history}).Context(ctx).Do()
+	if err != nil {
+		log.Printf("Unable to record the error history of %s: %v", file.Title, err)
+	}
+
+	if attempts < config.MaxAttempts {
+		log.Printf("Attempt %d of %d at %s failed, it will be retried: %v", attempts, config.MaxAttempts, file.Title, cause)
+		return nil
+	}
+
+	_, err = moveFileToFolder(ctx, file, folderID, folders.Failed)
+	if err != nil {
+		return fmt.Errorf("Unable to move %s to Failed after %d attempts: %v", file.Title, attempts, err)
+	}
+	log.Printf("Gave up on %s after %d attempts, moved it to Failed: %v", file.Title, attempts, cause)
+	notifyGaveUp(file, attempts, cause)
+	return nil
+}
+
+// recordFailedGCSAttempt is recordFailedAttempt for an object of GCSInputBucketEnv, counting
+// attempts in its metadata and moving it under GCSFailedPrefix once MaxAttemptsEnv is reached
+func recordFailedGCSAttempt(bucket string, name string, cause error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), attemptRecordTimeout)
+	defer cancel()
+
+	object, err := storageService.Objects.Get(bucket, name).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to get %s to record an attempt after %v: %v", name, cause, err)
+	}
+	attempts := 1
+	if n, err := strconv.Atoi(object.Metadata[attemptsPropertyKey]); err == nil {
+		attempts = n + 1
+	}
+
+	// The history is written with the count, objects have nowhere else to keep it
+	metadata := map[string]string{
+		attemptsPropertyKey:     strconv.Itoa(attempts),
+		errorHistoryMetadataKey: appendErrorHistory(object.Metadata[errorHistoryMetadataKey], attempts, cause),
+	}
+	_, err = storageService.Objects.Patch(bucket, name, &storage.Object{Metadata: metadata}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to record attempt %d at %s after %v: %v", attempts, name, cause, err)
+	}
+
+	if attempts < config.MaxAttempts {
+		log.Printf("Attempt %d of %d at %s failed, it will be retried: %v", attempts, config.MaxAttempts, name, cause)
+		return nil
+	}
+
+	err = moveGCSObject(ctx, bucket, name, GCSFailedPrefix)
+	if err != nil {
+		return fmt.Errorf("Unable to move %s to %s after %d attempts: %v", name, GCSFailedPrefix, attempts, err)
+	}
+	log.Printf("Gave up on %s after %d attempts, moved it to %s: %v", name, attempts, GCSFailedPrefix, cause)
+	notifyGaveUp(&drive.File{Id: "gs://" + bucket + "/" + name, Title: name, AlternateLink: "https://storage.cloud.google.com/" + bucket + "/" + name}, attempts, cause)
+	return nil
+}
+
+// appendErrorHistory adds an attempt's error to an error history, dropping the oldest text past
+// maxErrorHistory bytes. It's cut at a rune boundary, so the history stays valid UTF-8.
+func appendErrorHistory(history string, attempts int, cause error) string {
+	history = strings.TrimSpace(history + "\n" + fmt.Sprintf("Attempt %d at %s: %v", attempts, time.Now().UTC().Format(time.RFC3339), cause))
+	if len(history) <= maxErrorHistory {
+		return history
+	}
+	start := len(history) - maxErrorHistory
+	for start < len(history) && !utf8.RuneStart(history[start]) {
+		start++
+	}
+	return history[start:]
+}
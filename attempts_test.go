@@ -0,0 +1,55 @@
+package trimark
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
+)
+
+func TestFailedAppendIsRetriedThenDeadLettered(t *testing.T) {
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.MaxAttempts = 2 }),
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "donation.txt", MimeType: "text/plain"}}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeSheets.Fail(http.MethodPost, ":append", &googleapi.Error{Code: http.StatusBadRequest, Message: "Invalid range"})
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		Main(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		upload := fakeDrive.File("upload-1")
+		inUpload, inFailed := inFolder(upload, testUploadFolderID), inFolder(upload, testFailedFolderID)
+		if attempt == 1 && (!inUpload || inFailed) {
+			t.Errorf("After attempt 1 the upload is in %v, want it left in Upload", upload.Parents)
+		}
+		if attempt == 2 && (inUpload || !inFailed) {
+			t.Errorf("After attempt 2 the upload is in %v, want it in Failed", upload.Parents)
+		}
+		if len(fakeDrive.FilesIn(testProcessedFolderID)) > 0 {
+			t.Errorf("Attempt %d moved the upload to Processed without a row", attempt)
+		}
+		if !hasProperty(upload, attemptsPropertyKey, "") {
+			t.Errorf("Attempt %d wasn't counted on the upload", attempt)
+		}
+	}
+}
+
+func TestAppendErrorHistoryKeepsRunesWhole(t *testing.T) {
+	// Three byte runes, so the cap falls inside one unless the cut is moved
+	history := strings.Repeat("€", maxErrorHistory)
+	got := appendErrorHistory(history, 2, errors.New("Quantity Not Found"))
+	if !utf8.ValidString(got) {
+		t.Fatal("appendErrorHistory split a rune")
+	}
+	if len(got) > maxErrorHistory || len(got) < maxErrorHistory-utf8.UTFMax {
+		t.Errorf("appendErrorHistory kept %d bytes, want up to %d", len(got), maxErrorHistory)
+	}
+	if !strings.HasSuffix(got, "Quantity Not Found") {
+		t.Errorf("appendErrorHistory = %q, want the new error last", got[len(got)-80:])
+	}
+}
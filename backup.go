@@ -0,0 +1,101 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// backupFileLayout names the daily CSV backup files in the Backup folder
+const backupFileLayout = "trimark-backup-2006-01-02.csv"
+
+// backupMu serialises appends, since each one rewrites the whole day's file
+var backupMu sync.Mutex
+
+// backupFileIDs caches the IDs of daily backup files by name
+var backupFileIDs = map[string]string{}
+
+// appendCSVBackup appends a row, in the report's column order, to the day's CSV file in the
+// Backup folder. A new file starts with the report's header row. Drive can't append to a file,
+// so the day's file is downloaded and uploaded again with the row added.
+func appendCSVBackup(ctx context.Context, row []interface{}) error {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	name := time.Now().Format(backupFileLayout)
+	fileID, ok := backupFileIDs[name]
+	if !ok {
+		file, err := GetFileByNameInFolder(ctx, name, foldersFrom(ctx).Backup)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if file != nil {
+			fileID = file.Id
+		}
+	}
+
+	var body bytes.Buffer
+	if fileID != "" {
+		resp, err := driveService.Files.Get(fileID).Context(ctx).Download()
+		if err != nil {
+			return fmt.Errorf("Unable to download %s: %v", name, err)
+		}
+		_, err = body.ReadFrom(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("Unable to read %s: %v", name, err)
+		}
+	}
+
+	w := csv.NewWriter(&body)
+	if fileID == "" {
+		if err := w.Write(csvRecord(buildHeaders())); err != nil {
+			return err
+		}
+	}
+	if err := w.Write(csvRecord(row)); err != nil {
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	media := ioutil.NopCloser(bytes.NewReader(body.Bytes()))
+	if fileID == "" {
+		f := &drive.File{
+			Title:    name,
+			MimeType: "text/csv",
+			Parents:  []*drive.ParentReference{{Id: foldersFrom(ctx).Backup}},
+		}
+		f, err := driveService.Files.Insert(f).Media(media, googleapi.ContentType("text/csv")).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("Unable to create %s: %v", name, err)
+		}
+		backupFileIDs[name] = f.Id
+		return nil
+	}
+
+	_, err := driveService.Files.Update(fileID, &drive.File{}).Media(media, googleapi.ContentType("text/csv")).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to update %s: %v", name, err)
+	}
+	backupFileIDs[name] = fileID
+	return nil
+}
+
+// csvRecord formats a sheet row as CSV fields
+func csvRecord(row []interface{}) []string {
+	record := make([]string, len(row))
+	for i, v := range row {
+		record[i] = fmt.Sprint(v)
+	}
+	return record
+}
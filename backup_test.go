@@ -0,0 +1,62 @@
+package trimark
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestCSVBackupGrowsWithEachRow(t *testing.T) {
+	sc, fakeDrive, _ := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.CSVBackup = true }),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: "one.txt", MimeType: "text/plain"},
+			{Id: "upload-2", Title: "two.txt", MimeType: "text/plain"},
+		}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.SetContent("upload-2", []byte(donationText("2020-06-19 12:34:56", "Pilot Two", "2,000")))
+	backup := fakeDrive.AddFile(&drive.File{Title: BackupFolderName, MimeType: FolderMimeType, Parents: parentRefs(testMasterFolderID)}, nil)
+	BackupFolderID = backup.Id
+	forgetBackups := func() {
+		backupMu.Lock()
+		backupFileIDs = map[string]string{}
+		backupMu.Unlock()
+	}
+	t.Cleanup(forgetBackups)
+
+	// backupRows reads the day's CSV, header included
+	backupRows := func() [][]string {
+		t.Helper()
+		files := fakeDrive.FilesIn(BackupFolderID)
+		if len(files) != 1 || files[0].Title != time.Now().Format(backupFileLayout) {
+			t.Fatalf("Backup folder holds %v, want the day's CSV", files)
+		}
+		rows, err := csv.NewReader(bytes.NewReader(fakeDrive.Content(files[0].Id))).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+
+	config.Serial = true
+	runBatch(t, sc)
+	rows := backupRows()
+	if len(rows) != 3 || rows[0][0] != buildHeaders()[0] {
+		t.Fatalf("Backup = %q, want the header and a line for each row", rows)
+	}
+	if rows[1][nameColumn] != "Pilot One" || rows[2][nameColumn] != "Pilot Two" {
+		t.Errorf("Backup = %q, want the rows in the order they were appended", rows)
+	}
+
+	fakeDrive.AddFile(&drive.File{Id: "upload-3", Title: "three.txt", MimeType: "text/plain", Parents: parentRefs(testUploadFolderID)},
+		[]byte(donationText("2020-06-20 12:34:56", "Pilot Three", "3,000")))
+	// A new instance finds the day's file rather than starting another
+	forgetBackups()
+	runBatch(t, sc)
+	if rows := backupRows(); len(rows) != 4 || rows[3][nameColumn] != "Pilot Three" {
+		t.Errorf("Backup = %q, want one more line for the next row", rows)
+	}
+}
@@ -0,0 +1,325 @@
+package trimark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/drive/v2"
+)
+
+// batchOptions is a run of processUploads, by Main or the ProcessBatch RPC
+type batchOptions struct {
+	folders folderSnapshot
+	runID   string
+
+	// maxFiles stops the batch once that many files are started, 0 starts them all
+	maxFiles int
+
+	// span is the parent of the files' spans, nil when the caller isn't traced
+	span trace.Span
+
+	// done is passed each file's result as it finishes, or the error it was given up on with.
+	// It's never called concurrently.
+	done func(title string, result ExtractionResult, err error)
+}
+
+// batchStats is what became of the files processUploads found
+type batchStats struct {
+	Files []FileTimings
+
+	// Failed files hit an error and were left for the next run, Inconsistent files were recorded
+	// but couldn't be moved. NotStarted files were left by a cancelled request or a read-only sheet.
+	Failed       int
+	Inconsistent int
+	NotStarted   int
+
+	OldestUpload time.Duration
+
+	// ReadOnly is the error of the first file to find the sheet read-only, no files are started after it
+	ReadOnly error
+}
+
+// batchError is a batch which stopped part way, Reason is what the caller is told
+type batchError struct {
+	Reason string
+	Err    error
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+func (e *batchError) Unwrap() error {
+	return e.Err
+}
+
+// processUploads processes the Upload folder, or GCSInputBucket, concurrently unless SerialEnv
+// is set. Files are processed detached from ctx, so a cancelled caller doesn't leave them half
+// processed; cancelling it only stops new files being started. The files already started are
+// finished before it returns, whatever the error.
+func processUploads(ctx context.Context, opts batchOptions) (batchStats, error) {
+	var stats batchStats
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	started := 0
+
+	process := func(title string, run func(ctx context.Context) (ExtractionResult, error)) {
+		perFileCtx := withRunID(withFolders(context.Background(), opts.folders), opts.runID)
+		if opts.span != nil {
+			perFileCtx = trace.ContextWithSpan(perFileCtx, opts.span)
+		}
+		perFileCtx, cancel := context.WithTimeout(perFileCtx, config.ProcessTimeout)
+		defer cancel()
+
+		start := time.Now()
+		fileCtx, span := tracer.Start(perFileCtx, "file", trace.WithTimestamp(start), trace.WithAttributes(attribute.String("file.name", title)))
+		result, err := run(fileCtx)
+		result.recordStage(fileCtx, "total", start)
+		span.SetAttributes(attribute.String("file.id", result.FileID))
+		endSpan(span, err)
+		debugf("Stage timings of %s: %+v", title, result.Metadata)
+
+		mu.Lock()
+		defer mu.Unlock()
+		stats.Files = append(stats.Files, FileTimings{FileID: result.FileID, FileName: title, Metadata: result.Metadata})
+		opts.done(title, result, err)
+		if config.DryRun {
+			return
+		}
+		if errors.Is(err, ErrSheetReadOnly) {
+			if stats.ReadOnly == nil {
+				stats.ReadOnly = err
+			}
+			return
+		}
+		if result.Inconsistent {
+			stats.Inconsistent++
+		}
+		// One file's failure doesn't stop the batch, the file is left for the next run
+		if errors.Is(err, ErrDeadlineExceeded) {
+			log.Printf("Gave up on %s: %v", title, err)
+			stats.Failed++
+		} else if err != nil {
+			log.Printf("ERROR: %s: %v", title, err)
+			stats.Failed++
+		}
+	}
+
+	// dispatch starts a file, it returns false once the batch has stopped starting files
+	dispatch := func(title string, run func(ctx context.Context) (ExtractionResult, error)) bool {
+		if opts.maxFiles > 0 && started == opts.maxFiles {
+			return false
+		}
+		// Once the request is cancelled nobody reads the results, so only in-flight files finish
+		mu.Lock()
+		stopped := stats.ReadOnly != nil || ctx.Err() != nil
+		if stopped {
+			stats.NotStarted++
+		}
+		mu.Unlock()
+		if stopped {
+			return true
+		}
+
+		// Hold new files back rather than have them fail part way through on an exhausted quota
+		if err := quotaMonitor.Wait(ctx); err != nil {
+			log.Printf("Gave up waiting for API quota before %s: %v", title, err)
+			mu.Lock()
+			stats.NotStarted++
+			mu.Unlock()
+			return true
+		}
+		started++
+
+		// Serial mode keeps the logs of each file together for debugging
+		if config.Serial {
+			process(title, run)
+			return true
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			process(title, run)
+		}()
+		return true
+	}
+
+	// giveUp reports a file which couldn't be started
+	giveUp := func(file *drive.File, err error) {
+		log.Printf("ERROR: %v", err)
+		mu.Lock()
+		defer mu.Unlock()
+		stats.Failed++
+		opts.done(file.Title, ExtractionResult{FileID: file.Id, FileName: file.Title}, err)
+	}
+
+	if config.GCSInputBucket != "" {
+		names, err := listGCSObjects(ctx, config.GCSInputBucket, config.GCSInputPrefix)
+		if err != nil {
+			return stats, &batchError{"Unable to list objects", err}
+		}
+		for _, name := range names {
+			name := name
+			if !dispatch(name, func(ctx context.Context) (ExtractionResult, error) {
+				result, err := processGCSObject(ctx, config.GCSInputBucket, name)
+				if err != nil && config.MaxAttempts > 0 && !config.DryRun && !errors.Is(err, ErrSheetReadOnly) {
+					if attemptErr := recordFailedGCSAttempt(config.GCSInputBucket, name, err); attemptErr != nil {
+						log.Printf("ERROR: %v", attemptErr)
+					}
+				}
+				return result, err
+			}) {
+				break
+			}
+		}
+		wg.Wait()
+		return stats, nil
+	}
+
+	var cp *checkpoint
+	if config.Checkpoint && !config.DryRun {
+		var err error
+		cp, err = loadCheckpoint(ctx)
+		if err != nil {
+			return stats, &batchError{"Unable to load checkpoint", err}
+		}
+		if n := cp.len(); n > 0 {
+			log.Printf("Resuming a batch, %d files were processed by an earlier run", n)
+		}
+	}
+	var dispatched, finished int64
+	// listErr stops the scan, the files already started finish before returning
+	var listErr error
+	limited := false
+
+	// The next page is listed while the files of this one are dispatched
+	listCtx, cancelListing := context.WithCancel(ctx)
+	defer cancelListing()
+	for recheck := false; ; recheck = true {
+		listed := 0
+		for page := range listUploadPages(listCtx) {
+			if page.err != nil {
+				if ctx.Err() == nil {
+					listErr = page.err
+				}
+				cancelListing()
+				break
+			}
+			if limited {
+				continue
+			}
+			folder := page.folder
+
+			listed += len(page.files)
+
+			candidates, failed := uploadCandidates(ctx, page.files, cp)
+			mu.Lock()
+			stats.Failed += failed
+			mu.Unlock()
+
+			// Halves are paired once both are known to be processed, a bottom half whose top
+			// half was skipped is processed alone
+			var bottoms map[string]*drive.File
+			var merged map[string]bool
+			if config.MergeSplitScreenshots {
+				bottoms, merged = pairSplitScreenshots(candidates)
+			}
+
+			for _, c := range candidates {
+				if merged[c.Id] {
+					debugf("Skipping %s, it is stitched below its top half", c.Title)
+					continue
+				}
+
+				if ctx.Err() != nil {
+					mu.Lock()
+					stats.NotStarted++
+					mu.Unlock()
+					continue
+				}
+
+				// Listings are oldest first, so a backlog shows up before anything newer
+				if created, err := time.Parse(time.RFC3339, c.CreatedDate); err == nil {
+					age := time.Since(created)
+					if age > stats.OldestUpload {
+						stats.OldestUpload = age
+					}
+					if age > config.UploadAgeWarning {
+						log.Printf("WARN: %s was uploaded %s ago, the Upload folder may have a backlog", c.Title, age.Round(time.Minute))
+					}
+				}
+
+				if opts.maxFiles > 0 && started == opts.maxFiles {
+					limited = true
+					cancelListing()
+					break
+				}
+
+				fileDetails, err := driveService.Files.Get(c.Id).Context(ctx).Do()
+				if err != nil {
+					giveUp(c, fmt.Errorf("failed to get %s: %v", c.Title, err))
+					continue
+				}
+
+				bottom := bottoms[fileDetails.Id]
+				if dispatch(fileDetails.Title, func(ctx context.Context) (ExtractionResult, error) {
+					if folder.ID != "" {
+						ctx = withUploadFolder(ctx, folder)
+					}
+					if bottom != nil {
+						ctx = withBottomHalf(ctx, bottom)
+					}
+					result, err := processFile(ctx, fileDetails)
+					if err == nil && cp != nil {
+						atomic.AddInt64(&finished, 1)
+						if err := cp.markDone(ctx, fileDetails.Id); err != nil {
+							log.Printf("WARN: unable to checkpoint %s: %v", fileDetails.Title, err)
+						}
+					}
+					// A read-only sheet isn't the upload's fault, so it doesn't count as an attempt
+					if err != nil && config.MaxAttempts > 0 && !config.DryRun && !errors.Is(err, ErrSheetReadOnly) {
+						if attemptErr := recordFailedAttempt(fileDetails, foldersFrom(ctx), uploadFolderFrom(ctx).ID, err); attemptErr != nil {
+							log.Printf("ERROR: %v", attemptErr)
+						}
+					}
+					return result, err
+				}) {
+					atomic.AddInt64(&dispatched, 1)
+				}
+			}
+		}
+
+		// A scheduled run can fire seconds before an expected upload lands, so look once more
+		if listed > 0 || !config.ExpectFiles || recheck || listErr != nil || limited {
+			break
+		}
+		log.Printf("%s is empty, listing it again in %s", UploadFolderName, config.ExpectFilesDelay)
+		timer := time.NewTimer(config.ExpectFilesDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+	wg.Wait()
+
+	if listErr != nil {
+		return stats, &batchError{"Unable to get files from folder", listErr}
+	}
+
+	// Files left unfinished, by a deadline, a retry or the file limit, keep the batch open for the next run
+	if cp != nil && !limited && atomic.LoadInt64(&finished) == atomic.LoadInt64(&dispatched) && ctx.Err() == nil {
+		if err := cp.clear(ctx); err != nil {
+			log.Printf("WARN: unable to clear checkpoint: %v", err)
+		}
+	}
+	return stats, nil
+}
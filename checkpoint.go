@@ -0,0 +1,121 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// checkpointFileName is the JSON file in the master folder recording the files of an unfinished batch
+const checkpointFileName = "trimark-checkpoint.json"
+
+// checkpoint is the set of upload IDs a batch has finished with. It survives an invocation
+// running out of time, so the next one skips them, and is deleted once a batch drains.
+type checkpoint struct {
+	mu     sync.Mutex
+	fileID string
+	done   map[string]bool
+}
+
+type checkpointFile struct {
+	Processed []string `json:"processed"`
+}
+
+// loadCheckpoint reads the checkpoint left by an unfinished batch, or starts an empty one
+func loadCheckpoint(ctx context.Context) (*checkpoint, error) {
+	c := &checkpoint{done: map[string]bool{}}
+
+	file, err := GetFileByNameInFolder(ctx, checkpointFileName, config.FolderID)
+	if err == ErrNotFound {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.fileID = file.Id
+
+	resp, err := driveService.Files.Get(file.Id).Context(ctx).Download()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to download %s: %v", checkpointFileName, err)
+	}
+	defer resp.Body.Close()
+
+	var saved checkpointFile
+	if err := json.NewDecoder(resp.Body).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %v", checkpointFileName, err)
+	}
+	for _, id := range saved.Processed {
+		c.done[id] = true
+	}
+	return c, nil
+}
+
+// has reports whether the batch has already finished with an upload
+func (c *checkpoint) has(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[id]
+}
+
+// len is the number of uploads the batch has finished with
+func (c *checkpoint) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done)
+}
+
+// markDone records an upload as finished and saves the checkpoint straight away,
+// so it isn't lost if the invocation is killed
+func (c *checkpoint) markDone(ctx context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[id] = true
+
+	var saved checkpointFile
+	for id := range c.done {
+		saved.Processed = append(saved.Processed, id)
+	}
+	body, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	if c.fileID == "" {
+		f := &drive.File{
+			Title:    checkpointFileName,
+			MimeType: "application/json",
+			Parents:  []*drive.ParentReference{{Id: config.FolderID}},
+		}
+		f, err = driveService.Files.Insert(f).Media(bytes.NewReader(body), googleapi.ContentType("application/json")).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("Unable to create %s: %v", checkpointFileName, err)
+		}
+		c.fileID = f.Id
+		return nil
+	}
+
+	_, err = driveService.Files.Update(c.fileID, &drive.File{}).Media(bytes.NewReader(body), googleapi.ContentType("application/json")).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to update %s: %v", checkpointFileName, err)
+	}
+	return nil
+}
+
+// clear deletes the checkpoint once its batch has drained
+func (c *checkpoint) clear(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fileID != "" {
+		if err := driveService.Files.Delete(c.fileID).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("Unable to delete %s: %v", checkpointFileName, err)
+		}
+	}
+	c.fileID = ""
+	c.done = map[string]bool{}
+	return nil
+}
@@ -0,0 +1,43 @@
+package trimark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestMainResumesFromCheckpoint(t *testing.T) {
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.Checkpoint = true }),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: "one.txt", MimeType: "text/plain"},
+			{Id: "upload-2", Title: "two.txt", MimeType: "text/plain"},
+		}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.SetContent("upload-2", []byte(donationText("2020-06-19 12:34:56", "Pilot Two", "2,000")))
+	// An earlier run finished with upload-1 and ran out of time
+	cp := fakeDrive.AddFile(&drive.File{Title: checkpointFileName, MimeType: "application/json", Parents: parentRefs(testMasterFolderID)},
+		[]byte(`{"processed":["upload-1"]}`))
+
+	w := httptest.NewRecorder()
+	Main(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Main responded %d: %s", w.Code, w.Body)
+	}
+
+	rows := fakeSheets.Values(testSheetID, "Sheet1")
+	if len(rows) != 2 || rows[1][3] != "Pilot Two" {
+		t.Errorf("Report = %q, want only Pilot Two's donation added", rows)
+	}
+	if !inFolder(fakeDrive.File("upload-1"), testUploadFolderID) {
+		t.Error("The checkpointed upload was processed again")
+	}
+	if !inFolder(fakeDrive.File("upload-2"), testProcessedFolderID) {
+		t.Error("The upload missing from the checkpoint wasn't processed")
+	}
+	if fakeDrive.File(cp.Id) != nil {
+		t.Error("The checkpoint wasn't deleted once its batch drained")
+	}
+}
@@ -0,0 +1,135 @@
+package trimark
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+)
+
+// ocrDocSuffix is appended to an upload's title to name its OCR document
+const ocrDocSuffix = "_results"
+
+// CleanupReport is the JSON body returned by HandleCleanFailed
+type CleanupReport struct {
+	DeletedOriginals int `json:"deletedOriginals"`
+	DeletedOCRDocs   int `json:"deletedOCRDocs"`
+	RetainedFiles    int `json:"retainedFiles"`
+}
+
+// CleanFailedFolder permanently deletes the uploads in Failed older than retentionDays, with their
+// OCR documents. When MaxAttemptsEnv is set, an upload whose attempts are counted is only deleted
+// once they have reached it; uploads sent to Failed by a failed extraction aren't counted.
+func CleanFailedFolder(ctx context.Context, retentionDays int) (CleanupReport, error) {
+	Initialize()
+
+	var report CleanupReport
+
+	files, err := getFilesFromFolder(foldersFrom(ctx).Failed, false)
+	if err != nil {
+		return report, err
+	}
+
+	docs := map[string]*drive.File{}
+	var originals []*drive.File
+	for _, f := range files {
+		if f.MimeType == DocumentMimeType && strings.HasSuffix(f.Title, ocrDocSuffix) {
+			docs[f.Title] = f
+		} else {
+			originals = append(originals, f)
+		}
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	expired := func(f *drive.File) bool {
+		created, err := time.Parse(time.RFC3339, f.CreatedDate)
+		return err == nil && created.Before(cutoff)
+	}
+
+	for _, f := range originals {
+		if !expired(f) || !attemptsExhausted(f) {
+			report.RetainedFiles++
+			continue
+		}
+
+		if err := driveService.Files.Delete(f.Id).Context(ctx).Do(); err != nil {
+			return report, fmt.Errorf("Unable to delete %s: %v", f.Title, err)
+		}
+		report.DeletedOriginals++
+
+		doc, ok := docs[f.Title+ocrDocSuffix]
+		if !ok {
+			continue
+		}
+		delete(docs, doc.Title)
+		if err := driveService.Files.Delete(doc.Id).Context(ctx).Do(); err != nil {
+			return report, fmt.Errorf("Unable to delete %s: %v", doc.Title, err)
+		}
+		report.DeletedOCRDocs++
+	}
+
+	// What's left are documents of retained uploads, or of uploads deleted by hand
+	for _, doc := range docs {
+		report.RetainedFiles++
+		debugf("Keeping %s in %s", doc.Title, FailedFolderName)
+	}
+	return report, nil
+}
+
+// attemptsExhausted reports whether an upload is done being retried, it always is when attempts aren't counted
+func attemptsExhausted(f *drive.File) bool {
+	if config.MaxAttempts == 0 {
+		return true
+	}
+	for _, p := range f.Properties {
+		if p.Key == attemptsPropertyKey {
+			n, err := strconv.Atoi(p.Value)
+			return err == nil && n >= config.MaxAttempts
+		}
+	}
+	return true
+}
+
+// HandleCleanFailed runs CleanFailedFolder with ?retentionDays=7 when POSTed to. It requires the
+// AdminTokenEnv bearer token, as it permanently deletes files.
+func HandleCleanFailed(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if !requireWarmup(w, r) {
+		return
+	}
+
+	r = r.WithContext(withFolders(r.Context(), snapshotFolders()))
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	retentionDays, err := strconv.Atoi(r.URL.Query().Get("retentionDays"))
+	if err != nil || retentionDays < 1 {
+		http.Error(w, "retentionDays must be a positive number", http.StatusBadRequest)
+		return
+	}
+
+	report, err := CleanFailedFolder(r.Context(), retentionDays)
+	log.Printf("Cleaning %s deleted %d uploads and %d OCR documents, kept %d files", FailedFolderName, report.DeletedOriginals, report.DeletedOCRDocs, report.RetainedFiles)
+	if err != nil {
+		log.Printf("Unable to clean %s: %v", FailedFolderName, err)
+		http.Error(w, "Unable to clean folder", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Unable to write cleanup report: %v", err)
+	}
+}
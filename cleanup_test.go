@@ -0,0 +1,115 @@
+package trimark
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestCleanFailedFolder(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t, WithConfig(func(c *Config) { c.MaxAttempts = 3 }))
+	old := time.Now().AddDate(0, 0, -10).UTC().Format(time.RFC3339)
+	recent := time.Now().AddDate(0, 0, -2).UTC().Format(time.RFC3339)
+	attempts := func(n string) []*drive.Property {
+		return []*drive.Property{{Key: attemptsPropertyKey, Value: n, Visibility: "PRIVATE"}}
+	}
+	files := []*drive.File{
+		// Expired and out of attempts, deleted with its document
+		{Id: "exhausted", Title: "exhausted.png", CreatedDate: old, Properties: attempts("3")},
+		{Id: "exhausted-doc", Title: "exhausted.png" + ocrDocSuffix, MimeType: DocumentMimeType, CreatedDate: old},
+		// Expired and failed by its extraction, so never counted
+		{Id: "uncounted", Title: "uncounted.png", CreatedDate: old},
+		// Expired but with attempts left
+		{Id: "retrying", Title: "retrying.png", CreatedDate: old, Properties: attempts("1")},
+		{Id: "retrying-doc", Title: "retrying.png" + ocrDocSuffix, MimeType: DocumentMimeType, CreatedDate: old},
+		// Out of attempts but within the retention period
+		{Id: "recent", Title: "recent.png", CreatedDate: recent, Properties: attempts("3")},
+	}
+	for _, f := range files {
+		f.Parents = parentRefs(testFailedFolderID)
+		fakeDrive.AddFile(f, []byte("content"))
+	}
+	// A file of the same name elsewhere isn't touched
+	fakeDrive.AddFile(&drive.File{Id: "processed", Title: "exhausted.png", CreatedDate: old, Parents: parentRefs(testProcessedFolderID)}, []byte("content"))
+
+	report, err := CleanFailedFolder(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (CleanupReport{DeletedOriginals: 2, DeletedOCRDocs: 1, RetainedFiles: 3}); report != want {
+		t.Errorf("CleanFailedFolder = %+v, want %+v", report, want)
+	}
+
+	var left []string
+	for _, f := range fakeDrive.FilesIn(testFailedFolderID) {
+		left = append(left, f.Id)
+	}
+	sort.Strings(left)
+	if want := []string{"recent", "retrying", "retrying-doc"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("Failed holds %v, want %v", left, want)
+	}
+	if fakeDrive.File("processed") == nil {
+		t.Error("A processed file was deleted")
+	}
+}
+
+func TestHandleCleanFailed(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t, WithConfig(func(c *Config) { c.AdminToken = "secret" }))
+	fakeDrive.AddFile(&drive.File{Id: "expired", Title: "expired.png", CreatedDate: time.Now().AddDate(0, 0, -10).UTC().Format(time.RFC3339), Parents: parentRefs(testFailedFolderID)}, []byte("content"))
+
+	tests := []struct {
+		method, target string
+		wantCode       int
+	}{
+		{http.MethodGet, "/cleanup/failed?retentionDays=7", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/cleanup/failed?retentionDays=0", http.StatusBadRequest},
+		{http.MethodPost, "/cleanup/failed", http.StatusBadRequest},
+		{http.MethodPost, "/cleanup/failed?retentionDays=7", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		HandleCleanFailed(w, r)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s %s responded %d, want %d: %s", tt.method, tt.target, w.Code, tt.wantCode, w.Body)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var report CleanupReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || report.DeletedOriginals != 1 {
+			t.Errorf("Report = %s, want the expired upload deleted", w.Body)
+		}
+	}
+	if fakeDrive.File("expired") != nil {
+		t.Error("The expired upload wasn't deleted")
+	}
+}
+
+func TestHandleCleanFailedRequiresAdminToken(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t, WithConfig(func(c *Config) { c.AdminToken = "secret" }))
+	fakeDrive.AddFile(&drive.File{Id: "expired", Title: "expired.png", CreatedDate: time.Now().AddDate(0, 0, -10).UTC().Format(time.RFC3339), Parents: parentRefs(testFailedFolderID)}, []byte("content"))
+
+	for _, authorization := range []string{"", "Bearer guess"} {
+		r := httptest.NewRequest(http.MethodPost, "/cleanup/failed?retentionDays=7", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		HandleCleanFailed(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: HandleCleanFailed responded %d, want %d", authorization, w.Code, http.StatusUnauthorized)
+		}
+	}
+	if fakeDrive.File("expired") == nil {
+		t.Error("An unauthorized request deleted the expired upload")
+	}
+}
@@ -0,0 +1,90 @@
+// Command server runs the trimark functions as a long-lived Cloud Run service,
+// avoiding the Cloud Functions timeout for bulk backfill operations.
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+
+	"github.com/Bourne-ID/trimark-demo"
+	"github.com/Bourne-ID/trimark-demo/trimarkpb"
+	"github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	// Set up the clients and warm up before taking traffic, rather than on the first request
+	trimark.Initialize()
+
+	ctx := context.Background()
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/", trimark.Main); err != nil {
+		log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+	}
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/status", trimark.Status); err != nil {
+		log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+	}
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/quota", trimark.Quota); err != nil {
+		log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+	}
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/report/monthly", trimark.HandleMonthlyReport); err != nil {
+		log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+	}
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/archive/annual", trimark.HandleAnnualArchive); err != nil {
+		log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+	}
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/watch", trimark.Watch); err != nil {
+		log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+	}
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/watch/notify", trimark.HandleWatchNotification); err != nil {
+		log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+	}
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/ingest", trimark.Ingest); err != nil {
+		log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+	}
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/rebuild", trimark.Rebuild); err != nil {
+		log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+	}
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/export", trimark.HandleExport); err != nil {
+		log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+	}
+	if err := funcframework.RegisterHTTPFunctionContext(ctx, "/cleanup/failed", trimark.HandleCleanFailed); err != nil {
+		log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+	}
+	// Never expose the reset endpoint unless it has been explicitly allowed
+	if trimark.ResetAllowed() {
+		if err := funcframework.RegisterHTTPFunctionContext(ctx, "/admin/reset", trimark.HandleReset); err != nil {
+			log.Fatalf("funcframework.RegisterHTTPFunctionContext: %v", err)
+		}
+	}
+
+	// Cloud Run provides the port to listen on
+	port := "8080"
+	if envPort := os.Getenv("PORT"); envPort != "" {
+		port = envPort
+	}
+
+	// GRPC_PORT serves the Trimark gRPC service, which streams the progress of ProcessBatch and
+	// BackfillAll. Cloud Run routes a single port, so a gRPC deployment sets it to PORT and
+	// serves gRPC alone.
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatalf("net.Listen: %v", err)
+		}
+		// The RPCs process and rebuild the report, so they need the admin token as /ingest and /rebuild do
+		server := grpc.NewServer(grpc.UnaryInterceptor(trimark.AuthorizeUnary), grpc.StreamInterceptor(trimark.AuthorizeStream))
+		trimarkpb.RegisterTrimarkServer(server, trimark.NewGRPCServer())
+		if grpcPort == port {
+			log.Fatalf("grpc.Serve: %v", server.Serve(lis))
+		}
+		go func() {
+			log.Fatalf("grpc.Serve: %v", server.Serve(lis))
+		}()
+	}
+
+	if err := funcframework.Start(port); err != nil {
+		log.Fatalf("funcframework.Start: %v", err)
+	}
+}
@@ -0,0 +1,468 @@
+package trimark
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidConfig is returned when an environment variable has a malformed value
+var ErrInvalidConfig = errors.New("Invalid configuration")
+
+// Config is the environment driven configuration, loaded and validated once at startup
+type Config struct {
+	FolderID       string
+	ProcessTimeout time.Duration
+
+	// PropertyFilterKey is empty when uploads aren't filtered by property
+	PropertyFilterKey   string
+	PropertyFilterValue string
+
+	// AmountDecimals is -1 when amounts are recorded as extracted
+	AmountDecimals   int
+	AmountMultiplier float64
+
+	// SheetsWritesPerMinute is 0 when writes aren't rate limited
+	SheetsWritesPerMinute int
+
+	// MaxAttempts is 0 when failed uploads aren't retried
+	MaxAttempts int
+
+	FolderRevalidateInterval time.Duration
+
+	// ExtractRetries is 0 when failed extractions go straight to Failed
+	ExtractRetries    int
+	ExtractRetryDelay time.Duration
+
+	ExpectFiles      bool
+	ExpectFilesDelay time.Duration
+
+	UploadAgeWarning time.Duration
+
+	QuarantineOCRDocs   bool
+	DryRun              bool
+	RejectZeroQuantity  bool
+	MemberMonthlyTotals bool
+	Debug               bool
+	Serial              bool
+	UploaderColumn      bool
+	PreserveOriginal    bool
+	AllowReset          bool
+	Checkpoint          bool
+	VerifyWrite         bool
+	VerifySheetWrites   bool
+	FailuresTab         bool
+	FailuresThumbnail   bool
+	ImageInfoColumns    bool
+	WarmupAsync         bool
+	MonthlySheets       bool
+	HighlightDuplicates bool
+	NegativeAmounts     bool
+	RunIDColumn         bool
+	RecursiveUpload     bool
+	SubfolderColumn     bool
+	CSVBackup           bool
+	FlattenAlpha        bool
+	AlphaBackground     color.RGBA
+	Preprocess          PreprocessConfig
+	Trim                TrimConfig
+	Review              ReviewConfig
+	ProcessWindow       ProcessWindow
+
+	// MaxFileBytes is 0 when uploads of any size are downloaded
+	MaxFileBytes int64
+
+	// MergeSplitScreenshots can't be used with PreserveOriginal, which only copies the top half
+	MergeSplitScreenshots bool
+
+	DateFormat     string
+	AmountFormat   string
+	AdminToken     string
+	DedupStore     string
+	DedupProject   string
+	WatchAddress   string
+	WatchToken     string
+	GCSInputBucket string
+	GCSInputPrefix string
+	TraceProject   string
+
+	SummaryRowPolicy  string
+	QuantityAgreement string
+
+	// QuantityFallbackPattern is empty when only the built-in quantity patterns are tried
+	QuantityFallbackPattern string
+
+	MultiParentPolicy string
+
+	NotificationEmailTo   string
+	NotificationEmailFrom string
+	SMTPHost              string
+	SMTPPort              int
+
+	SecondaryOCRURL string
+
+	// IgnoredUsernames holds normalized usernames
+	IgnoredUsernames map[string]bool
+
+	// FeatureFlags holds the knownFeatures which are switched on, read them with IsEnabled
+	FeatureFlags map[string]bool
+}
+
+// Bounds of the in-run extraction retries, which have to fit in ProcessTimeoutEnv
+const (
+	maxExtractRetries        = 3
+	maxExtractRetryDelay     = 30 * time.Second
+	defaultExtractRetryDelay = 5 * time.Second
+	maxExpectFilesDelay      = 30 * time.Second
+	defaultExpectFilesDelay  = 5 * time.Second
+	defaultUploadAgeWarning  = 24 * time.Hour
+)
+
+// config is loaded by Initialize, every function reads its settings from here
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		ProcessTimeout:           defaultProcessTimeout,
+		AmountDecimals:           -1,
+		AmountMultiplier:         1,
+		FolderRevalidateInterval: defaultFolderRevalidateInterval,
+		SummaryRowPolicy:         SummaryRowNone,
+		QuantityAgreement:        QuantityAgreementFirst,
+		MultiParentPolicy:        MultiParentWarn,
+		SMTPPort:                 defaultSMTPPort,
+		ExtractRetryDelay:        defaultExtractRetryDelay,
+		ExpectFilesDelay:         defaultExpectFilesDelay,
+		AlphaBackground:          defaultAlphaBackground,
+		UploadAgeWarning:         defaultUploadAgeWarning,
+		VerifySheetWrites:        true,
+	}
+}
+
+// LoadConfig reads Config from getenv, such as os.Getenv, reporting every malformed value at once
+func LoadConfig(getenv func(string) string) (Config, error) {
+	c := defaultConfig()
+	var problems []string
+
+	positiveInt := func(name string, v string) (int, bool) {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be a positive number, got %q", name, v))
+			return 0, false
+		}
+		return n, true
+	}
+	boolean := func(name string) bool {
+		v := getenv(name)
+		if v == "" {
+			return false
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s must be true or false, got %q", name, v))
+		}
+		return b
+	}
+
+	c.FolderID = getenv(FolderIDEnv)
+
+	if v := getenv(ProcessTimeoutEnv); v != "" {
+		if seconds, ok := positiveInt(ProcessTimeoutEnv, v); ok {
+			c.ProcessTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if v := getenv(PropertyFilterEnv); v != "" {
+		kv := strings.SplitN(v, "=", 2)
+		c.PropertyFilterKey = kv[0]
+		if len(kv) == 2 {
+			c.PropertyFilterValue = kv[1]
+		}
+	}
+
+	if v := getenv(AmountDecimalsEnv); v != "" {
+		decimals, err := strconv.Atoi(v)
+		if err != nil || decimals < 0 {
+			problems = append(problems, fmt.Sprintf("%s must be zero or a positive number, got %q", AmountDecimalsEnv, v))
+		} else {
+			c.AmountDecimals = decimals
+		}
+	}
+
+	if v := getenv(AmountMultiplierEnv); v != "" {
+		multiplier, err := strconv.ParseFloat(v, 64)
+		if err != nil || multiplier <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be a positive number, got %q", AmountMultiplierEnv, v))
+		} else {
+			c.AmountMultiplier = multiplier
+		}
+	}
+
+	if v := getenv(SheetsWritesPerMinuteEnv); v != "" {
+		if perMinute, ok := positiveInt(SheetsWritesPerMinuteEnv, v); ok {
+			c.SheetsWritesPerMinute = perMinute
+		}
+	}
+
+	if v := getenv(MaxAttemptsEnv); v != "" {
+		if attempts, ok := positiveInt(MaxAttemptsEnv, v); ok {
+			c.MaxAttempts = attempts
+		}
+	}
+
+	if v := getenv(FolderRevalidateIntervalEnv); v != "" {
+		if minutes, ok := positiveInt(FolderRevalidateIntervalEnv, v); ok {
+			c.FolderRevalidateInterval = time.Duration(minutes) * time.Minute
+		}
+	}
+
+	if v := getenv(ExtractRetriesEnv); v != "" {
+		if retries, ok := positiveInt(ExtractRetriesEnv, v); ok {
+			if retries > maxExtractRetries {
+				problems = append(problems, fmt.Sprintf("%s must be at most %d, got %d", ExtractRetriesEnv, maxExtractRetries, retries))
+			}
+			c.ExtractRetries = retries
+		}
+	}
+
+	if v := getenv(ExtractRetryDelayEnv); v != "" {
+		if seconds, ok := positiveInt(ExtractRetryDelayEnv, v); ok {
+			delay := time.Duration(seconds) * time.Second
+			if delay > maxExtractRetryDelay {
+				problems = append(problems, fmt.Sprintf("%s must be at most %d, got %d", ExtractRetryDelayEnv, int(maxExtractRetryDelay/time.Second), seconds))
+			}
+			c.ExtractRetryDelay = delay
+		}
+	}
+
+	if v := getenv(UploadAgeWarningHoursEnv); v != "" {
+		if hours, ok := positiveInt(UploadAgeWarningHoursEnv, v); ok {
+			c.UploadAgeWarning = time.Duration(hours) * time.Hour
+		}
+	}
+
+	if v := getenv(MaxFileBytesEnv); v != "" {
+		if n, ok := positiveInt(MaxFileBytesEnv, v); ok {
+			c.MaxFileBytes = int64(n)
+		}
+	}
+
+	c.ExpectFiles = boolean(ExpectFilesEnv)
+	if v := getenv(ExpectFilesDelayEnv); v != "" {
+		if seconds, ok := positiveInt(ExpectFilesDelayEnv, v); ok {
+			delay := time.Duration(seconds) * time.Second
+			if delay > maxExpectFilesDelay {
+				problems = append(problems, fmt.Sprintf("%s must be at most %d, got %d", ExpectFilesDelayEnv, int(maxExpectFilesDelay/time.Second), seconds))
+			}
+			c.ExpectFilesDelay = delay
+		}
+	}
+
+	c.QuarantineOCRDocs = boolean(QuarantineOCRDocsEnv)
+	c.DryRun = boolean(DryRunEnv)
+	c.RejectZeroQuantity = boolean(RejectZeroQuantityEnv)
+	c.MemberMonthlyTotals = boolean(MemberMonthlyTotalsEnv)
+	c.Debug = boolean(DebugEnv)
+	c.Serial = boolean(SerialEnv)
+	c.UploaderColumn = boolean(UploaderColumnEnv)
+	c.PreserveOriginal = boolean(PreserveOriginalEnv)
+	c.AllowReset = boolean(AllowResetEnv)
+	c.Checkpoint = boolean(CheckpointEnv)
+	c.VerifyWrite = boolean(VerifyWriteEnv)
+	c.FailuresTab = boolean(FailuresTabEnv)
+	c.FailuresThumbnail = boolean(FailuresThumbnailEnv)
+	c.ImageInfoColumns = boolean(ImageInfoColumnsEnv)
+	c.WarmupAsync = boolean(WarmupAsyncEnv)
+	c.MonthlySheets = boolean(MonthlySheetsEnv)
+	c.HighlightDuplicates = boolean(HighlightDuplicatesEnv)
+	c.NegativeAmounts = boolean(NegativeAmountsEnv)
+	c.RunIDColumn = boolean(RunIDColumnEnv)
+	c.RecursiveUpload = boolean(RecursiveUploadEnv)
+	c.SubfolderColumn = boolean(SubfolderColumnEnv)
+	c.CSVBackup = boolean(CSVBackupEnv)
+	c.MergeSplitScreenshots = boolean(MergeSplitScreenshotsEnv)
+	if c.MergeSplitScreenshots && c.PreserveOriginal {
+		problems = append(problems, fmt.Sprintf("%s can't be used with %s", MergeSplitScreenshotsEnv, PreserveOriginalEnv))
+	}
+	if getenv(VerifySheetWritesEnv) != "" {
+		c.VerifySheetWrites = boolean(VerifySheetWritesEnv)
+	}
+	c.FlattenAlpha = boolean(FlattenAlphaEnv)
+	if v := getenv(AlphaBackgroundEnv); v != "" {
+		background, err := parseHexColor(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %v", AlphaBackgroundEnv, err))
+		} else {
+			c.AlphaBackground = background
+		}
+	}
+	c.Preprocess.EnableCLAHE = boolean(PreprocessCLAHEEnv)
+	c.Preprocess.EnableOtsu = boolean(PreprocessOtsuEnv)
+
+	trims := []struct {
+		name string
+		edge *TrimEdge
+	}{
+		{TrimTopEnv, &c.Trim.Top},
+		{TrimBottomEnv, &c.Trim.Bottom},
+		{TrimLeftEnv, &c.Trim.Left},
+		{TrimRightEnv, &c.Trim.Right},
+	}
+	for _, t := range trims {
+		if v := getenv(t.name); v != "" {
+			edge, err := parseTrimEdge(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s %v", t.name, err))
+				continue
+			}
+			*t.edge = edge
+		}
+	}
+
+	if v := getenv(NeedsReviewEnv); v != "" {
+		review, err := parseReviewHeuristics(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %v", NeedsReviewEnv, err))
+		}
+		c.Review = review
+	}
+	if v := getenv(ReviewMaxAmountEnv); v != "" {
+		if amount, ok := positiveInt(ReviewMaxAmountEnv, v); ok {
+			c.Review.MaxAmount = int64(amount)
+		}
+	}
+
+	switch v := getenv(QuantityAgreementEnv); v {
+	case "", QuantityAgreementFirst:
+	case QuantityAgreementReview, QuantityAgreementStrict:
+		c.QuantityAgreement = v
+		c.Review.QuantityDisagreement = v == QuantityAgreementReview
+	default:
+		problems = append(problems, fmt.Sprintf("%s must be first, review or strict, got %q", QuantityAgreementEnv, v))
+	}
+
+	if v := getenv(QuantityFallbackPatternEnv); v != "" {
+		re, err := regexp.Compile(v)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s doesn't compile: %v", QuantityFallbackPatternEnv, err))
+		case !hasSubexp(re, "quantity"):
+			problems = append(problems, fmt.Sprintf("%s has no (?P<quantity>) group", QuantityFallbackPatternEnv))
+		default:
+			c.QuantityFallbackPattern = v
+		}
+	}
+
+	switch v := getenv(MultiParentPolicyEnv); v {
+	case "":
+	case MultiParentWarn, MultiParentDetach:
+		c.MultiParentPolicy = v
+	default:
+		problems = append(problems, fmt.Sprintf("%s must be warn or detach, got %q", MultiParentPolicyEnv, v))
+	}
+
+	if v := getenv(SecondaryOCRURLEnv); v != "" {
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%s must be an http or https URL, got %q", SecondaryOCRURLEnv, v))
+		} else {
+			c.SecondaryOCRURL = v
+			c.Review.SecondaryOCR = true
+		}
+	}
+
+	start, end := getenv(ProcessWindowStartEnv), getenv(ProcessWindowEndEnv)
+	if start != "" || end != "" {
+		var err error
+		c.ProcessWindow.Start, err = parseClock(start)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %v", ProcessWindowStartEnv, err))
+		}
+		c.ProcessWindow.End, err = parseClock(end)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %v", ProcessWindowEndEnv, err))
+		}
+		c.ProcessWindow.Location = time.UTC
+		if tz := getenv(ProcessWindowTimezoneEnv); tz != "" {
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be a time zone such as Europe/London, got %q", ProcessWindowTimezoneEnv, tz))
+			} else {
+				c.ProcessWindow.Location = loc
+			}
+		}
+		c.ProcessWindow.set = true
+	}
+
+	c.DateFormat = getenv(DateFormatEnv)
+	c.AmountFormat = getenv(AmountFormatEnv)
+	c.AdminToken = getenv(AdminTokenEnv)
+	c.DedupStore = getenv(DedupStoreEnv)
+	c.DedupProject = getenv(DedupProjectEnv)
+	c.WatchAddress = getenv(WatchAddressEnv)
+	c.WatchToken = getenv(WatchTokenEnv)
+	c.GCSInputBucket = getenv(GCSInputBucketEnv)
+	c.GCSInputPrefix = getenv(GCSInputPrefixEnv)
+	c.TraceProject = getenv(TraceProjectEnv)
+
+	c.IgnoredUsernames = map[string]bool{}
+	for _, name := range strings.Split(getenv(IgnoreUsernamesEnv), ",") {
+		if name = normalizeUsername(name); name != "" {
+			c.IgnoredUsernames[name] = true
+		}
+	}
+
+	c.FeatureFlags = map[string]bool{}
+	for _, f := range knownFeatures {
+		if boolean(f.envName()) {
+			c.FeatureFlags[string(f)] = true
+		}
+	}
+
+	c.NotificationEmailTo = getenv(NotificationEmailToEnv)
+	c.NotificationEmailFrom = getenv(NotificationEmailFromEnv)
+	c.SMTPHost = getenv(SMTPHostEnv)
+	if v := getenv(SMTPPortEnv); v != "" {
+		if port, ok := positiveInt(SMTPPortEnv, v); ok {
+			c.SMTPPort = port
+		}
+	}
+	if c.NotificationEmailTo != "" && (c.NotificationEmailFrom == "" || c.SMTPHost == "") {
+		problems = append(problems, fmt.Sprintf("%s and %s are required by %s", NotificationEmailFromEnv, SMTPHostEnv, NotificationEmailToEnv))
+	}
+
+	if c.WatchAddress != "" && !strings.HasPrefix(c.WatchAddress, "https://") {
+		problems = append(problems, fmt.Sprintf("%s must be an https:// address, got %q", WatchAddressEnv, c.WatchAddress))
+	}
+	if c.WatchAddress != "" && c.WatchToken == "" {
+		problems = append(problems, fmt.Sprintf("%s is required by %s, notifications without it are refused", WatchTokenEnv, WatchAddressEnv))
+	}
+
+	if v := getenv(SummaryRowEnv); v != "" {
+		switch v {
+		case SummaryRowNone, SummaryRowTop, SummaryRowBottom:
+			c.SummaryRowPolicy = v
+		default:
+			problems = append(problems, fmt.Sprintf("%s must be top, bottom or none, got %q", SummaryRowEnv, v))
+		}
+	}
+
+	switch c.DedupStore {
+	case "":
+	case "firestore":
+		if c.DedupProject == "" {
+			problems = append(problems, fmt.Sprintf("%s is required by %s=firestore", DedupProjectEnv, DedupStoreEnv))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("%s must be firestore or unset, got %q", DedupStoreEnv, c.DedupStore))
+	}
+
+	if len(problems) > 0 {
+		return c, fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+	return c, nil
+}
@@ -0,0 +1,165 @@
+package trimark
+
+import (
+	"errors"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		// problems are the settings the error must name, none when the config is valid
+		problems []string
+		check    func(t *testing.T, c Config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, c Config) {
+				if c.ProcessTimeout != defaultProcessTimeout {
+					t.Errorf("ProcessTimeout = %s, want %s", c.ProcessTimeout, defaultProcessTimeout)
+				}
+				if c.AmountDecimals != -1 || c.AmountMultiplier != 1 {
+					t.Errorf("AmountDecimals, AmountMultiplier = %d, %v, want -1, 1", c.AmountDecimals, c.AmountMultiplier)
+				}
+				if c.SummaryRowPolicy != SummaryRowNone {
+					t.Errorf("SummaryRowPolicy = %q, want %q", c.SummaryRowPolicy, SummaryRowNone)
+				}
+				if !c.VerifySheetWrites {
+					t.Error("VerifySheetWrites is off by default")
+				}
+				if c.AlphaBackground != defaultAlphaBackground {
+					t.Errorf("AlphaBackground = %v, want %v", c.AlphaBackground, defaultAlphaBackground)
+				}
+			},
+		},
+		{
+			name: "values",
+			env: map[string]string{
+				FolderIDEnv:              "folder",
+				ProcessTimeoutEnv:        "90",
+				PropertyFilterEnv:        "source=phone",
+				ExtractRetriesEnv:        "2",
+				MonthlySheetsEnv:         "true",
+				VerifySheetWritesEnv:     "false",
+				AlphaBackgroundEnv:       "#102030",
+				UploadAgeWarningHoursEnv: "6",
+				IgnoreUsernamesEnv:       " Wallet  Bot ,,EVE System",
+			},
+			check: func(t *testing.T, c Config) {
+				if c.FolderID != "folder" {
+					t.Errorf("FolderID = %q", c.FolderID)
+				}
+				if c.ProcessTimeout != 90*time.Second {
+					t.Errorf("ProcessTimeout = %s, want 1m30s", c.ProcessTimeout)
+				}
+				if c.PropertyFilterKey != "source" || c.PropertyFilterValue != "phone" {
+					t.Errorf("PropertyFilter = %q=%q, want source=phone", c.PropertyFilterKey, c.PropertyFilterValue)
+				}
+				if c.ExtractRetries != 2 {
+					t.Errorf("ExtractRetries = %d, want 2", c.ExtractRetries)
+				}
+				if !c.MonthlySheets || c.VerifySheetWrites {
+					t.Errorf("MonthlySheets, VerifySheetWrites = %v, %v, want true, false", c.MonthlySheets, c.VerifySheetWrites)
+				}
+				if want := (color.RGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff}); c.AlphaBackground != want {
+					t.Errorf("AlphaBackground = %v, want %v", c.AlphaBackground, want)
+				}
+				if c.UploadAgeWarning != 6*time.Hour {
+					t.Errorf("UploadAgeWarning = %s, want 6h", c.UploadAgeWarning)
+				}
+				if want := map[string]bool{"wallet bot": true, "eve system": true}; !reflect.DeepEqual(c.IgnoredUsernames, want) {
+					t.Errorf("IgnoredUsernames = %v, want %v", c.IgnoredUsernames, want)
+				}
+			},
+		},
+		{
+			name:     "not a boolean",
+			env:      map[string]string{DryRunEnv: "yes please"},
+			problems: []string{DryRunEnv},
+		},
+		{
+			name:     "not positive",
+			env:      map[string]string{MaxAttemptsEnv: "0"},
+			problems: []string{MaxAttemptsEnv},
+		},
+		{
+			name:     "over the limit",
+			env:      map[string]string{ExtractRetriesEnv: "9", ExpectFilesDelayEnv: "60"},
+			problems: []string{ExtractRetriesEnv, ExpectFilesDelayEnv},
+		},
+		{
+			name:     "conflicting",
+			env:      map[string]string{MergeSplitScreenshotsEnv: "true", PreserveOriginalEnv: "true"},
+			problems: []string{MergeSplitScreenshotsEnv},
+		},
+		{
+			name:     "watch without a token",
+			env:      map[string]string{WatchAddressEnv: "https://example.com/watch/notify"},
+			problems: []string{WatchTokenEnv},
+		},
+		{
+			name:     "pattern without a quantity group",
+			env:      map[string]string{QuantityFallbackPatternEnv: `Amount\r\n([0-9,]+)`},
+			problems: []string{QuantityFallbackPatternEnv},
+		},
+		{
+			name:     "every problem at once",
+			env:      map[string]string{SummaryRowEnv: "middle", AlphaBackgroundEnv: "white", ProcessTimeoutEnv: "-5"},
+			problems: []string{SummaryRowEnv, AlphaBackgroundEnv, ProcessTimeoutEnv},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := LoadConfig(func(name string) string { return tt.env[name] })
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("LoadConfig: %v", err)
+				}
+				tt.check(t, c)
+				return
+			}
+
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("LoadConfig error = %v, want ErrInvalidConfig", err)
+			}
+			for _, name := range tt.problems {
+				if !strings.Contains(err.Error(), name) {
+					t.Errorf("LoadConfig error %q doesn't mention %s", err, name)
+				}
+			}
+		})
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want map[Feature]bool
+	}{
+		{"none", nil, map[Feature]bool{FeatureBigQuery: false, FeatureESIValidation: false, FeatureMultiStrip: false}},
+		{"bigquery", map[string]string{"TRIMARK_FF_BIGQUERY": "true"}, map[Feature]bool{FeatureBigQuery: true, FeatureESIValidation: false, FeatureMultiStrip: false}},
+		{"switched off", map[string]string{"TRIMARK_FF_BIGQUERY": "false", "TRIMARK_FF_MULTI_STRIP": "true"}, map[Feature]bool{FeatureBigQuery: false, FeatureMultiStrip: true}},
+		// Flags for features this build doesn't know are ignored rather than failing to start
+		{"unknown", map[string]string{"TRIMARK_FF_TELEPORT": "true", "TRIMARK_FF_ESI_VALIDATION": "true"}, map[Feature]bool{"teleport": false, FeatureESIValidation: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := LoadConfig(func(name string) string { return tt.env[name] })
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			for f, want := range tt.want {
+				if got := c.IsEnabled(f); got != want {
+					t.Errorf("IsEnabled(%q) = %v, want %v", f, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/firestore/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// dedupCollection is the Firestore collection checksums are recorded in, one document each
+const dedupCollection = "trimark_checksums"
+
+// DedupStore records donation checksums outside Drive, so deduplication doesn't depend on
+// Drive property queries and is shared by every instance
+type DedupStore interface {
+	// Claim atomically records a checksum, reporting false when it was recorded already
+	Claim(ctx context.Context, checksum string) (bool, error)
+	// Release forgets a claimed checksum whose row couldn't be written
+	Release(ctx context.Context, checksum string) error
+	// Clear forgets every checksum, returning how many were recorded
+	Clear(ctx context.Context) (int, error)
+}
+
+// dedupStore is nil when checksums are claimed with Drive properties, the default
+var dedupStore DedupStore
+
+// newDedupStore returns the store selected by DedupStoreEnv
+func newDedupStore(jsonPath string) (DedupStore, error) {
+	switch config.DedupStore {
+	case "":
+		return nil, nil
+	case "firestore":
+		ctx := context.Background()
+		client, err := newHTTPClient(ctx, jsonPath, quotaAPIFirestore)
+		if err != nil {
+			return nil, err
+		}
+		service, err := firestore.NewService(ctx, option.WithHTTPClient(client))
+		if err != nil {
+			return nil, err
+		}
+		return &firestoreDedupStore{
+			service: service,
+			parent:  fmt.Sprintf("projects/%s/databases/(default)/documents", config.DedupProject),
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unknown %s %q", DedupStoreEnv, config.DedupStore)
+	}
+}
+
+// firestoreDedupStore claims a checksum by creating a document named after it, which
+// Firestore refuses to do twice
+type firestoreDedupStore struct {
+	service *firestore.Service
+	parent  string
+}
+
+func (s *firestoreDedupStore) Claim(ctx context.Context, checksum string) (bool, error) {
+	doc := &firestore.Document{Fields: map[string]firestore.Value{
+		"claimedAt": {TimestampValue: time.Now().UTC().Format(time.RFC3339)},
+	}}
+	_, err := s.service.Projects.Databases.Documents.CreateDocument(s.parent, dedupCollection, doc).DocumentId(checksum).Context(ctx).Do()
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusConflict {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *firestoreDedupStore) Release(ctx context.Context, checksum string) error {
+	_, err := s.service.Projects.Databases.Documents.Delete(s.parent + "/" + dedupCollection + "/" + checksum).Context(ctx).Do()
+	return err
+}
+
+func (s *firestoreDedupStore) Clear(ctx context.Context) (int, error) {
+	cleared := 0
+	err := s.service.Projects.Databases.Documents.List(s.parent, dedupCollection).MaskFieldPaths("claimedAt").Pages(ctx, func(page *firestore.ListDocumentsResponse) error {
+		for _, doc := range page.Documents {
+			if _, err := s.service.Projects.Databases.Documents.Delete(doc.Name).Context(ctx).Do(); err != nil {
+				return err
+			}
+			cleared++
+		}
+		return nil
+	})
+	return cleared, err
+}
@@ -0,0 +1,131 @@
+package trimark
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/firestore/v1"
+	"google.golang.org/api/option"
+)
+
+// fakeFirestore serves the document create, delete and list calls of firestoreDedupStore
+type fakeFirestore struct {
+	mu   sync.Mutex
+	docs map[string]bool
+}
+
+func (f *fakeFirestore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch r.Method {
+	case http.MethodPost:
+		name := path + "/" + r.URL.Query().Get("documentId")
+		if f.docs[name] {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":{"code":409,"message":"Document already exists"}}`))
+			return
+		}
+		f.docs[name] = true
+		json.NewEncoder(w).Encode(&firestore.Document{Name: name})
+	case http.MethodDelete:
+		delete(f.docs, path)
+		w.Write([]byte("{}"))
+	case http.MethodGet:
+		var names []string
+		for name := range f.docs {
+			if strings.HasPrefix(name, path+"/") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		resp := &firestore.ListDocumentsResponse{}
+		for _, name := range names {
+			resp.Documents = append(resp.Documents, &firestore.Document{Name: name})
+		}
+		json.NewEncoder(w).Encode(resp)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestFirestoreDedupStore(t *testing.T) *firestoreDedupStore {
+	srv := httptest.NewServer(&fakeFirestore{docs: map[string]bool{}})
+	t.Cleanup(srv.Close)
+	service, err := firestore.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &firestoreDedupStore{service: service, parent: "projects/test/databases/(default)/documents"}
+}
+
+func TestFirestoreDedupStore(t *testing.T) {
+	store := newTestFirestoreDedupStore(t)
+	ctx := context.Background()
+
+	claim := func(checksum string, want bool) {
+		t.Helper()
+		got, err := store.Claim(ctx, checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Claim(%s) = %t, want %t", checksum, got, want)
+		}
+	}
+	claim("a", true)
+	claim("a", false)
+	claim("b", true)
+
+	if err := store.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	claim("a", true)
+
+	cleared, err := store.Clear(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cleared != 2 {
+		t.Errorf("Clear = %d, want 2", cleared)
+	}
+	claim("a", true)
+	claim("b", true)
+}
+
+func TestHandleResetClearsDedupStore(t *testing.T) {
+	NewTestServiceContext(t, WithConfig(func(c *Config) {
+		c.AllowReset = true
+		c.AdminToken = "secret"
+	}))
+	store := newTestFirestoreDedupStore(t)
+	dedupStore = store
+	if _, err := store.Claim(context.Background(), "checksum"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/reset?confirm="+ResetConfirmation, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	HandleReset(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleReset responded %d: %s", w.Code, w.Body)
+	}
+	var report ResetReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.ChecksumsCleared != 1 {
+		t.Errorf("ChecksumsCleared = %d, want 1", report.ChecksumsCleared)
+	}
+	if claimed, err := store.Claim(context.Background(), "checksum"); err != nil || !claimed {
+		t.Errorf("Claim after the reset = %t, %v, want the checksum forgotten", claimed, err)
+	}
+}
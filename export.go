@@ -0,0 +1,155 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// DataExporter writes donation records out in a format, returning the body and its MIME type
+type DataExporter interface {
+	Export(ctx context.Context, records []DonationRecord) ([]byte, string, error)
+}
+
+// exportHeaders are the columns of the CSV and Sheet exports
+var exportHeaders = []string{"ID", "Import Date", "Echoes Date", "Name", "Amount", "Link"}
+
+// NewExporter returns the exporter for ?format=, one of "csv", "json" or "sheet"
+func NewExporter(format string) (DataExporter, error) {
+	switch format {
+	case "csv", "":
+		return CSVExporter{}, nil
+	case "json":
+		return JSONExporter{}, nil
+	case "sheet":
+		return SheetExporter{ParentID: snapshotFolders().Report}, nil
+	default:
+		return nil, fmt.Errorf("Unknown export format %q", format)
+	}
+}
+
+// exportRow returns a record in the column order of exportHeaders
+func exportRow(record DonationRecord) []string {
+	echoesDate := ""
+	if !record.EchoesDate.IsZero() {
+		echoesDate = record.EchoesDate.Format(time.RFC3339)
+	}
+	return []string{record.ID, record.ImportDate, echoesDate, record.Name, strconv.FormatFloat(record.Amount, 'f', -1, 64), record.Link}
+}
+
+// CSVExporter exports records as CSV with a header row
+type CSVExporter struct{}
+
+// Export implements DataExporter
+func (CSVExporter) Export(ctx context.Context, records []DonationRecord) ([]byte, string, error) {
+	buf := new(bytes.Buffer)
+	cw := csv.NewWriter(buf)
+	if err := cw.Write(exportHeaders); err != nil {
+		return nil, "", err
+	}
+	for _, record := range records {
+		if err := cw.Write(exportRow(record)); err != nil {
+			return nil, "", err
+		}
+	}
+	cw.Flush()
+	return buf.Bytes(), "text/csv", cw.Error()
+}
+
+// JSONExporter exports records as a JSON array, with RFC 3339 Echoes dates
+type JSONExporter struct{}
+
+// Export implements DataExporter
+func (JSONExporter) Export(ctx context.Context, records []DonationRecord) ([]byte, string, error) {
+	if records == nil {
+		records = []DonationRecord{}
+	}
+	body, err := json.Marshal(records)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, "application/json", nil
+}
+
+// SheetExporter exports records to a new Google Sheet in ParentID, returning its link
+type SheetExporter struct {
+	ParentID string
+}
+
+// Export implements DataExporter
+func (e SheetExporter) Export(ctx context.Context, records []DonationRecord) ([]byte, string, error) {
+	name := fmt.Sprintf("%s Export %s", SheetName, time.Now().Format("2006-01-02 15:04:05"))
+	file, err := createSheet(name, e.ParentID)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to create %s: %v", name, err)
+	}
+
+	values := make([][]interface{}, 0, len(records)+1)
+	header := make([]interface{}, len(exportHeaders))
+	for i, h := range exportHeaders {
+		header[i] = h
+	}
+	values = append(values, header)
+	for _, record := range records {
+		row := exportRow(record)
+		cells := make([]interface{}, len(row))
+		for i, c := range row {
+			cells[i] = c
+		}
+		values = append(values, cells)
+	}
+
+	valueRange := &sheets.ValueRange{Values: values}
+	_, err = sheetService.Spreadsheets.Values.Update(file.Id, "Sheet1!A1", valueRange).ValueInputOption("RAW").Context(ctx).Do()
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to write %s: %v", name, err)
+	}
+	return []byte(file.AlternateLink), "text/plain", nil
+}
+
+// HandleExport exports the report in ?format=csv (the default), json or sheet. It requires the
+// AdminTokenEnv bearer token, as the report names every member.
+func HandleExport(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if !requireWarmup(w, r) {
+		return
+	}
+
+	r = r.WithContext(withFolders(r.Context(), snapshotFolders()))
+
+	exporter, err := NewExporter(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, "format must be csv, json or sheet", http.StatusBadRequest)
+		return
+	}
+
+	records, err := ReadSheetData(r.Context())
+	if err != nil {
+		log.Printf("Unable to read %s: %v", SheetName, err)
+		http.Error(w, "Unable to read sheet", http.StatusInternalServerError)
+		return
+	}
+
+	body, mimeType, err := exporter.Export(r.Context(), records)
+	if err != nil {
+		log.Printf("Unable to export %d records: %v", len(records), err)
+		http.Error(w, "Unable to export", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Unable to write export: %v", err)
+	}
+}
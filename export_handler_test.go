@@ -0,0 +1,149 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestHandleExportRequiresAdminToken(t *testing.T) {
+	NewTestServiceContext(t, WithConfig(func(c *Config) { c.AdminToken = "secret" }))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", http.StatusUnauthorized},
+		// Authorized, so it gets as far as refusing the format
+		{"admin token", "Bearer secret", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/export?format=xml", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		HandleExport(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: HandleExport responded %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func testExportRecords() []DonationRecord {
+	return []DonationRecord{
+		{ID: "1", ImportDate: "2020-06-19", EchoesDate: time.Date(2020, 6, 18, 12, 34, 56, 0, time.UTC), Name: "Pilot One", Amount: 1000, Link: "https://example.com/1"},
+		{ID: "2", ImportDate: "2020-06-19", Name: "Pilot, Two", Amount: 2500.5, Link: "https://example.com/2"},
+	}
+}
+
+func TestCSVExporter(t *testing.T) {
+	body, mimeType, err := CSVExporter{}.Export(context.Background(), testExportRecords())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mimeType != "text/csv" {
+		t.Errorf("Content type = %q, want text/csv", mimeType)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatalf("Export wrote invalid CSV: %v\n%s", err, body)
+	}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], exportHeaders) {
+		t.Fatalf("Export = %q, want the header and 2 rows", rows)
+	}
+	if want := []string{"2", "2020-06-19", "", "Pilot, Two", "2500.5", "https://example.com/2"}; !reflect.DeepEqual(rows[2], want) {
+		t.Errorf("Row 2 = %q, want %q", rows[2], want)
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	for _, records := range [][]DonationRecord{nil, testExportRecords()} {
+		body, mimeType, err := JSONExporter{}.Export(context.Background(), records)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mimeType != "application/json" {
+			t.Errorf("Content type = %q, want application/json", mimeType)
+		}
+		// No records is an empty array, not null
+		var decoded []DonationRecord
+		if len(body) == 0 || body[0] != '[' || json.Unmarshal(body, &decoded) != nil {
+			t.Fatalf("Export = %s, want a JSON array", body)
+		}
+		if len(decoded) != len(records) {
+			t.Errorf("Export has %d records, want %d", len(decoded), len(records))
+		}
+		if len(records) > 0 && !reflect.DeepEqual(decoded, records) {
+			t.Errorf("Export = %+v, want %+v", decoded, records)
+		}
+	}
+}
+
+func TestSheetExporter(t *testing.T) {
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t)
+
+	body, mimeType, err := SheetExporter{ParentID: testReportFolderID}.Export(context.Background(), testExportRecords())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mimeType != "text/plain" {
+		t.Errorf("Content type = %q, want text/plain", mimeType)
+	}
+
+	var sheet *drive.File
+	for _, f := range fakeDrive.FilesIn(testReportFolderID) {
+		if strings.HasPrefix(f.Title, SheetName+" Export ") {
+			sheet = f
+		}
+	}
+	if sheet == nil {
+		t.Fatalf("No export sheet in the Report folder")
+	}
+	if string(body) != sheet.AlternateLink {
+		t.Errorf("Export = %q, want the link %q", body, sheet.AlternateLink)
+	}
+	rows := fakeSheets.Values(sheet.Id, "Sheet1")
+	if len(rows) != 3 || rows[0][0] != "ID" || rows[1][3] != "Pilot One" {
+		t.Errorf("Export sheet = %q, want the header and 2 rows", rows)
+	}
+}
+
+func TestNewExporter(t *testing.T) {
+	NewTestServiceContext(t)
+
+	tests := []struct {
+		format string
+		want   DataExporter
+	}{
+		{"", CSVExporter{}},
+		{"csv", CSVExporter{}},
+		{"json", JSONExporter{}},
+		{"sheet", SheetExporter{ParentID: testReportFolderID}},
+	}
+	for _, tt := range tests {
+		exporter, err := NewExporter(tt.format)
+		if err != nil {
+			t.Errorf("NewExporter(%q) failed: %v", tt.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(exporter, tt.want) {
+			t.Errorf("NewExporter(%q) = %#v, want %#v", tt.format, exporter, tt.want)
+		}
+	}
+
+	if _, err := NewExporter("xml"); err == nil {
+		t.Error("NewExporter(\"xml\") succeeded")
+	}
+}
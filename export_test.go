@@ -0,0 +1,1542 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// The IDs of the folders and report sheet NewTestServiceContext sets up
+const (
+	testMasterFolderID    = "trimark-test-master-folder-id"
+	testUploadFolderID    = "upload-folder-id"
+	testProcessedFolderID = "processed-folder-id"
+	testFailedFolderID    = "failed-folder-id"
+	testReportFolderID    = "report-folder-id"
+	testSheetID           = "report-sheet-id"
+)
+
+// testSetup is what a test's TestOptions change about the state NewTestServiceContext sets up
+type testSetup struct {
+	configure []func(c *Config)
+	files     []*drive.File
+	rows      [][]interface{}
+}
+
+// TestOption sets up part of the state a NewTestServiceContext test starts with
+type TestOption func(s *testSetup)
+
+// WithPreloadedFiles adds files to Drive before the test starts. Files without parents are put in
+// the Upload folder, and their content can be set with FakeDriveService.SetContent.
+func WithPreloadedFiles(files []*drive.File) TestOption {
+	return func(s *testSetup) {
+		s.files = append(s.files, files...)
+	}
+}
+
+// WithExistingSheetRows adds rows below the report's header, entered as a user would type them
+func WithExistingSheetRows(rows [][]interface{}) TestOption {
+	return func(s *testSetup) {
+		s.rows = append(s.rows, rows...)
+	}
+}
+
+// WithConfig changes the config before the report's header is written, so optional columns are in it
+func WithConfig(configure func(c *Config)) TestOption {
+	return func(s *testSetup) {
+		s.configure = append(s.configure, configure)
+	}
+}
+
+// NewTestServiceContext points the package at in-memory fakes of Drive and Sheets, with the
+// folders and a report sheet with its header already set up, as warmup would have left them.
+// Everything it sets, and anything the test changes, is reset when the test ends, so tests using
+// it can't run in parallel.
+func NewTestServiceContext(t testing.TB, opts ...TestOption) (*ServiceContext, *FakeDriveService, *FakeSheetsService) {
+	t.Helper()
+
+	var setup testSetup
+	for _, opt := range opts {
+		opt(&setup)
+	}
+
+	resetPackageState()
+	fakeSheets := &FakeSheetsService{spreadsheets: map[string]*fakeSpreadsheet{}}
+	fakeDrive := &FakeDriveService{files: map[string]*fakeFile{}, ocrText: map[string][]string{}, sheets: fakeSheets, start: time.Now().UTC()}
+	srv := httptest.NewServer(fakeAPIs(fakeDrive, fakeSheets))
+	t.Cleanup(func() {
+		srv.Close()
+		resetPackageState()
+	})
+
+	// Responses go through the same transport as the real clients, so the quotas are tracked
+	client := func(api string) *http.Client {
+		return &http.Client{Transport: &apiWarningTransport{
+			Base:   srv.Client().Transport,
+			Logger: log.New(os.Stderr, "", log.LstdFlags),
+			Quota:  quotaMonitor,
+			API:    api,
+		}}
+	}
+	var err error
+	driveService, err = drive.NewService(context.Background(), option.WithHTTPClient(client(quotaAPIDrive)), option.WithEndpoint(srv.URL+"/drive/v2/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheetService, err = sheets.NewService(context.Background(), option.WithHTTPClient(client(quotaAPISheets)), option.WithEndpoint(srv.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Initialize would replace the fakes with clients from service.json
+	initOnce.Do(func() {})
+	if !WarmupComplete() {
+		close(warmupDone)
+	}
+	warmupErr = nil
+
+	config.FolderID = testMasterFolderID
+	for _, configure := range setup.configure {
+		configure(&config)
+	}
+	setReportRanges(config.SummaryRowPolicy)
+
+	fakeDrive.add(&drive.File{Id: testMasterFolderID, Title: "Trimark", MimeType: FolderMimeType}, nil)
+	for _, folder := range []struct{ id, name string }{
+		{testUploadFolderID, UploadFolderName},
+		{testProcessedFolderID, ProcessedFolderName},
+		{testFailedFolderID, FailedFolderName},
+		{testReportFolderID, ReportFolderName},
+	} {
+		fakeDrive.add(&drive.File{Id: folder.id, Title: folder.name, MimeType: FolderMimeType, Parents: parentRefs(testMasterFolderID)}, nil)
+	}
+	fakeDrive.add(&drive.File{Id: testSheetID, Title: SheetName, MimeType: SpreadsheetMimeType, Parents: parentRefs(testReportFolderID)}, nil)
+	UploadFolderID, ProcessedFolderID, FailedFolderID, ReportFolderID = testUploadFolderID, testProcessedFolderID, testFailedFolderID, testReportFolderID
+	SheetID = testSheetID
+	lastFolderValidation = time.Now()
+
+	if err := writeSheetHeader(SheetID); err != nil {
+		t.Fatalf("Writing the report header: %v", err)
+	}
+	if len(setup.rows) > 0 {
+		rows := fmt.Sprintf("Sheet1!A%d", headerRowIndex()+2)
+		_, err := sheetService.Spreadsheets.Values.Update(SheetID, rows, &sheets.ValueRange{Values: setup.rows}).ValueInputOption("USER_ENTERED").Do()
+		if err != nil {
+			t.Fatalf("Writing the existing rows: %v", err)
+		}
+	}
+
+	for _, f := range setup.files {
+		if len(f.Parents) == 0 {
+			f.Parents = parentRefs(testUploadFolderID)
+		}
+		fakeDrive.AddFile(f, nil)
+	}
+
+	fakeDrive.resetRequests()
+	fakeSheets.resetRequests()
+	return newServiceContext(), fakeDrive, fakeSheets
+}
+
+// resetPackageState puts the package's clients, folder IDs, caches and counters back as they are
+// before Initialize has run
+func resetPackageState() {
+	driveService, sheetService, storageService = nil, nil, nil
+	UploadFolderID, ProcessedFolderID, FailedFolderID, ReportFolderID = "", "", "", ""
+	OCRArchiveFolderID, BackupFolderID, SheetID = "", "", ""
+	LastSetupReport = FolderSetupReport{}
+	lastFolderValidation = time.Time{}
+
+	config = defaultConfig()
+	setReportRanges(config.SummaryRowPolicy)
+	dedupStore = nil
+	sheetsLimiter = nil
+	emailNotifier = nil
+	quotaMonitor = newQuotaMonitor()
+	patternStats = PatternStats{}
+	secondaryOCRStats = SecondaryOCRStats{}
+	atomic.StoreInt64(&apiDeprecationWarnings, 0)
+
+	monthlySheetIDsMu.Lock()
+	monthlySheetIDs = map[string]string{}
+	monthlySheetIDsMu.Unlock()
+	backupMu.Lock()
+	backupFileIDs = map[string]string{}
+	backupMu.Unlock()
+	failuresMu.Lock()
+	failuresTabReady = false
+	failuresMu.Unlock()
+	memberTotalsMu.Lock()
+	memberTotalsTabReady = false
+	memberTotalsMu.Unlock()
+	monthlyReportCacheMu.Lock()
+	monthlyReportCache = map[string]cachedMonthlyReport{}
+	monthlyReportCacheMu.Unlock()
+	lastStageDurationsMu.Lock()
+	lastStageDurations = nil
+	lastStageDurationsMu.Unlock()
+	watchMu.Lock()
+	watchChannel = nil
+	watchMu.Unlock()
+	watchRunMu.Lock()
+	watchRunning, watchRerun = false, false
+	watchRunMu.Unlock()
+}
+
+// parentRefs is the Parents of a file in the given folders
+func parentRefs(ids ...string) []*drive.ParentReference {
+	var parents []*drive.ParentReference
+	for _, id := range ids {
+		parents = append(parents, &drive.ParentReference{Id: id})
+	}
+	return parents
+}
+
+// donationText is the text Drive exports from the OCR of a donation screenshot, with its CRLF line endings
+func donationText(date, username, quantity string) string {
+	return strings.Join([]string{"Corporation Wallet", "Transaction Details", date, "Member Donation [" + username + "]", "Type", quantity + " ISK", "Close"}, "\r\n")
+}
+
+// testPNG is a screenshot to upload, the OCR text of which is set with FakeDriveService.SetOCRText
+func testPNG(t testing.TB) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// batchResult is the outcome of a file processed by runBatch
+type batchResult struct {
+	result ExtractionResult
+	err    error
+}
+
+// runBatch processes every upload with sc, failing the test if the batch couldn't run
+func runBatch(t testing.TB, sc *ServiceContext) []batchResult {
+	t.Helper()
+	var results []batchResult
+	err := sc.processBatch(context.Background(), 0, func(result ExtractionResult, err error) {
+		results = append(results, batchResult{result, err})
+	})
+	if err != nil {
+		t.Fatalf("processBatch: %v", err)
+	}
+	return results
+}
+
+// fakeAPIs serves the fakes at the paths the Drive v2 and Sheets v4 clients call
+func fakeAPIs(d *FakeDriveService, s *FakeSheetsService) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/drive/v2/", d.serveHTTP)
+	mux.HandleFunc("/upload/drive/v2/", d.serveHTTP)
+	mux.HandleFunc("/v4/spreadsheets/", s.serveHTTP)
+	return mux
+}
+
+// fakeAPI is what the fakes share, a log of the requests they were sent and the failures a test injected
+type fakeAPI struct {
+	logMu    sync.Mutex
+	requests []string
+	failures []fakeFailure
+	latency  time.Duration
+}
+
+type fakeFailure struct {
+	method string
+	path   string
+	err    *googleapi.Error
+}
+
+// Fail makes the requests with method whose path contains path fail with err, until ClearFailures.
+// Paths are as sent, unescaped, such as "/v4/spreadsheets/report-sheet-id/values/Sheet1!A1:G1:append".
+func (a *fakeAPI) Fail(method, path string, err *googleapi.Error) {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	a.failures = append(a.failures, fakeFailure{method: method, path: path, err: err})
+}
+
+// SetLatency delays every response by d, such as to have a call outlast a deadline
+func (a *fakeAPI) SetLatency(d time.Duration) {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	a.latency = d
+}
+
+// ClearFailures stops the failures injected by Fail
+func (a *fakeAPI) ClearFailures() {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	a.failures = nil
+}
+
+// Requests lists the requests served since the test started, as "METHOD path", with "?alt=media"
+// after the path of a download
+func (a *fakeAPI) Requests() []string {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	return append([]string(nil), a.requests...)
+}
+
+func (a *fakeAPI) resetRequests() {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	a.requests = nil
+}
+
+// begin logs a request, returning the failure injected for it or nil
+func (a *fakeAPI) begin(r *http.Request) *googleapi.Error {
+	a.logMu.Lock()
+	request := r.Method + " " + r.URL.Path
+	if r.URL.Query().Get("alt") == "media" {
+		request += "?alt=media"
+	}
+	a.requests = append(a.requests, request)
+	latency := a.latency
+	var failure *googleapi.Error
+	for _, f := range a.failures {
+		if f.method == r.Method && strings.Contains(r.URL.Path, f.path) {
+			failure = f.err
+			break
+		}
+	}
+	a.logMu.Unlock()
+
+	time.Sleep(latency)
+	return failure
+}
+
+// writeAPIError writes an error response in the format googleapi.CheckResponse parses
+func writeAPIError(w http.ResponseWriter, e *googleapi.Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{
+		"code":    e.Code,
+		"message": e.Message,
+		"errors":  e.Errors,
+	}})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func badRequest(w http.ResponseWriter, format string, v ...interface{}) {
+	writeAPIError(w, &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf(format, v...)})
+}
+
+func notFound(w http.ResponseWriter, what string) {
+	writeAPIError(w, &googleapi.Error{Code: http.StatusNotFound, Message: "Not found: " + what, Errors: []googleapi.ErrorItem{{Reason: "notFound"}}})
+}
+
+// FakeDriveService is an in-memory Drive v2 API, serving the calls the package makes
+type FakeDriveService struct {
+	fakeAPI
+
+	mu    sync.Mutex
+	files map[string]*fakeFile
+	// order is the IDs in the order the files were added, for listings without an orderBy
+	order []string
+	// ocrText is the text each OCR of an upload produces in turn, by the upload's title
+	ocrText map[string][]string
+	next    int
+	start   time.Time
+
+	// PageSize is the most files a listing returns a page, 100 when it's 0
+	PageSize int
+
+	sheets *FakeSheetsService
+}
+
+type fakeFile struct {
+	file    *drive.File
+	content []byte
+}
+
+// AddFile adds a file with its content, returning it with the fields Drive fills in
+func (d *FakeDriveService) AddFile(f *drive.File, content []byte) *drive.File {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return cloneFile(d.add(cloneFile(f), content))
+}
+
+// SetContent replaces the content of a file
+func (d *FakeDriveService) SetContent(id string, content []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f := d.files[id]; f != nil {
+		f.content = content
+		f.file.FileSize = int64(len(content))
+	}
+}
+
+// SetOCRText sets the text Drive reads from an upload of the given title when it's converted to a doc
+func (d *FakeDriveService) SetOCRText(title, text string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ocrText[title] = []string{text}
+}
+
+// SetOCRTexts sets the text of each conversion of an upload of the given title in turn, the
+// last being read from then on, as an OCR which is flaky at first
+func (d *FakeDriveService) SetOCRTexts(title string, texts ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ocrText[title] = texts
+}
+
+// File returns a copy of a file, or nil when there is none
+func (d *FakeDriveService) File(id string) *drive.File {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f := d.files[id]; f != nil {
+		return cloneFile(f.file)
+	}
+	return nil
+}
+
+// Content returns the content of a file
+func (d *FakeDriveService) Content(id string) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f := d.files[id]; f != nil {
+		return f.content
+	}
+	return nil
+}
+
+// FilesIn lists copies of the files in a folder, in the order they were added
+func (d *FakeDriveService) FilesIn(folderID string) []*drive.File {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var files []*drive.File
+	for _, id := range d.order {
+		if f := d.files[id]; f != nil && inFolder(f.file, folderID) {
+			files = append(files, cloneFile(f.file))
+		}
+	}
+	return files
+}
+
+func cloneFile(f *drive.File) *drive.File {
+	b, err := json.Marshal(f)
+	if err != nil {
+		panic(err)
+	}
+	clone := &drive.File{}
+	if err := json.Unmarshal(b, clone); err != nil {
+		panic(err)
+	}
+	return clone
+}
+
+func inFolder(f *drive.File, folderID string) bool {
+	for _, p := range f.Parents {
+		if p.Id == folderID {
+			return true
+		}
+	}
+	return false
+}
+
+// add stores a file, filling in the fields Drive sets. Uploads converted to a doc are OCRed, and
+// new spreadsheets are created in the Sheets fake. It must be called with d.mu held.
+func (d *FakeDriveService) add(f *drive.File, content []byte) *drive.File {
+	d.next++
+	if f.Id == "" {
+		f.Id = fmt.Sprintf("fake-file-%04d", d.next)
+	}
+	// Listings are ordered by createdDate, so every file gets its own
+	now := d.start.Add(time.Duration(d.next) * time.Millisecond).Format("2006-01-02T15:04:05.000Z")
+	if f.CreatedDate == "" {
+		f.CreatedDate = now
+	}
+	if f.ModifiedDate == "" {
+		f.ModifiedDate = now
+	}
+	if f.MimeType == "" {
+		f.MimeType = octetStreamMimeType
+	}
+	f.Kind = "drive#file"
+	f.AlternateLink = "https://drive.google.com/file/d/" + f.Id + "/view"
+
+	switch f.MimeType {
+	case DocumentMimeType:
+		f.DefaultOpenWithLink = "https://docs.google.com/document/d/" + f.Id + "/edit"
+		if content != nil {
+			title := strings.TrimSuffix(f.Title, ocrDocSuffix)
+			content = []byte{}
+			if texts := d.ocrText[title]; len(texts) > 0 {
+				content = []byte(texts[0])
+				if len(texts) > 1 {
+					d.ocrText[title] = texts[1:]
+				}
+			}
+		}
+	case SpreadsheetMimeType:
+		f.DefaultOpenWithLink = "https://docs.google.com/spreadsheets/d/" + f.Id + "/edit"
+		d.sheets.addSpreadsheet(f.Id)
+	case FolderMimeType:
+	default:
+		if content != nil {
+			f.FileSize = int64(len(content))
+		}
+	}
+
+	d.files[f.Id] = &fakeFile{file: f, content: content}
+	d.order = append(d.order, f.Id)
+	return f
+}
+
+func (d *FakeDriveService) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if e := d.begin(r); e != nil {
+		writeAPIError(w, e)
+		return
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/upload"), "/drive/v2/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "files" && r.Method == http.MethodGet:
+		d.list(w, r)
+	case path == "files" && r.Method == http.MethodPost:
+		d.insert(w, r)
+	case path == "channels/stop" && r.Method == http.MethodPost:
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[0] == "files":
+		d.serveFile(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "files" && r.Method == http.MethodPost && parts[2] == "copy":
+		d.copy(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "files" && r.Method == http.MethodGet && parts[2] == "export":
+		d.export(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "files" && r.Method == http.MethodPost && parts[2] == "properties":
+		d.insertProperty(w, r, parts[1])
+	case len(parts) == 4 && parts[0] == "files" && r.Method == http.MethodDelete && parts[2] == "properties":
+		d.deleteProperty(w, r, parts[1], parts[3])
+	case len(parts) == 3 && parts[0] == "files" && r.Method == http.MethodPost && parts[2] == "watch":
+		writeJSON(w, &drive.Channel{Kind: "api#channel", ResourceId: "resource-" + parts[1], Expiration: time.Now().Add(time.Hour).Unix() * 1000})
+	default:
+		notFound(w, r.Method+" "+r.URL.Path)
+	}
+}
+
+func (d *FakeDriveService) serveFile(w http.ResponseWriter, r *http.Request, id string) {
+	var meta *drive.File
+	var content []byte
+	if r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		var err error
+		meta, content, err = readUpload(r)
+		if err != nil {
+			badRequest(w, "%v", err)
+			return
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.files[id]
+	if f == nil {
+		notFound(w, "File "+id)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Get("alt") == "media" {
+			w.Header().Set("Content-Type", f.file.MimeType)
+			w.Write(f.content)
+			return
+		}
+	case http.MethodDelete:
+		delete(d.files, id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPut:
+		// Parents are changed with addParents and removeParents, the stale ones sent with a move are ignored
+		q := r.URL.Query()
+		if remove := q.Get("removeParents"); remove != "" {
+			for _, parent := range strings.Split(remove, ",") {
+				for i, p := range f.file.Parents {
+					if p.Id == parent {
+						f.file.Parents = append(f.file.Parents[:i:i], f.file.Parents[i+1:]...)
+						break
+					}
+				}
+			}
+		}
+		if add := q.Get("addParents"); add != "" {
+			for _, parent := range strings.Split(add, ",") {
+				if !inFolder(f.file, parent) {
+					f.file.Parents = append(f.file.Parents, &drive.ParentReference{Id: parent})
+				}
+			}
+		}
+		if meta.Title != "" {
+			f.file.Title = meta.Title
+		}
+		if content != nil {
+			f.content = content
+			f.file.FileSize = int64(len(content))
+		}
+		f.file.ModifiedDate = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	case http.MethodPatch:
+		if meta.Title != "" {
+			f.file.Title = meta.Title
+		}
+		if meta.Description != "" {
+			f.file.Description = meta.Description
+		}
+		for _, p := range meta.Properties {
+			setProperty(f.file, p)
+		}
+		f.file.ModifiedDate = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	default:
+		notFound(w, r.Method+" "+r.URL.Path)
+		return
+	}
+	writeJSON(w, f.file)
+}
+
+// readUpload reads the metadata, and the media of a multipart upload, from a request body
+func readUpload(r *http.Request) (meta *drive.File, content []byte, err error) {
+	meta = &drive.File{}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return meta, nil, json.NewDecoder(r.Body).Decode(meta)
+	}
+
+	parts := multipart.NewReader(r.Body, params["boundary"])
+	part, err := parts.NextPart()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.NewDecoder(part).Decode(meta); err != nil {
+		return nil, nil, err
+	}
+	part, err = parts.NextPart()
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err = ioutil.ReadAll(part)
+	return meta, content, err
+}
+
+func (d *FakeDriveService) insert(w http.ResponseWriter, r *http.Request) {
+	meta, content, err := readUpload(r)
+	if err != nil {
+		badRequest(w, "%v", err)
+		return
+	}
+	meta.Id = ""
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	writeJSON(w, d.add(meta, content))
+}
+
+func (d *FakeDriveService) copy(w http.ResponseWriter, r *http.Request, id string) {
+	meta, _, err := readUpload(r)
+	if err != nil {
+		badRequest(w, "%v", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.files[id]
+	if f == nil {
+		notFound(w, "File "+id)
+		return
+	}
+	c := cloneFile(f.file)
+	c.Id, c.CreatedDate, c.ModifiedDate = "", "", ""
+	if meta.Title != "" {
+		c.Title = meta.Title
+	}
+	if len(meta.Parents) > 0 {
+		c.Parents = meta.Parents
+	}
+	if len(meta.Properties) > 0 {
+		c.Properties = meta.Properties
+	}
+	c = d.add(c, nil)
+	d.files[c.Id].content = f.content
+	writeJSON(w, c)
+}
+
+func (d *FakeDriveService) export(w http.ResponseWriter, r *http.Request, id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.files[id]
+	if f == nil {
+		notFound(w, "File "+id)
+		return
+	}
+	if f.file.MimeType != DocumentMimeType {
+		writeAPIError(w, &googleapi.Error{Code: http.StatusForbidden, Message: "Export only supports Docs Editors files.", Errors: []googleapi.ErrorItem{{Reason: "fileNotExportable"}}})
+		return
+	}
+
+	switch r.URL.Query().Get("mimeType") {
+	case "text/plain":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(f.content)
+	case "text/html":
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head><style>p{}</style></head><body>")
+		for _, line := range strings.Split(string(f.content), "\r\n") {
+			fmt.Fprintf(w, "<p>%s</p>", html.EscapeString(line))
+		}
+		fmt.Fprint(w, "</body></html>")
+	default:
+		badRequest(w, "Unsupported export type %s", r.URL.Query().Get("mimeType"))
+	}
+}
+
+func (d *FakeDriveService) insertProperty(w http.ResponseWriter, r *http.Request, id string) {
+	p := &drive.Property{}
+	if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+		badRequest(w, "%v", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.files[id]
+	if f == nil {
+		notFound(w, "File "+id)
+		return
+	}
+	setProperty(f.file, p)
+	writeJSON(w, p)
+}
+
+func (d *FakeDriveService) deleteProperty(w http.ResponseWriter, r *http.Request, id, key string) {
+	visibility := r.URL.Query().Get("visibility")
+	if visibility == "" {
+		visibility = "PRIVATE"
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.files[id]
+	if f == nil {
+		notFound(w, "File "+id)
+		return
+	}
+	for i, p := range f.file.Properties {
+		if p.Key == key && p.Visibility == visibility {
+			f.file.Properties = append(f.file.Properties[:i], f.file.Properties[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	notFound(w, "Property "+key)
+}
+
+// setProperty adds a property to a file, replacing one with the same key and visibility
+func setProperty(f *drive.File, p *drive.Property) {
+	for _, existing := range f.Properties {
+		if existing.Key == p.Key && existing.Visibility == p.Visibility {
+			existing.Value = p.Value
+			return
+		}
+	}
+	f.Properties = append(f.Properties, &drive.Property{Key: p.Key, Value: p.Value, Visibility: p.Visibility})
+}
+
+func (d *FakeDriveService) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	match, err := parseDriveQuery(q.Get("q"))
+	if err != nil {
+		badRequest(w, "Invalid query %q: %v", q.Get("q"), err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var items []*drive.File
+	for _, id := range d.order {
+		if f := d.files[id]; f != nil && match(f.file) {
+			items = append(items, f.file)
+		}
+	}
+	if q.Get("orderBy") == "createdDate" {
+		sort.SliceStable(items, func(i, j int) bool { return items[i].CreatedDate < items[j].CreatedDate })
+	}
+
+	size := d.PageSize
+	if size == 0 {
+		size = 100
+	}
+	if max, err := strconv.Atoi(q.Get("maxResults")); err == nil && max < size {
+		size = max
+	}
+	offset, _ := strconv.Atoi(q.Get("pageToken"))
+	if offset > len(items) {
+		offset = len(items)
+	}
+	end := offset + size
+	list := &drive.FileList{Kind: "drive#fileList"}
+	if end < len(items) {
+		list.NextPageToken = strconv.Itoa(end)
+	} else {
+		end = len(items)
+	}
+	list.Items = items[offset:end]
+	writeJSON(w, list)
+}
+
+var (
+	queryInParentsRegex  = regexp.MustCompile(`^'((?:[^'\\]|\\.)*)' in parents$`)
+	queryFieldRegex      = regexp.MustCompile(`^(title|mimeType|trashed|key|value|visibility)\s*(=|!=)\s*(?:'((?:[^'\\]|\\.)*)'|(true|false))$`)
+	queryPropertiesRegex = regexp.MustCompile(`^properties has \{(.*)\}$`)
+	queryUnescaper       = strings.NewReplacer(`\'`, `'`, `\\`, `\`)
+)
+
+// parseDriveQuery turns the parts of the Drive v2 query language the package uses into a filter.
+// Anything else is an error, so a new kind of query isn't silently matched wrongly.
+func parseDriveQuery(q string) (func(f *drive.File) bool, error) {
+	var terms []func(f *drive.File) bool
+	for _, clause := range splitQuery(q) {
+		if m := queryInParentsRegex.FindStringSubmatch(clause); m != nil {
+			parent := queryUnescaper.Replace(m[1])
+			terms = append(terms, func(f *drive.File) bool { return inFolder(f, parent) })
+			continue
+		}
+		if m := queryPropertiesRegex.FindStringSubmatch(clause); m != nil {
+			want := &drive.Property{}
+			for _, part := range splitQuery(strings.TrimSpace(m[1])) {
+				fm := queryFieldRegex.FindStringSubmatch(part)
+				if fm == nil || fm[2] != "=" {
+					return nil, fmt.Errorf("unsupported property condition %q", part)
+				}
+				switch v := queryUnescaper.Replace(fm[3]); fm[1] {
+				case "key":
+					want.Key = v
+				case "value":
+					want.Value = v
+				case "visibility":
+					want.Visibility = v
+				default:
+					return nil, fmt.Errorf("unsupported property condition %q", part)
+				}
+			}
+			terms = append(terms, func(f *drive.File) bool {
+				for _, p := range f.Properties {
+					if p.Key == want.Key && p.Value == want.Value && (want.Visibility == "" || p.Visibility == want.Visibility) {
+						return true
+					}
+				}
+				return false
+			})
+			continue
+		}
+		m := queryFieldRegex.FindStringSubmatch(clause)
+		if m == nil {
+			return nil, fmt.Errorf("unsupported condition %q", clause)
+		}
+		field, negate, value := m[1], m[2] == "!=", queryUnescaper.Replace(m[3])
+		if m[4] != "" {
+			value = m[4]
+		}
+		var get func(f *drive.File) string
+		switch field {
+		case "title":
+			get = func(f *drive.File) string { return f.Title }
+		case "mimeType":
+			get = func(f *drive.File) string { return f.MimeType }
+		case "trashed":
+			get = func(f *drive.File) string { return strconv.FormatBool(f.Labels != nil && f.Labels.Trashed) }
+		default:
+			return nil, fmt.Errorf("unsupported condition %q", clause)
+		}
+		terms = append(terms, func(f *drive.File) bool { return (get(f) == value) != negate })
+	}
+
+	return func(f *drive.File) bool {
+		for _, term := range terms {
+			if !term(f) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// splitQuery splits a query on the ands outside quotes and braces
+func splitQuery(q string) []string {
+	var clauses []string
+	quoted, depth, start := false, 0, 0
+	for i := 0; i < len(q); i++ {
+		switch c := q[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		case depth == 0 && c == ' ' && strings.HasPrefix(strings.ToLower(q[i:]), " and "):
+			clauses = append(clauses, strings.TrimSpace(q[start:i]))
+			start = i + len(" and ")
+			i = start - 1
+		}
+	}
+	if rest := strings.TrimSpace(q[start:]); rest != "" {
+		clauses = append(clauses, rest)
+	}
+	return clauses
+}
+
+// FakeSheetsService is an in-memory Sheets v4 API, serving the calls the package makes. Values
+// entered as USER_ENTERED are parsed as Sheets would, into numbers, dates, booleans and the
+// SUM formulas of the summary row.
+type FakeSheetsService struct {
+	fakeAPI
+
+	mu           sync.Mutex
+	spreadsheets map[string]*fakeSpreadsheet
+	rewrite      func(values [][]interface{}) [][]interface{}
+}
+
+// RewriteAppends changes the values of each append before they're written, as a concurrent
+// edit or a sheet dropping a write would. The response still reports the rows as appended.
+func (s *FakeSheetsService) RewriteAppends(rewrite func(values [][]interface{}) [][]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rewrite = rewrite
+}
+
+type fakeSpreadsheet struct {
+	tabs      []*fakeTab
+	nextTabID int64
+}
+
+type fakeTab struct {
+	props              *sheets.SheetProperties
+	rows               [][]fakeCell
+	conditionalFormats []*sheets.ConditionalFormatRule
+	// columnFormats are the formats repeated down each column, by column index
+	columnFormats map[int64]*sheets.CellFormat
+}
+
+// fakeCell is a cell as it was written, nil for an empty cell
+type fakeCell struct {
+	value interface{}
+	// userEntered is set for text typed in, which Sheets parses as a formula, number, date or bool
+	userEntered bool
+}
+
+// addSpreadsheet creates a spreadsheet with the Sheet1 tab a new spreadsheet has
+func (s *FakeSheetsService) addSpreadsheet(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spreadsheets[id] = &fakeSpreadsheet{
+		tabs:      []*fakeTab{{props: &sheets.SheetProperties{SheetId: 0, Title: "Sheet1", Index: 0}}},
+		nextTabID: 1,
+	}
+}
+
+// Values returns a range of a spreadsheet as a Get would, with the values as they are displayed
+func (s *FakeSheetsService) Values(spreadsheetID, a1 string) [][]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss := s.spreadsheets[spreadsheetID]
+	if ss == nil {
+		return nil
+	}
+	rng, err := parseA1(a1)
+	if err != nil {
+		panic(err)
+	}
+	tab := ss.tab(rng.tab)
+	if tab == nil {
+		return nil
+	}
+	return tab.values(rng, false, true)
+}
+
+// ColumnFormat returns the format last repeated down a column of a tab, nil when there's none
+func (s *FakeSheetsService) ColumnFormat(spreadsheetID, title string, col int64) *sheets.CellFormat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss := s.spreadsheets[spreadsheetID]
+	if ss == nil {
+		return nil
+	}
+	tab := ss.tab(title)
+	if tab == nil {
+		return nil
+	}
+	return tab.columnFormats[col]
+}
+
+// Formulas returns a range of a spreadsheet with the cells as they were entered, formulas
+// rather than their values
+func (s *FakeSheetsService) Formulas(spreadsheetID, a1 string) [][]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss := s.spreadsheets[spreadsheetID]
+	if ss == nil {
+		return nil
+	}
+	rng, err := parseA1(a1)
+	if err != nil {
+		panic(err)
+	}
+	tab := ss.tab(rng.tab)
+	if tab == nil {
+		return nil
+	}
+	var values [][]interface{}
+	for row := rng.startRow; row < len(tab.rows) && (rng.endRow < 0 || row < rng.endRow); row++ {
+		cells := []interface{}{}
+		for col := rng.startCol; col < len(tab.rows[row]) && (rng.endCol < 0 || col < rng.endCol); col++ {
+			v := tab.rows[row][col].value
+			if v == nil {
+				v = ""
+			}
+			cells = append(cells, v)
+		}
+		values = append(values, cells)
+	}
+	return values
+}
+
+func (ss *fakeSpreadsheet) tab(title string) *fakeTab {
+	for _, t := range ss.tabs {
+		if t.props.Title == title {
+			return t
+		}
+	}
+	return nil
+}
+
+func (ss *fakeSpreadsheet) tabByID(id int64) *fakeTab {
+	for _, t := range ss.tabs {
+		if t.props.SheetId == id {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *FakeSheetsService) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if e := s.begin(r); e != nil {
+		writeAPIError(w, e)
+		return
+	}
+
+	// The range is escaped, so one holding a / or : isn't split
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/v4/spreadsheets/")
+	id, a1, action := path, "", ""
+	if i := strings.Index(path, "/values/"); i >= 0 {
+		id, a1 = path[:i], path[i+len("/values/"):]
+		for _, suffix := range []string{":append", ":clear"} {
+			if strings.HasSuffix(a1, suffix) {
+				a1, action = strings.TrimSuffix(a1, suffix), suffix
+			}
+		}
+	} else if strings.HasSuffix(id, ":batchUpdate") {
+		id, action = strings.TrimSuffix(id, ":batchUpdate"), ":batchUpdate"
+	}
+	id, _ = url.PathUnescape(id)
+	a1, _ = url.PathUnescape(a1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss := s.spreadsheets[id]
+	if ss == nil {
+		notFound(w, "Spreadsheet "+id)
+		return
+	}
+
+	if a1 == "" {
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			s.get(w, id, ss)
+		case action == ":batchUpdate" && r.Method == http.MethodPost:
+			s.batchUpdate(w, r, id, ss)
+		default:
+			notFound(w, r.Method+" "+r.URL.Path)
+		}
+		return
+	}
+
+	rng, err := parseA1(a1)
+	if err != nil {
+		badRequest(w, "Unable to parse range: %s", a1)
+		return
+	}
+	tab := ss.tab(rng.tab)
+	if tab == nil {
+		badRequest(w, "Unable to parse range: %s", a1)
+		return
+	}
+	q := r.URL.Query()
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		unformatted := q.Get("valueRenderOption") == "UNFORMATTED_VALUE"
+		serial := q.Get("dateTimeRenderOption") != "FORMATTED_STRING"
+		writeJSON(w, &sheets.ValueRange{Range: rng.String(), MajorDimension: "ROWS", Values: tab.values(rng, unformatted, serial)})
+	case action == "" && r.Method == http.MethodPut:
+		vr := &sheets.ValueRange{}
+		if err := json.NewDecoder(r.Body).Decode(vr); err != nil {
+			badRequest(w, "%v", err)
+			return
+		}
+		updated := tab.write(rng.startRow, rng.startCol, vr.Values, q.Get("valueInputOption") == "USER_ENTERED")
+		writeJSON(w, &sheets.UpdateValuesResponse{SpreadsheetId: id, UpdatedRange: updated.String(), UpdatedRows: int64(len(vr.Values))})
+	case action == ":append" && r.Method == http.MethodPost:
+		vr := &sheets.ValueRange{}
+		if err := json.NewDecoder(r.Body).Decode(vr); err != nil {
+			badRequest(w, "%v", err)
+			return
+		}
+		s.append(w, q, id, tab, rng, vr.Values)
+	case action == ":clear" && r.Method == http.MethodPost:
+		for row := rng.startRow; row < len(tab.rows) && (rng.endRow < 0 || row < rng.endRow); row++ {
+			for col := rng.startCol; col < len(tab.rows[row]) && (rng.endCol < 0 || col < rng.endCol); col++ {
+				tab.rows[row][col] = fakeCell{}
+			}
+		}
+		writeJSON(w, &sheets.ClearValuesResponse{SpreadsheetId: id, ClearedRange: rng.String()})
+	default:
+		notFound(w, r.Method+" "+r.URL.Path)
+	}
+}
+
+func (s *FakeSheetsService) get(w http.ResponseWriter, id string, ss *fakeSpreadsheet) {
+	resp := &sheets.Spreadsheet{SpreadsheetId: id}
+	for _, tab := range ss.tabs {
+		resp.Sheets = append(resp.Sheets, &sheets.Sheet{Properties: tab.props, ConditionalFormats: tab.conditionalFormats})
+	}
+	writeJSON(w, resp)
+}
+
+// append writes values below the table found from the range's first row, as Sheets does: the
+// rows from there down to the first empty row
+func (s *FakeSheetsService) append(w http.ResponseWriter, q url.Values, id string, tab *fakeTab, rng fakeRange, values [][]interface{}) {
+	table := rng
+	target := rng.startRow
+	if !tab.rowEmpty(rng.startRow) {
+		last := rng.startRow
+		for !tab.rowEmpty(last + 1) {
+			last++
+		}
+		table.endRow = last + 1
+		target = last + 1
+	}
+	if q.Get("insertDataOption") == "INSERT_ROWS" && target < len(tab.rows) {
+		tab.insertRows(target, len(values))
+	}
+	written := values
+	if s.rewrite != nil {
+		written = s.rewrite(values)
+	}
+
+	updated := tab.write(target, rng.startCol, written, q.Get("valueInputOption") == "USER_ENTERED")
+	resp := &sheets.AppendValuesResponse{
+		SpreadsheetId: id,
+		Updates: &sheets.UpdateValuesResponse{
+			SpreadsheetId: id,
+			UpdatedRange:  updated.String(),
+			UpdatedRows:   int64(len(values)),
+		},
+	}
+	if table.endRow > table.startRow {
+		resp.TableRange = table.String()
+	}
+	if q.Get("includeValuesInResponse") == "true" {
+		resp.Updates.UpdatedData = &sheets.ValueRange{Range: updated.String(), MajorDimension: "ROWS", Values: tab.values(updated, false, true)}
+	}
+	writeJSON(w, resp)
+}
+
+func (s *FakeSheetsService) batchUpdate(w http.ResponseWriter, r *http.Request, id string, ss *fakeSpreadsheet) {
+	batch := &sheets.BatchUpdateSpreadsheetRequest{}
+	if err := json.NewDecoder(r.Body).Decode(batch); err != nil {
+		badRequest(w, "%v", err)
+		return
+	}
+
+	tab := func(id int64) *fakeTab {
+		t := ss.tabByID(id)
+		if t == nil {
+			badRequest(w, "No grid with id: %d", id)
+		}
+		return t
+	}
+	resp := &sheets.BatchUpdateSpreadsheetResponse{SpreadsheetId: id}
+	for _, req := range batch.Requests {
+		reply := &sheets.Response{}
+		switch {
+		case req.InsertDimension != nil && req.InsertDimension.Range.Dimension == "ROWS":
+			d := req.InsertDimension.Range
+			t := tab(d.SheetId)
+			if t == nil {
+				return
+			}
+			if int(d.StartIndex) < len(t.rows) {
+				t.insertRows(int(d.StartIndex), int(d.EndIndex-d.StartIndex))
+			}
+		case req.DeleteDimension != nil && req.DeleteDimension.Range.Dimension == "ROWS":
+			d := req.DeleteDimension.Range
+			t := tab(d.SheetId)
+			if t == nil {
+				return
+			}
+			start, end := int(d.StartIndex), int(d.EndIndex)
+			if end > len(t.rows) {
+				end = len(t.rows)
+			}
+			if start < end {
+				t.rows = append(t.rows[:start], t.rows[end:]...)
+			}
+		case req.UpdateCells != nil && req.UpdateCells.Start != nil:
+			u := req.UpdateCells
+			t := tab(u.Start.SheetId)
+			if t == nil {
+				return
+			}
+			for i, row := range u.Rows {
+				for j, cell := range row.Values {
+					t.set(int(u.Start.RowIndex)+i, int(u.Start.ColumnIndex)+j, extendedValueCell(cell.UserEnteredValue))
+				}
+			}
+		case req.AddSheet != nil:
+			props := &sheets.SheetProperties{}
+			if req.AddSheet.Properties != nil {
+				*props = *req.AddSheet.Properties
+			}
+			if ss.tab(props.Title) != nil {
+				badRequest(w, "A sheet with the name %q already exists.", props.Title)
+				return
+			}
+			props.SheetId, props.Index = ss.nextTabID, int64(len(ss.tabs))
+			ss.nextTabID++
+			ss.tabs = append(ss.tabs, &fakeTab{props: props})
+			reply.AddSheet = &sheets.AddSheetResponse{Properties: props}
+		case req.RepeatCell != nil && req.RepeatCell.Cell != nil:
+			rng := req.RepeatCell.Range
+			t := tab(rng.SheetId)
+			if t == nil {
+				return
+			}
+			if t.columnFormats == nil {
+				t.columnFormats = map[int64]*sheets.CellFormat{}
+			}
+			for col := rng.StartColumnIndex; col < rng.EndColumnIndex; col++ {
+				t.columnFormats[col] = req.RepeatCell.Cell.UserEnteredFormat
+			}
+		case req.AddConditionalFormatRule != nil:
+			rule := req.AddConditionalFormatRule.Rule
+			t := tab(rule.Ranges[0].SheetId)
+			if t == nil {
+				return
+			}
+			t.conditionalFormats = append(t.conditionalFormats, rule)
+		default:
+			// Formatting doesn't change any values
+		}
+		resp.Replies = append(resp.Replies, reply)
+	}
+	writeJSON(w, resp)
+}
+
+// extendedValueCell is the cell an UpdateCells value writes
+func extendedValueCell(v *sheets.ExtendedValue) fakeCell {
+	switch {
+	case v == nil:
+		return fakeCell{}
+	case v.FormulaValue != nil:
+		return fakeCell{value: *v.FormulaValue, userEntered: true}
+	case v.StringValue != nil:
+		return fakeCell{value: *v.StringValue}
+	case v.NumberValue != nil:
+		return fakeCell{value: *v.NumberValue}
+	case v.BoolValue != nil:
+		return fakeCell{value: *v.BoolValue}
+	}
+	return fakeCell{}
+}
+
+func (t *fakeTab) rowEmpty(row int) bool {
+	if row >= len(t.rows) {
+		return true
+	}
+	for _, c := range t.rows[row] {
+		if c.value != nil && c.value != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *fakeTab) insertRows(at, n int) {
+	rows := make([][]fakeCell, 0, len(t.rows)+n)
+	rows = append(rows, t.rows[:at]...)
+	rows = append(rows, make([][]fakeCell, n)...)
+	t.rows = append(rows, t.rows[at:]...)
+}
+
+func (t *fakeTab) set(row, col int, cell fakeCell) {
+	for len(t.rows) <= row {
+		t.rows = append(t.rows, nil)
+	}
+	for len(t.rows[row]) <= col {
+		t.rows[row] = append(t.rows[row], fakeCell{})
+	}
+	t.rows[row][col] = cell
+}
+
+// write sets the cells from row and col, returning the range written
+func (t *fakeTab) write(row, col int, values [][]interface{}, userEntered bool) fakeRange {
+	written := fakeRange{tab: t.props.Title, startRow: row, endRow: row + len(values), startCol: col, endCol: col + 1}
+	for i, vs := range values {
+		for j, v := range vs {
+			t.set(row+i, col+j, fakeCell{value: v, userEntered: userEntered})
+		}
+		if col+len(vs) > written.endCol {
+			written.endCol = col + len(vs)
+		}
+	}
+	return written
+}
+
+// values reads a range, without the empty cells and rows Sheets leaves off the end
+func (t *fakeTab) values(rng fakeRange, unformatted, serial bool) [][]interface{} {
+	var values [][]interface{}
+	for row := rng.startRow; row < len(t.rows) && (rng.endRow < 0 || row < rng.endRow); row++ {
+		cells := []interface{}{}
+		for col := rng.startCol; col < len(t.rows[row]) && (rng.endCol < 0 || col < rng.endCol); col++ {
+			cells = append(cells, t.render(row, col, unformatted, serial))
+		}
+		for len(cells) > 0 && cells[len(cells)-1] == "" {
+			cells = cells[:len(cells)-1]
+		}
+		values = append(values, cells)
+	}
+	for len(values) > 0 && len(values[len(values)-1]) == 0 {
+		values = values[:len(values)-1]
+	}
+	return values
+}
+
+var (
+	fakeNumberRegex = regexp.MustCompile(`^-?(\d{1,3}(,\d{3})+|\d+)(\.\d+)?$`)
+	// The totals of the summary rows, SUM over a column to its end or, with INDIRECT, down to the row above
+	fakeSumRegex         = regexp.MustCompile(`^=SUM\(([A-Z]+)(\d+):[A-Z]+\)$`)
+	fakeIndirectSumRegex = regexp.MustCompile(`^=SUM\(INDIRECT\("([A-Z]+)(\d+):[A-Z]+"&\(ROW\(\)-1\)\)\)$`)
+	fakeDateLayouts      = []string{"2006-01-02 15:04:05", importDateLayout, "2006-01-02"}
+)
+
+// parsed is the value Sheets holds for a cell: the result of a formula, and text typed in parsed
+// as a number, date or bool. date is set for dates, which are held as serial numbers.
+func (t *fakeTab) parsed(row, col int) (value interface{}, date bool) {
+	if row >= len(t.rows) || col >= len(t.rows[row]) {
+		return nil, false
+	}
+	c := t.rows[row][col]
+	s, ok := c.value.(string)
+	if !ok || !c.userEntered {
+		return c.value, false
+	}
+
+	if strings.HasPrefix(s, "=") {
+		return t.evaluate(row, s), false
+	}
+	if fakeNumberRegex.MatchString(s) {
+		v, _ := strconv.ParseFloat(strings.Replace(s, ",", "", -1), 64)
+		return v, false
+	}
+	for _, layout := range fakeDateLayouts {
+		if d, err := time.Parse(layout, s); err == nil {
+			return d.Sub(sheetsEpoch).Hours() / 24, true
+		}
+	}
+	if b, err := strconv.ParseBool(s); err == nil && (strings.EqualFold(s, "true") || strings.EqualFold(s, "false")) {
+		return b, false
+	}
+	return s, false
+}
+
+// evaluate works out the summary row formulas, other formulas are an error
+func (t *fakeTab) evaluate(row int, formula string) interface{} {
+	col, from, to := "", 0, len(t.rows)
+	if m := fakeSumRegex.FindStringSubmatch(formula); m != nil {
+		col, from = m[1], atoi(m[2])-1
+	} else if m := fakeIndirectSumRegex.FindStringSubmatch(formula); m != nil {
+		col, from, to = m[1], atoi(m[2])-1, row
+	} else {
+		return "#ERROR!"
+	}
+
+	c := columnIndex(col)
+	sum := 0.0
+	for r := from; r < to; r++ {
+		if r == row {
+			continue
+		}
+		if v, _ := t.parsed(r, c); v != nil {
+			if f, ok := v.(float64); ok {
+				sum += f
+			}
+		}
+	}
+	return sum
+}
+
+// render is a cell as a Get returns it, as displayed or, when unformatted, as held
+func (t *fakeTab) render(row, col int, unformatted, serial bool) interface{} {
+	v, date := t.parsed(row, col)
+	c := t.rows[row][col]
+	if unformatted && !(date && !serial) {
+		if v == nil {
+			return ""
+		}
+		return v
+	}
+	if s, ok := c.value.(string); ok && !strings.HasPrefix(s, "=") {
+		return s
+	}
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	}
+	return fmt.Sprint(v)
+}
+
+// fakeRange is a parsed A1 range, zero-indexed with exclusive ends. An end of -1 is unbounded.
+type fakeRange struct {
+	tab              string
+	startRow, endRow int
+	startCol, endCol int
+}
+
+var (
+	a1CellRegex = regexp.MustCompile(`^([A-Za-z]*)(\d*)$`)
+	// plainTabNameRegex matches the tab names Sheets doesn't quote in a range
+	plainTabNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+func parseA1(a1 string) (fakeRange, error) {
+	tab, cells := a1, ""
+	if i := strings.LastIndex(a1, "!"); i >= 0 {
+		tab, cells = a1[:i], a1[i+1:]
+	}
+	if len(tab) > 1 && strings.HasPrefix(tab, "'") && strings.HasSuffix(tab, "'") {
+		tab = strings.Replace(tab[1:len(tab)-1], "''", "'", -1)
+	}
+	rng := fakeRange{tab: tab, endRow: -1, endCol: -1}
+	if cells == "" {
+		return rng, nil
+	}
+
+	ends := strings.SplitN(strings.Replace(cells, "$", "", -1), ":", 2)
+	start := a1CellRegex.FindStringSubmatch(ends[0])
+	if start == nil || ends[0] == "" {
+		return rng, fmt.Errorf("invalid range %q", a1)
+	}
+	if start[1] != "" {
+		rng.startCol = columnIndex(start[1])
+	}
+	if start[2] != "" {
+		rng.startRow = atoi(start[2]) - 1
+	}
+	if len(ends) == 1 {
+		if start[1] != "" {
+			rng.endCol = rng.startCol + 1
+		}
+		if start[2] != "" {
+			rng.endRow = rng.startRow + 1
+		}
+		return rng, nil
+	}
+
+	end := a1CellRegex.FindStringSubmatch(ends[1])
+	if end == nil || ends[1] == "" {
+		return rng, fmt.Errorf("invalid range %q", a1)
+	}
+	if end[1] != "" {
+		rng.endCol = columnIndex(end[1]) + 1
+	}
+	if end[2] != "" {
+		rng.endRow = atoi(end[2])
+	}
+	return rng, nil
+}
+
+// String formats the range as Sheets does in its responses, such as Sheet1!A5:H5
+func (r fakeRange) String() string {
+	tab := r.tab
+	if !plainTabNameRegex.MatchString(tab) {
+		tab = quoteTab(tab)
+	}
+	endCol, endRow := "", ""
+	if r.endCol > 0 {
+		endCol = columnName(r.endCol - 1)
+	}
+	if r.endRow > 0 {
+		endRow = strconv.Itoa(r.endRow)
+	}
+	return fmt.Sprintf("%s!%s%d:%s%s", tab, columnName(r.startCol), r.startRow+1, endCol, endRow)
+}
+
+// columnIndex converts A1 column letters to a zero-indexed column, the inverse of columnName
+func columnIndex(letters string) int {
+	col := 0
+	for _, c := range strings.ToUpper(letters) {
+		col = col*26 + int(c-'A'+1)
+	}
+	return col - 1
+}
+
+func atoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
@@ -0,0 +1,97 @@
+package trimark
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/sheets/v4"
+)
+
+// FailuresTabName is the tab of the report listing uploads sent to Failed, see FailuresTabEnv
+const FailuresTabName = "Failures"
+
+// failuresMu serialises creating the Failures tab
+var failuresMu sync.Mutex
+
+var failuresTabReady bool
+
+// failureHeaders returns the Failures tab header row, one entry per logFailure column
+func failureHeaders() []interface{} {
+	headers := []interface{}{"Time", "File", "Error", "Link"}
+	if config.FailuresThumbnail {
+		headers = append(headers, "Thumbnail")
+	}
+	return headers
+}
+
+// logFailure appends an upload which was sent to Failed to the Failures tab. With
+// FailuresThumbnailEnv its Drive thumbnail is shown with IMAGE(); Drive thumbnail links
+// expire after a few hours, so older thumbnails stop loading. Files without a thumbnail
+// get an empty cell.
+func logFailure(ctx context.Context, file *drive.File, reason string) {
+	if !config.FailuresTab {
+		return
+	}
+
+	if err := ensureFailuresTab(ctx); err != nil {
+		log.Printf("WARN: unable to create the %s tab: %v", FailuresTabName, err)
+		return
+	}
+
+	row := []interface{}{time.Now().Format("01-02-2006 15:04:05"), file.Title, reason, file.AlternateLink}
+	if config.FailuresThumbnail {
+		thumbnail := ""
+		if file.ThumbnailLink != "" {
+			thumbnail = `=IMAGE("` + strings.Replace(file.ThumbnailLink, `"`, `""`, -1) + `")`
+		}
+		row = append(row, thumbnail)
+	}
+
+	valueRange := &sheets.ValueRange{Values: [][]interface{}{row}}
+	_, err := sheetService.Spreadsheets.Values.Append(foldersFrom(ctx).Sheet, quoteTab(FailuresTabName)+"!A1", valueRange).InsertDataOption("INSERT_ROWS").ValueInputOption("USER_ENTERED").Context(ctx).Do()
+	if err != nil {
+		log.Printf("WARN: unable to log %s to the %s tab: %v", file.Title, FailuresTabName, err)
+	}
+}
+
+// ensureFailuresTab adds the Failures tab, with its header, the first time a failure is logged
+func ensureFailuresTab(ctx context.Context) error {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	if failuresTabReady {
+		return nil
+	}
+
+	sheetID := foldersFrom(ctx).Sheet
+	ss, err := sheetService.Spreadsheets.Get(sheetID).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	for _, sheet := range ss.Sheets {
+		if sheet.Properties != nil && sheet.Properties.Title == FailuresTabName {
+			failuresTabReady = true
+			return nil
+		}
+	}
+
+	addSheet := &sheets.Request{AddSheet: &sheets.AddSheetRequest{
+		Properties: &sheets.SheetProperties{Title: FailuresTabName},
+	}}
+	batch := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{addSheet}}
+	_, err = sheetService.Spreadsheets.BatchUpdate(sheetID, batch).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	header := &sheets.ValueRange{Values: [][]interface{}{failureHeaders()}}
+	_, err = sheetService.Spreadsheets.Values.Update(sheetID, quoteTab(FailuresTabName)+"!1:1", header).ValueInputOption("USER_ENTERED").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	failuresTabReady = true
+	return nil
+}
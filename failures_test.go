@@ -0,0 +1,55 @@
+package trimark
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestFailuresTabThumbnail(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: "thumbnail.png", MimeType: "image/png", ThumbnailLink: `https://lh3.googleusercontent.com/thumb?a="b"`},
+			{Id: "upload-2", Title: "none.png", MimeType: "image/png"},
+		}),
+		WithConfig(func(c *Config) {
+			c.FailuresTab = true
+			c.FailuresThumbnail = true
+		}))
+	for _, id := range []string{"upload-1", "upload-2"} {
+		fakeDrive.SetContent(id, testPNG(t))
+	}
+	fakeDrive.SetOCRText("thumbnail.png", "Corporation Wallet\r\nClose")
+	fakeDrive.SetOCRText("none.png", "Corporation Wallet\r\nClose")
+
+	results := runBatch(t, sc)
+	if len(results) != 2 {
+		t.Fatalf("processBatch results = %+v, want 2", results)
+	}
+
+	rows := fakeSheets.Formulas(testSheetID, FailuresTabName)
+	if len(rows) != 3 {
+		t.Fatalf("%s tab = %q, want a header and 2 failures", FailuresTabName, rows)
+	}
+	if want := []interface{}{"Time", "File", "Error", "Link", "Thumbnail"}; !reflect.DeepEqual(rows[0], want) {
+		t.Errorf("Header = %q, want %q", rows[0], want)
+	}
+	thumbnails := map[interface{}]interface{}{}
+	for _, row := range rows[1:] {
+		if len(row) != 5 {
+			t.Fatalf("Failure row = %q, want 5 columns", row)
+		}
+		if row[2] != "Date Not Found" {
+			t.Errorf("Error of %s = %q, want the extraction's", row[1], row[2])
+		}
+		thumbnails[row[1]] = row[4]
+	}
+	want := map[interface{}]interface{}{
+		"thumbnail.png": `=IMAGE("https://lh3.googleusercontent.com/thumb?a=""b""")`,
+		"none.png":      "",
+	}
+	if !reflect.DeepEqual(thumbnails, want) {
+		t.Errorf("Thumbnails = %q, want %q", thumbnails, want)
+	}
+}
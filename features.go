@@ -0,0 +1,29 @@
+package trimark
+
+import "strings"
+
+// FeatureFlagEnvPrefix prefixes the environment variables which switch features on, such as TRIMARK_FF_BIGQUERY=true
+const FeatureFlagEnvPrefix = "TRIMARK_FF_"
+
+// Feature is a behaviour being rolled out behind a flag
+type Feature string
+
+// Features which can be switched on with a FeatureFlagEnvPrefix variable
+const (
+	FeatureBigQuery      Feature = "bigquery"
+	FeatureESIValidation Feature = "esi_validation"
+	FeatureMultiStrip    Feature = "multi_strip"
+)
+
+// knownFeatures are the flags LoadConfig reads, variables for any other name are ignored
+var knownFeatures = []Feature{FeatureBigQuery, FeatureESIValidation, FeatureMultiStrip}
+
+// envName is the variable which switches a feature on
+func (f Feature) envName() string {
+	return FeatureFlagEnvPrefix + strings.ToUpper(string(f))
+}
+
+// IsEnabled reports whether a feature has been switched on, unknown features never are
+func (c *Config) IsEnabled(f Feature) bool {
+	return c.FeatureFlags[string(f)]
+}
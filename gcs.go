@@ -0,0 +1,116 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// GCSProcessedPrefix is where objects go under GCSInputPrefixEnv once recorded
+const GCSProcessedPrefix = "processed/"
+
+// GCSFailedPrefix is where objects go under GCSInputPrefixEnv when OCR extraction fails
+const GCSFailedPrefix = "failed/"
+
+var storageService *storage.Service
+
+func createStorageService(jsonPath string) (*storage.Service, error) {
+	ctx := context.Background()
+	client, err := newHTTPClient(ctx, jsonPath, quotaAPIStorage)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.NewService(ctx, option.WithHTTPClient(client))
+}
+
+// listGCSObjects returns the names of the objects under prefix waiting to be processed,
+// skipping those already moved to the processed and failed prefixes
+func listGCSObjects(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	var names []string
+	err := storageService.Objects.List(bucket).Prefix(prefix).Pages(ctx, func(objects *storage.Objects) error {
+		for _, o := range objects.Items {
+			rest := strings.TrimPrefix(o.Name, prefix)
+			if strings.HasSuffix(o.Name, "/") || strings.HasPrefix(rest, GCSProcessedPrefix) || strings.HasPrefix(rest, GCSFailedPrefix) {
+				continue
+			}
+			names = append(names, o.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// processGCSObject crops, OCRs and records a single object, as processFile does for Drive uploads
+func processGCSObject(ctx context.Context, bucket string, name string) (result ExtractionResult, err error) {
+	title := path.Base(name)
+	result = ExtractionResult{FileID: "gs://" + bucket + "/" + name, FileName: title}
+	defer func() {
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("%w: %v", ErrDeadlineExceeded, err)
+		}
+	}()
+
+	resp, err := storageService.Objects.Get(bucket, name).Context(ctx).Download()
+	if err != nil {
+		return result, fmt.Errorf("Download image -> %v", err)
+	}
+	defer resp.Body.Close()
+
+	moveSource := func(ctx context.Context, failed bool) error {
+		to := GCSProcessedPrefix
+		if failed {
+			to = GCSFailedPrefix
+		}
+		return moveGCSObject(ctx, bucket, name, to)
+	}
+
+	start := time.Now()
+	body := &progressReader{r: resp.Body, total: resp.ContentLength, onProgress: logDownloadProgress(result.FileID)}
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return result, fmt.Errorf("ioutil.ReadAll -> %v", err)
+	}
+	result.recordStage(ctx, "download", start)
+
+	start = time.Now()
+	img, err := cropImageData(bytes.NewReader(raw))
+	result.recordStage(ctx, "crop", start)
+	if errors.Is(err, ErrUnsupportedImage) {
+		return rejectUnsupported(ctx, result, err, moveSource)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	// Objects don't record who uploaded them
+	return recordImage(ctx, result, title, "", img, moveSource)
+}
+
+// moveGCSObject moves an object under the input prefix to the given sub-prefix,
+// Cloud Storage has no move so it is copied then deleted
+func moveGCSObject(ctx context.Context, bucket string, name string, to string) error {
+	dest := config.GCSInputPrefix + to + strings.TrimPrefix(name, config.GCSInputPrefix)
+
+	_, err := storageService.Objects.Copy(bucket, name, bucket, dest, nil).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to copy %s to %s: %v", name, dest, err)
+	}
+
+	err = storageService.Objects.Delete(bucket, name).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to delete %s after copying it to %s: %v", name, dest, err)
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package trimark
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+const testBucket = "trimark-uploads"
+
+// FakeStorageService is an in-memory Cloud Storage JSON API, serving the object calls the package makes
+type FakeStorageService struct {
+	fakeAPI
+
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+
+	// PageSize is the most objects a listing returns a page, 1000 when it's 0
+	PageSize int
+}
+
+type fakeObject struct {
+	data     []byte
+	metadata map[string]string
+}
+
+// newFakeStorage points storageService at a FakeStorageService until the test ends. It's called
+// after NewTestServiceContext, which would reset it.
+func newFakeStorage(t testing.TB) *FakeStorageService {
+	t.Helper()
+	fake := &FakeStorageService{objects: map[string]*fakeObject{}}
+	srv := httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(srv.Close)
+
+	var err error
+	storageService, err = storage.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL+"/storage/v1/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake
+}
+
+// AddObject stores an object in the test bucket
+func (s *FakeStorageService) AddObject(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[name] = &fakeObject{data: data}
+}
+
+// Names lists the objects in the test bucket, sorted
+func (s *FakeStorageService) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Metadata is the custom metadata of an object, nil when it doesn't exist
+func (s *FakeStorageService) Metadata(name string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[name]
+	if !ok {
+		return nil
+	}
+	metadata := map[string]string{}
+	for k, v := range o.metadata {
+		metadata[k] = v
+	}
+	return metadata
+}
+
+func (s *FakeStorageService) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if failure := s.begin(r); failure != nil {
+		writeAPIError(w, failure)
+		return
+	}
+
+	// Object names are escaped into single path segments
+	var parts []string
+	for _, p := range strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/storage/v1/"), "/") {
+		unescaped, err := url.PathUnescape(p)
+		if err != nil {
+			badRequest(w, "Invalid path %q", r.URL.EscapedPath())
+			return
+		}
+		parts = append(parts, unescaped)
+	}
+	if len(parts) < 3 || parts[0] != "b" || parts[1] != testBucket || parts[2] != "o" {
+		notFound(w, r.URL.Path)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		s.list(w, r)
+	case len(parts) == 4:
+		o, ok := s.objects[parts[3]]
+		if !ok {
+			notFound(w, parts[3])
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("alt") == "media" {
+				w.Write(o.data)
+				return
+			}
+			writeJSON(w, objectResource(parts[3], o))
+		case http.MethodPatch:
+			var patch storage.Object
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				badRequest(w, "Invalid object: %v", err)
+				return
+			}
+			if o.metadata == nil {
+				o.metadata = map[string]string{}
+			}
+			for k, v := range patch.Metadata {
+				o.metadata[k] = v
+			}
+			writeJSON(w, objectResource(parts[3], o))
+		case http.MethodDelete:
+			delete(s.objects, parts[3])
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			badRequest(w, "Unsupported method %s", r.Method)
+		}
+	case len(parts) == 9 && parts[4] == "copyTo" && r.Method == http.MethodPost:
+		o, ok := s.objects[parts[3]]
+		if !ok {
+			notFound(w, parts[3])
+			return
+		}
+		copied := &fakeObject{data: o.data, metadata: map[string]string{}}
+		for k, v := range o.metadata {
+			copied.metadata[k] = v
+		}
+		s.objects[parts[8]] = copied
+		writeJSON(w, objectResource(parts[8], copied))
+	default:
+		badRequest(w, "Unsupported request %s %s", r.Method, r.URL.Path)
+	}
+}
+
+// list serves a page of the objects under ?prefix=, in name order as Cloud Storage lists them
+func (s *FakeStorageService) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+	size := s.PageSize
+	if size == 0 {
+		size = 1000
+	}
+	resp := &storage.Objects{}
+	for i := start; i < len(names) && i < start+size; i++ {
+		resp.Items = append(resp.Items, objectResource(names[i], s.objects[names[i]]))
+	}
+	if start+size < len(names) {
+		resp.NextPageToken = strconv.Itoa(start + size)
+	}
+	writeJSON(w, resp)
+}
+
+func objectResource(name string, o *fakeObject) *storage.Object {
+	return &storage.Object{Bucket: testBucket, Name: name, Size: uint64(len(o.data)), Metadata: o.metadata}
+}
+
+func TestListGCSObjects(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		// Only the processed and failed prefixes of the input prefix are skipped
+		{"no prefix", "", []string{"a.png", "inbox/a.png", "inbox/failed/c.png", "inbox/processed/b.png", "inbox/z.png", "sub/d.png"}},
+		{"prefix", "inbox/", []string{"inbox/a.png", "inbox/z.png"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			NewTestServiceContext(t)
+			fake := newFakeStorage(t)
+			// A page at a time, so every page is read
+			fake.PageSize = 2
+			for _, name := range []string{
+				"a.png", "processed/b.png", "failed/c.png", "sub/", "sub/d.png",
+				"inbox/a.png", "inbox/processed/b.png", "inbox/failed/c.png", "inbox/z.png",
+			} {
+				fake.AddObject(name, []byte("image"))
+			}
+
+			names, err := listGCSObjects(context.Background(), testBucket, tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("listGCSObjects = %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoveGCSObject(t *testing.T) {
+	NewTestServiceContext(t, WithConfig(func(c *Config) { c.GCSInputPrefix = "inbox/" }))
+	fake := newFakeStorage(t)
+	fake.AddObject("inbox/a.png", []byte("image"))
+	fake.AddObject("inbox/b.png", []byte("image"))
+
+	if err := moveGCSObject(context.Background(), testBucket, "inbox/a.png", GCSProcessedPrefix); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"inbox/b.png", "inbox/processed/a.png"}; !reflect.DeepEqual(fake.Names(), want) {
+		t.Errorf("Objects = %q, want %q", fake.Names(), want)
+	}
+
+	// Nothing is deleted unless it was copied
+	fake.Fail(http.MethodPost, "copyTo", &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "Backend Error"})
+	if err := moveGCSObject(context.Background(), testBucket, "inbox/b.png", GCSFailedPrefix); err == nil {
+		t.Error("moveGCSObject succeeded without a copy")
+	}
+	if want := []string{"inbox/b.png", "inbox/processed/a.png"}; !reflect.DeepEqual(fake.Names(), want) {
+		t.Errorf("Objects = %q, want %q", fake.Names(), want)
+	}
+}
+
+func TestMainProcessesGCSObjects(t *testing.T) {
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t, WithConfig(func(c *Config) {
+		c.GCSInputBucket = testBucket
+		c.GCSInputPrefix = "inbox/"
+	}))
+	fake := newFakeStorage(t)
+	fake.AddObject("inbox/wallet.png", testPNG(t))
+	fake.AddObject("inbox/processed/old.png", testPNG(t))
+	fakeDrive.SetOCRText("wallet.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+
+	w := httptest.NewRecorder()
+	Main(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		body, _ := ioutil.ReadAll(w.Body)
+		t.Fatalf("Main responded %d: %s", w.Code, body)
+	}
+
+	if want := []string{"inbox/processed/old.png", "inbox/processed/wallet.png"}; !reflect.DeepEqual(fake.Names(), want) {
+		t.Errorf("Objects = %q, want %q", fake.Names(), want)
+	}
+	rows := fakeSheets.Values(testSheetID, "Sheet1")
+	if len(rows) != 2 || rows[1][3] != "Pilot One" {
+		t.Errorf("Report = %q, want the header and the donation of Pilot One", rows)
+	}
+}
+
+func TestFailedGCSObjectIsRetriedThenDeadLettered(t *testing.T) {
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t, WithConfig(func(c *Config) {
+		c.GCSInputBucket = testBucket
+		c.GCSInputPrefix = "inbox/"
+		c.MaxAttempts = 2
+	}))
+	fake := newFakeStorage(t)
+	fake.AddObject("inbox/wallet.png", testPNG(t))
+	fakeDrive.SetOCRTexts("wallet.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"), donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+	fakeSheets.Fail(http.MethodPost, ":append", &googleapi.Error{Code: http.StatusBadRequest, Message: "Invalid range"})
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		Main(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		want := []string{"inbox/wallet.png"}
+		if attempt == 2 {
+			want = []string{"inbox/failed/wallet.png"}
+		}
+		if !reflect.DeepEqual(fake.Names(), want) {
+			t.Fatalf("After attempt %d the objects are %q, want %q", attempt, fake.Names(), want)
+		}
+		metadata := fake.Metadata(want[0])
+		if metadata[attemptsPropertyKey] != strconv.Itoa(attempt) {
+			t.Errorf("After attempt %d the object counts %q attempts", attempt, metadata[attemptsPropertyKey])
+		}
+		if got := strings.Count(metadata[errorHistoryMetadataKey], "Invalid range"); got != attempt {
+			t.Errorf("After attempt %d the error history has %d errors: %q", attempt, got, metadata[errorHistoryMetadataKey])
+		}
+	}
+}
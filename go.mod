@@ -6,6 +6,13 @@ require (
 	cloud.google.com/go v0.63.0
 	github.com/GoogleCloudPlatform/functions-framework-go v1.1.0
 	github.com/oliamb/cutter v0.2.2
+	go.opentelemetry.io/otel v1.10.0
+	go.opentelemetry.io/otel/sdk v1.10.0
+	go.opentelemetry.io/otel/trace v1.10.0
+	golang.org/x/net v0.0.0-20200707034311-ab3426394381
+	golang.org/x/sys v0.10.0 // indirect
 	google.golang.org/api v0.30.0
+	google.golang.org/grpc v1.31.0
+	google.golang.org/protobuf v1.25.0
 	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,91 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// SetupInfo is what setup found or created, passed to OnSetupComplete hooks
+type SetupInfo struct {
+	FolderIDs map[string]string `json:"folderIds"`
+	SheetID   string            `json:"sheetId"`
+	SheetURL  string            `json:"sheetUrl"`
+}
+
+// SetupHook runs once the folders and report sheet have been set up
+type SetupHook func(ctx context.Context, info SetupInfo) error
+
+var (
+	setupHooks    []SetupHook
+	setupHooksRan bool
+	setupHooksMu  sync.Mutex
+	setupInfo     SetupInfo
+)
+
+// OnSetupComplete registers a hook to run after the folders and report sheet have been set up,
+// such as to record their IDs elsewhere. An error from a hook fails warmup, so with WarmupAsyncEnv
+// Main returns 503. Hooks registered once setup has completed run straight away, returning their error.
+func OnSetupComplete(ctx context.Context, hook SetupHook) error {
+	setupHooksMu.Lock()
+	if !setupHooksRan {
+		setupHooks = append(setupHooks, hook)
+		setupHooksMu.Unlock()
+		return nil
+	}
+	info := setupInfo
+	setupHooksMu.Unlock()
+	return hook(ctx, info)
+}
+
+// runSetupHooks runs the hooks registered before setup completed, stopping at the first error
+func runSetupHooks(ctx context.Context, info SetupInfo) error {
+	setupHooksMu.Lock()
+	setupHooksRan = true
+	setupInfo = info
+	hooks := setupHooks
+	setupHooks = nil
+	setupHooksMu.Unlock()
+
+	for _, hook := range hooks {
+		if err := hook(ctx, info); err != nil {
+			return fmt.Errorf("Setup hook failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// currentSetupInfo snapshots the folder and sheet IDs, the caller holds folderIDsMu
+func currentSetupInfo() SetupInfo {
+	info := SetupInfo{
+		FolderIDs: map[string]string{
+			UploadFolderName:    UploadFolderID,
+			ProcessedFolderName: ProcessedFolderID,
+			FailedFolderName:    FailedFolderID,
+			ReportFolderName:    ReportFolderID,
+		},
+		SheetID: SheetID,
+	}
+	if OCRArchiveFolderID != "" {
+		info.FolderIDs[OCRArchiveFolderName] = OCRArchiveFolderID
+	}
+	if BackupFolderID != "" {
+		info.FolderIDs[BackupFolderName] = BackupFolderID
+	}
+	if SheetID != "" {
+		info.SheetURL = "https://docs.google.com/spreadsheets/d/" + SheetID
+	}
+	return info
+}
+
+// LogSetupInfoHook is a SetupHook logging the folder IDs and report sheet URL to logger
+func LogSetupInfoHook(logger *log.Logger) SetupHook {
+	return func(ctx context.Context, info SetupInfo) error {
+		for name, id := range info.FolderIDs {
+			logger.Printf("INFO: folder %s is %s", name, id)
+		}
+		logger.Printf("INFO: %s is %s", SheetName, info.SheetURL)
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+// resetSetupHooks forgets the hooks registered and that setup ran, as in a new instance
+func resetSetupHooks(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		setupHooksMu.Lock()
+		defer setupHooksMu.Unlock()
+		setupHooks, setupHooksRan, setupInfo = nil, false, SetupInfo{}
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestOnSetupComplete(t *testing.T) {
+	NewTestServiceContext(t)
+	resetSetupHooks(t)
+	UploadFolderID, ProcessedFolderID, FailedFolderID, ReportFolderID, SheetID = "", "", "", "", ""
+
+	var before SetupInfo
+	if err := OnSetupComplete(context.Background(), func(ctx context.Context, info SetupInfo) error {
+		before = info
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if before.FolderIDs != nil {
+		t.Fatal("Hook ran before setup")
+	}
+
+	if err := warmup(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		UploadFolderName:    testUploadFolderID,
+		ProcessedFolderName: testProcessedFolderID,
+		FailedFolderName:    testFailedFolderID,
+		ReportFolderName:    testReportFolderID,
+	}
+	for name, id := range want {
+		if before.FolderIDs[name] != id {
+			t.Errorf("Hook saw %s folder %q, want %q", name, before.FolderIDs[name], id)
+		}
+	}
+	if before.SheetID != testSheetID || !strings.HasSuffix(before.SheetURL, "/"+testSheetID) {
+		t.Errorf("Hook saw sheet %q at %q, want %s", before.SheetID, before.SheetURL, testSheetID)
+	}
+
+	// A hook registered once setup has completed runs straight away
+	var after SetupInfo
+	if err := OnSetupComplete(context.Background(), func(ctx context.Context, info SetupInfo) error {
+		after = info
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if after.SheetID != testSheetID || after.FolderIDs[UploadFolderName] != testUploadFolderID {
+		t.Errorf("Hook registered after setup saw %+v", after)
+	}
+}
+
+func TestOnSetupCompleteError(t *testing.T) {
+	NewTestServiceContext(t)
+	resetSetupHooks(t)
+
+	hookErr := errors.New("registry unavailable")
+	if err := OnSetupComplete(context.Background(), func(ctx context.Context, info SetupInfo) error {
+		return hookErr
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := warmup(); err == nil || !strings.Contains(err.Error(), hookErr.Error()) {
+		t.Errorf("warmup = %v, want the hook's error", err)
+	}
+}
+
+func TestLogSetupInfoHook(t *testing.T) {
+	var logged bytes.Buffer
+	info := SetupInfo{
+		FolderIDs: map[string]string{UploadFolderName: "upload-id", ReportFolderName: "report-id"},
+		SheetID:   "sheet-id",
+		SheetURL:  "https://docs.google.com/spreadsheets/d/sheet-id",
+	}
+	if err := LogSetupInfoHook(log.New(&logged, "", 0))(context.Background(), info); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"folder " + UploadFolderName + " is upload-id", "folder " + ReportFolderName + " is report-id", info.SheetURL} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("Logged %q, want %q", logged.String(), want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+)
+
+// checksumPropertyKey tags an OCR document with the checksum of the row it is writing
+const checksumPropertyKey = "trimark_checksum"
+
+// committedPropertyKey tags an OCR document with the sheet row it wrote
+const committedPropertyKey = "trimark_sheet_committed"
+
+// claimDonation claims a checksum in the DedupStore when one is configured, otherwise on the document
+func claimDonation(ctx context.Context, docID string, checksum string) (bool, error) {
+	if dedupStore != nil {
+		return dedupStore.Claim(ctx, checksum)
+	}
+	return claimChecksum(ctx, docID, checksum)
+}
+
+// releaseDonation gives up a claim whose row couldn't be written, so a later run can record the donation
+func releaseDonation(ctx context.Context, docID string, checksum string) error {
+	if dedupStore != nil {
+		return dedupStore.Release(ctx, checksum)
+	}
+	return releaseChecksum(ctx, docID)
+}
+
+// claimChecksum tags the OCR document with its checksum, then reports whether it should
+// write the row. It loses when another document has committed the checksum, or when a
+// concurrent document with a lower ID is still writing it. Claims left behind by runs
+// which outlived the processing timeout are ignored.
+func claimChecksum(ctx context.Context, docID string, checksum string) (bool, error) {
+	property := &drive.Property{Key: checksumPropertyKey, Value: checksum, Visibility: "PRIVATE"}
+	_, err := driveService.Properties.Insert(docID, property).Context(ctx).Do()
+	if err != nil {
+		return false, err
+	}
+
+	q := fmt.Sprintf("properties has { key='%s' and value='%s' and visibility='PRIVATE' } and trashed = false", checksumPropertyKey, checksum)
+	list, err := driveService.Files.List().Q(q).Context(ctx).Do()
+	if err != nil {
+		return false, err
+	}
+
+	for _, f := range list.Items {
+		if f.Id == docID {
+			continue
+		}
+		if hasProperty(f, committedPropertyKey, "") {
+			return false, nil
+		}
+
+		modified, err := time.Parse(time.RFC3339, f.ModifiedDate)
+		if err == nil && time.Since(modified) > config.ProcessTimeout {
+			continue
+		}
+		if f.Id < docID {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// releaseChecksum removes the checksum tag claimChecksum put on the OCR document
+func releaseChecksum(ctx context.Context, docID string) error {
+	return driveService.Properties.Delete(docID, checksumPropertyKey).Visibility("PRIVATE").Context(ctx).Do()
+}
+
+// commitChecksum records the row written by the OCR document
+func commitChecksum(ctx context.Context, docID string, rowID string) error {
+	property := &drive.Property{Key: committedPropertyKey, Value: rowID, Visibility: "PRIVATE"}
+	_, err := driveService.Properties.Insert(docID, property).Context(ctx).Do()
+	return err
+}
@@ -0,0 +1,72 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
+)
+
+func TestClaimChecksumConcurrently(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t)
+	var docs []string
+	for i := 0; i < 4; i++ {
+		doc := fakeDrive.AddFile(&drive.File{Title: fmt.Sprintf("doc-%d", i), MimeType: DocumentMimeType, Parents: parentRefs(testProcessedFolderID)}, nil)
+		docs = append(docs, doc.Id)
+	}
+	// Every claim is tagged before any of them lists the others
+	fakeDrive.SetLatency(50 * time.Millisecond)
+
+	claimed := make([]bool, len(docs))
+	errs := make([]error, len(docs))
+	var wg sync.WaitGroup
+	for i, id := range docs {
+		i, id := i, id
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed[i], errs[i] = claimChecksum(context.Background(), id, "checksum")
+		}()
+	}
+	wg.Wait()
+
+	for i := range docs {
+		if errs[i] != nil {
+			t.Fatalf("claimChecksum(%s): %v", docs[i], errs[i])
+		}
+		if want := i == 0; claimed[i] != want {
+			t.Errorf("claimChecksum(%s) = %t, want %t; the lowest ID wins", docs[i], claimed[i], want)
+		}
+	}
+
+	fakeDrive.SetLatency(0)
+	if err := commitChecksum(context.Background(), docs[0], "12"); err != nil {
+		t.Fatal(err)
+	}
+	late := fakeDrive.AddFile(&drive.File{Title: "late", MimeType: DocumentMimeType}, nil)
+	if ok, err := claimChecksum(context.Background(), late.Id, "checksum"); err != nil || ok {
+		t.Errorf("claimChecksum after the row was committed = %t, %v, want false", ok, err)
+	}
+}
+
+func TestFailedAppendReleasesClaim(t *testing.T) {
+	sc, fakeDrive, _ := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "donation.txt", MimeType: "text/plain"}}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.sheets.Fail(http.MethodPost, ":append", &googleapi.Error{Code: http.StatusBadRequest, Message: "Invalid range"})
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || results[0].err == nil {
+		t.Fatalf("processBatch results = %+v, want the append to fail", results)
+	}
+	for _, p := range fakeDrive.File("upload-1").Properties {
+		if p.Key == checksumPropertyKey {
+			t.Errorf("The checksum is still claimed after the append failed: %+v", p)
+		}
+	}
+}
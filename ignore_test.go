@@ -0,0 +1,42 @@
+package trimark
+
+import (
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestIgnoredUsernamesAreSkipped(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: "bot.png", MimeType: "image/png"},
+			{Id: "upload-2", Title: "member.png", MimeType: "image/png"},
+		}),
+		WithConfig(func(c *Config) { c.IgnoredUsernames = map[string]bool{"wallet bot": true} }))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetContent("upload-2", testPNG(t))
+	fakeDrive.SetOCRText("bot.png", donationText("2020-06-18 12:34:56", "Wallet  BOT", "1,000"))
+	fakeDrive.SetOCRText("member.png", donationText("2020-06-18 12:35:56", "Pilot One", "2,000"))
+
+	results := runBatch(t, sc)
+	ignored := map[string]bool{}
+	for _, r := range results {
+		if r.err != nil || r.result.Error != "" {
+			t.Fatalf("processBatch results = %+v, want no errors", results)
+		}
+		ignored[r.result.FileID] = r.result.Ignored
+	}
+	if !ignored["upload-1"] || ignored["upload-2"] {
+		t.Errorf("Ignored = %v, want only upload-1", ignored)
+	}
+
+	rows := fakeSheets.Values(testSheetID, "Sheet1!A2:G")
+	if len(rows) != 1 || rows[0][nameColumn] != "Pilot One" {
+		t.Errorf("Report rows = %v, want only Pilot One's", rows)
+	}
+	for _, id := range []string{"upload-1", "upload-2"} {
+		if !inFolder(fakeDrive.File(id), testProcessedFolderID) {
+			t.Errorf("%s was not moved to Processed", id)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package trimark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+)
+
+// Formats returned by detectFormat
+const (
+	formatPNG  = "png"
+	formatJPEG = "jpeg"
+	formatWebP = "webp"
+)
+
+// jpegQuality is the quality cropped JPEG screenshots are encoded at
+const jpegQuality = 90
+
+// errUnknownFormat is returned by detectFormat for content without a known image signature
+var errUnknownFormat = errors.New("unknown image format")
+
+// detectFormat identifies an image by its magic bytes
+func detectFormat(imgBytes []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(imgBytes, []byte("\x89PNG")):
+		return formatPNG, nil
+	case bytes.HasPrefix(imgBytes, []byte("\xFF\xD8\xFF")):
+		return formatJPEG, nil
+	case len(imgBytes) >= 12 && bytes.HasPrefix(imgBytes, []byte("RIFF")) && string(imgBytes[8:12]) == "WEBP":
+		return formatWebP, nil
+	default:
+		return "", errUnknownFormat
+	}
+}
+
+// croppedImage is a cropped screenshot, encoded in the format it was uploaded in so JPEGs
+// aren't inflated by re-encoding them as PNG
+type croppedImage struct {
+	*bytes.Reader
+	OutputFormat string
+
+	// SourceSize and SourceBounds describe the screenshot before it was trimmed and cropped
+	SourceSize   int64
+	SourceBounds image.Rectangle
+}
+
+// SourceDimensions formats SourceBounds as width by height, such as 1920x1080
+func (c *croppedImage) SourceDimensions() string {
+	return fmt.Sprintf("%dx%d", c.SourceBounds.Dx(), c.SourceBounds.Dy())
+}
+
+// MimeType is the content type of the encoded image, for the Drive upload
+func (c *croppedImage) MimeType() string {
+	return "image/" + c.OutputFormat
+}
+
+// encodeImage encodes img as format, a PNG or JPEG
+func encodeImage(img image.Image, format string) (*croppedImage, error) {
+	buf := new(bytes.Buffer)
+	var err error
+	if format == formatJPEG {
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		format = formatPNG
+		err = png.Encode(buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &croppedImage{Reader: bytes.NewReader(buf.Bytes()), OutputFormat: format}, nil
+}
@@ -0,0 +1,45 @@
+package trimark
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	var pngBytes, jpegBytes bytes.Buffer
+	if err := png.Encode(&pngBytes, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegBytes, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "png", data: pngBytes.Bytes(), want: formatPNG},
+		{name: "jpeg", data: jpegBytes.Bytes(), want: formatJPEG},
+		{name: "webp", data: []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), want: formatWebP},
+		{name: "riff but not webp", data: []byte("RIFF\x00\x00\x00\x00WAVEfmt "), wantErr: true},
+		{name: "short riff", data: []byte("RIFF"), wantErr: true},
+		{name: "text", data: []byte("Member Donation"), wantErr: true},
+		{name: "empty", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := detectFormat(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: detectFormat error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: detectFormat = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"google.golang.org/api/drive/v2"
+)
+
+// maxIngestBytes caps the size of a request to Ingest
+const maxIngestBytes = 64 << 20
+
+// IngestResponse is the JSON body returned by Ingest
+type IngestResponse struct {
+	DryRun  bool               `json:"dryRun"`
+	Results []ExtractionResult `json:"results"`
+}
+
+// Ingest crops, OCRs and records the images of a multipart POST, so other systems can
+// submit screenshots without uploading them to Drive. With ?archive=true the originals
+// are stored in Processed, or Failed when extraction fails. It requires the AdminTokenEnv
+// bearer token.
+func Ingest(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if !requireWarmup(w, r) {
+		return
+	}
+
+	r = r.WithContext(withFolders(r.Context(), snapshotFolders()))
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxIngestBytes)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "multipart/form-data required", http.StatusBadRequest)
+		return
+	}
+	archive := r.URL.Query().Get("archive") == "true"
+
+	response := IngestResponse{DryRun: config.DryRun, Results: []ExtractionResult{}}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unable to read upload: %v", err), http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		original, err := ioutil.ReadAll(part)
+		part.Close()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unable to read %s: %v", part.FileName(), err), http.StatusBadRequest)
+			return
+		}
+
+		result, err := ingestImage(r.Context(), part.FileName(), original, archive)
+		if err != nil {
+			log.Printf("Unable to ingest %s: %v", part.FileName(), err)
+			result.Error = err.Error()
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Unable to write ingest results: %v", err)
+	}
+}
+
+// ingestImage records a single image posted to Ingest
+func ingestImage(ctx context.Context, name string, original []byte, archive bool) (result ExtractionResult, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.ProcessTimeout)
+	defer cancel()
+
+	result = ExtractionResult{FileName: name}
+	defer func() {
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("%w: %v", ErrDeadlineExceeded, err)
+		}
+	}()
+
+	img, err := cropImageData(bytes.NewReader(original))
+	if err != nil {
+		return result, err
+	}
+
+	return recordImage(ctx, result, name, "", img, func(ctx context.Context, failed bool) error {
+		if !archive {
+			return nil
+		}
+		folderID := foldersFrom(ctx).Processed
+		if failed {
+			folderID = foldersFrom(ctx).Failed
+		}
+		f := &drive.File{Title: name, Parents: []*drive.ParentReference{{Id: folderID}}}
+		_, err := driveService.Files.Insert(f).Media(bytes.NewReader(original)).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("Unable to archive %s: %v", name, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,106 @@
+package trimark
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIngestRequiresAdminToken(t *testing.T) {
+	NewTestServiceContext(t, WithConfig(func(c *Config) { c.AdminToken = "secret" }))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", http.StatusUnauthorized},
+		// Authorized, so it gets as far as refusing the body
+		{"admin token", "Bearer secret", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/ingest", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		Ingest(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: Ingest responded %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestIngestRecordsMultipartImages(t *testing.T) {
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t, WithConfig(func(c *Config) {
+		c.AdminToken = "secret"
+		c.Serial = true
+	}))
+	fakeDrive.SetOCRText("wallet.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+	fakeDrive.SetOCRText("blurry.png", "Corporation Wallet\r\nClose")
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.WriteField("note", "not a file, so skipped"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"wallet.png", "blurry.png"} {
+		part, err := mw.CreateFormFile("images", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(testPNG(t))
+	}
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/ingest?archive=true", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	Ingest(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Ingest responded %d: %s", w.Code, w.Body)
+	}
+
+	var response IngestResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Results) != 2 {
+		t.Fatalf("Ingest results = %+v, want one per image", response.Results)
+	}
+	if got := response.Results[0]; got.FileName != "wallet.png" || got.Error != "" || got.RowID == "" {
+		t.Errorf("Result of wallet.png = %+v, want a recorded row", got)
+	}
+	if got := response.Results[1]; got.FileName != "blurry.png" || got.Error == "" {
+		t.Errorf("Result of blurry.png = %+v, want an extraction failure", got)
+	}
+
+	// The failed extraction has a row too, linking its document for triage
+	rows := fakeSheets.Values(testSheetID, "Sheet1")
+	if len(rows) != 3 || rows[1][nameColumn] != "Pilot One" || rows[2][nameColumn] != "" {
+		t.Errorf("Report = %q, want the header, the donation of Pilot One and the failure", rows)
+	}
+
+	// The originals are archived beside the Drive uploads they stand in for
+	titles := func(folderID string) []string {
+		var titles []string
+		for _, f := range fakeDrive.FilesIn(folderID) {
+			if f.MimeType != DocumentMimeType {
+				titles = append(titles, f.Title)
+			}
+		}
+		return titles
+	}
+	if got := titles(testProcessedFolderID); len(got) != 1 || !strings.Contains(got[0], "wallet.png") {
+		t.Errorf("Processed holds %q, want wallet.png", got)
+	}
+	if got := titles(testFailedFolderID); len(got) != 1 || !strings.Contains(got[0], "blurry.png") {
+		t.Errorf("Failed holds %q, want blurry.png", got)
+	}
+}
@@ -0,0 +1,48 @@
+// Package testhelpers holds utilities for integration tests run against real Drive folders.
+package testhelpers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"google.golang.org/api/drive/v2"
+)
+
+// maxConcurrentDeletes bounds the deletions in flight, keeping clear of Drive's rate limits
+const maxConcurrentDeletes = 10
+
+// BatchDeleteFiles permanently deletes files in parallel, returning how many were deleted and
+// an error for each one which wasn't. A failure doesn't stop the remaining deletions.
+func BatchDeleteFiles(ctx context.Context, service *drive.Service, fileIDs []string) (deleted int, errs []error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, maxConcurrentDeletes)
+
+	for _, id := range fileIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := service.Files.Delete(id).Context(ctx).Do()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Unable to delete %s: %v", id, err))
+				return
+			}
+			deleted++
+		}(id)
+	}
+	wg.Wait()
+	return deleted, errs
+}
+
+// BatchDeleteFolders permanently deletes folders, and everything in them, in parallel
+func BatchDeleteFolders(ctx context.Context, service *drive.Service, folderIDs []string) (deleted int, errs []error) {
+	return BatchDeleteFiles(ctx, service, folderIDs)
+}
@@ -0,0 +1,71 @@
+package testhelpers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/option"
+)
+
+func TestBatchDeleteFiles(t *testing.T) {
+	var mu sync.Mutex
+	var requests, inFlight, peak int
+	// The first maxConcurrentDeletes requests are held until they're all in flight
+	gate := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		if inFlight == maxConcurrentDeletes && requests == maxConcurrentDeletes {
+			close(gate)
+		}
+		mu.Unlock()
+
+		select {
+		case <-gate:
+		case <-time.After(5 * time.Second):
+		}
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		if r.Method != http.MethodDelete || strings.HasSuffix(r.URL.Path, "/missing") {
+			http.Error(w, `{"error":{"code":404,"message":"File not found"}}`, http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	service, err := drive.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for i := 0; i < 19; i++ {
+		ids = append(ids, fmt.Sprintf("file-%d", i))
+	}
+	ids = append(ids, "missing")
+
+	deleted, errs := BatchDeleteFiles(context.Background(), service, ids)
+	if deleted != 19 || len(errs) != 1 {
+		t.Errorf("BatchDeleteFiles = %d, %v, want 19 deleted and the missing file's error", deleted, errs)
+	}
+	if requests != 20 {
+		t.Errorf("%d deletions requested, want 20", requests)
+	}
+	if peak != maxConcurrentDeletes {
+		t.Errorf("%d deletions were in flight at once, want %d", peak, maxConcurrentDeletes)
+	}
+}
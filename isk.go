@@ -0,0 +1,63 @@
+package trimark
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// iskSuffix follows the amounts popups show, such as "1,234,567.00 ISK"
+const iskSuffix = " ISK"
+
+// ErrNonIntegerISK is returned for a quantity with a fraction of an ISK, which is never transferred
+var ErrNonIntegerISK = errors.New("Quantity is not a whole number of ISK")
+
+// dotThousandsRegex matches a quantity grouped in thousands with dots, as some locales show it
+var dotThousandsRegex = regexp.MustCompile(`^\d{1,3}(\.\d{3})+$`)
+
+// NormalizeQuantityString reduces a quantity as a popup shows it, such as "1,234,567.00 ISK", to
+// the digits and thousands separators recorded in the report, "1,234,567". Dots grouping
+// thousands, as in "1.234.567", become commas. A fraction other than zeros is ErrNonIntegerISK.
+func NormalizeQuantityString(s string) (string, error) {
+	v := strings.TrimSpace(s)
+	if strings.HasSuffix(strings.ToUpper(v), strings.TrimSpace(iskSuffix)) {
+		v = strings.TrimSpace(v[:len(v)-len(strings.TrimSpace(iskSuffix))])
+	}
+	// A full stop after the amount is punctuation, not a decimal point
+	v = strings.TrimRight(v, ".")
+
+	if dotThousandsRegex.MatchString(v) {
+		return strings.Replace(v, ".", ",", -1), nil
+	}
+
+	switch strings.Count(v, ".") {
+	case 0:
+		return v, nil
+	case 1:
+		i := strings.Index(v, ".")
+		if strings.Trim(v[i+1:], "0") != "" {
+			return "", fmt.Errorf("%w: %q", ErrNonIntegerISK, s)
+		}
+		return v[:i], nil
+	default:
+		return "", fmt.Errorf("Unable to parse quantity %q", s)
+	}
+}
+
+// splitQuantitySign splits the sign off a captured quantity. A leading hyphen or minus sign, or
+// accounting parentheses as in "(1,234)", mark a negative amount such as a withdrawal.
+func splitQuantitySign(s string) (negative bool, quantity string) {
+	v := strings.TrimSpace(s)
+	if strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")") {
+		return true, strings.TrimSpace(v[1 : len(v)-1])
+	}
+	// A lone closing parenthesis is punctuation after the amount
+	v = strings.TrimSuffix(v, ")")
+	for _, minus := range []string{"-", "\u2212"} {
+		if strings.HasPrefix(v, minus) {
+			return true, strings.TrimPrefix(v, minus)
+		}
+	}
+	return false, v
+}
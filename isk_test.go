@@ -0,0 +1,119 @@
+package trimark
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestNormalizeQuantityString(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr error
+	}{
+		{"1,234,567.00 ISK", "1,234,567", nil},
+		{"500 ISK", "500", nil},
+		{"500 isk", "500", nil},
+		{"500", "500", nil},
+		{"500.", "500", nil},
+		{"1.234.567", "1,234,567", nil},
+		{"500.50 ISK", "", ErrNonIntegerISK},
+		{"1.234.567,50", "", nil},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeQuantityString(tt.in)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("NormalizeQuantityString(%q) = %q, want an error", tt.in, got)
+		case tt.want != "" && err != nil:
+			t.Errorf("NormalizeQuantityString(%q) failed: %v", tt.in, err)
+		case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+			t.Errorf("NormalizeQuantityString(%q) error = %v, want %v", tt.in, err, tt.wantErr)
+		case got != tt.want:
+			t.Errorf("NormalizeQuantityString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalQuantityIsExtracted(t *testing.T) {
+	sc, fakeDrive, _ := NewTestServiceContext(t, WithPreloadedFiles([]*drive.File{
+		{Id: "whole", Title: "whole.txt", MimeType: "text/plain"},
+		{Id: "fraction", Title: "fraction.txt", MimeType: "text/plain"},
+	}))
+	fakeDrive.SetContent("whole", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,234,567.00")))
+	fakeDrive.SetContent("fraction", []byte(donationText("2020-06-18 12:34:56", "Pilot Two", "500.50")))
+
+	for _, r := range runBatch(t, sc) {
+		switch r.result.FileName {
+		case "whole.txt":
+			if r.err != nil || r.result.Quantity != "1,234,567" {
+				t.Errorf("whole.txt quantity = %q, %v, want 1,234,567", r.result.Quantity, r.err)
+			}
+		case "fraction.txt":
+			if !strings.Contains(r.result.Error, ErrNonIntegerISK.Error()) {
+				t.Errorf("fraction.txt error = %q, want %v", r.result.Error, ErrNonIntegerISK)
+			}
+		}
+	}
+	if f := fakeDrive.File("fraction"); !inFolder(f, testFailedFolderID) {
+		t.Error("Upload with a fraction of an ISK was not moved to Failed")
+	}
+}
+
+func TestSplitQuantitySign(t *testing.T) {
+	tests := []struct {
+		in       string
+		negative bool
+		want     string
+	}{
+		{"1,234", false, "1,234"},
+		{"(1,234)", true, "1,234"},
+		{"( 1,234 ISK )", true, "1,234 ISK"},
+		{"-1,234", true, "1,234"},
+		{"−1,234", true, "1,234"},
+		{"1,234)", false, "1,234"},
+	}
+	for _, tt := range tests {
+		negative, got := splitQuantitySign(tt.in)
+		if negative != tt.negative || got != tt.want {
+			t.Errorf("splitQuantitySign(%q) = %v, %q, want %v, %q", tt.in, negative, got, tt.negative, tt.want)
+		}
+	}
+}
+
+func TestExtractSignedQuantity(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	tests := []struct {
+		quantity string
+		negative bool
+		want     string
+	}{
+		{"1,234", true, "1,234"},
+		{"(1,234)", true, "-1,234"},
+		{"-1,234", true, "-1,234"},
+		{"−1,234.00", true, "-1,234"},
+		{"1,234", false, "1,234"},
+		{"(1,234)", false, ""},
+		{"−1,234", false, ""},
+	}
+	for _, tt := range tests {
+		config.NegativeAmounts = tt.negative
+		text := donationText("2020-06-18 12:34:56", "Pilot One", tt.quantity)
+		record, err := extractData(ioutil.NopCloser(strings.NewReader(text)))
+		if tt.want == "" {
+			if err == nil && record.Quantity != "" {
+				t.Errorf("NegativeAmounts=%v: %q extracted as %q, want no quantity", tt.negative, tt.quantity, record.Quantity)
+			}
+			continue
+		}
+		if err != nil || record.Quantity != tt.want {
+			t.Errorf("NegativeAmounts=%v: %q extracted as %q, %v, want %q", tt.negative, tt.quantity, record.Quantity, err, tt.want)
+		}
+	}
+}
@@ -4,37 +4,43 @@ package trimark
 import (
 	"bytes"
 	"context"
-	"crypto/md5"
-	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"image/png"
 	//screenshots
 	_ "image/jpeg"
 
 	"github.com/oliamb/cutter"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 )
 
-//FolderIDEnv name of the Drive Folder Id
+// FolderIDEnv name of the Drive Folder Id
 const FolderIDEnv = "DRIVE_FOLDER_ID"
 
-//UploadFolderName is the folder name for file uploads
+// UploadFolderName is the folder name for file uploads
 const UploadFolderName = "UploadHere"
 
-//ProcessedFolderName is the folder name to place processed files
+// ProcessedFolderName is the folder name to place processed files
 const ProcessedFolderName = "Processed"
 
 // FailedFolderName is the folder name where OCR has failed
@@ -43,392 +49,1943 @@ const FailedFolderName = "Failed"
 // ReportFolderName is the folder which contains the end result sheet
 const ReportFolderName = "Report"
 
+// OCRArchiveFolderName is the folder OCR documents are moved to when QuarantineOCRDocsEnv is set
+const OCRArchiveFolderName = "OCR-Archive"
+
+// BackupFolderName is the folder holding the daily CSV backups of the report when CSVBackupEnv is set
+const BackupFolderName = "Backup"
+
 // SheetName is the file name for the report
 const SheetName = "ISK Import Report"
 
+// FolderMimeType is the Drive MIME type of a folder
+const FolderMimeType = "application/vnd.google-apps.folder"
+
+// SpreadsheetMimeType is the Drive MIME type of a Google Sheet
+const SpreadsheetMimeType = "application/vnd.google-apps.spreadsheet"
+
+// DocumentMimeType is the Drive MIME type of a Google Doc, uploads converted to it are OCRed
+const DocumentMimeType = "application/vnd.google-apps.document"
+
+// octetStreamMimeType is what Drive labels uploads it can't identify, which are sniffed instead
+const octetStreamMimeType = "application/octet-stream"
+
+// ProcessTimeoutEnv is the number of seconds each file may take before it is abandoned
+const ProcessTimeoutEnv = "PROCESS_TIMEOUT_SECONDS"
+
+const defaultProcessTimeout = 180 * time.Second
+
+// PropertyFilterEnv restricts processing to files tagged with a Drive property, as "key" or "key=value"
+const PropertyFilterEnv = "PROCESS_PROPERTY"
+
+// AmountDecimalsEnv is the number of decimal places amounts are rounded to, unset records them as extracted
+const AmountDecimalsEnv = "AMOUNT_DECIMALS"
+
+// QuarantineOCRDocsEnv moves OCR documents of processed files to the OCR-Archive folder instead of leaving them in Processed
+const QuarantineOCRDocsEnv = "QUARANTINE_OCR_DOCS"
+
+// DryRunEnv reports what would be extracted from the uploads without changing the sheet or moving files
+const DryRunEnv = "DRY_RUN"
+
+// SheetsWritesPerMinuteEnv caps the rate of rows appended to the report, independent of Drive usage
+const SheetsWritesPerMinuteEnv = "SHEETS_WRITES_PER_MINUTE"
+
+// PreprocessCLAHEEnv enables contrast equalisation of screenshots before OCR
+const PreprocessCLAHEEnv = "PREPROCESS_CLAHE"
+
+// PreprocessOtsuEnv enables black and white binarisation of screenshots before OCR
+const PreprocessOtsuEnv = "PREPROCESS_OTSU"
+
+// RejectZeroQuantityEnv sends extractions with a quantity of 0, almost always an OCR error, to Failed
+const RejectZeroQuantityEnv = "REJECT_ZERO_QUANTITY"
+
+// MemberMonthlyTotalsEnv maintains a member by month totals tab in the report, ready for charting
+const MemberMonthlyTotalsEnv = "MEMBER_MONTHLY_TOTALS"
+
+// AmountMultiplierEnv scales every extracted amount, the unscaled amount is kept in a Raw Amount column
+const AmountMultiplierEnv = "AMOUNT_MULTIPLIER"
+
+// DebugEnv enables debug logging
+const DebugEnv = "DEBUG"
+
+// SerialEnv processes uploads one at a time instead of concurrently
+const SerialEnv = "SERIAL"
+
+// WatchAddressEnv is the HTTPS address of HandleWatchNotification, which Drive push notifications are sent to
+const WatchAddressEnv = "WATCH_ADDRESS"
+
+// WatchTokenEnv is a shared secret Drive echoes back on each push notification, notifications
+// are refused when it is unset
+const WatchTokenEnv = "WATCH_TOKEN"
+
+// UploaderColumnEnv adds an Uploader column with who uploaded each screenshot
+const UploaderColumnEnv = "UPLOADER_COLUMN"
+
+// PreserveOriginalEnv leaves uploads untouched in the Upload folder, processing a copy of each instead
+const PreserveOriginalEnv = "PRESERVE_ORIGINAL"
+
+// MaxAttemptsEnv retries uploads which fail to process on later runs, moving them to Failed after
+// this many attempts. Unset, a failure stops the run.
+const MaxAttemptsEnv = "MAX_ATTEMPTS"
+
+// FolderRevalidateIntervalEnv is how many minutes apart Main checks the folders still exist
+const FolderRevalidateIntervalEnv = "FOLDER_REVALIDATE_INTERVAL_MINUTES"
+
+const defaultFolderRevalidateInterval = 5 * time.Minute
+
+// DateFormatEnv is a Sheets date time pattern for the date columns, such as "yyyy-mm-dd hh:mm:ss"
+const DateFormatEnv = "DATE_FORMAT"
+
+// AmountFormatEnv is a Sheets number pattern for the Amount column, such as "#,##0"
+const AmountFormatEnv = "AMOUNT_FORMAT"
+
+// DedupStoreEnv selects where donation checksums are claimed, "firestore" or unset for Drive properties
+const DedupStoreEnv = "DEDUP_STORE"
+
+// DedupProjectEnv is the Google Cloud project of the DedupStoreEnv Firestore database
+const DedupProjectEnv = "DEDUP_PROJECT"
+
+// TrimTopEnv trims the top of screenshots before cropping, in pixels such as "96" or a ratio such as "0.05" or "5%"
+const TrimTopEnv = "TRIM_TOP"
+
+// TrimBottomEnv trims the bottom of screenshots before cropping, as TrimTopEnv
+const TrimBottomEnv = "TRIM_BOTTOM"
+
+// TrimLeftEnv trims the left of screenshots before cropping, as TrimTopEnv
+const TrimLeftEnv = "TRIM_LEFT"
+
+// TrimRightEnv trims the right of screenshots before cropping, as TrimTopEnv
+const TrimRightEnv = "TRIM_RIGHT"
+
+// AllowResetEnv enables the /admin/reset endpoint, which deletes everything processed so far
+const AllowResetEnv = "ALLOW_RESET"
+
+// AdminTokenEnv is the bearer token admin endpoints require, they refuse every request when it is unset
+const AdminTokenEnv = "ADMIN_TOKEN"
+
+// GCSInputBucketEnv reads uploads from a Cloud Storage bucket instead of the UploadHere folder
+const GCSInputBucketEnv = "GCS_INPUT_BUCKET"
+
+// GCSInputPrefixEnv limits GCSInputBucketEnv to objects under a prefix, such as "screenshots/"
+const GCSInputPrefixEnv = "GCS_INPUT_PREFIX"
+
+// CheckpointEnv, when true, records the uploads a batch has processed in the master folder, so a
+// run which runs out of time is resumed by the next one without revisiting them
+const CheckpointEnv = "CHECKPOINT"
+
+// NeedsReviewEnv lists the heuristics, of ocr, amount and partial, which flag a recorded row in a Needs Review column
+const NeedsReviewEnv = "NEEDS_REVIEW"
+
+// ReviewMaxAmountEnv is the amount above which the amount heuristic flags a row, zero amounts are always flagged
+const ReviewMaxAmountEnv = "REVIEW_MAX_AMOUNT"
+
+// NotificationEmailToEnv is the address emailed when an upload is given up on after MaxAttemptsEnv,
+// no email is sent when it is unset
+const NotificationEmailToEnv = "NOTIFICATION_EMAIL_TO"
+
+// NotificationEmailFromEnv is the sender of notification emails, required with NotificationEmailToEnv
+const NotificationEmailFromEnv = "NOTIFICATION_EMAIL_FROM"
+
+// SMTPHostEnv is the mail server notification emails are sent through, required with NotificationEmailToEnv
+const SMTPHostEnv = "SMTP_HOST"
+
+// SMTPPortEnv is the port of SMTPHostEnv, 25 by default
+const SMTPPortEnv = "SMTP_PORT"
+
+// VerifyWriteEnv, when true, reads each appended row back before the upload is moved to Processed,
+// sending it to Failed if the row doesn't match
+const VerifyWriteEnv = "VERIFY_WRITE"
+
+// IgnoreUsernamesEnv is a comma separated list of usernames, such as bot accounts, whose donations
+// aren't recorded. Names are compared case insensitively.
+const IgnoreUsernamesEnv = "IGNORE_USERNAMES"
+
+// ExtractRetriesEnv is how many times, up to 3, a screenshot is OCRed again in the same run
+// when nothing could be extracted from it, before it is sent to Failed
+const ExtractRetriesEnv = "EXTRACT_RETRIES"
+
+// ExtractRetryDelayEnv is the seconds, up to 30, to wait before OCRing a screenshot again, 5 by default
+const ExtractRetryDelayEnv = "EXTRACT_RETRY_DELAY_SECONDS"
+
+// VerifySheetWritesEnv, true by default, checks the ID column of each appended row holds its checksum.
+// Set it to false to save the extra read.
+const VerifySheetWritesEnv = "VERIFY_SHEET_WRITES"
+
+// QuantityAgreementEnv is what happens when the quantity patterns match different numbers: first, the
+// default, takes the first pattern's; review flags the row in the Needs Review column; strict sends the file to Failed
+const QuantityAgreementEnv = "QUANTITY_AGREEMENT"
+
+// QuantityFallbackPatternEnv is a quantity pattern, with a (?P<quantity>) group, tried after the built-in
+// ones, for OCR layouts they don't recognise
+const QuantityFallbackPatternEnv = "QUANTITY_FALLBACK_PATTERN"
+
+// Values of QuantityAgreementEnv
+const (
+	QuantityAgreementFirst  = "first"
+	QuantityAgreementReview = "review"
+	QuantityAgreementStrict = "strict"
+)
+
+// ProcessWindowStartEnv and ProcessWindowEndEnv limit Main to a time of day, as HH:MM, such as 02:00 and 06:00.
+// An end before the start wraps past midnight.
+const ProcessWindowStartEnv = "PROCESS_WINDOW_START"
+
+// ProcessWindowEndEnv is the end of the processing window, see ProcessWindowStartEnv
+const ProcessWindowEndEnv = "PROCESS_WINDOW_END"
+
+// ProcessWindowTimezoneEnv is the IANA time zone of the processing window, UTC by default
+const ProcessWindowTimezoneEnv = "PROCESS_WINDOW_TZ"
+
+// FailuresTabEnv, when true, lists the uploads sent to Failed, with the reason, in a Failures tab of the report
+const FailuresTabEnv = "FAILURES_TAB"
+
+// FailuresThumbnailEnv, when true, adds a thumbnail of each failed upload to the Failures tab
+const FailuresThumbnailEnv = "FAILURES_THUMBNAIL"
+
+// ImageInfoColumnsEnv, when true, records the size in bytes and the dimensions of each screenshot
+// in columns after Link
+const ImageInfoColumnsEnv = "IMAGE_INFO_COLUMNS"
+
+// WarmupAsyncEnv, when true, sets up the folders and report sheet in the background at startup,
+// so a cold start doesn't wait on the Drive calls. Main waits for it, and returns 503 if it failed.
+const WarmupAsyncEnv = "WARMUP_ASYNC"
+
+// ExpectFilesEnv, when true, lists an empty Upload folder again after ExpectFilesDelayEnv,
+// in case the run fired just before an upload landed
+const ExpectFilesEnv = "EXPECT_FILES"
+
+// ExpectFilesDelayEnv is the seconds, up to 30, to wait before listing an empty Upload folder again, 5 by default
+const ExpectFilesDelayEnv = "EXPECT_FILES_DELAY_SECONDS"
+
+// MonthlySheetsEnv, when true, appends each donation to a report spreadsheet for the month of its
+// Echoes date, such as "ISK Import Report 2020-06", created in the Report folder when needed.
+// The monthly report, export, rebuild and reset read every month's spreadsheet. The Member Totals
+// and Failures tabs stay in the main report, and there is no annual archive to make.
+const MonthlySheetsEnv = "MONTHLY_SHEETS"
+
+// UploadAgeWarningHoursEnv is the age in hours, 24 by default, past which an upload still waiting
+// in the Upload folder is logged as a backlog
+const UploadAgeWarningHoursEnv = "UPLOAD_AGE_WARNING_HOURS"
+
+// HighlightDuplicatesEnv, when true, adds a conditional format to the report highlighting IDs,
+// the donation checksums, which appear more than once
+const HighlightDuplicatesEnv = "HIGHLIGHT_DUPLICATES"
+
+// FlattenAlphaEnv, when true, flattens screenshots with transparency onto an opaque background before OCR
+const FlattenAlphaEnv = "FLATTEN_ALPHA"
+
+// AlphaBackgroundEnv is the #RRGGBB colour FlattenAlphaEnv flattens onto, white by default
+const AlphaBackgroundEnv = "ALPHA_BACKGROUND"
+
+// SecondaryOCRURLEnv is an OCR service each cropped screenshot is also POSTed to, which responds with
+// its text. Rows where it reads a different donation than the Docs OCR are flagged for review.
+// It is off by default, as every screenshot is OCRed twice.
+const SecondaryOCRURLEnv = "SECONDARY_OCR_URL"
+
+// NegativeAmountsEnv, when true, records amounts written with a minus sign or in accounting
+// parentheses, such as "(1,234)", as negative. Otherwise they aren't matched as a quantity.
+const NegativeAmountsEnv = "NEGATIVE_AMOUNTS"
+
+// RunIDColumnEnv, when true, adds a Run ID column holding the ID of the Main run which wrote each
+// row, so a batch can be found or removed together
+const RunIDColumnEnv = "RUN_ID_COLUMN"
+
+// MaxFileBytesEnv is the largest upload, in bytes, which is downloaded. Larger uploads are sent
+// to Failed. There is no limit by default.
+const MaxFileBytesEnv = "MAX_FILE_BYTES"
+
+// MultiParentPolicyEnv is what a move does with the other folders of a file which is in more than
+// one, "warn" (the default) to leave it in them and log it, or "detach" to remove it from them
+const MultiParentPolicyEnv = "MULTI_PARENT"
+
+// Policies of MultiParentPolicyEnv
+const (
+	MultiParentWarn   = "warn"
+	MultiParentDetach = "detach"
+)
+
+// RecursiveUploadEnv, when true, also processes uploads in subfolders of the Upload folder, up to
+// 5 folders deep. Processed uploads are moved out of their subfolder, which is left in place.
+const RecursiveUploadEnv = "RECURSIVE_UPLOAD"
+
+// SubfolderColumnEnv, when true, adds a Subfolder column holding the path below the Upload folder
+// of each RecursiveUploadEnv upload
+const SubfolderColumnEnv = "SUBFOLDER_COLUMN"
+
+// CSVBackupEnv, when true, also appends every row written to the report to a daily CSV file in
+// the Backup folder, a copy which edits to the sheet can't touch
+const CSVBackupEnv = "CSV_BACKUP"
+
+// MergeSplitScreenshotsEnv, when true, stitches the halves of a donation log split across two
+// screenshots into one image before OCR. Halves are named alike, ending -1 and -2 or _top and _bottom.
+const MergeSplitScreenshotsEnv = "MERGE_SPLIT_SCREENSHOTS"
+
+// TraceProjectEnv is the Google Cloud project whose Cloud Trace receives a span for each run, file
+// and processing stage. Tracing is off when it is unset.
+const TraceProjectEnv = "TRACE_PROJECT"
+
+// SummaryRowEnv places a row totalling the Amount column at the top or bottom of the report, or none (the default)
+const SummaryRowEnv = "SUMMARY_ROW"
+
+// extractionTimeout bounds each regex match against the OCR text, tests shorten it
+var extractionTimeout = 5 * time.Second
+
+// ErrExtractionTimeout is returned when matching the OCR text takes longer than extractionTimeout
+var ErrExtractionTimeout = errors.New("Extraction timed out")
+
+// ErrZeroQuantity is returned for a quantity of 0 when RejectZeroQuantityEnv is set
+var ErrZeroQuantity = errors.New("Quantity is zero")
+
+// ErrNotFound is returned when a Drive lookup has no results
+var ErrNotFound = errors.New("File not found")
+
+// ErrDeadlineExceeded is returned when a file takes longer than the processing timeout
+var ErrDeadlineExceeded = errors.New("Processing deadline exceeded")
+
+// ErrFileTooLarge is returned when an upload is larger than MaxFileBytesEnv
+var ErrFileTooLarge = errors.New("File too large")
+
+// ErrUnsupportedImage is returned when an upload's content isn't an image format we can decode
+var ErrUnsupportedImage = errors.New("Unsupported image")
+
+// ErrQuantityDisagreement is returned when QuantityAgreementEnv is strict and the quantity patterns matched different numbers
+var ErrQuantityDisagreement = errors.New("Quantity patterns disagree")
+
+// ErrInvalidFolderID is returned when FolderIDEnv isn't a bare Drive file ID
+var ErrInvalidFolderID = errors.New("Invalid Drive folder ID")
+
 var driveService *drive.Service
 var sheetService *sheets.Service
 
-//UploadFolderID is the ID of the folder
-var UploadFolderID string
+// UploadFolderID is the ID of the folder
+var UploadFolderID string
+
+// ProcessedFolderID is the ID of the folder
+var ProcessedFolderID string
+
+// FailedFolderID is the ID of the folder
+var FailedFolderID string
+
+// ReportFolderID is the ID of the folder
+var ReportFolderID string
+
+// OCRArchiveFolderID is the ID of the folder, empty unless quarantining OCR documents
+var OCRArchiveFolderID string
+
+// BackupFolderID is the ID of the folder, empty unless backing up rows to CSV
+var BackupFolderID string
+
+// SheetID is the sheet ID
+var SheetID string = ""
+
+// sheetsLimiter is nil when Sheets writes are not rate limited
+var sheetsLimiter *tokenBucket
+
+// dateRegex captures the date and time of a timestamp separately, so up to three spaces or
+// stray punctuation from the OCR between them are dropped. The timestamp may sit against
+// anything but another digit, which keeps it from matching inside longer numbers.
+var dateRegex = `(?:^|\D)(\d{4}-\d{2}-\d{2})[\s.,;:]{1,3}(\d{2}:\d{2}:\d{2})(?:\D|$)`
+var usernameRegex = `Member Donation.*[([](?P<Member>.*)[)\]]`
+
+// The quantity patterns only need (?i), as OCR doesn't keep the case of the labels. They have
+// no . for (?s) to let match a newline, and no ^ or $ for (?m) to anchor at line ends; the
+// line break is matched literally as the \r\n the exported text uses. Some popups show the
+// amount with decimals and an ISK suffix, which NormalizeQuantityString removes. The sign of a
+// withdrawal, a minus or accounting parentheses, is split off by splitQuantitySign.
+var quantityZeroRegex = `(?i)Member Donation\r\n(?P<quantity>[-\x{2212}(]?[0-9,.]*(?: ISK)?\)?)`
+var quantityFirstRegex = `(?i)Type\r\n(?P<quantity>[-\x{2212}(]?[0-9,.]*(?: ISK)?\)?)`
+var quantitySecondRegex = `(?i)Quantity\r\n(?P<quantity>[-\x{2212}(]?[0-9,.]*(?: ISK)?\)?)`
+
+// a1RangeRegex captures the first and optional last row of an A1 range without its tab name
+var a1RangeRegex = regexp.MustCompile(`^\$?[A-Za-z]+\$?(\d+)(?::\$?[A-Za-z]+\$?(\d+))?$`)
+
+// processedNameRegex matches the rowID-title-checksum names given to processed files
+var processedNameRegex = regexp.MustCompile(`^\d+-.*-[0-9a-f]{32}$`)
+
+// driveFileIDRegex matches the format of Drive file IDs
+var driveFileIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{25,50}$`)
+
+// headerRowRange is the whole header row, whichever optional columns are enabled. It's set by
+// setReportRanges, as a top summary row moves the header down.
+var headerRowRange = "Sheet1!1:1"
+
+var initOnce sync.Once
+
+// Initialize loads the config, creates the Google API clients from service.json and starts warmup,
+// once per instance. Every function calls it first, so an instance initialises on its first request,
+// and a server can call it before listening to warm up first. It isn't run by the package's init,
+// so the package builds and tests without credentials.
+func Initialize() {
+	initOnce.Do(initialize)
+}
+
+func initialize() {
+	var err error
+	config, err = LoadConfig(os.Getenv)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	setReportRanges(config.SummaryRowPolicy)
+
+	if err := validateExtractionPatterns(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if config.SheetsWritesPerMinute > 0 {
+		sheetsLimiter = newTokenBucket(config.SheetsWritesPerMinute)
+	}
+
+	driveService, sheetService, err = createServices("service.json")
+
+	if err != nil {
+		log.Fatalf("Unable to retrieve Drive client or files: %v", err)
+	}
+
+	if err := setupTracing("service.json"); err != nil {
+		log.Fatalf("Unable to set up tracing to %s: %v", config.TraceProject, err)
+	}
+
+	startWarmup()
+
+	emailNotifier = newEmailNotifier(config)
+
+	dedupStore, err = newDedupStore("service.json")
+	if err != nil {
+		log.Fatalf("Unable to set up %s: %v", DedupStoreEnv, err)
+	}
+
+	if config.GCSInputBucket != "" {
+		storageService, err = createStorageService("service.json")
+		if err != nil {
+			log.Fatalf("Unable to retrieve Cloud Storage client: %v", err)
+		}
+	}
+}
+
+// Main is the main function to do the processing
+func Main(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+
+	// The run's span is the parent of its files' spans, they're all exported before Main returns
+	_, runSpan := tracer.Start(r.Context(), "Main")
+	defer flushTraces()
+	defer runSpan.End()
+
+	// Scheduled invocations outside the window succeed without touching Drive
+	if !config.ProcessWindow.Contains(clock()) {
+		log.Printf("Outside processing window %s, not scanning", config.ProcessWindow)
+		fmt.Fprintf(w, "Outside processing window %s\n", config.ProcessWindow)
+		return
+	}
+
+	if !requireWarmup(w, r) {
+		return
+	}
+
+	if !config.DryRun {
+		err := ensureSheetHeader()
+		if err != nil {
+			log.Printf("Failed to verify sheet header: %v", err)
+			http.Error(w, "Unable to verify sheet header", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err := revalidateFolderIDs(r.Context())
+	if err != nil {
+		log.Printf("Failed to revalidate folders: %v", err)
+		http.Error(w, "Unable to revalidate folders", http.StatusInternalServerError)
+		return
+	}
+	folders := snapshotFolders()
+	r = r.WithContext(withFolders(r.Context(), folders))
+
+	runID, err := newRunID()
+	if err != nil {
+		log.Printf("Failed to generate a run ID: %v", err)
+		http.Error(w, "Unable to generate a run ID", http.StatusInternalServerError)
+		return
+	}
+	summary := &ProcessingSummary{DryRun: config.DryRun, RunID: runID}
+	runSpan.SetAttributes(attribute.String("run.id", runID), attribute.Bool("dry_run", config.DryRun))
+
+	stats, err := processUploads(r.Context(), batchOptions{
+		folders: folders,
+		runID:   runID,
+		span:    runSpan,
+		done: func(title string, result ExtractionResult, err error) {
+			if !config.DryRun {
+				return
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			summary.DryRunExtractions = append(summary.DryRunExtractions, result)
+		},
+	})
+	summary.Files = stats.Files
+	summary.Failed = stats.Failed
+	summary.Inconsistent = stats.Inconsistent
+	summary.NotStarted = stats.NotStarted
+	summary.OldestUploadAgeSeconds = int64(stats.OldestUpload / time.Second)
+	if err != nil {
+		log.Printf("Failed to process %s: %v", UploadFolderName, err)
+		reason := "Unable to process files"
+		var be *batchError
+		if errors.As(err, &be) {
+			reason = be.Reason
+		}
+		http.Error(w, reason, http.StatusInternalServerError)
+		return
+	}
+
+	summary.StageDurations = stagePercentiles(summary.Files)
+	runSpan.SetAttributes(attribute.Int("files", len(summary.Files)), attribute.Int("failed", summary.Failed))
+	lastStageDurationsMu.Lock()
+	lastStageDurations = summary.StageDurations
+	lastStageDurationsMu.Unlock()
+
+	if stats.ReadOnly != nil {
+		log.Printf("ERROR: %v", stats.ReadOnly)
+		http.Error(w, fmt.Sprintf("%s is protected or read-only. Uploads which couldn't be recorded were left in %s and %d were not started. Give the service account edit access to the sheet and its Sheet1 range, then run again.", SheetName, UploadFolderName, summary.NotStarted), http.StatusInternalServerError)
+		return
+	}
+
+	// The response can't be written to a cancelled request, so the summary goes to the log
+	if err := r.Context().Err(); err != nil {
+		summary.Cancelled = true
+		partial, _ := json.Marshal(summary)
+		log.Printf("WARN: request cancelled (%v), in-flight files finished and %d were left for the next run: %s", err, summary.NotStarted, partial)
+		return
+	}
+
+	writeSummary(w, summary)
+}
+
+// uploadCandidates filters a page of the Upload folder down to the uploads to process, logging
+// why the others are skipped. failed counts the uploads which couldn't be checked, they're left
+// for the next run. cp is nil unless CheckpointEnv is set.
+func uploadCandidates(ctx context.Context, files []*drive.File, cp *checkpoint) (candidates []*drive.File, failed int) {
+	for _, c := range files {
+		// v2 can't query properties, so the listing is filtered here
+		if config.PropertyFilterKey != "" && !hasProperty(c, config.PropertyFilterKey, config.PropertyFilterValue) {
+			log.Printf("Skipping %s, it is not tagged with %s %q", c.Title, config.PropertyFilterKey, config.PropertyFilterValue)
+			continue
+		}
+
+		if processedNameRegex.MatchString(c.Title) {
+			log.Printf("Skipping %s, it has already been processed", c.Title)
+			continue
+		}
+
+		if cp != nil && cp.has(c.Id) {
+			log.Printf("Skipping %s, the checkpoint records it as processed", c.Title)
+			continue
+		}
+
+		if config.PreserveOriginal && !hasProperty(c, originalPropertyKey, "") {
+			copied, err := hasWorkingCopy(ctx, c.Id)
+			if err != nil {
+				log.Printf("ERROR: failed to look for a copy of %s: %v", c.Title, err)
+				failed++
+				continue
+			}
+			if copied {
+				debugf("Skipping %s, it is a preserved original", c.Title)
+				continue
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, failed
+}
+
+// processFile crops, OCRs and records a single uploaded file.
+// In dry run mode the extraction is reported without touching the sheet or the uploaded file.
+func processFile(ctx context.Context, fileDetails *drive.File) (result ExtractionResult, err error) {
+	result = ExtractionResult{FileID: fileDetails.Id, FileName: fileDetails.Title}
+	defer func() {
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("%w: %v", ErrDeadlineExceeded, err)
+		}
+	}()
+	// A recorded Error is an upload which was sent to Failed
+	defer func() {
+		if err == nil && result.Error != "" && !config.DryRun {
+			logFailure(ctx, fileDetails, result.Error)
+		}
+	}()
+
+	// The folder the upload was listed in, a subfolder of the Upload folder with RecursiveUploadEnv
+	sourceFolderID := uploadFolderFrom(ctx).ID
+
+	// The original stays in the Upload folder, untouched, while its copy is processed
+	if config.PreserveOriginal && !config.DryRun && !hasProperty(fileDetails, originalPropertyKey, "") {
+		fileDetails, err = copyOriginal(ctx, fileDetails)
+		if err != nil {
+			return result, err
+		}
+		// Copies are made in the Upload folder itself
+		sourceFolderID = foldersFrom(ctx).Upload
+	}
+
+	// The bottom half of a split screenshot follows its top half, which was the one processed
+	bottom := bottomHalfFrom(ctx)
+	bottomFolderID := uploadFolderFrom(ctx).ID
+
+	moveSource := func(ctx context.Context, failed bool) error {
+		folders := foldersFrom(ctx)
+		movedTo := folders.Processed
+		if failed {
+			_, err := moveFileToFolder(ctx, fileDetails, sourceFolderID, folders.Failed)
+			if err != nil {
+				return fmt.Errorf("Unable to move file to Failed: %v", err)
+			}
+			movedTo = folders.Failed
+		} else {
+			_, err := moveFileToFolder(ctx, fileDetails, sourceFolderID, folders.Processed)
+			if err != nil {
+				return fmt.Errorf("Unable to move file to Processed: %v", err)
+			}
+		}
+		if bottom != nil {
+			if _, err := moveFileToFolder(ctx, bottom, bottomFolderID, movedTo); err != nil {
+				return fmt.Errorf("Unable to move bottom half %s: %v", bottom.Title, err)
+			}
+		}
+		return nil
+	}
+
+	// Drive reports the size, so an oversized upload is rejected without downloading it
+	if config.MaxFileBytes > 0 && fileDetails.FileSize > config.MaxFileBytes {
+		return rejectUnsupported(ctx, result, fmt.Errorf("%w: %d bytes, the limit is %d", ErrFileTooLarge, fileDetails.FileSize, config.MaxFileBytes), moveSource)
+	}
+
+	if fileDetails.MimeType == octetStreamMimeType {
+		debugf("%s is labelled %s, sniffing its content", fileDetails.Title, octetStreamMimeType)
+	}
+
+	start := time.Now()
+	raw, err := downloadImage(ctx, fileDetails)
+	if err != nil {
+		return result, err
+	}
+	result.recordStage(ctx, "download", start)
+
+	if bottom != nil {
+		start = time.Now()
+		raw, err = stitchBottomHalf(ctx, raw, bottom)
+		result.recordStage(ctx, "stitch", start)
+		if errors.Is(err, ErrUnsupportedImage) {
+			return rejectUnsupported(ctx, result, err, moveSource)
+		}
+		if err != nil {
+			return result, err
+		}
+		debugf("Stitched %s below %s", bottom.Title, fileDetails.Title)
+	}
+
+	// Text uploads, such as the raw log, don't need cropping or OCR
+	if isPlainText(fileDetails, raw) {
+		debugf("%s is plain text, extracting from it directly", fileDetails.Title)
+		return recordText(ctx, result, fileDetails.Title, rowExtras{Uploader: uploaderOf(fileDetails)}, bytes.NewReader(raw), fileDetails, false, moveSource)
+	}
+
+	//Lets crop the image - remove some of the dead records
+	start = time.Now()
+	img, err := cropImageData(bytes.NewReader(raw))
+	result.recordStage(ctx, "crop", start)
+	if errors.Is(err, ErrUnsupportedImage) {
+		return rejectUnsupported(ctx, result, err, moveSource)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	return recordImage(ctx, result, fileDetails.Title, uploaderOf(fileDetails), img, moveSource)
+}
+
+// rejectUnsupported sends an upload which isn't a supported image, or is over MaxFileBytesEnv,
+// to Failed without OCRing it
+func rejectUnsupported(ctx context.Context, result ExtractionResult, reason error, moveSource moveSourceFunc) (ExtractionResult, error) {
+	result.Error = reason.Error()
+	if config.DryRun {
+		return result, nil
+	}
+	log.Printf("Rejecting %s: %v", result.FileName, reason)
+	return result, moveSource(ctx, true)
+}
+
+// moveSourceFunc moves an uploaded image out of the upload area once it has been OCRed
+type moveSourceFunc func(ctx context.Context, failed bool) error
+
+// isPlainText reports whether an upload is a text file, by both its name or label and its content
+func isPlainText(file *drive.File, raw []byte) bool {
+	labelled := file.MimeType == "text/plain" || strings.HasSuffix(strings.ToLower(file.Title), ".txt")
+	return labelled && strings.HasPrefix(http.DetectContentType(raw), "text/plain")
+}
+
+// recordImage OCRs a cropped image and records the extraction, moveSource is called once
+// the outcome is known. Dry runs never move the source.
+func recordImage(ctx context.Context, result ExtractionResult, title string, uploader string, img *croppedImage, moveSource moveSourceFunc) (ExtractionResult, error) {
+	mime := DocumentMimeType
+
+	//And Upload this as a text file...!
+	f := &drive.File{Title: title + ocrDocSuffix, MimeType: mime}
+	f.Parents = []*drive.ParentReference{&drive.ParentReference{Id: foldersFrom(ctx).Processed}}
+
+	// Compared with the Docs OCR by recordText, which only flags the row for review
+	if config.SecondaryOCRURL != "" {
+		start := time.Now()
+		result.secondaryText = runSecondaryOCR(ctx, title, img)
+		result.recordStage(ctx, "secondary_ocr", start)
+	}
+
+	for attempt := 0; ; attempt++ {
+		img.Seek(0, io.SeekStart)
+		start := time.Now()
+		r, err := driveService.Files.Insert(f).Media(img, googleapi.ContentType(img.MimeType())).Context(ctx).Do()
+		result.recordStage(ctx, "insert", start)
+
+		if err != nil {
+			return result, fmt.Errorf("Failed to create document: %v", err)
+		}
+
+		//and now we re-read it
+		start = time.Now()
+		textDoc, err := exportAsPlainText(ctx, r.Id)
+		result.recordStage(ctx, "export", start)
+		if err != nil {
+			return result, fmt.Errorf("Failed to download document: %v", err)
+		}
+		text, err := ioutil.ReadAll(textDoc)
+		textDoc.Close()
+		if err != nil {
+			return result, fmt.Errorf("Failed to download document: %v", err)
+		}
+
+		if attempt < config.ExtractRetries {
+			if _, extractErr := extractData(ioutil.NopCloser(bytes.NewReader(text))); extractErr != nil {
+				log.Printf("Extraction from %s failed, OCRing it again in %s: %v", title, config.ExtractRetryDelay, extractErr)
+				if err := retryOCRAfter(ctx, r, config.ExtractRetryDelay); err != nil {
+					return result, err
+				}
+				continue
+			}
+		}
+
+		extras := rowExtras{Uploader: uploader, ImageSizeBytes: img.SourceSize, ImageDimensions: img.SourceDimensions()}
+		return recordText(ctx, result, title, extras, bytes.NewReader(text), r, true, moveSource)
+	}
+}
+
+// retryOCRAfter deletes an OCR document which couldn't be extracted from and waits before it is redone
+func retryOCRAfter(ctx context.Context, doc *drive.File, delay time.Duration) error {
+	err := driveService.Files.Delete(doc.Id).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to delete document before retrying: %v", err)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// recordText extracts a donation from text and records it. doc is the OCR document the text
+// was exported from, or the upload itself when it was text already; it carries the checksum
+// claim, is renamed after the row and is what the row links to.
+func recordText(ctx context.Context, result ExtractionResult, title string, extras rowExtras, text io.Reader, doc *drive.File, ocr bool, moveSource moveSourceFunc) (ExtractionResult, error) {
+	r := doc
+
+	//Extract the information
+	start := time.Now()
+	record, extractErr := extractData(ioutil.NopCloser(text))
+	result.recordStage(ctx, "extract", start)
+	result.Date, result.Username, result.Quantity = record.Date, record.Username, record.Quantity
+	if extractErr != nil {
+		result.Error = extractErr.Error()
+		// The failure is still recorded, as a row without a donation
+		record = newRecord("", "", "")
+	}
+	record.SourceFileID = result.FileID
+	record.Link = r.DefaultOpenWithLink
+	if record.Link == "" {
+		// Text uploads have no default app to open them with
+		record.Link = r.AlternateLink
+	}
+	result.Ignored = extractErr == nil && config.IgnoredUsernames[record.Member]
+	if extractErr == nil && config.Review.Enabled() {
+		result.ReviewReasons = reviewReasons(config.Review, record)
+		if result.secondaryText != nil {
+			result.ReviewReasons = append(result.ReviewReasons, compareSecondaryOCR(record, *result.secondaryText)...)
+		}
+		if len(result.ReviewReasons) > 0 {
+			log.Printf("Flagging %s for review: %s", title, strings.Join(result.ReviewReasons, "; "))
+		}
+	}
+
+	if config.DryRun && !ocr {
+		return result, nil
+	}
+	if config.DryRun {
+		// The OCR document is only a temporary artifact in a dry run
+		err := driveService.Files.Delete(r.Id).Context(ctx).Do()
+		if err != nil {
+			return result, fmt.Errorf("Unable to delete dry run document: %v", err)
+		}
+		return result, nil
+	}
+
+	// Bot and system accounts are cleared out of the Upload folder without a row
+	if result.Ignored {
+		log.Printf("Skipping %s, %s is listed in %s", title, record.Username, IgnoreUsernamesEnv)
+		return result, moveSource(ctx, false)
+	}
+
+	// The upload only leaves the Upload folder once its row is written, or read back with
+	// VerifyWriteEnv, so a file which fails before then is retried by the next run
+	verify := config.VerifyWrite && extractErr == nil
+
+	// Guard against a concurrent or earlier run having written the same donation
+	if extractErr == nil {
+		claimed, err := claimDonation(ctx, r.Id, record.Checksum)
+		if err != nil {
+			return result, fmt.Errorf("Unable to claim checksum: %v", err)
+		}
+		if !claimed {
+			log.Printf("Skipping %s, its donation has already been recorded", title)
+			return result, moveSource(ctx, false)
+		}
+	}
+
+	//import it into the spreadsheet
+	start = time.Now()
+	extras.NeedsReview = len(result.ReviewReasons) > 0
+	extras.RunID = runIDFrom(ctx)
+	extras.Subfolder = uploadFolderFrom(ctx).Path
+	rowID, err := appendDataToSheet(ctx, record, extras)
+	result.recordStage(ctx, "append", start)
+	if err != nil && extractErr == nil {
+		// Let a later run record the donation
+		if err := releaseDonation(ctx, r.Id, record.Checksum); err != nil {
+			log.Printf("Unable to release checksum %s: %v", record.Checksum, err)
+		}
+	}
+	// An upload whose row can't be confirmed goes to Failed, other errors leave it in the Upload folder
+	if verify && (errors.Is(err, ErrWriteVerifyFailed) || errors.Is(err, ErrSheetWriteNotConfirmed)) {
+		return rejectUnverified(ctx, result, r, ocr, err, moveSource)
+	}
+	if errors.Is(err, ErrSheetWriteNotConfirmed) {
+		return result, err
+	}
+	if errors.Is(err, ErrSheetReadOnly) {
+		// A later run OCRs the upload again, so this document would only be left behind
+		if ocr {
+			if err := driveService.Files.Delete(r.Id).Context(ctx).Do(); err != nil {
+				log.Printf("Unable to delete document %s: %v", r.Id, err)
+			}
+		}
+		return result, err
+	}
+	if err != nil {
+		return result, fmt.Errorf("Unable to update spreadsheet: %v", err)
+	}
+	result.RowID = rowID
+	if extractErr == nil {
+		result.Checksum = record.Checksum
+	}
+
+	if extractErr == nil && dedupStore == nil {
+		err = commitChecksum(ctx, r.Id, rowID)
+		if err != nil {
+			log.Printf("Unable to mark %s as committed to row %s: %v", r.Id, rowID, err)
+		}
+	}
+
+	if config.MemberMonthlyTotals && extractErr == nil {
+		err = incrementMemberTotal(ctx, record)
+		if err != nil {
+			log.Printf("Unable to update %s for %s: %v", MemberTotalsTabName, record.Username, err)
+		}
+	}
+
+	moveRecorded(ctx, &result, title, rowID, extractErr != nil, moveSource)
+
+	// The document only follows its failure row to Failed, so a retry of an unwritten row doesn't
+	// leave a stray one there
+	if extractErr != nil && ocr {
+		folders := foldersFrom(ctx)
+		if _, err := moveFileToFolder(ctx, r, folders.Processed, folders.Failed); err != nil {
+			log.Printf("ERROR: inconsistency: document %s of row %s is still in %s: %v", r.Id, rowID, ProcessedFolderName, err)
+		}
+	}
+
+	// rename the files to make it easier to scan
+	renameFile(ctx, r, rowID+"-"+sanitizeFileName(r.Title)+"-"+record.Checksum)
+
+	// Failed OCR documents stay in Failed for triage
+	if config.QuarantineOCRDocs && extractErr == nil && ocr {
+		folders := foldersFrom(ctx)
+		_, err = moveFileToFolder(ctx, r, folders.Processed, folders.OCRArchive)
+		if err != nil {
+			return result, fmt.Errorf("Unable to move document to %s: %v", OCRArchiveFolderName, err)
+		}
+	}
+
+	return result, nil
+}
+
+// moveRecorded moves an upload to Processed, or Failed when nothing could be extracted from it,
+// once its row has been written. The row can't be taken back, so a failed move is logged as an
+// inconsistency to reconcile rather than failing the run; a later run finds the upload again and
+// skips it as a duplicate.
+func moveRecorded(ctx context.Context, result *ExtractionResult, title string, rowID string, failed bool, moveSource moveSourceFunc) {
+	start := time.Now()
+	err := moveSource(ctx, failed)
+	result.recordStage(ctx, "move", start)
+	if err != nil {
+		log.Printf("ERROR: inconsistency: %s is recorded in row %s but is still in %s: %v", title, rowID, UploadFolderName, err)
+		result.Inconsistent = true
+	}
+}
+
+// rejectUnverified sends an upload, and its OCR document, to Failed when its row didn't read back
+// as written, leaving the row for a person to compare with the screenshot
+func rejectUnverified(ctx context.Context, result ExtractionResult, doc *drive.File, ocr bool, reason error, moveSource moveSourceFunc) (ExtractionResult, error) {
+	result.Error = reason.Error()
+	log.Printf("ERROR: %s: %v", result.FileName, reason)
+	if ocr {
+		folders := foldersFrom(ctx)
+		_, err := moveFileToFolder(ctx, doc, folders.Processed, folders.Failed)
+		if err != nil {
+			return result, fmt.Errorf("Unable to move document to Failed: %v", err)
+		}
+	}
+	return result, moveSource(ctx, true)
+}
+
+// normalizeUsername folds the case and spacing of a username, which OCR doesn't reproduce reliably
+func normalizeUsername(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// uploaderOf names who uploaded a file, preferring an email address over a display name
+func uploaderOf(file *drive.File) string {
+	users := file.Owners
+	if file.LastModifyingUser != nil {
+		users = append([]*drive.User{file.LastModifyingUser}, users...)
+	}
+	for _, u := range users {
+		if u.EmailAddress != "" {
+			return u.EmailAddress
+		}
+	}
+	for _, u := range users {
+		if u.DisplayName != "" {
+			return u.DisplayName
+		}
+	}
+	return file.LastModifyingUserName
+}
+
+// debugf logs only when DebugEnv is set
+func debugf(format string, v ...interface{}) {
+	if config.Debug {
+		log.Printf("DEBUG: "+format, v...)
+	}
+}
+
+// hasProperty reports whether a file carries the property key, and value when one is given
+func hasProperty(file *drive.File, key string, value string) bool {
+	for _, p := range file.Properties {
+		if p.Key == key && (value == "" || p.Value == value) {
+			return true
+		}
+	}
+	return false
+}
+
+func createServices(jsonPath string) (*drive.Service, *sheets.Service, error) {
+	ctx := context.Background()
+	driveClient, err := newHTTPClient(ctx, jsonPath, quotaAPIDrive)
+	if err != nil {
+		return nil, nil, err
+	}
+	sheetsClient, err := newHTTPClient(ctx, jsonPath, quotaAPISheets)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	drive, err := drive.NewService(ctx, option.WithHTTPClient(driveClient))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sheet, err := sheets.NewService(ctx, option.WithHTTPClient(sheetsClient))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return drive, sheet, nil
+}
+
+// FolderSetupReport records which folders setupFolders found and which it had to create
+type FolderSetupReport struct {
+	Found     int               `json:"found"`
+	Created   int               `json:"created"`
+	FolderIDs map[string]string `json:"folderIds"`
+}
+
+// LastSetupReport is the report of the most recent setupFolders call
+var LastSetupReport FolderSetupReport
+
+func setupFolders(masterFolderID string) (report FolderSetupReport, err error) {
+	report.FolderIDs = map[string]string{}
+	defer func() {
+		LastSetupReport = report
+	}()
+
+	err = validateDriveFolderID(masterFolderID)
+	if err != nil {
+		return report, err
+	}
+
+	folders, err := getFilesFromFolder(masterFolderID, true)
+	if err != nil {
+		return report, fmt.Errorf("Unable to list folders in %s: %v", masterFolderID, err)
+	}
+	existing := map[string]string{}
+	for _, folder := range folders {
+		existing[folder.Title] = folder.Id
+	}
+
+	// Adding a folder is a line here, optional folders are only created when their feature is on
+	required := []struct {
+		name   string
+		id     *string
+		create bool
+	}{
+		{UploadFolderName, &UploadFolderID, true},
+		{ProcessedFolderName, &ProcessedFolderID, true},
+		{FailedFolderName, &FailedFolderID, true},
+		{ReportFolderName, &ReportFolderID, true},
+		{OCRArchiveFolderName, &OCRArchiveFolderID, config.QuarantineOCRDocs},
+		{BackupFolderName, &BackupFolderID, config.CSVBackup},
+	}
+
+	// Missing folders are created in parallel
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for _, f := range required {
+		// Under the lock, as the goroutines already started add to existing
+		existingFoldersMu.Lock()
+		_, found := existing[f.name]
+		existingFoldersMu.Unlock()
+		if !found && !f.create {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string, idVar *string, found bool) {
+			defer wg.Done()
+			id, err := GetOrCreateFolder(ctx, name, masterFolderID, existing)
+
+			// Folders which were created are kept even if another failed
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			*idVar = id
+			report.FolderIDs[name] = id
+			if found {
+				report.Found++
+			} else {
+				report.Created++
+			}
+		}(f.name, f.id, found)
+	}
+	wg.Wait()
+	return report, firstErr
+}
+
+// existingFoldersMu guards the maps of folder names given to GetOrCreateFolder, which
+// setupFolders calls concurrently
+var existingFoldersMu sync.Mutex
+
+// GetOrCreateFolder returns the ID of the named folder from existing, a map of folder names to
+// IDs, creating the folder in parentID and adding it to existing when it isn't there. Concurrent
+// calls may share existing, but not a name.
+func GetOrCreateFolder(ctx context.Context, name, parentID string, existing map[string]string) (string, error) {
+	existingFoldersMu.Lock()
+	id, ok := existing[name]
+	existingFoldersMu.Unlock()
+	if ok {
+		return id, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	f, err := createFolder(name, parentID)
+	if err != nil {
+		return "", fmt.Errorf("Unable to create folder %s: %w", name, err)
+	}
+	existingFoldersMu.Lock()
+	existing[name] = f.Id
+	existingFoldersMu.Unlock()
+	return f.Id, nil
+}
+
+func setupSheet(folderID string) error {
+	id, err := prepareSheet(folderID, SheetName)
+	if id != "" {
+		SheetID = id
+	}
+	return err
+}
+
+// prepareSheet finds the named report spreadsheet in a folder, creating it with a header row when
+// it's missing, and returns its ID. The ID is returned with any error setting up a sheet it found or created.
+func prepareSheet(folderID string, name string) (spreadsheetID string, err error) {
+	files, err := getFilesFromFolder(folderID, false)
+	if err != nil {
+		return "", err
+	}
+
+	for _, file := range files {
+		if file.Title != name {
+			continue
+		}
+		// A folder or doc given the report's name would break every Sheets call
+		if file.MimeType != SpreadsheetMimeType {
+			log.Printf("WARN: ignoring %s %s, it is a %s not a spreadsheet", name, file.Id, file.MimeType)
+			continue
+		}
+		spreadsheetID = file.Id
+		break
+	}
+
+	created := false
+	if spreadsheetID == "" {
+		//create a new one?
+		file, err := createSheet(name, folderID)
+		if err != nil {
+			return "", err
+		}
+
+		ss, err := sheetService.Spreadsheets.Get(file.Id).Do()
+		if err != nil {
+			return "", fmt.Errorf("Unable to open new report sheet %s: %w", file.Id, err)
+		}
+		spreadsheetID = ss.SpreadsheetId
+		created = true
+	}
+
+	// A new sheet's header goes in row 1 before the summary row, which leaves a bottom row below
+	// it and moves it down to headerRowRange when a top row is inserted above it
+	if created {
+		err = writeHeaderRow(spreadsheetID, "Sheet1!1:1")
+		if err != nil {
+			return spreadsheetID, err
+		}
+	}
+
+	if err := addSummaryRow(context.Background(), spreadsheetID, "Sheet1"); err != nil {
+		log.Printf("WARN: unable to add a summary row to %s: %v", name, err)
+	}
+
+	// Formatting is cosmetic, the report works without it
+	if err := applySheetFormats(context.Background(), spreadsheetID); err != nil {
+		log.Printf("WARN: unable to format %s: %v", name, err)
+	}
+	if err := highlightDuplicateChecksums(context.Background(), spreadsheetID); err != nil {
+		log.Printf("WARN: unable to highlight duplicates in %s: %v", name, err)
+	}
+	return spreadsheetID, nil
+}
+
+func writeSheetHeader(spreadsheetID string) error {
+	return writeHeaderRow(spreadsheetID, headerRowRange)
+}
+
+// writeHeaderRow writes the header into a row of a report sheet
+func writeHeaderRow(spreadsheetID string, a1 string) error {
+	values := [][]interface{}{buildHeaders()}
+
+	valueRange := &sheets.ValueRange{Values: values}
+
+	_, err := sheetService.Spreadsheets.Values.Update(spreadsheetID, a1, valueRange).ValueInputOption("USER_ENTERED").Do()
+	return err
+}
+
+// ensureSheetHeader restores the header row if a user has cleared or deleted it
+func ensureSheetHeader() error {
+	sheetID := snapshotFolders().Sheet
+	vr, err := sheetService.Spreadsheets.Values.Get(sheetID, headerRowRange).Do()
+	if err != nil {
+		return err
+	}
+
+	if len(vr.Values) == 1 && headerMatches(vr.Values[0]) {
+		return nil
+	}
+
+	if len(vr.Values) == 1 && len(vr.Values[0]) > 0 && fmt.Sprint(vr.Values[0][0]) == fmt.Sprint(buildHeaders()[0]) {
+		// Optional columns have been switched on or off since the header was written
+		log.Printf("Header row of %s is out of date, updating it", SheetName)
+	} else if len(vr.Values) == 1 && len(vr.Values[0]) > 0 {
+		// The header row holds data, so make room rather than overwrite it
+		log.Printf("Header row missing from %s, inserting a new header row above %v", SheetName, vr.Values[0])
+		insert := &sheets.Request{InsertDimension: &sheets.InsertDimensionRequest{
+			Range: &sheets.DimensionRange{
+				SheetId:         0,
+				Dimension:       "ROWS",
+				StartIndex:      headerRowIndex(),
+				EndIndex:        headerRowIndex() + 1,
+				ForceSendFields: []string{"SheetId", "StartIndex"},
+			},
+		}}
+		batch := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{insert}}
+		_, err = sheetService.Spreadsheets.BatchUpdate(sheetID, batch).Do()
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Printf("Header row missing from %s, restoring it", SheetName)
+	}
+
+	return writeSheetHeader(sheetID)
+}
+
+func headerMatches(row []interface{}) bool {
+	headers := buildHeaders()
+	if len(row) != len(headers) {
+		return false
+	}
+	for i, h := range headers {
+		if fmt.Sprint(row[i]) != h {
+			return false
+		}
+	}
+	return true
+}
+
+// filesOrderBy is the Drive ordering of folder listings, without one the order is unspecified
+const filesOrderBy = "createdDate"
+
+func getFilesFromFolder(folderID string, foldersOnly bool) ([]*drive.File, error) {
+	var cs []*drive.File
+	for page := range listFolderPages(context.Background(), folderID, foldersOnly) {
+		if page.err != nil {
+			fmt.Printf("An error occurred: %v\n", page.err)
+			return cs, page.err
+		}
+		cs = append(cs, page.files...)
+	}
+	return cs, nil
+}
+
+// filePage is a page of a folder listing, or the error which ended the listing
+type filePage struct {
+	files []*drive.File
+	err   error
 
-//ProcessedFolderID is the ID of the folder
-var ProcessedFolderID string
+	// folder is set by listUploadPages to the folder the page was listed from
+	folder uploadFolder
+}
 
-//FailedFolderID is the ID of the folder
-var FailedFolderID string
+// listFolderPages lists a folder a page at a time, fetching the next page while the caller works
+// through the current one. The channel is closed after the last page or an error; cancel ctx to
+// stop listing early.
+func listFolderPages(ctx context.Context, folderID string, foldersOnly bool) <-chan filePage {
+	var query = "'" + folderID + "' in parents"
+	if foldersOnly {
+		query = query + " AND mimeType = '" + FolderMimeType + "'"
+	}
 
-//ReportFolderID is the ID of the folder
-var ReportFolderID string
+	// One page of buffer is the prefetch, the lister blocks once it is a page ahead
+	pages := make(chan filePage, 1)
+	go func() {
+		defer close(pages)
+
+		pageToken := ""
+		for {
+			q := driveService.Files.List()
+			q = q.Q(query).OrderBy(filesOrderBy)
+			// If we have a pageToken set, apply it to the query
+			if pageToken != "" {
+				q = q.PageToken(pageToken)
+			}
+			r, err := q.Context(ctx).Do()
 
-//SheetID is the sheet ID
-var SheetID string = ""
+			page := filePage{err: err}
+			if err == nil {
+				page.files = r.Items
+			}
+			select {
+			case pages <- page:
+			case <-ctx.Done():
+				return
+			}
 
-var dateRegex = `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})`
-var usernameRegex = `Member Donation.*[([](?P<Member>.*)[)\]]`
-var quantityZeroRegex = `(?ims)Member Donation\r\n(?P<quantity>[0-9,]*)`
-var quantityFirstRegex = `(?ims)Type\r\n(?P<quantity>[0-9,]*)`
-var quantitySecondRegex = `(?ims)Quantity\r\n(?P<quantity>[0-9,]*)`
-var rowRegex = `.*:[A-Z](\d.*?)$`
+			if err != nil || r.NextPageToken == "" {
+				return
+			}
+			pageToken = r.NextPageToken
+		}
+	}()
+	return pages
+}
 
-func init() {
-	var err error
-	masterFolderID := os.Getenv(FolderIDEnv)
+// StableSortFiles sorts files in place by a Drive field, "title", "createdDate" or "modifiedDate",
+// keeping the existing order of ties. Other fields leave the files as they are.
+func StableSortFiles(files []*drive.File, field string) []*drive.File {
+	var key func(f *drive.File) string
+	switch field {
+	case "title":
+		key = func(f *drive.File) string { return f.Title }
+	case "createdDate":
+		key = func(f *drive.File) string { return f.CreatedDate }
+	case "modifiedDate":
+		key = func(f *drive.File) string { return f.ModifiedDate }
+	default:
+		return files
+	}
 
-	driveService, sheetService, err = createServices("service.json")
+	// Drive dates are all RFC 3339 in UTC, so they sort as strings
+	sort.SliceStable(files, func(i, j int) bool {
+		return key(files[i]) < key(files[j])
+	})
+	return files
+}
 
-	setupFolders(masterFolderID)
+// GetFileByName finds a file anywhere the service account can see, returning ErrNotFound if there is none
+func GetFileByName(ctx context.Context, name, mimeType string) (*drive.File, error) {
+	q := fmt.Sprintf("title='%s' AND mimeType='%s' AND trashed=false", escapeQuery(name), escapeQuery(mimeType))
+	return firstFile(ctx, q)
+}
 
-	setupSheet(ReportFolderID)
+// GetFileByNameInFolder finds a file within a folder, returning ErrNotFound if there is none
+func GetFileByNameInFolder(ctx context.Context, name, parentID string) (*drive.File, error) {
+	q := fmt.Sprintf("title='%s' AND '%s' in parents AND trashed=false", escapeQuery(name), escapeQuery(parentID))
+	return firstFile(ctx, q)
+}
 
+func firstFile(ctx context.Context, q string) (*drive.File, error) {
+	r, err := driveService.Files.List().Q(q).MaxResults(1).Context(ctx).Do()
 	if err != nil {
-		log.Fatalf("Unable to retrieve Drive client or files: %v", err)
+		return nil, err
 	}
+	if len(r.Items) == 0 {
+		return nil, ErrNotFound
+	}
+	return r.Items[0], nil
+}
 
+// escapeQuery escapes a value for use in a quoted Drive query string
+func escapeQuery(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	return strings.Replace(value, `'`, `\'`, -1)
 }
 
-// Main is the main function to do the processing
-func Main(w http.ResponseWriter, r *http.Request) {
-	// Step 1: Loop through the folder and find files to process
-	cs, err := getFilesFromFolder(UploadFolderID, false)
-	if err != nil {
-		log.Fatalf("Failed to get files from folder: %v", err)
+// validateDriveFolderID catches share URLs and other strings pasted in place of a folder ID
+func validateDriveFolderID(id string) error {
+	if id == "" || strings.Contains(id, "/") || !driveFileIDRegex.MatchString(id) {
+		return fmt.Errorf("%w: expected a bare Drive file ID like '1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgVE2upms', got: %s", ErrInvalidFolderID, id)
 	}
+	return nil
+}
 
-	// Step 2: Process files async (waitgroups)
-	var wg sync.WaitGroup
+func createSheet(name string, parentID string) (*drive.File, error) {
+	mime := SpreadsheetMimeType
+	return createEntity(name, parentID, mime)
+}
 
-	for _, c := range cs {
-		fileRef := driveService.Files.Get(c.Id)
-		fileDetails, err := fileRef.Do()
-		if err != nil {
-			log.Fatalf("Failed to get file: %v", err)
-		}
+func createFolder(name string, parentID string) (*drive.File, error) {
+	mime := FolderMimeType
+	return createEntity(name, parentID, mime)
+}
 
-		wg.Add(1)
+func createEntity(name string, parentID string, mime string) (*drive.File, error) {
+	f := &drive.File{Title: name, MimeType: mime}
+	p := &drive.ParentReference{Id: parentID}
+	f.Parents = []*drive.ParentReference{p}
+	return driveService.Files.Insert(f).Do()
+}
 
-		go func(fileRef2 *drive.File) {
-			defer wg.Done()
-			mime := "application/vnd.google-apps.document"
+func extractData(textDoc io.ReadCloser) (record Record, err error) {
+	//Get the content of the message
+	content, err := ioutil.ReadAll(textDoc)
+	if err != nil {
+		return Record{}, err
+	}
+	text := ContentNormalizer.Replace(string(stripBOM(content)))
 
-			//Lets crop the image - remove some of the dead records
-			img, err := cropImage(fileDetails)
+	//Get the date
+	dateResults, err := findSubmatch(dateRegex, text)
+	if err != nil {
+		return Record{}, err
+	}
+	if len(dateResults) != 3 {
+		return Record{}, errors.New("Date Not Found")
+	}
+	date := dateResults[1] + " " + dateResults[2]
 
-			//And Upload this as a text file...!
-			f := &drive.File{Title: fileDetails.Title + "_results", MimeType: mime}
-			f.Parents = []*drive.ParentReference{&drive.ParentReference{Id: ProcessedFolderID}}
+	//Get the username
+	usernameResults, err := findSubmatch(usernameRegex, text)
+	if err != nil {
+		return Record{}, err
+	}
+	if len(usernameResults) != 2 {
+		return Record{}, errors.New("Username Not Found")
+	}
 
-			r, err := driveService.Files.Insert(f).Media(img).Do()
+	type quantityPattern struct {
+		name    string
+		pattern string
+		hits    *int64
+	}
+	// The zero pattern is a rare occurance but important one, so it goes first
+	quantityPatterns := []quantityPattern{
+		{"quantityZeroRegex", quantityZeroRegex, &patternStats.ZeroPatternHits},
+		{"quantityFirstRegex", quantityFirstRegex, &patternStats.FirstPatternHits},
+		{"quantitySecondRegex", quantitySecondRegex, &patternStats.SecondPatternHits},
+	}
+	if config.QuantityFallbackPattern != "" {
+		quantityPatterns = append(quantityPatterns, quantityPattern{QuantityFallbackPatternEnv, config.QuantityFallbackPattern, &patternStats.EnvFallbackHits})
+	}
+	var quantity string
+	// Every distinct match, gathered when QuantityAgreementEnv has the patterns checked against each other
+	var candidates []string
+	for _, p := range quantityPatterns {
+		quantityResults, err := findSubmatch(p.pattern, text)
+		if err != nil {
+			return Record{}, err
+		}
 
-			if err != nil {
-				log.Fatalf("Failed to create document: %v", err)
+		matched := ""
+		if len(quantityResults) == 2 && quantityResults[1] != "" {
+			negative, unsigned := splitQuantitySign(quantityResults[1])
+			// Without NegativeAmountsEnv a signed amount is no match, as it was before signs were recognised
+			if negative && !config.NegativeAmounts {
+				unsigned = ""
 			}
-
-			//and now we re-read it
-			textDoc, err := driveService.Files.Export(r.Id, "text/plain").Download()
+			matched, err = NormalizeQuantityString(unsigned)
 			if err != nil {
-				log.Fatalf("Failed to download document: %v", err)
+				return Record{}, err
 			}
-			defer textDoc.Body.Close()
-
-			//Extract the information
-			date, username, quantity, err := extractData(textDoc.Body)
-			if err != nil {
-				_, err2 := moveFileToFolder(fileDetails, UploadFolderID, FailedFolderID)
-				if err2 != nil {
-					log.Fatalf("Unable to move file to Failed: %v", err)
-				}
-				_, err2 = moveFileToFolder(r, UploadFolderID, FailedFolderID)
-				if err2 != nil {
-					log.Fatalf("Unable to move file to Failed: %v", err)
-				}
-			} else {
-				_, err := moveFileToFolder(fileDetails, UploadFolderID, ProcessedFolderID)
-				if err != nil {
-					log.Fatalf("Unable to move file to Failed: %v", err)
-				}
+			if negative && matched != "" {
+				matched = "-" + matched
 			}
+		}
+		debugf("pattern_attempted patternName=%s matched=%t captureGroup=%q", p.name, matched != "", matched)
+		if matched == "" {
+			continue
+		}
 
-			//import it into the spreadsheet
-			rowID, cs, err := appendDataToSheet(date, username, quantity, r.DefaultOpenWithLink)
-			if cs == "" && err != nil {
-				log.Fatalf("Unable to update spreadsheet: %v", err)
-			}
-			if err != nil {
-				log.Fatalf("Couldn't Get RowID: %v", err)
-			}
+		if quantity == "" {
+			quantity = matched
+			atomic.AddInt64(p.hits, 1)
+			log.Printf("Quantity extracted by %s", p.name)
+		}
+		if config.QuantityAgreement == QuantityAgreementFirst {
+			break
+		}
+		if !containsQuantity(candidates, matched) {
+			candidates = append(candidates, matched)
+		}
+	}
+	if quantity == "" {
+		atomic.AddInt64(&patternStats.AllPatternsFailedCount, 1)
+		log.Printf("Quantity not matched by any pattern")
+		return Record{}, errors.New("Quantity Not Found")
+	}
 
-			// rename the files to make it easier to scan
-			renameFile(r, rowID+"-"+r.Title+"-"+cs)
-			renameFile(f, rowID+"-"+r.Title+"-"+cs)
+	if config.RejectZeroQuantity && strings.Trim(quantity, "-0,") == "" {
+		return Record{}, ErrZeroQuantity
+	}
 
-		}(fileDetails)
+	record = newRecord(date, usernameResults[1], quantity)
+	if len(candidates) > 1 {
+		if config.QuantityAgreement == QuantityAgreementStrict {
+			return Record{}, fmt.Errorf("%w: %s", ErrQuantityDisagreement, strings.Join(candidates, " or "))
+		}
+		record.QuantityCandidates = candidates
+	}
+	return record, nil
+}
 
+// containsQuantity reports whether a quantity is among quantities, ignoring thousands separators
+func containsQuantity(quantities []string, quantity string) bool {
+	for _, q := range quantities {
+		if strings.Replace(q, ",", "", -1) == strings.Replace(quantity, ",", "", -1) {
+			return true
+		}
 	}
-	wg.Wait()
+	return false
 }
 
-func createServices(jsonPath string) (*drive.Service, *sheets.Service, error) {
-	ctx := context.Background()
-	drive, err := drive.NewService(ctx, option.WithCredentialsFile(jsonPath))
-	if err != nil {
-		return nil, nil, err
+// validateExtractionPatterns compiles the extraction patterns up front, so a broken pattern stops
+// the function starting rather than panicking in findSubmatch on the first upload
+func validateExtractionPatterns() error {
+	patterns := []struct {
+		name    string
+		pattern string
+		group   string
+	}{
+		{"dateRegex", dateRegex, ""},
+		{"usernameRegex", usernameRegex, ""},
+		{"quantityZeroRegex", quantityZeroRegex, "quantity"},
+		{"quantityFirstRegex", quantityFirstRegex, "quantity"},
+		{"quantitySecondRegex", quantitySecondRegex, "quantity"},
+	}
+	for _, p := range patterns {
+		if strings.TrimSpace(p.pattern) == "" {
+			return fmt.Errorf("%s is empty", p.name)
+		}
+		re, err := regexp.Compile(p.pattern)
+		if err != nil {
+			return fmt.Errorf("%s doesn't compile: %v", p.name, err)
+		}
+		if p.group != "" && !hasSubexp(re, p.group) {
+			return fmt.Errorf("%s has no (?P<%s>) group", p.name, p.group)
+		}
 	}
+	return nil
+}
 
-	sheet, err := sheets.NewService(ctx, option.WithCredentialsFile(jsonPath))
-	if err != nil {
-		return nil, nil, err
+// hasSubexp reports whether a pattern has a named group
+func hasSubexp(re *regexp.Regexp, name string) bool {
+	for _, n := range re.SubexpNames() {
+		if n == name {
+			return true
+		}
 	}
+	return false
+}
 
-	return drive, sheet, nil
+// compiledPatterns holds the *regexp.Regexp of each pattern findSubmatch has been given
+var compiledPatterns sync.Map
+
+// matchSlots bounds the matches running at once, including those abandoned after extractionTimeout
+var matchSlots = make(chan struct{}, runtime.NumCPU())
+
+// findSubmatch runs a pattern against the OCR text, giving up after extractionTimeout.
+// Go's RE2 engine matches in linear time so it can't backtrack catastrophically, but
+// pathological OCR output can still be large enough to stall a file.
+func findSubmatch(pattern string, text string) ([]string, error) {
+	re, ok := compiledPatterns.Load(pattern)
+	if !ok {
+		re, _ = compiledPatterns.LoadOrStore(pattern, regexp.MustCompile(pattern))
+	}
+
+	timer := time.NewTimer(extractionTimeout)
+	defer timer.Stop()
+
+	// A matcher outlasting the timeout keeps its slot until it finishes, so stalled
+	// matches can't pile up
+	select {
+	case matchSlots <- struct{}{}:
+	case <-timer.C:
+		return nil, ErrExtractionTimeout
+	}
+
+	// Buffered so the matcher can finish and exit after a timeout
+	results := make(chan []string, 1)
+	go func() {
+		match := re.(*regexp.Regexp).FindStringSubmatch(text)
+		<-matchSlots
+		results <- match
+	}()
+
+	select {
+	case r := <-results:
+		return r, nil
+	case <-timer.C:
+		return nil, ErrExtractionTimeout
+	}
 }
 
-func setupFolders(masterFolderID string) (err error) {
-	folders, err := getFilesFromFolder(masterFolderID, true)
+// moveFileToFolder moves a file between folders, tolerating a previous partial move which left
+// it in toFolder already or took it out of fromFolder
+func moveFileToFolder(ctx context.Context, file *drive.File, fromFolder string, toFolder string) (*drive.File, error) {
+	parents, err := fileParentIDs(ctx, file.Id)
 	if err != nil {
-		fmt.Printf("An error occurred: %v\n", err)
+		return nil, fmt.Errorf("Unable to get parents of %s: %v", file.Title, err)
 	}
-	check := 0
-	for _, folder := range folders {
-		if folder.Title == UploadFolderName {
-			check = check | 1
-			UploadFolderID = folder.Id
-		}
-		if folder.Title == ProcessedFolderName {
-			check = check | 2
-			ProcessedFolderID = folder.Id
-		}
-		if folder.Title == FailedFolderName {
-			check = check | 4
-			FailedFolderID = folder.Id
-		}
-		if folder.Title == ReportFolderName {
-			check = check | 8
-			ReportFolderID = folder.Id
-		}
+	if parents[toFolder] {
+		log.Printf("%s is already in folder %s, not moving it", file.Title, toFolder)
+		return file, nil
 	}
-	if check != 15 {
-		if check&1 == 0 {
-			f, err := createFolder(UploadFolderName, masterFolderID)
-			if err != nil {
-				return err
-			}
-			UploadFolderID = f.Id
-		}
-		if check&2 == 0 {
-			f, err := createFolder(ProcessedFolderName, masterFolderID)
-			if err != nil {
-				return err
-			}
-			ProcessedFolderID = f.Id
-		}
-		if check&4 == 0 {
-			f, err := createFolder(FailedFolderName, masterFolderID)
-			if err != nil {
-				return err
-			}
-			FailedFolderID = f.Id
+
+	var remove []string
+	if parents[fromFolder] {
+		remove = append(remove, fromFolder)
+	} else {
+		log.Printf("WARN: %s isn't in folder %s, only adding it to %s", file.Title, fromFolder, toFolder)
+	}
+
+	// A file in other folders too stays in them after the move, see MultiParentPolicyEnv
+	var others []string
+	for id := range parents {
+		if id != fromFolder {
+			others = append(others, id)
 		}
-		if check&8 == 0 {
-			f, err := createFolder(ReportFolderName, masterFolderID)
-			if err != nil {
-				return err
-			}
-			ReportFolderID = f.Id
+	}
+	if len(others) > 0 {
+		sort.Strings(others)
+		if config.MultiParentPolicy == MultiParentDetach {
+			log.Printf("Removing %s from its other folders %v", file.Title, others)
+			remove = append(remove, others...)
+		} else {
+			log.Printf("WARN: %s is also in folders %v, it is left in them", file.Title, others)
 		}
 	}
-	return nil
+
+	call := driveService.Files.Update(file.Id, file).AddParents(toFolder)
+	if len(remove) > 0 {
+		call = call.RemoveParents(strings.Join(remove, ","))
+	}
+	return call.Context(ctx).Do()
 }
 
-func setupSheet(folderID string) (err error) {
-	files, err := getFilesFromFolder(folderID, false)
+// isFileInFolder reports whether folderID is one of a file's parents
+func isFileInFolder(ctx context.Context, fileID, folderID string) (bool, error) {
+	parents, err := fileParentIDs(ctx, fileID)
 	if err != nil {
-		return err
+		return false, err
 	}
+	return parents[folderID], nil
+}
 
-	for _, file := range files {
-		if file.Title == SheetName {
-			SheetID = file.Id
-			break
-		}
+// fileParentIDs fetches the current parents of a file, rather than trusting a stale *drive.File
+func fileParentIDs(ctx context.Context, fileID string) (map[string]bool, error) {
+	f, err := driveService.Files.Get(fileID).Fields("parents").Context(ctx).Do()
+	if err != nil {
+		return nil, err
 	}
+	parents := map[string]bool{}
+	for _, p := range f.Parents {
+		parents[p.Id] = true
+	}
+	return parents, nil
+}
 
-	if SheetID == "" {
-		//create a new one?
-		file, err := createSheet(SheetName, folderID)
-		if err != nil {
-			return err
-		}
-
-		ss, err := sheetService.Spreadsheets.Get(file.Id).Do()
-		SheetID = ss.SpreadsheetId
+// maxFileNameLength caps a sanitized file name, in characters
+const maxFileNameLength = 200
 
-		headers := []interface{}{"ID", "Import Date", "Echoes Date", "Name", "Amount", "Link"}
-		values := [][]interface{}{headers}
+// fileNameDisallowedRegex matches characters file systems reject, which a synced Drive would trip over
+var fileNameDisallowedRegex = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
 
-		valueRange := &sheets.ValueRange{Values: values}
+// dashRunRegex matches the runs of dashes left by joining names with dashes
+var dashRunRegex = regexp.MustCompile(`-{2,}`)
 
-		readRange := "Sheet1!A1:F1"
-		_, err = sheetService.Spreadsheets.Values.Update(SheetID, readRange, valueRange).ValueInputOption("USER_ENTERED").Do()
-		if err != nil {
-			return err
-		}
+// sanitizeFileName tidies a name for Drive: disallowed characters are removed, runs of dashes
+// collapsed, dashes trimmed from the ends and the name cut to maxFileNameLength characters
+func sanitizeFileName(name string) string {
+	name = fileNameDisallowedRegex.ReplaceAllString(name, "")
+	name = dashRunRegex.ReplaceAllString(name, "-")
+	name = strings.Trim(name, "-")
+	if runes := []rune(name); len(runes) > maxFileNameLength {
+		name = strings.TrimRight(string(runes[:maxFileNameLength]), "-")
 	}
-	return nil
+	return name
 }
 
-func getFilesFromFolder(folderID string, foldersOnly bool) ([]*drive.File, error) {
-	var cs []*drive.File
-	var query = "'" + folderID + "' in parents"
-	if foldersOnly {
-		query = query + " AND mimeType = 'application/vnd.google-apps.folder'"
+func renameFile(ctx context.Context, file *drive.File, newName string) error {
+	file.Title = newName
+	// Only the title is sent, file's parents may be stale after a move
+	_, err := driveService.Files.Patch(file.Id, &drive.File{Title: newName}).Context(ctx).Do()
+	return err
+}
+
+func appendDataToSheet(ctx context.Context, record Record, extras rowExtras) (rowID string, err error) {
+	now := time.Now().Format("01-02-2006 15:04:05")
+
+	amount, err := record.reportAmount()
+	if err != nil {
+		return "", err
 	}
+	values := [][]interface{}{buildRowValues(record, now, amount, extras)}
+
+	valueRange := &sheets.ValueRange{Values: values}
 
-	pageToken := ""
-	for {
-		q := driveService.Files.List()
-		q = q.Q(query)
-		// If we have a pageToken set, apply it to the query
-		if pageToken != "" {
-			q = q.PageToken(pageToken)
+	if sheetsLimiter != nil {
+		err = sheetsLimiter.Wait(ctx)
+		if err != nil {
+			return "", err
 		}
-		r, err := q.Do()
+	}
+
+	spreadsheetID := foldersFrom(ctx).Sheet
+	if config.MonthlySheets {
+		spreadsheetID, err = monthlySheetID(ctx, record.Date)
 		if err != nil {
-			fmt.Printf("An error occurred: %v\n", err)
-			return cs, err
+			return "", err
 		}
-		cs = append(cs, r.Items...)
-		pageToken = r.NextPageToken
-		if pageToken == "" {
-			break
+	}
+
+	r, err := sheetService.Spreadsheets.Values.Append(spreadsheetID, "Sheet1!A1:G1", valueRange).InsertDataOption("INSERT_ROWS").ValueInputOption("USER_ENTERED").IncludeValuesInResponse(true).Context(ctx).Do()
+	if isPermissionDenied(err) {
+		return "", fmt.Errorf("%w: %v", ErrSheetReadOnly, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	row, ok := appendedRow(r)
+	if !ok {
+		return "", errors.New("Unable to parse row which was imported")
+	}
+
+	// The row is in the sheet, so a failed backup is only logged
+	if config.CSVBackup {
+		if err := appendCSVBackup(ctx, values[0]); err != nil {
+			log.Printf("WARN: unable to back up row %d to %s: %v", row, BackupFolderName, err)
 		}
 	}
-	return cs, nil
+
+	// VerifyWriteEnv compares the whole row, which covers the checksum
+	if config.VerifyWrite {
+		err = verifyAppendedRow(ctx, spreadsheetID, row, values[0])
+	} else if config.VerifySheetWrites {
+		err = verifyRowWritten(ctx, spreadsheetID, fmt.Sprintf("Sheet1!A%d", row), record.Checksum)
+	}
+	return strconv.FormatInt(row, 10), err
+
 }
 
-func createSheet(name string, parentID string) (*drive.File, error) {
-	mime := "application/vnd.google-apps.spreadsheet"
-	return createEntity(name, parentID, mime)
+// appendedRow finds the row an append wrote to, falling back from the updated range to
+// the range of the returned data, then to the row after the table which was appended to
+func appendedRow(r *sheets.AppendValuesResponse) (int64, bool) {
+	if r.Updates != nil {
+		if row, ok := firstRow(r.Updates.UpdatedRange); ok {
+			return row, true
+		}
+		if r.Updates.UpdatedData != nil {
+			if row, ok := firstRow(r.Updates.UpdatedData.Range); ok {
+				return row, true
+			}
+		}
+	}
+	if row, ok := lastRow(r.TableRange); ok {
+		return row + 1, true
+	}
+	return 0, false
 }
 
-func createFolder(name string, parentID string) (*drive.File, error) {
-	mime := "application/vnd.google-apps.folder"
-	return createEntity(name, parentID, mime)
+// firstRow returns the first row of an A1 range such as 'Tab: 2020'!A5:F6
+func firstRow(a1 string) (int64, bool) {
+	m := a1RangeRegex.FindStringSubmatch(cellRange(a1))
+	if m == nil {
+		return 0, false
+	}
+	row, err := strconv.ParseInt(m[1], 10, 64)
+	return row, err == nil
 }
 
-func createEntity(name string, parentID string, mime string) (*drive.File, error) {
-	f := &drive.File{Title: name, MimeType: mime}
-	p := &drive.ParentReference{Id: parentID}
-	f.Parents = []*drive.ParentReference{p}
-	return driveService.Files.Insert(f).Do()
+// lastRow returns the last row of an A1 range such as 'Tab: 2020'!A5:F6
+func lastRow(a1 string) (int64, bool) {
+	m := a1RangeRegex.FindStringSubmatch(cellRange(a1))
+	if m == nil {
+		return 0, false
+	}
+	last := m[1]
+	if m[2] != "" {
+		last = m[2]
+	}
+	row, err := strconv.ParseInt(last, 10, 64)
+	return row, err == nil
 }
 
-func extractData(textDoc io.ReadCloser) (date string, username string, quantity string, err error) {
-	//Get the content of the message
-	content, err := ioutil.ReadAll(textDoc)
-	if err != nil {
-		return "", "", "", err
+// cellRange strips the tab name, which may be quoted and contain anything, from an A1 range
+func cellRange(a1 string) string {
+	if i := strings.LastIndex(a1, "!"); i >= 0 {
+		return a1[i+1:]
 	}
+	return a1
+}
 
-	//Get the date
-	rDate := regexp.MustCompile(dateRegex)
-	dateResults := rDate.FindStringSubmatch(string(content))
-	if len(dateResults) != 2 {
-		return "", "", "", errors.New("Date Not Found")
+// rowExtras holds the values of the optional report columns
+type rowExtras struct {
+	Uploader    string
+	NeedsReview bool
+
+	// ImageSizeBytes and ImageDimensions describe the screenshot, they're empty for text uploads
+	ImageSizeBytes  int64
+	ImageDimensions string
+
+	// RunID is the Main run which wrote the row, empty for rows from other handlers
+	RunID string
+
+	// Subfolder is the path below the Upload folder of a RecursiveUploadEnv upload
+	Subfolder string
+}
+
+// buildHeaders returns the report header row, one entry per buildRowValues column.
+// Optional columns follow Link when enabled.
+func buildHeaders() []interface{} {
+	headers := []interface{}{"ID", "Import Date", "Echoes Date", "Name", "Amount", "Link"}
+	if config.ImageInfoColumns {
+		headers = append(headers, "Image Size (B)", "Dimensions")
+	}
+	if config.AmountMultiplier != 1 {
+		headers = append(headers, "Raw Amount")
 	}
+	if config.UploaderColumn {
+		headers = append(headers, "Uploader")
+	}
+	if config.Review.Enabled() {
+		headers = append(headers, "Needs Review")
+	}
+	if config.RunIDColumn {
+		headers = append(headers, "Run ID")
+	}
+	if config.SubfolderColumn {
+		headers = append(headers, "Subfolder")
+	}
+	return headers
+}
 
-	//Get the username
-	rUser := regexp.MustCompile(usernameRegex)
-	usernameResults := rUser.FindStringSubmatch(string(content))
-	if len(usernameResults) != 2 {
-		return "", "", "", errors.New("Username Not Found")
-	}
-
-	//First pass - rare occurance but important one
-	rQuantity := regexp.MustCompile(quantityZeroRegex)
-	quantityResults := rQuantity.FindStringSubmatch(string(content))
-	if len(quantityResults) != 2 || (len(quantityResults) == 2 && quantityResults[1] == "") {
-		rQuantity = regexp.MustCompile(quantityFirstRegex)
-		quantityResults = rQuantity.FindStringSubmatch(string(content))
-		if len(quantityResults) != 2 || (len(quantityResults) == 2 && quantityResults[1] == "") {
-			//First failed, try second
-			rQuantity = regexp.MustCompile(quantitySecondRegex)
-			quantityResults = rQuantity.FindStringSubmatch(string(content))
-			if len(quantityResults) != 2 || (len(quantityResults) == 2 && quantityResults[1] == "") {
-				return "", "", "", errors.New("Quantity Not Found")
-			}
+// buildRowValues returns a report row in the column order of buildHeaders
+func buildRowValues(record Record, importDate, amount string, extras rowExtras) []interface{} {
+	values := []interface{}{record.Checksum, importDate, record.Date, record.Username, amount, record.Link}
+	if config.ImageInfoColumns {
+		size := ""
+		if extras.ImageSizeBytes > 0 {
+			size = strconv.FormatInt(extras.ImageSizeBytes, 10)
 		}
+		values = append(values, size, extras.ImageDimensions)
 	}
-	return dateResults[1], usernameResults[1], quantityResults[1], nil
+	if config.AmountMultiplier != 1 {
+		values = append(values, record.Quantity)
+	}
+	if config.UploaderColumn {
+		values = append(values, extras.Uploader)
+	}
+	if config.Review.Enabled() {
+		values = append(values, extras.NeedsReview)
+	}
+	if config.RunIDColumn {
+		values = append(values, extras.RunID)
+	}
+	if config.SubfolderColumn {
+		values = append(values, extras.Subfolder)
+	}
+	return values
 }
 
-func moveFileToFolder(file *drive.File, fromFolder string, toFolder string) (*drive.File, error) {
-	return driveService.Files.Update(file.Id, file).RemoveParents(fromFolder).AddParents(toFolder).Do()
+// scaleAmount multiplies an extracted amount, such as a stack count by the stack size
+func scaleAmount(amount string, multiplier float64) (string, error) {
+	value, err := strconv.ParseFloat(strings.Replace(amount, ",", "", -1), 64)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse amount %q: %v", amount, err)
+	}
+	return strconv.FormatFloat(value*multiplier, 'f', -1, 64), nil
 }
 
-func renameFile(file *drive.File, newName string) error {
-	file.Title = newName
-	_, err := driveService.Files.Update(file.Id, file).Do()
-	return err
-}
+// formatAmount rounds an extracted amount to the given number of decimal places.
+// Halves are rounded away from zero, so 2.5 becomes 3 with no decimals.
+func formatAmount(amount string, decimals int) (string, error) {
+	value, err := strconv.ParseFloat(strings.Replace(amount, ",", "", -1), 64)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse amount %q: %v", amount, err)
+	}
 
-func appendDataToSheet(date, name, amount, link string) (rowID string, checksum string, err error) {
-	cs := md5.Sum([]byte(date + name + amount))
-	css := hex.EncodeToString(cs[:])
-	now := time.Now().Format("01-02-2006 15:04:05")
-	values := [][]interface{}{[]interface{}{css, now, date, name, amount, link}}
+	scale := math.Pow(10, float64(decimals))
+	value = math.Round(value*scale) / scale
 
-	valueRange := &sheets.ValueRange{Values: values}
+	return strconv.FormatFloat(value, 'f', decimals, 64), nil
+}
 
-	r, err := sheetService.Spreadsheets.Values.Append(SheetID, "Sheet1!A1:G1", valueRange).InsertDataOption("INSERT_ROWS").ValueInputOption("USER_ENTERED").Do()
+func cropImage(ctx context.Context, file *drive.File) (*croppedImage, error) {
+	raw, err := downloadImage(ctx, file)
 	if err != nil {
-		return "", string(css), err
+		return nil, err
 	}
-	regex := regexp.MustCompile(rowRegex)
-	regexResults := regex.FindStringSubmatch(r.Updates.UpdatedRange)
+	return cropImageData(bytes.NewReader(raw))
+}
 
-	if len(regexResults) != 2 {
-		return "", css, errors.New("Unable to parse row which was imported")
+// downloadImage reads the content of an uploaded file
+func downloadImage(ctx context.Context, file *drive.File) ([]byte, error) {
+	iRaw, err := driveService.Files.Get(file.Id).Context(ctx).Download()
+	if err != nil {
+		return nil, fmt.Errorf("Download image -> %v", err)
 	}
-	return regexResults[1], css, nil
+	defer iRaw.Body.Close()
 
+	body := &progressReader{r: iRaw.Body, total: iRaw.ContentLength, onProgress: logDownloadProgress(file.Id)}
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadAll -> %v", err)
+	}
+	return raw, nil
 }
 
-func cropImage(file *drive.File) (*bytes.Reader, error) {
-	iRaw, err := driveService.Files.Get(file.Id).Download()
+// cropImageData crops a downloaded screenshot to the half the OCR should read
+func cropImageData(body io.Reader) (*croppedImage, error) {
+	imgByte, err := ioutil.ReadAll(body)
 	if err != nil {
-		log.Fatalf("Download image -> %v", err)
+		return nil, fmt.Errorf("ioutil.ReadAll -> %v", err)
 	}
-	defer iRaw.Body.Close()
 
-	imgByte, err := ioutil.ReadAll(iRaw.Body)
+	// Trust the magic bytes over the label, Drive reports some screenshots as octet-stream
+	format, err := detectFormat(imgByte)
 	if err != nil {
-		log.Fatalf("ioutil.ReadAll -> %v", err)
+		return nil, fmt.Errorf("%w: content is %s", ErrUnsupportedImage, http.DetectContentType(imgByte))
+	}
+	if format == formatWebP {
+		return nil, fmt.Errorf("%w: there is no WebP decoder", ErrUnsupportedImage)
 	}
 
 	img, _, err := image.Decode(bytes.NewReader(imgByte))
 	if err != nil {
-		log.Fatalf("image.Decode -> %v", err)
+		return nil, fmt.Errorf("image.Decode -> %v", err)
 	}
-	imageDetails, _, err := image.DecodeConfig(bytes.NewReader(imgByte))
-	if err != nil {
-		log.Fatalf("image.Decode -> %v", err)
+	sourceBounds := img.Bounds()
+
+	if config.FlattenAlpha {
+		img = flattenAlpha(img, config.AlphaBackground)
 	}
+
+	// Trim OS chrome such as status bars first, so it isn't part of the crop
+	if config.Trim.Enabled() {
+		img, err = trimBorders(img, config.Trim)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bounds := img.Bounds()
 	croppedImg, err := cutter.Crop(img, cutter.Config{
-		Width:  imageDetails.Width / 2,
-		Height: imageDetails.Height,
+		Width:  bounds.Dx() / 2,
+		Height: bounds.Dy(),
 	})
 	if err != nil {
-		log.Fatalf("cutter.Crop -> %v", err)
+		return nil, fmt.Errorf("cutter.Crop -> %v", err)
 	}
 
-	buf := new(bytes.Buffer)
-	err = png.Encode(buf, croppedImg)
-	if err != nil {
-		log.Fatalf("png.Encode -> %v", err)
+	if config.Preprocess.Enabled() {
+		croppedImg = PreprocessImage(croppedImg, config.Preprocess)
 	}
 
-	a := bytes.NewReader(buf.Bytes())
+	a, err := encodeImage(croppedImg, format)
+	if err != nil {
+		return nil, fmt.Errorf("encode %s -> %v", format, err)
+	}
+	a.SourceSize = int64(len(imgByte))
+	a.SourceBounds = sourceBounds
 
 	return a, nil
 }
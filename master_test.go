@@ -0,0 +1,1638 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Screenshot_2020-06-01.png", "Screenshot_2020-06-01.png"},
+		{`a<b>c:d"e/f\g|h?i*j.png`, "abcdefghij.png"},
+		{"tab\there\nnewline", "tabherenewline"},
+		{"12--name---abc", "12-name-abc"},
+		{"-leading and trailing-", "leading and trailing"},
+		{"", ""},
+		{strings.Repeat("a", maxFileNameLength-1) + "-b", strings.Repeat("a", maxFileNameLength-1)},
+		{strings.Repeat("é", maxFileNameLength+10), strings.Repeat("é", maxFileNameLength)},
+	}
+	for _, tt := range tests {
+		if got := sanitizeFileName(tt.name); got != tt.want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProcessedFileNameIsSanitized(t *testing.T) {
+	sc, fakeDrive, _ := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: `-wallet<1>--"june".png`, MimeType: "image/png"}}))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetOCRText(`-wallet<1>--"june".png`, donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || results[0].err != nil || results[0].result.Error != "" {
+		t.Fatalf("processBatch results = %+v, want a recorded row", results)
+	}
+	// The OCR document is renamed, from the upload's title with its suffix
+	want := results[0].result.RowID + "-wallet1-june.png_results-" + results[0].result.Checksum
+	var titles []string
+	for _, f := range fakeDrive.FilesIn(testProcessedFolderID) {
+		if f.MimeType == DocumentMimeType {
+			titles = append(titles, f.Title)
+		}
+	}
+	if len(titles) != 1 || titles[0] != want {
+		t.Errorf("Processed documents = %q, want %q", titles, want)
+	}
+}
+
+func TestFirstAndLastRow(t *testing.T) {
+	tests := []struct {
+		a1          string
+		first, last int64
+		ok          bool
+	}{
+		{"Sheet1!A5:F6", 5, 6, true},
+		{"'Tab: 2020'!A5:F6", 5, 6, true},
+		{"Sheet1!A7", 7, 7, true},
+		{"$A$3:$H$3", 3, 3, true},
+		{"Sheet1!A:A", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		first, ok := firstRow(tt.a1)
+		if first != tt.first || ok != tt.ok {
+			t.Errorf("firstRow(%q) = %d, %v, want %d, %v", tt.a1, first, ok, tt.first, tt.ok)
+		}
+		last, ok := lastRow(tt.a1)
+		if last != tt.last || ok != tt.ok {
+			t.Errorf("lastRow(%q) = %d, %v, want %d, %v", tt.a1, last, ok, tt.last, tt.ok)
+		}
+	}
+}
+
+func TestAppendedRow(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *sheets.AppendValuesResponse
+		want int64
+		ok   bool
+	}{
+		{
+			name: "updated range",
+			resp: &sheets.AppendValuesResponse{Updates: &sheets.UpdateValuesResponse{UpdatedRange: "Sheet1!A12:H12"}},
+			want: 12, ok: true,
+		},
+		{
+			name: "updated data",
+			resp: &sheets.AppendValuesResponse{Updates: &sheets.UpdateValuesResponse{UpdatedData: &sheets.ValueRange{Range: "Sheet1!A13:H13"}}},
+			want: 13, ok: true,
+		},
+		{
+			name: "table range",
+			resp: &sheets.AppendValuesResponse{TableRange: "Sheet1!A1:H13"},
+			want: 14, ok: true,
+		},
+		{
+			name: "nothing",
+			resp: &sheets.AppendValuesResponse{},
+		},
+	}
+	for _, tt := range tests {
+		got, ok := appendedRow(tt.resp)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: appendedRow = %d, %v, want %d, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestStableSortFiles(t *testing.T) {
+	files := func() []*drive.File {
+		return []*drive.File{
+			{Id: "1", Title: "b", CreatedDate: "2020-06-02T00:00:00Z", ModifiedDate: "2020-06-03T00:00:00Z"},
+			{Id: "2", Title: "a", CreatedDate: "2020-06-01T00:00:00Z", ModifiedDate: "2020-06-03T00:00:00Z"},
+			{Id: "3", Title: "b", CreatedDate: "2020-06-01T00:00:00Z", ModifiedDate: "2020-06-01T00:00:00Z"},
+		}
+	}
+	ids := func(files []*drive.File) []string {
+		var ids []string
+		for _, f := range files {
+			ids = append(ids, f.Id)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		field string
+		want  []string
+	}{
+		// Ties keep their listed order
+		{"title", []string{"2", "1", "3"}},
+		{"createdDate", []string{"2", "3", "1"}},
+		{"modifiedDate", []string{"3", "1", "2"}},
+		{"size", []string{"1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		if got := ids(StableSortFiles(files(), tt.field)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("StableSortFiles by %s = %v, want %v", tt.field, got, tt.want)
+		}
+	}
+}
+
+// extractionSample is the expected extraction of a testdata/extraction OCR text, in the .json
+// beside it. Env configures the run, and Error is part of the message when extraction fails.
+
+// extractionSample is the expected extraction of a testdata/extraction OCR text, in the .json
+// beside it. Env configures the run, and Error is part of the message when extraction fails.
+type extractionSample struct {
+	Env        map[string]string `json:"env"`
+	Date       string            `json:"date"`
+	Username   string            `json:"username"`
+	Quantity   string            `json:"quantity"`
+	Candidates []string          `json:"candidates"`
+	Error      string            `json:"error"`
+}
+
+// TestExtractDataCorpus runs extractData over the OCR samples in testdata/extraction, so pattern
+// changes can be checked against real-world text. Add a .txt of the text Drive exported, with its
+// CRLF line endings, and a .json of what it should extract.
+func TestExtractDataCorpus(t *testing.T) {
+	texts, err := filepath.Glob(filepath.Join("testdata", "extraction", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) == 0 {
+		t.Fatal("No samples in testdata/extraction")
+	}
+
+	saved := config
+	defer func() { config = saved }()
+
+	passed := 0
+	for _, text := range texts {
+		name := strings.TrimSuffix(filepath.Base(text), ".txt")
+		ok := t.Run(name, func(t *testing.T) {
+			want, err := ioutil.ReadFile(strings.TrimSuffix(text, ".txt") + ".json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sample extractionSample
+			if err := json.Unmarshal(want, &sample); err != nil {
+				t.Fatalf("Parsing the expected extraction: %v", err)
+			}
+			config, err = LoadConfig(func(name string) string { return sample.Env[name] })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			record, err := extractData(f)
+			if sample.Error != "" {
+				if err == nil || !strings.Contains(err.Error(), sample.Error) {
+					t.Fatalf("extractData error = %v, want %q", err, sample.Error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractData: %v", err)
+			}
+			if record.Date != sample.Date || record.Username != sample.Username || record.Quantity != sample.Quantity {
+				t.Errorf("extractData = %q, %q, %q, want %q, %q, %q",
+					record.Date, record.Username, record.Quantity, sample.Date, sample.Username, sample.Quantity)
+			}
+			if !reflect.DeepEqual(record.QuantityCandidates, sample.Candidates) {
+				t.Errorf("QuantityCandidates = %q, want %q", record.QuantityCandidates, sample.Candidates)
+			}
+		})
+		if ok {
+			passed++
+		}
+	}
+	t.Logf("%d of %d samples passed (%.0f%%)", passed, len(texts), 100*float64(passed)/float64(len(texts)))
+}
+
+func TestListFolderPagesPrefetches(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t, WithPreloadedFiles([]*drive.File{
+		{Id: "upload-1", Title: "1.png", MimeType: "image/png"},
+		{Id: "upload-2", Title: "2.png", MimeType: "image/png"},
+		{Id: "upload-3", Title: "3.png", MimeType: "image/png"},
+		{Id: "upload-4", Title: "4.png", MimeType: "image/png"},
+	}))
+	fakeDrive.PageSize = 1
+	listings := func() int {
+		n := 0
+		for _, r := range fakeDrive.Requests() {
+			if r == "GET /drive/v2/files" {
+				n++
+			}
+		}
+		return n
+	}
+	waitForListings := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for listings() < want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := listings(); got != want {
+			t.Fatalf("%d pages listed, want %d", got, want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pages := listFolderPages(ctx, testUploadFolderID, false)
+	first := <-pages
+	if first.err != nil || len(first.files) != 1 {
+		t.Fatalf("First page = %+v, want 1 file", first)
+	}
+
+	// While the first page is worked through the next are fetched, one buffered and one held
+	// by the lister until there's room, rather than the whole folder
+	waitForListings(3)
+	time.Sleep(20 * time.Millisecond)
+	if got := listings(); got != 3 {
+		t.Errorf("%d pages listed while the first was being processed, want 3", got)
+	}
+
+	var ids []string
+	ids = append(ids, first.files[0].Id)
+	for page := range pages {
+		if page.err != nil {
+			t.Fatal(page.err)
+		}
+		for _, f := range page.files {
+			ids = append(ids, f.Id)
+		}
+	}
+	if want := []string{"upload-1", "upload-2", "upload-3", "upload-4"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Listed %v, want %v", ids, want)
+	}
+}
+
+func TestProcessFileTimeout(t *testing.T) {
+	sc, fakeDrive, _ := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.ProcessTimeout = time.Millisecond }),
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "donation.txt", MimeType: "text/plain"}}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,234,567")))
+	fakeDrive.SetLatency(20 * time.Millisecond)
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || !errors.Is(results[0].err, ErrDeadlineExceeded) {
+		t.Fatalf("processBatch results = %+v, want ErrDeadlineExceeded", results)
+	}
+	if n := len(fakeDrive.FilesIn(testUploadFolderID)); n != 1 {
+		t.Errorf("%d files left in the Upload folder, want the upload left for the next run", n)
+	}
+}
+
+func TestDryRunMakesNoSheetsCalls(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.DryRun = true }),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: "donation.txt", MimeType: "text/plain"},
+			{Id: "upload-2", Title: "screenshot.png", MimeType: "image/png"},
+		}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,234,567")))
+	fakeDrive.SetContent("upload-2", testPNG(t))
+	fakeDrive.SetOCRText("screenshot.png", donationText("2020-06-19 08:00:00", "Pilot Two", "2,000"))
+
+	results := runBatch(t, sc)
+	if len(results) != 2 {
+		t.Fatalf("%d files processed, want 2", len(results))
+	}
+	for _, r := range results {
+		if r.err != nil || r.result.Quantity == "" {
+			t.Errorf("Dry run of %s = %+v, %v, want its extraction", r.result.FileName, r.result, r.err)
+		}
+	}
+
+	if requests := fakeSheets.Requests(); len(requests) > 0 {
+		t.Errorf("Dry run called Sheets: %q", requests)
+	}
+	if n := len(fakeDrive.FilesIn(testUploadFolderID)); n != 2 {
+		t.Errorf("%d files left in the Upload folder, want both uploads", n)
+	}
+	if files := fakeDrive.FilesIn(testProcessedFolderID); len(files) > 0 {
+		t.Errorf("Dry run left %d files in Processed, the OCR document should be deleted", len(files))
+	}
+}
+
+func TestCreatedFileMimeTypes(t *testing.T) {
+	sc, fakeDrive, _ := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "screenshot.png", MimeType: "image/png"}}))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetOCRText("screenshot.png", donationText("2020-06-19 08:00:00", "Pilot Two", "2,000"))
+
+	folder, err := createFolder("Subfolder", testMasterFolderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet, err := createSheet("Another Report", testMasterFolderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fakeDrive.File(folder.Id).MimeType; got != FolderMimeType {
+		t.Errorf("createFolder made a %s, want %s", got, FolderMimeType)
+	}
+	if got := fakeDrive.File(sheet.Id).MimeType; got != SpreadsheetMimeType {
+		t.Errorf("createSheet made a %s, want %s", got, SpreadsheetMimeType)
+	}
+
+	folders, err := getFilesFromFolder(testMasterFolderID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range folders {
+		if f.MimeType != FolderMimeType {
+			t.Errorf("getFilesFromFolder listed %s, a %s, among the folders", f.Title, f.MimeType)
+		}
+	}
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || results[0].err != nil || results[0].result.RowID == "" {
+		t.Fatalf("processBatch results = %+v, want the screenshot recorded", results)
+	}
+	docs := 0
+	for _, f := range fakeDrive.FilesIn(testProcessedFolderID) {
+		if f.MimeType == DocumentMimeType {
+			docs++
+		}
+	}
+	if docs != 1 {
+		t.Errorf("%d %s files in Processed, want the screenshot's OCR document", docs, DocumentMimeType)
+	}
+}
+
+func TestExtractRetrySucceeds(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "screenshot.png", MimeType: "image/png"}}),
+		WithConfig(func(c *Config) {
+			c.ExtractRetries = 2
+			c.ExtractRetryDelay = 10 * time.Millisecond
+		}))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetOCRTexts("screenshot.png", "", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || results[0].err != nil || results[0].result.Error != "" {
+		t.Fatalf("processBatch results = %+v, want the retry to be recorded", results)
+	}
+	if rows := fakeSheets.Values(testSheetID, "Sheet1!A2:G"); len(rows) != 1 || rows[0][nameColumn] != "Pilot One" {
+		t.Errorf("Report rows = %v, want Pilot One's donation", rows)
+	}
+	if f := fakeDrive.File("upload-1"); !inFolder(f, testProcessedFolderID) {
+		t.Error("Upload was not moved to Processed")
+	}
+
+	// The document of the failed OCR is deleted rather than left in Failed
+	docs := 0
+	for _, folder := range []string{testUploadFolderID, testProcessedFolderID, testFailedFolderID} {
+		for _, f := range fakeDrive.FilesIn(folder) {
+			if f.MimeType == DocumentMimeType {
+				docs++
+			}
+		}
+	}
+	if docs != 1 {
+		t.Errorf("%d OCR documents kept, want 1", docs)
+	}
+}
+
+func TestMoveFileToFolder(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t)
+	upload := fakeDrive.AddFile(&drive.File{Title: "screenshot.png", Parents: parentRefs(testUploadFolderID)}, nil)
+	moved := fakeDrive.AddFile(&drive.File{Title: "moved.png", Parents: parentRefs(testProcessedFolderID)}, nil)
+
+	if _, err := moveFileToFolder(context.Background(), upload, testUploadFolderID, testProcessedFolderID); err != nil {
+		t.Fatal(err)
+	}
+	if got := fakeDrive.File(upload.Id).Parents; len(got) != 1 || got[0].Id != testProcessedFolderID {
+		t.Errorf("Parents after the move = %v, want only Processed", got)
+	}
+
+	fakeDrive.resetRequests()
+	if _, err := moveFileToFolder(context.Background(), moved, testUploadFolderID, testProcessedFolderID); err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range fakeDrive.Requests() {
+		if strings.HasPrefix(request, http.MethodPut) {
+			t.Errorf("A file already in Processed was moved again: %s", request)
+		}
+	}
+}
+
+func TestFailedOCRDocumentMovesToFailed(t *testing.T) {
+	sc, fakeDrive, _ := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "screenshot.png", MimeType: "image/png"}}))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetOCRText("screenshot.png", "Corporation Wallet\r\nClose")
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || results[0].result.Error == "" {
+		t.Fatalf("processBatch results = %+v, want an extraction failure", results)
+	}
+	for _, f := range fakeDrive.FilesIn(testProcessedFolderID) {
+		t.Errorf("%s was left in Processed", f.Title)
+	}
+	docs := 0
+	for _, f := range fakeDrive.FilesIn(testFailedFolderID) {
+		if f.MimeType == DocumentMimeType {
+			docs++
+		}
+	}
+	if docs != 1 {
+		t.Errorf("%d OCR documents in Failed, want 1", docs)
+	}
+}
+
+func TestRegexFlagBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		text     string
+		quantity string
+	}{
+		// (?i) is the one flag the quantity patterns need: OCR doesn't keep the labels' case
+		{"(?i) matches an upper case label", quantityFirstRegex, "TYPE\r\n1,000 ISK", "1,000 ISK"},
+		{"(?i) matches a lower case label", quantitySecondRegex, "quantity\r\n2,500", "2,500"},
+		{"without (?i) the case must match", strings.TrimPrefix(quantityFirstRegex, "(?i)"), "TYPE\r\n1,000 ISK", ""},
+		// The line break is matched as the \r\n of the exported text, so (?s) and (?m) aren't needed
+		{"CRLF after the label", quantityZeroRegex, "Member Donation\r\n750", "750"},
+		{"a bare LF doesn't match", quantityFirstRegex, "Type\n1,000", ""},
+		{"(?m) changes nothing without ^ or $", "(?im)" + strings.TrimPrefix(quantityFirstRegex, "(?i)"), "x\r\nType\r\n1,000\r\ny", "1,000"},
+		{"(?s) changes nothing without .", "(?is)" + strings.TrimPrefix(quantityFirstRegex, "(?i)"), "x\r\nType\r\n1,000\r\ny", "1,000"},
+		// usernameRegex has no (?s), so its .* stays on the Member Donation line
+		{"usernameRegex stays on one line", usernameRegex, "Member Donation\r\n[Pilot One]", ""},
+	}
+	for _, tt := range tests {
+		got, err := findSubmatch(tt.pattern, tt.text)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		quantity := ""
+		if got != nil && len(got) > 1 {
+			quantity = got[1]
+		}
+		if quantity != tt.quantity {
+			t.Errorf("%s: matched %q, want %q", tt.name, quantity, tt.quantity)
+		}
+	}
+}
+
+func TestFindSubmatchCompilesOnce(t *testing.T) {
+	pattern := `(?i)Compiled once\r\n(?P<quantity>\d+)`
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := findSubmatch(pattern, "compiled ONCE\r\n42"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	first, ok := compiledPatterns.Load(pattern)
+	if !ok {
+		t.Fatal("Pattern wasn't cached")
+	}
+	findSubmatch(pattern, "")
+	if again, _ := compiledPatterns.Load(pattern); again != first {
+		t.Error("Pattern was compiled again")
+	}
+	if n := len(matchSlots); n != 0 {
+		t.Errorf("%d match slots held after the matches finished, want 0", n)
+	}
+}
+
+func TestPatternStats(t *testing.T) {
+	saved, savedStats := config, patternStats
+	defer func() { config, patternStats = saved, savedStats }()
+
+	text := donationText("2020-06-18 12:34:56", "Pilot One", "1,000")
+	tests := []struct {
+		name     string
+		text     string
+		fallback string
+		want     PatternStats
+	}{
+		{"zero pattern", strings.Replace(text, "Type", "Member Donation", 1), "", PatternStats{ZeroPatternHits: 1}},
+		{"first pattern", text, "", PatternStats{FirstPatternHits: 1}},
+		{"second pattern", strings.Replace(text, "Type", "Quantity", 1), "", PatternStats{SecondPatternHits: 1}},
+		{"fallback pattern", strings.Replace(text, "Type", "Amount", 1), `(?i)Amount\r\n(?P<quantity>[0-9,]+)`, PatternStats{EnvFallbackHits: 1}},
+		{"no pattern", strings.Replace(text, "Type", "Amount", 1), "", PatternStats{AllPatternsFailedCount: 1}},
+	}
+	for _, tt := range tests {
+		config = defaultConfig()
+		config.QuantityFallbackPattern = tt.fallback
+		patternStats = PatternStats{}
+
+		record, err := extractData(ioutil.NopCloser(strings.NewReader(tt.text)))
+		if tt.want.AllPatternsFailedCount == 0 && (err != nil || record.Quantity != "1,000") {
+			t.Errorf("%s: extractData = %q, %v, want 1,000", tt.name, record.Quantity, err)
+		}
+		if got := patternStats.snapshot(); got != tt.want {
+			t.Errorf("%s: patternStats = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQuantityAgreement(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	conflicting := donationText("2020-06-18 12:34:56", "Pilot One", "1,000") + "\r\nQuantity\r\n2,000"
+	agreeing := donationText("2020-06-18 12:34:56", "Pilot One", "1,000") + "\r\nQuantity\r\n1000"
+	tests := []struct {
+		mode           string
+		text           string
+		wantErr        error
+		wantCandidates []string
+		wantReasons    int
+	}{
+		{QuantityAgreementFirst, conflicting, nil, nil, 0},
+		{QuantityAgreementReview, conflicting, nil, []string{"1,000", "2,000"}, 1},
+		{QuantityAgreementStrict, conflicting, ErrQuantityDisagreement, nil, 0},
+		{QuantityAgreementStrict, agreeing, nil, nil, 0},
+	}
+	for _, tt := range tests {
+		config = defaultConfig()
+		config.QuantityAgreement = tt.mode
+		config.Review.QuantityDisagreement = tt.mode == QuantityAgreementReview
+
+		record, err := extractData(ioutil.NopCloser(strings.NewReader(tt.text)))
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("%s: extractData error = %v, want %v", tt.mode, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if record.Quantity != "1,000" {
+			t.Errorf("%s: Quantity = %q, want the first pattern's 1,000", tt.mode, record.Quantity)
+		}
+		if !reflect.DeepEqual(record.QuantityCandidates, tt.wantCandidates) {
+			t.Errorf("%s: QuantityCandidates = %q, want %q", tt.mode, record.QuantityCandidates, tt.wantCandidates)
+		}
+		if reasons := reviewReasons(config.Review, record); len(reasons) != tt.wantReasons {
+			t.Errorf("%s: review reasons = %q, want %d", tt.mode, reasons, tt.wantReasons)
+		}
+	}
+}
+
+func TestStrictQuantityDisagreementGoesToFailed(t *testing.T) {
+	sc, fakeDrive, _ := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "screenshot.png", MimeType: "image/png"}}),
+		WithConfig(func(c *Config) { c.QuantityAgreement = QuantityAgreementStrict }))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetOCRText("screenshot.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000")+"\r\nQuantity\r\n2,000")
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || !strings.Contains(results[0].result.Error, ErrQuantityDisagreement.Error()) {
+		t.Fatalf("processBatch results = %+v, want %v", results, ErrQuantityDisagreement)
+	}
+	if !inFolder(fakeDrive.File("upload-1"), testFailedFolderID) {
+		t.Error("Upload was not moved to Failed")
+	}
+}
+
+func TestSetupFolders(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t)
+	const masterID = "trimark-test-second-master-folder"
+	fakeDrive.AddFile(&drive.File{Id: masterID, Title: "Trimark", MimeType: FolderMimeType}, nil)
+	fakeDrive.AddFile(&drive.File{Id: "existing-upload-folder", Title: UploadFolderName, MimeType: FolderMimeType, Parents: parentRefs(masterID)}, nil)
+	fakeDrive.resetRequests()
+
+	const latency = 50 * time.Millisecond
+	fakeDrive.SetLatency(latency)
+	start := time.Now()
+	report, err := setupFolders(masterID)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Found != 1 || report.Created != 3 {
+		t.Errorf("Found, Created = %d, %d, want 1, 3", report.Found, report.Created)
+	}
+	if UploadFolderID != "existing-upload-folder" {
+		t.Errorf("UploadFolderID = %q, want the existing folder", UploadFolderID)
+	}
+	inserts := 0
+	for _, r := range fakeDrive.Requests() {
+		if r == "POST /drive/v2/files" {
+			inserts++
+		}
+	}
+	if inserts != 3 {
+		t.Errorf("%d folders inserted, want 3", inserts)
+	}
+	for _, name := range []string{ProcessedFolderName, FailedFolderName, ReportFolderName} {
+		folder := fakeDrive.File(report.FolderIDs[name])
+		if folder == nil || folder.Title != name || !inFolder(folder, masterID) {
+			t.Errorf("%s folder = %+v, want it created in the master folder", name, folder)
+		}
+	}
+	// The listing and one round of creates, rather than a create after another
+	if elapsed >= 3*latency {
+		t.Errorf("Setup took %s, want the folders created in parallel", elapsed)
+	}
+}
+
+func TestSetupFoldersListingError(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t)
+	fakeDrive.Fail(http.MethodGet, "/drive/v2/files", &googleapi.Error{Code: http.StatusInternalServerError, Message: "Backend Error"})
+	fakeDrive.resetRequests()
+
+	_, err := setupFolders(testMasterFolderID)
+	if err == nil || !strings.Contains(err.Error(), "Backend Error") {
+		t.Errorf("setupFolders error = %v, want the listing's error", err)
+	}
+	for _, r := range fakeDrive.Requests() {
+		if strings.HasPrefix(r, http.MethodPost) {
+			t.Errorf("%s after the listing failed, want no folders created", r)
+		}
+	}
+}
+
+func TestSetupSheetIgnoresNonSpreadsheets(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t)
+	// Listed ahead of the report, which is created with the folders
+	early := "2000-01-01T00:00:00.000Z"
+	folder := fakeDrive.AddFile(&drive.File{Title: SheetName, MimeType: FolderMimeType, CreatedDate: early, Parents: parentRefs(testReportFolderID)}, nil)
+	fakeDrive.AddFile(&drive.File{Title: SheetName, MimeType: DocumentMimeType, CreatedDate: early, Parents: parentRefs(testReportFolderID)}, nil)
+
+	if err := setupSheet(testReportFolderID); err != nil {
+		t.Fatal(err)
+	}
+	if SheetID != testSheetID {
+		t.Errorf("SheetID = %s, want the report spreadsheet %s", SheetID, testSheetID)
+	}
+
+	if err := driveService.Files.Delete(testSheetID).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if err := setupSheet(testReportFolderID); err != nil {
+		t.Fatal(err)
+	}
+	if SheetID == testSheetID || SheetID == folder.Id {
+		t.Fatalf("SheetID = %s, want a new spreadsheet", SheetID)
+	}
+	if got := fakeDrive.File(SheetID); got.MimeType != SpreadsheetMimeType || got.Title != SheetName {
+		t.Errorf("Created %s %q, want a %s named %q", got.MimeType, got.Title, SpreadsheetMimeType, SheetName)
+	}
+}
+
+func TestProcessedMoveFailureIsInconsistent(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t, WithPreloadedFiles([]*drive.File{
+		{Id: "stuck", Title: "stuck.txt", MimeType: "text/plain"},
+		{Id: "moved", Title: "moved.txt", MimeType: "text/plain"},
+	}))
+	fakeDrive.SetContent("stuck", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.SetContent("moved", []byte(donationText("2020-06-18 12:35:56", "Pilot Two", "2,000")))
+	fakeDrive.Fail(http.MethodPut, "/drive/v2/files/stuck", &googleapi.Error{Code: http.StatusInternalServerError, Message: "Backend Error"})
+
+	results := runBatch(t, sc)
+	if len(results) != 2 {
+		t.Fatalf("processBatch results = %+v, want both uploads", results)
+	}
+	for _, r := range results {
+		if r.err != nil {
+			t.Errorf("%s: %v, want a failed move not to fail the file", r.result.FileName, r.err)
+		}
+		if want := r.result.FileID == "stuck"; r.result.Inconsistent != want {
+			t.Errorf("%s Inconsistent = %v, want %v", r.result.FileName, r.result.Inconsistent, want)
+		}
+	}
+
+	// The row is kept, the upload is left for a later run to find as a duplicate
+	if rows := fakeSheets.Values(testSheetID, "Sheet1!A2:G"); len(rows) != 2 {
+		t.Errorf("Report rows = %v, want both donations", rows)
+	}
+	if f := fakeDrive.File("stuck"); !inFolder(f, testUploadFolderID) || inFolder(f, testProcessedFolderID) {
+		t.Errorf("Upload whose move failed has parents %v, want it left in Upload", f.Parents)
+	}
+	if f := fakeDrive.File("moved"); !inFolder(f, testProcessedFolderID) {
+		t.Error("The other upload was not moved to Processed")
+	}
+}
+
+// extractionPatterns are the pattern strings extractData matches the OCR text with
+var extractionPatterns = map[string]string{
+	"dateRegex":           dateRegex,
+	"usernameRegex":       usernameRegex,
+	"quantityZeroRegex":   quantityZeroRegex,
+	"quantityFirstRegex":  quantityFirstRegex,
+	"quantitySecondRegex": quantitySecondRegex,
+}
+
+func TestAllRegexPatternsCompile(t *testing.T) {
+	for name, pattern := range extractionPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			t.Errorf("%s doesn't compile: %v", name, err)
+		}
+	}
+	if err := validateExtractionPatterns(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAllRegexPatternsNotEmpty(t *testing.T) {
+	for name, pattern := range extractionPatterns {
+		if strings.TrimSpace(pattern) == "" {
+			t.Errorf("%s is empty", name)
+		}
+	}
+}
+
+func TestAllRegexPatternsHaveNamedGroups(t *testing.T) {
+	for _, name := range []string{"quantityZeroRegex", "quantityFirstRegex", "quantitySecondRegex"} {
+		re := regexp.MustCompile(extractionPatterns[name])
+		if !hasSubexp(re, "quantity") {
+			t.Errorf("%s groups are %q, want a quantity group", name, re.SubexpNames())
+		}
+	}
+
+	// A quantity pattern without the group stops the function starting
+	defer func(saved string) { quantitySecondRegex = saved }(quantitySecondRegex)
+	quantitySecondRegex = `(?i)Quantity\r\n([0-9,.]*)`
+	if err := validateExtractionPatterns(); err == nil || !strings.Contains(err.Error(), "quantitySecondRegex") {
+		t.Errorf("validateExtractionPatterns = %v, want quantitySecondRegex to be missing its group", err)
+	}
+}
+
+func TestOversizedUploadIsNotDownloaded(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.MaxFileBytes = 1024 }),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "small", Title: "small.txt", MimeType: "text/plain"},
+			{Id: "huge", Title: "huge.png", MimeType: "image/png"},
+		}))
+	fakeDrive.SetContent("small", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.SetContent("huge", append(testPNG(t), make([]byte, 2048)...))
+
+	for _, r := range runBatch(t, sc) {
+		tooLarge := strings.Contains(r.result.Error, ErrFileTooLarge.Error())
+		if want := r.result.FileID == "huge"; tooLarge != want {
+			t.Errorf("%s error = %q, %v, want ErrFileTooLarge %v", r.result.FileName, r.result.Error, r.err, want)
+		}
+	}
+	downloaded := map[string]bool{}
+	for _, r := range fakeDrive.Requests() {
+		downloaded[r] = true
+	}
+	if downloaded["GET /drive/v2/files/huge?alt=media"] || !downloaded["GET /drive/v2/files/small?alt=media"] {
+		t.Errorf("Downloads were %v, want only the upload under the limit", fakeDrive.Requests())
+	}
+	if f := fakeDrive.File("huge"); !inFolder(f, testFailedFolderID) {
+		t.Error("The oversized upload was not moved to Failed")
+	}
+	if rows := fakeSheets.Values(testSheetID, "Sheet1!A2:G"); len(rows) != 1 || rows[0][nameColumn] != "Pilot One" {
+		t.Errorf("Report rows = %v, want only the upload under the limit", rows)
+	}
+}
+
+func TestMultiParentUpload(t *testing.T) {
+	tests := []struct {
+		policy string
+		// kept is whether the upload stays in its other folder
+		kept bool
+	}{
+		{MultiParentWarn, true},
+		{MultiParentDetach, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			sc, fakeDrive, _ := NewTestServiceContext(t,
+				WithConfig(func(c *Config) { c.MultiParentPolicy = tt.policy }),
+				WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "one.txt", MimeType: "text/plain", Parents: parentRefs(testUploadFolderID, "shared-folder")}}))
+			fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+
+			if results := runBatch(t, sc); len(results) != 1 || results[0].err != nil || results[0].result.RowID == "" {
+				t.Fatalf("processBatch results = %+v, want the upload recorded", results)
+			}
+			f := fakeDrive.File("upload-1")
+			if !inFolder(f, testProcessedFolderID) || inFolder(f, testUploadFolderID) {
+				t.Errorf("Parents = %v, want it moved from Upload to Processed", f.Parents)
+			}
+			if inFolder(f, "shared-folder") != tt.kept {
+				t.Errorf("In its other folder = %v, want %v", inFolder(f, "shared-folder"), tt.kept)
+			}
+
+			// Out of Upload, the next run doesn't process it again
+			if results := runBatch(t, sc); len(results) != 0 {
+				t.Errorf("Next run processed %+v", results)
+			}
+		})
+	}
+}
+
+func TestMainFailuresRespond500(t *testing.T) {
+	backendError := &googleapi.Error{Code: http.StatusInternalServerError, Message: "Backend Error"}
+	tests := []struct {
+		name  string
+		setup func(fakeDrive *FakeDriveService, fakeSheets *FakeSheetsService)
+		want  string
+	}{
+		{"sheet header", func(fakeDrive *FakeDriveService, fakeSheets *FakeSheetsService) {
+			fakeSheets.Fail(http.MethodGet, "/v4/spreadsheets/"+testSheetID, backendError)
+		}, "Unable to verify sheet header"},
+		{"revalidating folders", func(fakeDrive *FakeDriveService, fakeSheets *FakeSheetsService) {
+			lastFolderValidation = time.Time{}
+			fakeDrive.Fail(http.MethodGet, "/drive/v2/files/", backendError)
+		}, "Unable to revalidate folders"},
+		{"checkpoint", func(fakeDrive *FakeDriveService, fakeSheets *FakeSheetsService) {
+			config.Checkpoint = true
+			fakeDrive.Fail(http.MethodGet, "/drive/v2/files", backendError)
+		}, "Unable to load checkpoint"},
+		{"listing uploads", func(fakeDrive *FakeDriveService, fakeSheets *FakeSheetsService) {
+			fakeDrive.Fail(http.MethodGet, "/drive/v2/files", backendError)
+		}, "Unable to get files from folder"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, fakeDrive, fakeSheets := NewTestServiceContext(t,
+				WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "one.txt", MimeType: "text/plain"}}))
+			fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+			tt.setup(fakeDrive, fakeSheets)
+
+			// A failure which used to exit the process responds instead
+			w := httptest.NewRecorder()
+			Main(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("Main responded %d %q, want 500 %q", w.Code, w.Body, tt.want)
+			}
+			if f := fakeDrive.File("upload-1"); !inFolder(f, testUploadFolderID) {
+				t.Error("The upload was moved, want it left for the next run")
+			}
+		})
+	}
+}
+
+func TestEnsureSheetHeaderRestoresMissingHeader(t *testing.T) {
+	existing := []interface{}{"earlier-checksum", "06-01-2020 10:00:00", "2020-06-01 09:00:00", "Pilot Two", "500", ""}
+	tests := []struct {
+		name string
+		// damage is what the user did to the header row
+		damage func(t *testing.T)
+	}{
+		{"intact", func(t *testing.T) {}},
+		{"cleared", func(t *testing.T) {
+			if _, err := sheetService.Spreadsheets.Values.Clear(testSheetID, "Sheet1!1:1", &sheets.ClearValuesRequest{}).Do(); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"deleted", func(t *testing.T) {
+			remove := &sheets.Request{DeleteDimension: &sheets.DeleteDimensionRequest{Range: &sheets.DimensionRange{
+				SheetId: 0, Dimension: "ROWS", StartIndex: 0, EndIndex: 1, ForceSendFields: []string{"SheetId", "StartIndex"},
+			}}}
+			batch := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{remove}}
+			if _, err := sheetService.Spreadsheets.BatchUpdate(testSheetID, batch).Do(); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, fakeSheets := NewTestServiceContext(t, WithExistingSheetRows([][]interface{}{existing}))
+			tt.damage(t)
+
+			if err := ensureSheetHeader(); err != nil {
+				t.Fatal(err)
+			}
+			rows := fakeSheets.Values(testSheetID, "Sheet1")
+			if len(rows) != 2 || !reflect.DeepEqual(rows[0], buildHeaders()) {
+				t.Fatalf("Sheet = %v, want the header above the existing row", rows)
+			}
+			if rows[1][idColumn] != existing[idColumn] || rows[1][nameColumn] != existing[nameColumn] {
+				t.Errorf("Existing row = %v, want it kept below the header", rows[1])
+			}
+		})
+	}
+}
+
+func TestPropertyFilter(t *testing.T) {
+	tagged := func(id, value string) *drive.File {
+		f := &drive.File{Id: id, Title: id + ".txt", MimeType: "text/plain"}
+		if value != "" {
+			f.Properties = []*drive.Property{{Key: "trimark", Value: value}}
+		}
+		return f
+	}
+	files := []*drive.File{tagged("yes", "process"), tagged("other", "later"), tagged("untagged", "")}
+
+	tests := []struct {
+		name       string
+		key, value string
+		want       map[string]bool
+	}{
+		{"off", "", "", map[string]bool{"yes": true, "other": true, "untagged": true}},
+		{"key only", "trimark", "", map[string]bool{"yes": true, "other": true}},
+		{"key and value", "trimark", "process", map[string]bool{"yes": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, fakeDrive, _ := NewTestServiceContext(t,
+				WithConfig(func(c *Config) { c.PropertyFilterKey, c.PropertyFilterValue = tt.key, tt.value }),
+				WithPreloadedFiles(files))
+			for i, f := range files {
+				fakeDrive.SetContent(f.Id, []byte(donationText(fmt.Sprintf("2020-06-1%d 12:34:56", i), "Pilot "+f.Id, "1,000")))
+			}
+
+			got := map[string]bool{}
+			for _, r := range runBatch(t, sc) {
+				got[r.result.FileID] = true
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Processed %v, want %v", got, tt.want)
+			}
+			for _, f := range files {
+				if left := inFolder(fakeDrive.File(f.Id), testUploadFolderID); left == tt.want[f.Id] {
+					t.Errorf("%s left in Upload = %v, want %v", f.Id, left, !tt.want[f.Id])
+				}
+			}
+		})
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount   string
+		decimals int
+		want     string
+	}{
+		{"1,000", 0, "1000"},
+		{"1,000", 2, "1000.00"},
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"1,234.565", 2, "1234.57"},
+		{"0.004", 2, "0.00"},
+	}
+	for _, tt := range tests {
+		got, err := formatAmount(tt.amount, tt.decimals)
+		if err != nil || got != tt.want {
+			t.Errorf("formatAmount(%q, %d) = %q, %v, want %q", tt.amount, tt.decimals, got, err, tt.want)
+		}
+	}
+	if _, err := formatAmount("lots", 2); err == nil {
+		t.Error("formatAmount accepted an amount which isn't a number")
+	}
+}
+
+func TestAmountDecimalsColumn(t *testing.T) {
+	const amountColumn = 4
+	tests := []struct {
+		decimals int
+		want     string
+	}{
+		{-1, "1,234"},
+		{0, "1234"},
+		{2, "1234.00"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.decimals), func(t *testing.T) {
+			sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+				WithConfig(func(c *Config) { c.AmountDecimals = tt.decimals }),
+				WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "one.txt", MimeType: "text/plain"}}))
+			fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,234")))
+
+			runBatch(t, sc)
+			if rows := fakeSheets.Formulas(testSheetID, "Sheet1!A2:G"); len(rows) != 1 || rows[0][amountColumn] != tt.want {
+				t.Errorf("Report rows = %v, want the amount written as %q", rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildRowValues(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.ImageInfoColumns = false
+	config.AmountMultiplier = 1
+	config.UploaderColumn = false
+	config.Review = ReviewConfig{}
+	config.RunIDColumn = false
+	config.SubfolderColumn = false
+
+	record := newRecord("2020-06-18 12:34:56", "Pilot One", "1,000")
+	record.Checksum = "abc123"
+	record.Link = "https://example.com/file"
+
+	values := buildRowValues(record, "2020-06-19", "1000", rowExtras{})
+	want := []interface{}{"abc123", "2020-06-19", "2020-06-18 12:34:56", "Pilot One", "1000", "https://example.com/file"}
+	if len(values) != len(want) {
+		t.Fatalf("got %d values, want %d: %v", len(values), len(want), values)
+	}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Errorf("values[%d] = %v, want %v", i, values[i], want[i])
+		}
+	}
+}
+
+func TestBuildRowValuesOptionalColumns(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.ImageInfoColumns = true
+	config.AmountMultiplier = 10
+	config.UploaderColumn = true
+	config.Review = ReviewConfig{LowOCRQuality: true}
+	config.RunIDColumn = true
+	config.SubfolderColumn = true
+
+	record := newRecord("2020-06-18 12:34:56", "Pilot One", "100")
+	extras := rowExtras{
+		Uploader:        "pilot@example.com",
+		NeedsReview:     true,
+		ImageSizeBytes:  2048,
+		ImageDimensions: "640x480",
+		RunID:           "run-1",
+		Subfolder:       "june/week1",
+	}
+
+	headers := buildHeaders()
+	values := buildRowValues(record, "2020-06-19", "1000", extras)
+	if len(headers) != len(values) {
+		t.Fatalf("got %d headers and %d values", len(headers), len(values))
+	}
+
+	want := map[string]interface{}{
+		"Amount":         "1000",
+		"Image Size (B)": "2048",
+		"Dimensions":     "640x480",
+		"Raw Amount":     "100",
+		"Uploader":       "pilot@example.com",
+		"Needs Review":   true,
+		"Run ID":         "run-1",
+		"Subfolder":      "june/week1",
+	}
+	for i, header := range headers {
+		expected, ok := want[header.(string)]
+		if !ok {
+			continue
+		}
+		if values[i] != expected {
+			t.Errorf("%s column = %v, want %v", header, values[i], expected)
+		}
+		delete(want, header.(string))
+	}
+	for header := range want {
+		t.Errorf("missing %s column", header)
+	}
+}
+
+func TestBuildHeadersMatchRowValues(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	options := []func(on bool){
+		func(on bool) { config.ImageInfoColumns = on },
+		func(on bool) {
+			config.AmountMultiplier = 1
+			if on {
+				config.AmountMultiplier = 2
+			}
+		},
+		func(on bool) { config.UploaderColumn = on },
+		func(on bool) { config.Review = ReviewConfig{AmountOutOfRange: on} },
+		func(on bool) { config.RunIDColumn = on },
+		func(on bool) { config.SubfolderColumn = on },
+	}
+
+	for mask := 0; mask < 1<<uint(len(options)); mask++ {
+		for i, set := range options {
+			set(mask&(1<<uint(i)) != 0)
+		}
+		headers := buildHeaders()
+		values := buildRowValues(newRecord("2020-06-18 12:34:56", "Pilot One", "1"), "2020-06-19", "1", rowExtras{})
+		if len(headers) != len(values) {
+			t.Errorf("options %06b: got %d headers and %d values", mask, len(headers), len(values))
+		}
+	}
+}
+
+func TestQuarantineOCRDocs(t *testing.T) {
+	const archiveFolderID = "ocr-archive-folder"
+	for _, quarantine := range []bool{true, false} {
+		quarantine := quarantine
+		t.Run(fmt.Sprintf("quarantine=%t", quarantine), func(t *testing.T) {
+			sc, fakeDrive, _ := NewTestServiceContext(t,
+				WithConfig(func(c *Config) { c.QuarantineOCRDocs = quarantine }),
+				WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "wallet.png", MimeType: "image/png"}}))
+			fakeDrive.AddFile(&drive.File{Id: archiveFolderID, Title: OCRArchiveFolderName, MimeType: FolderMimeType, Parents: parentRefs(testMasterFolderID)}, nil)
+			folderIDsMu.Lock()
+			OCRArchiveFolderID = archiveFolderID
+			folderIDsMu.Unlock()
+			fakeDrive.SetContent("upload-1", testPNG(t))
+			fakeDrive.SetOCRText("wallet.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+
+			results := runBatch(t, sc)
+			if len(results) != 1 || results[0].err != nil || results[0].result.Error != "" {
+				t.Fatalf("processBatch results = %+v, want a recorded row", results)
+			}
+
+			docsIn := func(folderID string) int {
+				n := 0
+				for _, f := range fakeDrive.FilesIn(folderID) {
+					if f.MimeType == DocumentMimeType {
+						n++
+					}
+				}
+				return n
+			}
+			archived, processed := docsIn(archiveFolderID), docsIn(testProcessedFolderID)
+			if quarantine && (archived != 1 || processed != 0) {
+				t.Errorf("%d documents in %s and %d in Processed, want the document quarantined", archived, OCRArchiveFolderName, processed)
+			}
+			if !quarantine && (archived != 0 || processed != 1) {
+				t.Errorf("%d documents in %s and %d in Processed, want the document left in Processed", archived, OCRArchiveFolderName, processed)
+			}
+			if n := len(fakeDrive.FilesIn(testProcessedFolderID)) - processed; n != 1 {
+				t.Errorf("%d uploads in Processed, want the screenshot there either way", n)
+			}
+		})
+	}
+}
+
+func TestPreRenamedUploadIsSkipped(t *testing.T) {
+	renamed := "12-wallet.png-" + strings.Repeat("0123abcd", 4)
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: renamed, MimeType: "image/png"},
+			{Id: "upload-2", Title: "12-wallet.png", MimeType: "text/plain"},
+		}))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetContent("upload-2", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	results := runBatch(t, sc)
+	// A row ID prefix alone isn't the processed name, it needs the checksum too
+	if len(results) != 1 || results[0].result.FileID != "upload-2" {
+		t.Fatalf("processBatch results = %+v, want only the upload without a checksum", results)
+	}
+	if !inFolder(fakeDrive.File("upload-1"), testUploadFolderID) {
+		t.Errorf("The renamed upload was moved to %v", fakeDrive.File("upload-1").Parents)
+	}
+	if !strings.Contains(logs.String(), "Skipping "+renamed+", it has already been processed") {
+		t.Errorf("Logs = %q, want the renamed upload's skip logged", logs.String())
+	}
+	if rows := fakeSheets.Values(testSheetID, "Sheet1"); len(rows) != 2 {
+		t.Errorf("Sheet has %d rows, want the header and one donation: %q", len(rows), rows)
+	}
+}
+
+func TestExtractionTimeout(t *testing.T) {
+	saved := extractionTimeout
+	defer func() { extractionTimeout = saved }()
+	extractionTimeout = time.Nanosecond
+
+	// Near misses of every pattern, so each match scans the whole text
+	adversarial := strings.Repeat("2020-06-18 12:34 Member Donation [( Type\r\n Quantity\r\n", 200000)
+	_, err := extractData(ioutil.NopCloser(strings.NewReader(adversarial)))
+	if !errors.Is(err, ErrExtractionTimeout) {
+		t.Errorf("extractData = %v, want %v", err, ErrExtractionTimeout)
+	}
+	// The abandoned matches keep their slots until they finish, taking every slot waits them out
+	// so they can't time out the extractions after this
+	for i := 0; i < cap(matchSlots); i++ {
+		matchSlots <- struct{}{}
+	}
+	for i := 0; i < cap(matchSlots); i++ {
+		<-matchSlots
+	}
+
+	// The timeout doesn't hold up ordinary text
+	extractionTimeout = saved
+	record, err := extractData(ioutil.NopCloser(strings.NewReader(donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))))
+	if err != nil || record.Username != "Pilot One" {
+		t.Errorf("extractData = %+v, %v, want the donation of Pilot One", record, err)
+	}
+}
+
+func TestPrepareSheetReturnsGetError(t *testing.T) {
+	_, _, fakeSheets := NewTestServiceContext(t)
+	fakeSheets.Fail(http.MethodGet, "/v4/spreadsheets/", &googleapi.Error{Code: http.StatusInternalServerError, Message: "Backend Error"})
+
+	id, err := prepareSheet(testReportFolderID, "New Report")
+	if err == nil || !strings.Contains(err.Error(), "Unable to open new report sheet") {
+		t.Fatalf("prepareSheet = %q, %v, want the failed Get returned", id, err)
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusInternalServerError {
+		t.Errorf("prepareSheet error %v doesn't wrap the Get's 500", err)
+	}
+}
+
+func TestSerialPreservesOrder(t *testing.T) {
+	var uploads []*drive.File
+	for i := 1; i <= 5; i++ {
+		uploads = append(uploads, &drive.File{
+			Id:          fmt.Sprintf("upload-%d", i),
+			Title:       fmt.Sprintf("donation-%d.txt", i),
+			MimeType:    "text/plain",
+			CreatedDate: fmt.Sprintf("2020-06-18T12:0%d:00Z", i),
+		})
+	}
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.Serial = true }),
+		WithPreloadedFiles(uploads))
+	for i := 1; i <= 5; i++ {
+		fakeDrive.SetContent(fmt.Sprintf("upload-%d", i), []byte(donationText(fmt.Sprintf("2020-06-18 12:3%d:00", i), fmt.Sprintf("Pilot %d", i), "1,000")))
+	}
+	// Latency would let a concurrent batch finish out of order
+	fakeDrive.SetLatency(5 * time.Millisecond)
+
+	results := runBatch(t, sc)
+	if len(results) != 5 {
+		t.Fatalf("processBatch results = %+v, want 5", results)
+	}
+	rows := fakeSheets.Values(testSheetID, "Sheet1!D2:D")
+	for i, r := range results {
+		want := fmt.Sprintf("upload-%d", i+1)
+		if r.result.FileID != want {
+			t.Errorf("Result %d is %s, want %s", i, r.result.FileID, want)
+		}
+		if i < len(rows) && rows[i][0] != fmt.Sprintf("Pilot %d", i+1) {
+			t.Errorf("Row %d is %v, want Pilot %d", i+2, rows[i], i+1)
+		}
+	}
+}
+
+func TestEscapeQuery(t *testing.T) {
+	tests := []struct{ value, want string }{
+		{"report", "report"},
+		{"Pilot's report", `Pilot\'s report`},
+		{`back\slash`, `back\\slash`},
+		{`x' or title='y`, `x\' or title=\'y`},
+	}
+	for _, tt := range tests {
+		if got := escapeQuery(tt.value); got != tt.want {
+			t.Errorf("escapeQuery(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetFileByName(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t)
+	fakeDrive.AddFile(&drive.File{Id: "quoted", Title: "Pilot's report", MimeType: SpreadsheetMimeType, Parents: parentRefs(testReportFolderID)}, nil)
+	fakeDrive.AddFile(&drive.File{Id: "other", Title: "y", MimeType: SpreadsheetMimeType, Parents: parentRefs(testMasterFolderID)}, nil)
+	ctx := context.Background()
+
+	f, err := GetFileByName(ctx, "Pilot's report", SpreadsheetMimeType)
+	if err != nil || f.Id != "quoted" {
+		t.Errorf("GetFileByName = %+v, %v, want the file with a quote in its title", f, err)
+	}
+	f, err = GetFileByNameInFolder(ctx, "Pilot's report", testReportFolderID)
+	if err != nil || f.Id != "quoted" {
+		t.Errorf("GetFileByNameInFolder = %+v, %v, want the file with a quote in its title", f, err)
+	}
+
+	// A quote can't close the title and add a clause of its own
+	if f, err := GetFileByName(ctx, "x' or title='y", SpreadsheetMimeType); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetFileByName of an injected clause = %+v, %v, want %v", f, err, ErrNotFound)
+	}
+	if f, err := GetFileByNameInFolder(ctx, "Pilot's report", testMasterFolderID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetFileByNameInFolder of another folder = %+v, %v, want %v", f, err, ErrNotFound)
+	}
+}
+
+func TestUploaderOf(t *testing.T) {
+	owner := &drive.User{EmailAddress: "owner@example.com", DisplayName: "Owner"}
+	modifier := &drive.User{EmailAddress: "modifier@example.com", DisplayName: "Modifier"}
+	tests := []struct {
+		name string
+		file *drive.File
+		want string
+	}{
+		{"last modifier over owner", &drive.File{LastModifyingUser: modifier, Owners: []*drive.User{owner}}, "modifier@example.com"},
+		{"owner alone", &drive.File{Owners: []*drive.User{owner}}, "owner@example.com"},
+		{"email over display name", &drive.File{LastModifyingUser: &drive.User{DisplayName: "Modifier"}, Owners: []*drive.User{owner}}, "owner@example.com"},
+		{"display name", &drive.File{LastModifyingUser: &drive.User{DisplayName: "Modifier"}}, "Modifier"},
+		{"modifier name only", &drive.File{LastModifyingUserName: "Someone"}, "Someone"},
+		{"neither", &drive.File{}, ""},
+	}
+	for _, tt := range tests {
+		if got := uploaderOf(tt.file); got != tt.want {
+			t.Errorf("%s: uploaderOf = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUploaderColumn(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.UploaderColumn = true }),
+		WithPreloadedFiles([]*drive.File{
+			{
+				Id: "upload-1", Title: "one.txt", MimeType: "text/plain", CreatedDate: "2020-06-18T12:00:00Z",
+				LastModifyingUser: &drive.User{EmailAddress: "modifier@example.com"},
+				Owners:            []*drive.User{{EmailAddress: "owner@example.com"}},
+			},
+			{Id: "upload-2", Title: "two.txt", MimeType: "text/plain", CreatedDate: "2020-06-18T13:00:00Z"},
+		}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.SetContent("upload-2", []byte(donationText("2020-06-18 12:35:56", "Pilot Two", "2,000")))
+
+	for _, r := range runBatch(t, sc) {
+		if r.err != nil || r.result.Error != "" {
+			t.Fatalf("processBatch result = %+v, %v, want the upload recorded", r.result, r.err)
+		}
+	}
+
+	headers := buildHeaders()
+	column := -1
+	for i, h := range headers {
+		if h == "Uploader" {
+			column = i
+		}
+	}
+	if column < 0 {
+		t.Fatalf("Headers %v have no Uploader column", headers)
+	}
+	rows := fakeSheets.Values(testSheetID, "Sheet1")
+	if len(rows) != 3 {
+		t.Fatalf("Sheet has %d rows, want the header and 2 donations: %q", len(rows), rows)
+	}
+	want := map[string]string{"Pilot One": "modifier@example.com", "Pilot Two": ""}
+	for _, row := range rows[1:] {
+		got := ""
+		if column < len(row) {
+			got = fmt.Sprint(row[column])
+		}
+		if name := fmt.Sprint(row[nameColumn]); got != want[name] {
+			t.Errorf("Uploader of %s = %q, want %q", name, got, want[name])
+		}
+	}
+}
+
+func TestValidateDriveFolderID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		ok   bool
+	}{
+		{"bare ID", "1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgVE2upms", true},
+		{"share URL", "https://drive.google.com/drive/folders/1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgVE2upms", false},
+		{"path", "folders/1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgVE2upms", false},
+		{"empty", "", false},
+		{"too short", "1BxiMVs0XRA5", false},
+		{"spaces", "1BxiMVs0XRA5nFMd KvBdBZjgmUUqptlbs74OgVE2upms", false},
+	}
+	for _, tt := range tests {
+		err := validateDriveFolderID(tt.id)
+		if tt.ok && err != nil {
+			t.Errorf("%s: validateDriveFolderID = %v, want nil", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidFolderID) {
+			t.Errorf("%s: validateDriveFolderID = %v, want %v", tt.name, err, ErrInvalidFolderID)
+		}
+		if !tt.ok && !strings.Contains(err.Error(), "got: "+tt.id) {
+			t.Errorf("%s: error %q doesn't show the value given", tt.name, err)
+		}
+	}
+}
+
+func TestSetupFoldersRejectsShareURL(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t)
+
+	_, err := setupFolders("https://drive.google.com/drive/folders/1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgVE2upms")
+	if !errors.Is(err, ErrInvalidFolderID) {
+		t.Errorf("setupFolders = %v, want %v", err, ErrInvalidFolderID)
+	}
+	if requests := fakeDrive.Requests(); len(requests) > 0 {
+		t.Errorf("setupFolders sent %q for an invalid ID", requests)
+	}
+}
+
+func TestOctetStreamUploadsAreSniffed(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: "wallet.png", MimeType: octetStreamMimeType},
+			{Id: "upload-2", Title: "notes.zip", MimeType: octetStreamMimeType},
+		}))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetContent("upload-2", []byte("PK\x03\x04 not an image"))
+	fakeDrive.SetOCRText("wallet.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+
+	results := runBatch(t, sc)
+	if len(results) != 2 {
+		t.Fatalf("processBatch results = %+v, want both uploads", results)
+	}
+	for _, r := range results {
+		if r.err != nil {
+			t.Errorf("%s failed: %v", r.result.FileName, r.err)
+		}
+	}
+
+	// The PNG is processed despite its label, the other file is failed without OCR
+	if !inFolder(fakeDrive.File("upload-1"), testProcessedFolderID) {
+		t.Errorf("The PNG was moved to %v, want Processed", fakeDrive.File("upload-1").Parents)
+	}
+	if !inFolder(fakeDrive.File("upload-2"), testFailedFolderID) {
+		t.Errorf("The non-image was moved to %v, want Failed", fakeDrive.File("upload-2").Parents)
+	}
+	rows := fakeSheets.Values(testSheetID, "Sheet1")
+	if len(rows) != 2 || rows[1][nameColumn] != "Pilot One" {
+		t.Errorf("Report = %q, want the header and the donation of Pilot One", rows)
+	}
+}
+
+func TestFailedOCRDocMovesAfterItsRow(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "blurry.png", MimeType: "image/png"}}))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetOCRTexts("blurry.png", "unreadable", "unreadable")
+
+	failedDocs := func() int {
+		n := 0
+		for _, f := range fakeDrive.FilesIn(testFailedFolderID) {
+			if f.MimeType == DocumentMimeType {
+				n++
+			}
+		}
+		return n
+	}
+
+	// The failure row can't be written, so the upload is retried and its document isn't failed
+	fakeSheets.Fail(http.MethodPost, ":append", &googleapi.Error{Code: http.StatusBadRequest, Message: "Bad Request"})
+	if results := runBatch(t, sc); len(results) != 1 || results[0].err == nil {
+		t.Fatalf("processBatch results = %+v, want the append error", results)
+	}
+	if !inFolder(fakeDrive.File("upload-1"), testUploadFolderID) {
+		t.Fatalf("The upload was moved to %v, want it left for a retry", fakeDrive.File("upload-1").Parents)
+	}
+	if n := failedDocs(); n != 0 {
+		t.Errorf("Failed has %d documents before the failure row is written, want 0", n)
+	}
+
+	fakeSheets.ClearFailures()
+	if results := runBatch(t, sc); len(results) != 1 || results[0].err != nil {
+		t.Fatalf("processBatch results = %+v, want the retry to succeed", results)
+	}
+	if n := failedDocs(); n != 1 {
+		t.Errorf("Failed has %d documents after the retry, want only the retry's", n)
+	}
+	if rows := fakeSheets.Values(testSheetID, "Sheet1"); len(rows) != 2 || rows[1][nameColumn] != "" {
+		t.Errorf("Report = %q, want the header and one failure row", rows)
+	}
+}
+
+func TestPreserveOriginal(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.PreserveOriginal = true }),
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "wallet.png", MimeType: "image/png"}}))
+	png := testPNG(t)
+	fakeDrive.SetContent("upload-1", png)
+	fakeDrive.SetOCRText("wallet.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || results[0].err != nil {
+		t.Fatalf("processBatch results = %+v, want the upload processed", results)
+	}
+
+	// The original keeps its ID, name, folder and content
+	original := fakeDrive.File("upload-1")
+	if original == nil {
+		t.Fatal("The original upload is gone")
+	}
+	if original.Title != "wallet.png" || !inFolder(original, testUploadFolderID) || !bytes.Equal(fakeDrive.Content("upload-1"), png) {
+		t.Errorf("Original = %q in %v, want it untouched in the Upload folder", original.Title, original.Parents)
+	}
+	var copies []*drive.File
+	for _, f := range fakeDrive.FilesIn(testProcessedFolderID) {
+		if f.MimeType != DocumentMimeType {
+			copies = append(copies, f)
+		}
+	}
+	if len(copies) != 1 || copies[0].Id == "upload-1" || !hasProperty(copies[0], originalPropertyKey, "upload-1") {
+		t.Errorf("Processed uploads = %v, want only a copy tagged with the original's ID", copies)
+	}
+
+	// The original isn't copied again by the next run
+	if results := runBatch(t, sc); len(results) != 0 {
+		t.Errorf("Second processBatch results = %+v, want none", results)
+	}
+	if rows := fakeSheets.Values(testSheetID, "Sheet1"); len(rows) != 2 {
+		t.Errorf("Sheet has %d rows, want the header and one donation: %q", len(rows), rows)
+	}
+}
+
+func TestTextUploadSkipsOCR(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "wallet log.txt", MimeType: "text/plain"}}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || results[0].err != nil {
+		t.Fatalf("processBatch results = %+v, want the text upload processed", results)
+	}
+	for _, request := range fakeDrive.Requests() {
+		if request == "POST /drive/v2/files" {
+			t.Errorf("An OCR document was made of the text upload")
+		}
+	}
+	if !inFolder(fakeDrive.File("upload-1"), testProcessedFolderID) {
+		t.Errorf("The upload was moved to %v, want Processed", fakeDrive.File("upload-1").Parents)
+	}
+
+	// With no OCR document, the row links to the upload itself
+	rows := fakeSheets.Values(testSheetID, "Sheet1")
+	if len(rows) != 2 || rows[1][nameColumn] != "Pilot One" || rows[1][5] != fakeDrive.File("upload-1").AlternateLink {
+		t.Errorf("Report = %q, want the donation of Pilot One linking to the upload", rows)
+	}
+}
+
+func TestIsPlainText(t *testing.T) {
+	text := []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+	tests := []struct {
+		name     string
+		title    string
+		mimeType string
+		content  []byte
+		want     bool
+	}{
+		{"labelled text", "log", "text/plain", text, true},
+		{"txt extension", "LOG.TXT", octetStreamMimeType, text, true},
+		{"unlabelled text", "log", octetStreamMimeType, text, false},
+		{"binary txt", "log.txt", "text/plain", testPNG(t), false},
+	}
+	for _, tt := range tests {
+		if got := isPlainText(&drive.File{Title: tt.title, MimeType: tt.mimeType}, tt.content); got != tt.want {
+			t.Errorf("%s: isPlainText = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpectFilesListsAgain(t *testing.T) {
+	tests := []struct {
+		name        string
+		expectFiles bool
+		wantRows    int
+	}{
+		{"expected", true, 2},
+		{"not expected", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, fakeDrive, fakeSheets := NewTestServiceContext(t, WithConfig(func(c *Config) {
+				c.ExpectFiles = tt.expectFiles
+				c.ExpectFilesDelay = 200 * time.Millisecond
+			}))
+			fakeDrive.SetOCRText("late.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+			fakeDrive.resetRequests()
+
+			// The upload lands once the empty folder has been listed
+			landed := make(chan struct{})
+			go func() {
+				defer close(landed)
+				for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+					for _, r := range fakeDrive.Requests() {
+						if r == "GET /drive/v2/files" {
+							fakeDrive.AddFile(&drive.File{Id: "late", Title: "late.png", MimeType: "image/png", Parents: parentRefs(testUploadFolderID)}, testPNG(t))
+							return
+						}
+					}
+				}
+			}()
+			results := runBatch(t, sc)
+			<-landed
+
+			if rows := fakeSheets.Values(testSheetID, "Sheet1"); len(rows) != tt.wantRows {
+				t.Errorf("Sheet has %d rows, want %d: %q", len(rows), tt.wantRows, rows)
+			}
+			if tt.expectFiles && (len(results) != 1 || results[0].err != nil) {
+				t.Errorf("processBatch results = %+v, want the late upload processed", results)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// MemberTotalsTabName is the tab holding member by month donation totals
+const MemberTotalsTabName = "Member Totals"
+
+// memberTotalsMu serialises the read-modify-write of the totals tab
+var memberTotalsMu sync.Mutex
+
+var memberTotalsTabReady bool
+
+// incrementMemberTotal adds a donation to the member's cell for the month of its Echoes date,
+// adding the member row and month column the first time they are seen
+func incrementMemberTotal(ctx context.Context, record Record) error {
+	echoesDate, err := time.Parse("2006-01-02 15:04:05", record.Date)
+	if err != nil {
+		return fmt.Errorf("Unable to parse Echoes date %q: %v", record.Date, err)
+	}
+	month := echoesDate.Format("2006-01")
+	name := record.Username
+
+	value, err := strconv.ParseFloat(strings.Replace(record.Quantity, ",", "", -1), 64)
+	if err != nil {
+		return fmt.Errorf("Unable to parse amount %q: %v", record.Quantity, err)
+	}
+	value *= config.AmountMultiplier
+
+	memberTotalsMu.Lock()
+	defer memberTotalsMu.Unlock()
+
+	err = ensureMemberTotalsTab(ctx)
+	if err != nil {
+		return err
+	}
+
+	vr, err := sheetService.Spreadsheets.Values.Get(foldersFrom(ctx).Sheet, quoteTab(MemberTotalsTabName)).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	grid := vr.Values
+	if len(grid) == 0 {
+		grid = [][]interface{}{{"Member"}}
+		err = updateCell(ctx, MemberTotalsTabName, 0, 0, "Member")
+		if err != nil {
+			return err
+		}
+	}
+
+	col := -1
+	for i, cell := range grid[0] {
+		if i > 0 && fmt.Sprint(cell) == month {
+			col = i
+			break
+		}
+	}
+	if col == -1 {
+		col = len(grid[0])
+		err = updateCell(ctx, MemberTotalsTabName, 0, col, month)
+		if err != nil {
+			return err
+		}
+	}
+
+	row := -1
+	for i, cells := range grid {
+		if i > 0 && len(cells) > 0 && fmt.Sprint(cells[0]) == name {
+			row = i
+			break
+		}
+	}
+	if row == -1 {
+		row = len(grid)
+		err = updateCell(ctx, MemberTotalsTabName, row, 0, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	total := value
+	if row < len(grid) && col < len(grid[row]) {
+		existing := strings.Replace(fmt.Sprint(grid[row][col]), ",", "", -1)
+		if existing != "" {
+			previous, err := strconv.ParseFloat(existing, 64)
+			if err != nil {
+				return fmt.Errorf("Unable to parse total %q for %s in %s: %v", existing, name, month, err)
+			}
+			total += previous
+		}
+	}
+	return updateCell(ctx, MemberTotalsTabName, row, col, total)
+}
+
+// ensureMemberTotalsTab adds the totals tab to the report if it doesn't exist yet
+func ensureMemberTotalsTab(ctx context.Context) error {
+	if memberTotalsTabReady {
+		return nil
+	}
+
+	sheetID := foldersFrom(ctx).Sheet
+	ss, err := sheetService.Spreadsheets.Get(sheetID).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	for _, sheet := range ss.Sheets {
+		if sheet.Properties != nil && sheet.Properties.Title == MemberTotalsTabName {
+			memberTotalsTabReady = true
+			return nil
+		}
+	}
+
+	addSheet := &sheets.Request{AddSheet: &sheets.AddSheetRequest{
+		Properties: &sheets.SheetProperties{Title: MemberTotalsTabName},
+	}}
+	batch := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{addSheet}}
+	_, err = sheetService.Spreadsheets.BatchUpdate(sheetID, batch).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	memberTotalsTabReady = true
+	return nil
+}
+
+// updateCell writes a single zero-indexed cell of a tab
+func updateCell(ctx context.Context, tab string, row, col int, value interface{}) error {
+	cellRange := fmt.Sprintf("%s!%s%d", quoteTab(tab), columnName(col), row+1)
+	valueRange := &sheets.ValueRange{Values: [][]interface{}{{value}}}
+	_, err := sheetService.Spreadsheets.Values.Update(foldersFrom(ctx).Sheet, cellRange, valueRange).ValueInputOption("USER_ENTERED").Context(ctx).Do()
+	return err
+}
+
+// quoteTab quotes a tab name for use in A1 notation
+func quoteTab(tab string) string {
+	return "'" + strings.Replace(tab, "'", "''", -1) + "'"
+}
+
+// columnName converts a zero-indexed column to its A1 letters, 0 is A and 26 is AA
+func columnName(col int) string {
+	name := ""
+	for col >= 0 {
+		name = string(rune('A'+col%26)) + name
+		col = col/26 - 1
+	}
+	return name
+}
@@ -0,0 +1,41 @@
+package trimark
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestMemberTotalsIncrementTheMonthCell(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) {
+			c.MemberMonthlyTotals = true
+			c.Serial = true
+		}),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: "one.txt", MimeType: "text/plain", CreatedDate: "2020-07-01T10:00:00Z"},
+			{Id: "upload-2", Title: "two.txt", MimeType: "text/plain", CreatedDate: "2020-07-01T11:00:00Z"},
+			{Id: "upload-3", Title: "three.txt", MimeType: "text/plain", CreatedDate: "2020-07-01T12:00:00Z"},
+			{Id: "upload-4", Title: "four.txt", MimeType: "text/plain", CreatedDate: "2020-07-01T13:00:00Z"},
+		}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.SetContent("upload-2", []byte(donationText("2020-06-20 08:00:00", "Pilot One", "250")))
+	fakeDrive.SetContent("upload-3", []byte(donationText("2020-07-01 09:00:00", "Pilot One", "100")))
+	fakeDrive.SetContent("upload-4", []byte(donationText("2020-06-21 09:00:00", "Pilot Two", "40")))
+
+	for _, r := range runBatch(t, sc) {
+		if r.err != nil || r.result.Error != "" {
+			t.Fatalf("processBatch result = %+v, %v, want the upload recorded", r.result, r.err)
+		}
+	}
+
+	want := [][]interface{}{
+		{"Member", "2020-06", "2020-07"},
+		{"Pilot One", "1250", "100"},
+		{"Pilot Two", "40"},
+	}
+	if got := fakeSheets.Values(testSheetID, quoteTab(MemberTotalsTabName)); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", MemberTotalsTabName, got, want)
+	}
+}
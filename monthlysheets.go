@@ -0,0 +1,89 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// monthlySheetIDs caches the ID of each month's report spreadsheet, keyed by YYYY-MM. The mutex
+// is held while a sheet is set up, so concurrent files of a new month don't each create one.
+var (
+	monthlySheetIDs   = map[string]string{}
+	monthlySheetIDsMu sync.Mutex
+)
+
+// reportMonth is the YYYY-MM of an Echoes date, or of now when the date wasn't extracted
+func reportMonth(echoesDate string) string {
+	if len(echoesDate) >= 7 {
+		if t, err := time.Parse("2006-01", echoesDate[:7]); err == nil {
+			return t.Format("2006-01")
+		}
+	}
+	return time.Now().UTC().Format("2006-01")
+}
+
+// monthlySheetName is the report spreadsheet for a month, such as "ISK Import Report 2020-06"
+func monthlySheetName(month string) string {
+	return fmt.Sprintf("%s %s", SheetName, month)
+}
+
+// monthlySheetNameRegex matches the name of a month's report spreadsheet, capturing its YYYY-MM.
+// Annual archives, named with only the year, don't match.
+var monthlySheetNameRegex = regexp.MustCompile(`^` + regexp.QuoteMeta(SheetName) + ` (\d{4}-\d{2})$`)
+
+// reportSpreadsheetIDs lists the spreadsheets holding the report's rows, the report itself or,
+// with MonthlySheetsEnv, each month's spreadsheet in the Report folder, oldest month first
+func reportSpreadsheetIDs(ctx context.Context) ([]string, error) {
+	folders := foldersFrom(ctx)
+	if !config.MonthlySheets {
+		return []string{folders.Sheet}, nil
+	}
+
+	files, err := getFilesFromFolder(folders.Report, false)
+	if err != nil {
+		return nil, err
+	}
+	byMonth := map[string]string{}
+	var months []string
+	for _, file := range files {
+		m := monthlySheetNameRegex.FindStringSubmatch(file.Title)
+		if m == nil || file.MimeType != SpreadsheetMimeType {
+			continue
+		}
+		if _, ok := byMonth[m[1]]; !ok {
+			months = append(months, m[1])
+		}
+		byMonth[m[1]] = file.Id
+	}
+	sort.Strings(months)
+
+	ids := make([]string, 0, len(months))
+	for _, month := range months {
+		ids = append(ids, byMonth[month])
+	}
+	return ids, ctx.Err()
+}
+
+// monthlySheetID returns the report spreadsheet for the month of an Echoes date, setting it up
+// in the Report folder the first time the month is seen
+func monthlySheetID(ctx context.Context, echoesDate string) (string, error) {
+	month := reportMonth(echoesDate)
+
+	monthlySheetIDsMu.Lock()
+	defer monthlySheetIDsMu.Unlock()
+	if id, ok := monthlySheetIDs[month]; ok {
+		return id, nil
+	}
+
+	name := monthlySheetName(month)
+	id, err := prepareSheet(foldersFrom(ctx).Report, name)
+	if err != nil {
+		return "", fmt.Errorf("Unable to set up %s: %v", name, err)
+	}
+	monthlySheetIDs[month] = id
+	return id, nil
+}
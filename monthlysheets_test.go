@@ -0,0 +1,81 @@
+package trimark
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestReportMonth(t *testing.T) {
+	current := time.Now().UTC().Format("2006-01")
+
+	tests := []struct {
+		echoesDate string
+		want       string
+	}{
+		{"2020-06-01 12:34:56", "2020-06"},
+		{"2020-12", "2020-12"},
+		{"2020-13-01 00:00:00", current},
+		{"06/01/2020", current},
+		{"", current},
+	}
+	for _, tt := range tests {
+		if got := reportMonth(tt.echoesDate); got != tt.want {
+			t.Errorf("reportMonth(%q) = %q, want %q", tt.echoesDate, got, tt.want)
+		}
+	}
+}
+
+func TestMonthlySheetsRouteRowsByEchoesDate(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.MonthlySheets = true }),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "june-1", Title: "june-1.txt", MimeType: "text/plain"},
+			{Id: "july-1", Title: "july-1.txt", MimeType: "text/plain"},
+			{Id: "june-2", Title: "june-2.txt", MimeType: "text/plain"},
+		}))
+	fakeDrive.SetContent("june-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.SetContent("july-1", []byte(donationText("2020-07-02 08:00:00", "Pilot Two", "2,000")))
+	fakeDrive.SetContent("june-2", []byte(donationText("2020-06-30 23:59:59", "Pilot Three", "3,000")))
+
+	for _, r := range runBatch(t, sc) {
+		if r.err != nil {
+			t.Fatalf("%s: %v", r.result.FileName, r.err)
+		}
+	}
+
+	sheets := map[string]string{}
+	for _, f := range fakeDrive.FilesIn(testReportFolderID) {
+		if f.MimeType == SpreadsheetMimeType {
+			sheets[f.Title] = f.Id
+		}
+	}
+	wantRows := map[string][]string{
+		monthlySheetName("2020-06"): {"Pilot One", "Pilot Three"},
+		monthlySheetName("2020-07"): {"Pilot Two"},
+	}
+	for name, want := range wantRows {
+		id, ok := sheets[name]
+		if !ok {
+			t.Errorf("No %s in the Report folder, found %v", name, sheets)
+			continue
+		}
+		rows := fakeSheets.Values(id, "Sheet1!A2:G")
+		if len(rows) != len(want) {
+			t.Errorf("%s rows = %v, want %d", name, rows, len(want))
+		}
+		got := map[interface{}]bool{}
+		for _, row := range rows {
+			got[row[nameColumn]] = true
+		}
+		for _, user := range want {
+			if !got[user] {
+				t.Errorf("%s has no row for %s", name, user)
+			}
+		}
+	}
+	if rows := fakeSheets.Values(testSheetID, "Sheet1!A2:G"); len(rows) != 0 {
+		t.Errorf("Report sheet rows = %v, want none with %s", rows, MonthlySheetsEnv)
+	}
+}
@@ -0,0 +1,23 @@
+package trimark
+
+import (
+	"bytes"
+	"strings"
+)
+
+// utf8BOM is the byte order mark Docs sometimes starts a plain text export with
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// ContentNormalizer removes the invisible characters some OCR output contains, which would
+// otherwise stop the regexes matching. Non-breaking spaces become plain spaces.
+var ContentNormalizer = strings.NewReplacer(
+	"\ufeff", "", // byte order mark, anywhere but the start
+	"\u200b", "", // zero-width space
+	"\u200e", "", // left-to-right mark
+	"\u00a0", " ", // non-breaking space
+)
+
+// stripBOM removes a leading UTF-8 byte order mark
+func stripBOM(content []byte) []byte {
+	return bytes.TrimPrefix(content, utf8BOM)
+}
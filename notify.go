@@ -0,0 +1,131 @@
+package trimark
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+)
+
+// defaultSMTPPort is used when SMTPPortEnv is unset
+const defaultSMTPPort = 25
+
+// emailSendTimeout bounds sending a notification, which the failed file's processing waits on
+const emailSendTimeout = 30 * time.Second
+
+// EmailNotifier emails the alliance manager about uploads which need a person to look at them
+type EmailNotifier struct {
+	From     string
+	To       string
+	SMTPHost string
+	SMTPPort int
+
+	// dial connects to the SMTP server, it's net.Dialer.DialContext unless replaced
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// emailNotifier is nil when NotificationEmailToEnv is unset, and notifications are skipped
+var emailNotifier *EmailNotifier
+
+// newEmailNotifier returns nil when no notification address has been configured
+func newEmailNotifier(c Config) *EmailNotifier {
+	if c.NotificationEmailTo == "" {
+		return nil
+	}
+	return &EmailNotifier{
+		From:     c.NotificationEmailFrom,
+		To:       c.NotificationEmailTo,
+		SMTPHost: c.SMTPHost,
+		SMTPPort: c.SMTPPort,
+	}
+}
+
+// SendEmail sends a plain text email, using STARTTLS when the server offers it
+func (n *EmailNotifier) SendEmail(ctx context.Context, subject, body string) error {
+	dial := n.dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", net.JoinHostPort(n.SMTPHost, strconv.Itoa(n.SMTPPort)))
+	if err != nil {
+		return fmt.Errorf("Unable to connect to %s: %v", n.SMTPHost, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, n.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.SMTPHost}); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(n.To); err != nil {
+		return err
+	}
+
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	msg := strings.Join([]string{
+		"From: " + n.From,
+		"To: " + n.To,
+		"Subject: " + encodeHeader(subject),
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+	if _, err := wc.Write([]byte(msg)); err != nil {
+		wc.Close()
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// encodeHeader makes a header value safe to write, such as a subject holding an uploader's file
+// name. Line breaks, which would start headers of their own, become spaces, and anything beyond
+// ASCII is Q-encoded.
+func encodeHeader(v string) string {
+	v = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(v)
+	return mime.QEncoding.Encode("UTF-8", v)
+}
+
+// notifyGaveUp emails the details of an upload moved to Failed after its last attempt. It waits
+// up to emailSendTimeout for the email to be sent, as an instance may be frozen once it responds.
+func notifyGaveUp(file *drive.File, attempts int, cause error) {
+	if emailNotifier == nil {
+		return
+	}
+
+	subject := fmt.Sprintf("trimark gave up on %s", file.Title)
+	body := fmt.Sprintf("%s failed %d attempts at processing and has been moved to Failed.\r\n\r\nFile: %s\r\nID: %s\r\nLink: %s\r\nLast error: %v\r\n",
+		file.Title, attempts, file.Title, file.Id, file.AlternateLink, cause)
+
+	ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+	defer cancel()
+	if err := emailNotifier.SendEmail(ctx, subject, body); err != nil {
+		log.Printf("WARN: unable to email %s about %s: %v", emailNotifier.To, file.Title, err)
+	}
+}
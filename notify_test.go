@@ -0,0 +1,102 @@
+package trimark
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+// fakeSMTP serves one SMTP session on conn, recording the envelope and message it was sent
+type fakeSMTP struct {
+	mu   sync.Mutex
+	from string
+	rcpt []string
+	data string
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 smtp.test ready")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+		s.mu.Lock()
+		switch verb {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 smtp.test")
+		case "MAIL":
+			s.from = line
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			s.rcpt = append(s.rcpt, line)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			data, _ := tp.ReadDotBytes()
+			s.data = string(data)
+			tp.PrintfLine("250 Queued")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			s.mu.Unlock()
+			return
+		default:
+			tp.PrintfLine("502 Not implemented")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestNotifyGaveUpEmailsConfiguredAddress(t *testing.T) {
+	NewTestServiceContext(t)
+
+	server := &fakeSMTP{}
+	var dialed string
+	emailNotifier = newEmailNotifier(Config{
+		NotificationEmailTo:   "manager@example.com",
+		NotificationEmailFrom: "trimark@example.com",
+		SMTPHost:              "smtp.test",
+		SMTPPort:              587,
+	})
+	emailNotifier.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = address
+		client, conn := net.Pipe()
+		go server.serve(conn)
+		return client, nil
+	}
+
+	file := &drive.File{Id: "upload-1", Title: "screenshot.png", AlternateLink: "https://drive.google.com/file/d/upload-1/view"}
+	notifyGaveUp(file, 3, errors.New("Quantity Not Found"))
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if dialed != "smtp.test:587" {
+		t.Errorf("Dialed %q, want smtp.test:587", dialed)
+	}
+	if !strings.Contains(server.from, "<trimark@example.com>") {
+		t.Errorf("MAIL = %q, want it from trimark@example.com", server.from)
+	}
+	if len(server.rcpt) != 1 || !strings.Contains(server.rcpt[0], "<manager@example.com>") {
+		t.Errorf("RCPT = %q, want only manager@example.com", server.rcpt)
+	}
+	for _, want := range []string{"Subject: trimark gave up on screenshot.png", "ID: upload-1", file.AlternateLink, "Last error: Quantity Not Found", "failed 3 attempts"} {
+		if !strings.Contains(server.data, want) {
+			t.Errorf("Email %q doesn't contain %q", server.data, want)
+		}
+	}
+}
+
+func TestNewEmailNotifierUnconfigured(t *testing.T) {
+	if n := newEmailNotifier(Config{SMTPHost: "smtp.test"}); n != nil {
+		t.Errorf("newEmailNotifier without %s = %+v, want nil", NotificationEmailToEnv, n)
+	}
+}
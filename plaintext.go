@@ -0,0 +1,69 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// blockElements end a line in the text of an HTML export, as they would in its plain text export
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// exportAsPlainText exports a Google Doc as text/plain, falling back to converting its text/html
+// export when Drive refuses, such as with a 403 for a document too large to export as text
+func exportAsPlainText(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	textDoc, err := driveService.Files.Export(fileID, "text/plain").Context(ctx).Download()
+	if err == nil {
+		return textDoc.Body, nil
+	}
+	log.Printf("Unable to export %s as text/plain, trying text/html: %v", fileID, err)
+
+	htmlDoc, err2 := driveService.Files.Export(fileID, "text/html").Context(ctx).Download()
+	if err2 != nil {
+		return nil, fmt.Errorf("text/plain export: %v, text/html export: %v", err, err2)
+	}
+	defer htmlDoc.Body.Close()
+
+	text, err := htmlToText(htmlDoc.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to convert text/html export: %v", err)
+	}
+	return ioutil.NopCloser(strings.NewReader(text)), nil
+}
+
+// htmlToText returns the text of an HTML document, with the \r\n line endings of a Docs plain
+// text export at each block element, which the extraction regexes depend on
+func htmlToText(r io.Reader) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "head" || n.Data == "script" || n.Data == "style") {
+			return
+		}
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && blockElements[n.Data] {
+			buf.WriteString("\r\n")
+		}
+	}
+	walk(doc)
+	return buf.String(), nil
+}
@@ -0,0 +1,90 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/option"
+)
+
+// mockExports points driveService at a server answering export requests with responses in turn,
+// it returns the mimeType of each request
+func mockExports(t *testing.T, responses ...func(w http.ResponseWriter)) *[]string {
+	t.Helper()
+	var mimeTypes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(mimeTypes) == len(responses) {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			http.Error(w, "unexpected", http.StatusNotImplemented)
+			return
+		}
+		respond := responses[len(mimeTypes)]
+		mimeTypes = append(mimeTypes, r.URL.Query().Get("mimeType"))
+		respond(w)
+	}))
+	t.Cleanup(srv.Close)
+
+	var err error
+	driveService, err = drive.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL+"/drive/v2/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mimeTypes
+}
+
+func TestExportAsPlainTextFallsBackToHTML(t *testing.T) {
+	NewTestServiceContext(t)
+
+	// Lines as a Docs HTML export has them, one paragraph each
+	var body strings.Builder
+	body.WriteString(`<html><head><style>p{margin:0}</style></head><body>`)
+	for _, line := range strings.Split(donationText("2020-06-18 12:34:56", "Pilot One", "1,000"), "\r\n") {
+		fmt.Fprintf(&body, `<p><span>%s</span></p>`, html.EscapeString(line))
+	}
+	body.WriteString(`</body></html>`)
+
+	mimeTypes := mockExports(t,
+		func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error": {"code": 500, "message": "Internal Error"}}`)
+		},
+		func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, body.String())
+		},
+	)
+
+	text, err := exportAsPlainText(context.Background(), "doc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"text/plain", "text/html"}; !reflect.DeepEqual(*mimeTypes, want) {
+		t.Errorf("Exported as %q, want %q", *mimeTypes, want)
+	}
+
+	record, err := extractData(text)
+	if err != nil {
+		t.Fatalf("extractData of the HTML export failed: %v", err)
+	}
+	if record.Date != "2020-06-18 12:34:56" || record.Username != "Pilot One" || record.Quantity != "1,000" {
+		t.Errorf("extractData = %q, %q, %q, want the donation of Pilot One", record.Date, record.Username, record.Quantity)
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	got, err := htmlToText(strings.NewReader(`<html><head><title>x</title><script>y()</script></head><body><div>a<b>b</b></div>c<br>d &amp; e<p>f</p></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ab\r\nc\r\nd & ef\r\n"; got != want {
+		t.Errorf("htmlToText = %q, want %q", got, want)
+	}
+}
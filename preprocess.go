@@ -0,0 +1,195 @@
+package trimark
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// claheTiles is the number of tiles CLAHE divides each image axis into
+const claheTiles = 8
+
+// claheClipLimit caps each histogram bin at this multiple of the average bin height
+const claheClipLimit = 2.0
+
+// PreprocessConfig selects the clean-up steps applied to screenshots before OCR
+type PreprocessConfig struct {
+	EnableCLAHE bool
+	EnableOtsu  bool
+}
+
+// Enabled reports whether any preprocessing step is switched on
+func (cfg PreprocessConfig) Enabled() bool {
+	return cfg.EnableCLAHE || cfg.EnableOtsu
+}
+
+// PreprocessImage converts img to grayscale, then applies contrast limited adaptive
+// histogram equalisation and Otsu binarisation when enabled, in that order
+func PreprocessImage(img image.Image, cfg PreprocessConfig) image.Image {
+	gray := toGray(img)
+	if cfg.EnableCLAHE {
+		gray = clahe(gray, claheTiles, claheClipLimit)
+	}
+	if cfg.EnableOtsu {
+		gray = binarize(gray, otsuThreshold(gray))
+	}
+	return gray
+}
+
+func toGray(img image.Image) *image.Gray {
+	b := img.Bounds()
+	gray := image.NewGray(b)
+	draw.Draw(gray, b, img, b.Min, draw.Src)
+	return gray
+}
+
+// otsuThreshold finds the threshold which maximises the variance between the dark and light pixels
+func otsuThreshold(img *image.Gray) uint8 {
+	var hist [256]float64
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			hist[img.GrayAt(x, y).Y]++
+		}
+	}
+
+	total := float64(b.Dx() * b.Dy())
+	sum := 0.0
+	for i, c := range hist {
+		sum += float64(i) * c
+	}
+
+	var sumBackground, weightBackground, best float64
+	var threshold uint8
+	for t, c := range hist {
+		weightBackground += c
+		if weightBackground == 0 {
+			continue
+		}
+		weightForeground := total - weightBackground
+		if weightForeground == 0 {
+			break
+		}
+
+		sumBackground += float64(t) * c
+		meanBackground := sumBackground / weightBackground
+		meanForeground := (sum - sumBackground) / weightForeground
+
+		between := weightBackground * weightForeground * (meanBackground - meanForeground) * (meanBackground - meanForeground)
+		if between > best {
+			best = between
+			threshold = uint8(t)
+		}
+	}
+	return threshold
+}
+
+// binarize turns pixels above the threshold white and the rest black
+func binarize(img *image.Gray, threshold uint8) *image.Gray {
+	b := img.Bounds()
+	out := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.GrayAt(x, y).Y > threshold {
+				out.SetGray(x, y, color.Gray{Y: 255})
+			} else {
+				out.SetGray(x, y, color.Gray{Y: 0})
+			}
+		}
+	}
+	return out
+}
+
+// clahe equalises the histogram of each tile separately, clipping the histograms to limit
+// noise amplification, and blends neighbouring tiles bilinearly to avoid visible seams
+func clahe(img *image.Gray, tiles int, clipLimit float64) *image.Gray {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < tiles || h < tiles {
+		return img
+	}
+
+	tileW := (w + tiles - 1) / tiles
+	tileH := (h + tiles - 1) / tiles
+	tilesX := (w + tileW - 1) / tileW
+	tilesY := (h + tileH - 1) / tileH
+
+	luts := make([][256]float64, tilesX*tilesY)
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			x0, y0 := tx*tileW, ty*tileH
+			x1, y1 := minInt(x0+tileW, w), minInt(y0+tileH, h)
+
+			var hist [256]int
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					hist[img.GrayAt(b.Min.X+x, b.Min.Y+y).Y]++
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+
+			limit := int(clipLimit * float64(n) / 256)
+			if limit < 1 {
+				limit = 1
+			}
+			excess := 0
+			for i := range hist {
+				if hist[i] > limit {
+					excess += hist[i] - limit
+					hist[i] = limit
+				}
+			}
+			for i := range hist {
+				hist[i] += excess / 256
+				if i < excess%256 {
+					hist[i]++
+				}
+			}
+
+			cdf := 0
+			lut := &luts[ty*tilesX+tx]
+			for i := range hist {
+				cdf += hist[i]
+				lut[i] = float64(cdf) * 255 / float64(n)
+			}
+		}
+	}
+
+	out := image.NewGray(b)
+	for y := 0; y < h; y++ {
+		ty0, ty1, wy := neighbourTiles(y, tileH, tilesY)
+		for x := 0; x < w; x++ {
+			tx0, tx1, wx := neighbourTiles(x, tileW, tilesX)
+			v := img.GrayAt(b.Min.X+x, b.Min.Y+y).Y
+
+			top := (1-wx)*luts[ty0*tilesX+tx0][v] + wx*luts[ty0*tilesX+tx1][v]
+			bottom := (1-wx)*luts[ty1*tilesX+tx0][v] + wx*luts[ty1*tilesX+tx1][v]
+			out.SetGray(b.Min.X+x, b.Min.Y+y, color.Gray{Y: uint8(math.Round((1-wy)*top + wy*bottom))})
+		}
+	}
+	return out
+}
+
+// neighbourTiles returns the two tiles whose centres surround pos and the weight of the second
+func neighbourTiles(pos int, size int, count int) (int, int, float64) {
+	f := (float64(pos)+0.5)/float64(size) - 0.5
+	first := int(math.Floor(f))
+	weight := f - float64(first)
+
+	second := first + 1
+	if first < 0 {
+		first, weight = 0, 0
+	}
+	if second > count-1 {
+		second, weight = count-1, 0
+	}
+	return first, second, weight
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
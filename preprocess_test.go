@@ -0,0 +1,33 @@
+package trimark
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOtsuThreshold(t *testing.T) {
+	// grayImage fills an image with the levels, one pixel each
+	grayImage := func(levels ...uint8) *image.Gray {
+		img := image.NewGray(image.Rect(0, 0, len(levels), 1))
+		for x, y := range levels {
+			img.SetGray(x, 0, color.Gray{Y: y})
+		}
+		return img
+	}
+
+	tests := []struct {
+		name string
+		img  *image.Gray
+		want uint8
+	}{
+		{"two levels", grayImage(20, 20, 20, 200, 200, 200), 20},
+		{"dark text on light", grayImage(10, 30, 220, 230, 240, 250), 30},
+		{"uniform", grayImage(128, 128, 128), 0},
+	}
+	for _, tt := range tests {
+		if got := otsuThreshold(tt.img); got != tt.want {
+			t.Errorf("%s: otsuThreshold = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/drive/v2"
+)
+
+// originalPropertyKey tags the working copy of an upload with the ID of the preserved original
+const originalPropertyKey = "trimark_original"
+
+// copyOriginal makes the working copy of an upload when PreserveOriginalEnv is set. The copy
+// sits next to the original in the Upload folder, so a run which fails part way through
+// picks the copy up next time as any other upload.
+func copyOriginal(ctx context.Context, original *drive.File) (*drive.File, error) {
+	c := &drive.File{
+		Title:      original.Title,
+		Parents:    []*drive.ParentReference{{Id: foldersFrom(ctx).Upload}},
+		Properties: []*drive.Property{{Key: originalPropertyKey, Value: original.Id, Visibility: "PRIVATE"}},
+	}
+	copied, err := driveService.Files.Copy(original.Id, c).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to copy %s: %v", original.Title, err)
+	}
+	return copied, nil
+}
+
+// hasWorkingCopy reports whether a preserved original has been copied for processing already
+func hasWorkingCopy(ctx context.Context, originalID string) (bool, error) {
+	q := fmt.Sprintf("properties has { key='%s' and value='%s' and visibility='PRIVATE' } and trashed = false", originalPropertyKey, escapeQuery(originalID))
+	list, err := driveService.Files.List().Q(q).Context(ctx).Do()
+	if err != nil {
+		return false, err
+	}
+	return len(list.Items) > 0, nil
+}
@@ -0,0 +1,39 @@
+package trimark
+
+import (
+	"io"
+)
+
+// progressInterval is how many bytes are read between progress reports
+const progressInterval = 1 << 20
+
+// progressReader reports how much of a download has been read
+type progressReader struct {
+	r          io.Reader
+	total      int64
+	read       int64
+	reported   int64
+	onProgress func(read, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.read-p.reported >= progressInterval || (err == io.EOF && p.read > p.reported) {
+		p.reported = p.read
+		p.onProgress(p.read, p.total)
+	}
+	return n, err
+}
+
+// logDownloadProgress returns an onProgress callback logging the download of a file,
+// without a percentage when the size is unknown
+func logDownloadProgress(fileID string) func(read, total int64) {
+	return func(read, total int64) {
+		if total <= 0 {
+			debugf("downloading fileId=%s read=%d", fileID, read)
+			return
+		}
+		debugf("downloading fileId=%s progress=%d%%", fileID, read*100/total)
+	}
+}
@@ -0,0 +1,56 @@
+package trimark
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProgressReader(t *testing.T) {
+	const size = 3<<20 + 512
+	var calls [][2]int64
+	p := &progressReader{r: bytes.NewReader(make([]byte, size)), total: size, onProgress: func(read, total int64) {
+		calls = append(calls, [2]int64{read, total})
+	}}
+
+	n, err := io.Copy(ioutil.Discard, p)
+	if err != nil || n != size {
+		t.Fatalf("Copy read %d bytes, %v, want %d", n, err, size)
+	}
+	// Once per MB, then the remainder at the end
+	if len(calls) != 4 {
+		t.Fatalf("onProgress called %d times, want 4: %v", len(calls), calls)
+	}
+	for i, c := range calls[:3] {
+		if c[0] < int64(i+1)<<20 || c[1] != size {
+			t.Errorf("Call %d = %v, want at least %d MB of %d", i, c, i+1, size)
+		}
+	}
+	if last := calls[len(calls)-1]; last[0] != size {
+		t.Errorf("Last call read %d, want all %d bytes", last[0], size)
+	}
+}
+
+func TestLogDownloadProgress(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.Debug = true
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	logDownloadProgress("file-1")(1<<20, 2<<20)
+	logDownloadProgress("file-2")(1<<20, -1)
+
+	if !strings.Contains(logs.String(), "downloading fileId=file-1 progress=50%") {
+		t.Errorf("Logs = %q, want the percentage of a known size", logs.String())
+	}
+	if !strings.Contains(logs.String(), "downloading fileId=file-2 read=1048576") || strings.Contains(logs.String(), "file-2 progress") {
+		t.Errorf("Logs = %q, want the bytes read of an unknown size", logs.String())
+	}
+}
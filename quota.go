@@ -0,0 +1,211 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Response headers reporting how much of the API quota is left
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// quotaLowWater is the remaining quota below which new files wait for the quota to reset
+const quotaLowWater = 10
+
+// quotaWindow is how long a quota is assumed to take to reset when a response doesn't say
+const quotaWindow = time.Minute
+
+// APIs whose quotas are tracked separately, so a limited API only holds back the work which calls it
+const (
+	quotaAPIDrive     = "drive"
+	quotaAPISheets    = "sheets"
+	quotaAPIStorage   = "storage"
+	quotaAPIFirestore = "firestore"
+	quotaAPITrace     = "cloudtrace"
+)
+
+// processingAPIs are the APIs every file calls, Wait holds new files back while any of them is low
+var processingAPIs = []string{quotaAPIDrive, quotaAPISheets}
+
+// QuotaMonitor tracks the quota of each API reported by responses, so processing can pause before
+// it runs out
+type QuotaMonitor struct {
+	mu   sync.Mutex
+	apis map[string]*apiQuota
+}
+
+type apiQuota struct {
+	// limit and remaining are -1 until a response has reported them
+	limit     int
+	remaining int
+	resetAt   time.Time
+}
+
+// QuotaStatus is the quota of an API in the JSON body returned by the Quota function
+type QuotaStatus struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"resetAt,omitempty"`
+	Paused    bool      `json:"paused"`
+}
+
+// quotaMonitor is updated by apiWarningTransport from every API response
+var quotaMonitor = newQuotaMonitor()
+
+func newQuotaMonitor() *QuotaMonitor {
+	return &QuotaMonitor{apis: map[string]*apiQuota{}}
+}
+
+// quota is the state of an API's quota, it must be called with m.mu held
+func (m *QuotaMonitor) quota(api string) *apiQuota {
+	q := m.apis[api]
+	if q == nil {
+		q = &apiQuota{limit: -1, remaining: -1}
+		m.apis[api] = q
+	}
+	return q
+}
+
+// low reports whether fewer than quotaLowWater requests remain until the quota resets
+func (q *apiQuota) low() bool {
+	return q.remaining >= 0 && q.remaining < quotaLowWater
+}
+
+// update records the quota reported by a response from api. A rateLimitExceeded error exhausts
+// the quota until the Retry-After delay, or quotaWindow, has passed.
+func (m *QuotaMonitor) update(api string, resp *http.Response) {
+	// Reading a 403's body can block on the network, so it's done before taking the lock
+	exceeded := rateLimitExceeded(resp)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.quota(api)
+
+	if v, err := strconv.Atoi(resp.Header.Get(RateLimitLimitHeader)); err == nil {
+		q.limit = v
+	}
+	if v, err := strconv.Atoi(resp.Header.Get(RateLimitRemainingHeader)); err == nil {
+		q.remaining = v
+		if v < quotaLowWater && !q.resetAt.After(time.Now()) {
+			q.resetAt = time.Now().Add(quotaWindow)
+		}
+	}
+	if v, err := strconv.ParseInt(resp.Header.Get(RateLimitResetHeader), 10, 64); err == nil {
+		q.resetAt = time.Unix(v, 0)
+	}
+
+	if exceeded {
+		q.remaining = 0
+		q.resetAt = time.Now().Add(quotaWindow)
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			q.resetAt = time.Now().Add(time.Duration(seconds) * time.Second)
+		}
+		log.Printf("WARN: %s API quota exceeded, pausing its use until %s", api, q.resetAt.Format(time.RFC3339))
+	}
+}
+
+// rateLimitExceeded reports whether a response is a rate limit error, leaving its body readable
+func rateLimitExceeded(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if resp.StatusCode != http.StatusForbidden || resp.Body == nil {
+		return false
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	var apiErr struct {
+		Error struct {
+			Errors []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &apiErr) != nil {
+		return false
+	}
+	for _, e := range apiErr.Error.Errors {
+		if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait sleeps until the quotas reset when less than quotaLowWater requests of any of the
+// processingAPIs remain
+func (m *QuotaMonitor) Wait(ctx context.Context) error {
+	m.mu.Lock()
+	var delay time.Duration
+	for _, api := range processingAPIs {
+		if q := m.apis[api]; q != nil && q.low() && time.Until(q.resetAt) > delay {
+			delay = time.Until(q.resetAt)
+		}
+	}
+	m.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	log.Printf("API quota is low, waiting %s for it to reset", delay.Round(time.Second))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	m.mu.Lock()
+	// Assume the quotas have refilled, the next responses will correct them
+	for _, api := range processingAPIs {
+		if q := m.apis[api]; q != nil && !q.resetAt.After(time.Now()) {
+			q.remaining = -1
+		}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// status reports the quota last seen of each API
+func (m *QuotaMonitor) status() map[string]QuotaStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := map[string]QuotaStatus{}
+	for api, q := range m.apis {
+		statuses[api] = QuotaStatus{
+			Limit:     q.limit,
+			Remaining: q.remaining,
+			ResetAt:   q.resetAt,
+			Paused:    q.low() && q.resetAt.After(time.Now()),
+		}
+	}
+	return statuses
+}
+
+// Quota reports the quota of each API last seen by this instance
+func Quota(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(quotaMonitor.status()); err != nil {
+		log.Printf("Unable to write quota status: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func quotaResponse(status int, header http.Header, body string) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{StatusCode: status, Header: header, Body: ioutil.NopCloser(strings.NewReader(body))}
+}
+
+func TestQuotaMonitorTracksEachAPI(t *testing.T) {
+	m := newQuotaMonitor()
+	waits := func() bool {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		return m.Wait(ctx) != nil
+	}
+
+	// Exhausting an API files don't call leaves them to carry on
+	m.update(quotaAPITrace, quotaResponse(http.StatusTooManyRequests, http.Header{"Retry-After": {"60"}}, ""))
+	m.update(quotaAPIFirestore, quotaResponse(http.StatusTooManyRequests, nil, ""))
+	if waits() {
+		t.Error("Wait held files back for the Cloud Trace and Firestore quotas")
+	}
+	if s := m.status()[quotaAPITrace]; !s.Paused || s.Remaining != 0 {
+		t.Errorf("Cloud Trace quota = %+v, want it paused", s)
+	}
+	if _, ok := m.status()[quotaAPIDrive]; ok {
+		t.Error("A Drive quota was recorded without a Drive response")
+	}
+
+	// A rate limited 403 is recognised from its body, which is left for the client to read
+	body := `{"error":{"code":403,"errors":[{"reason":"userRateLimitExceeded"}]}}`
+	resp := quotaResponse(http.StatusForbidden, nil, body)
+	m.update(quotaAPISheets, resp)
+	if got, _ := ioutil.ReadAll(resp.Body); string(got) != body {
+		t.Errorf("Body after update = %q, want %q", got, body)
+	}
+	if !waits() {
+		t.Error("Wait didn't hold files back for the exhausted Sheets quota")
+	}
+
+	// An ordinary 403 isn't a quota
+	m = newQuotaMonitor()
+	m.update(quotaAPIDrive, quotaResponse(http.StatusForbidden, nil, `{"error":{"code":403,"errors":[{"reason":"insufficientFilePermissions"}]}}`))
+	if waits() {
+		t.Error("Wait held files back after a permissions error")
+	}
+
+	header := http.Header{}
+	header.Set(RateLimitLimitHeader, "100")
+	header.Set(RateLimitRemainingHeader, "5")
+	m.update(quotaAPIDrive, quotaResponse(http.StatusOK, header, ""))
+	if s := m.status()[quotaAPIDrive]; s.Limit != 100 || s.Remaining != 5 || !s.Paused {
+		t.Errorf("Drive quota = %+v, want 5 of 100 left and paused", s)
+	}
+	if !waits() {
+		t.Error("Wait didn't hold files back for the low Drive quota")
+	}
+}
+
+func TestMainCountsFilesHeldBackByQuota(t *testing.T) {
+	NewTestServiceContext(t, WithPreloadedFiles([]*drive.File{
+		{Id: "upload-1", Title: "one.txt", MimeType: "text/plain"},
+		{Id: "upload-2", Title: "two.txt", MimeType: "text/plain"},
+	}))
+	quotaMonitor.update(quotaAPIDrive, quotaResponse(http.StatusTooManyRequests, http.Header{"Retry-After": {"60"}}, ""))
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	Main(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	if !strings.Contains(logged.String(), "2 were left for the next run") {
+		t.Errorf("Logged %q, want both files counted as not started", logged.String())
+	}
+}
@@ -0,0 +1,52 @@
+package trimark
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		perMinute int
+		// reserves are taken at these offsets from start
+		at   []time.Duration
+		want []time.Duration
+	}{
+		{
+			name:      "burst up to a minute's worth",
+			perMinute: 3,
+			at:        []time.Duration{0, 0, 0, 0},
+			want:      []time.Duration{0, 0, 0, 20 * time.Second},
+		},
+		{
+			name:      "refills over time",
+			perMinute: 2,
+			at:        []time.Duration{0, 0, 0, 15 * time.Second, 30 * time.Second},
+			want:      []time.Duration{0, 0, 30 * time.Second, 15 * time.Second, 0},
+		},
+		{
+			name:      "capped at capacity",
+			perMinute: 1,
+			at:        []time.Duration{time.Hour, time.Hour},
+			want:      []time.Duration{0, time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			b := newTokenBucket(tt.perMinute)
+			b.last = start
+			b.now = func() time.Time { return now }
+
+			for i, offset := range tt.at {
+				now = start.Add(offset)
+				if got := b.reserve(); got != tt.want[i] {
+					t.Errorf("reserve %d at +%s = %s, want %s", i, offset, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package trimark
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// RebuildReport is the JSON body returned by Rebuild
+type RebuildReport struct {
+	DryRun     bool `json:"dryRun"`
+	Appended   int  `json:"appended"`
+	Duplicates int  `json:"duplicates"`
+	OutOfRange int  `json:"outOfRange"`
+	Failed     int  `json:"failed"`
+}
+
+// Rebuild re-OCRs the screenshots in Processed and appends any donation the report doesn't
+// already have, for recovering a cleared or damaged sheet. ?from= and ?to= limit it to an
+// inclusive range of Echoes dates, as YYYY-MM-DD. Rows are deduplicated by their checksum
+// ID, so it is safe to rerun after a partial rebuild. Archived years are no longer in the
+// report, so limit the range to avoid appending them again. It requires the AdminTokenEnv
+// bearer token.
+func Rebuild(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if !requireWarmup(w, r) {
+		return
+	}
+
+	r = r.WithContext(withFolders(r.Context(), snapshotFolders()))
+
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if err := validateRebuildRange(from, to); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report := RebuildReport{DryRun: config.DryRun}
+	err := rebuild(r.Context(), from, to, func(result rebuildResult) {
+		switch {
+		case result.Err != nil:
+			report.Failed++
+		case result.Skipped == rebuildDuplicate:
+			report.Duplicates++
+		case result.Skipped == rebuildOutOfRange:
+			report.OutOfRange++
+		default:
+			report.Appended++
+		}
+	})
+	if err != nil {
+		log.Printf("Unable to rebuild: %v", err)
+		http.Error(w, "Unable to rebuild", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Rebuild appended %d rows, skipped %d duplicates and %d out of range, failed %d", report.Appended, report.Duplicates, report.OutOfRange, report.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Unable to write rebuild report: %v", err)
+	}
+}
+
+// ErrInvalidRebuildRange is returned for a rebuild range which isn't made of YYYY-MM-DD dates
+var ErrInvalidRebuildRange = errors.New("from and to must be dates as YYYY-MM-DD")
+
+// validateRebuildRange checks the inclusive range of Echoes dates a rebuild is limited to,
+// either end may be empty
+func validateRebuildRange(from, to string) error {
+	for _, d := range []string{from, to} {
+		if _, err := time.Parse("2006-01-02", d); d != "" && err != nil {
+			return ErrInvalidRebuildRange
+		}
+	}
+	return nil
+}
+
+// Reasons rebuild skips a screenshot without appending a row
+const (
+	rebuildDuplicate  = "duplicate"
+	rebuildOutOfRange = "out of range"
+)
+
+// rebuildResult is what rebuild did with one screenshot in Processed
+type rebuildResult struct {
+	File     *drive.File
+	Checksum string
+	// RowID is the row appended, empty in a dry run
+	RowID string
+	// Skipped is rebuildDuplicate or rebuildOutOfRange when no row was appended
+	Skipped string
+	Err     error
+}
+
+// rebuild re-OCRs the screenshots in Processed, as Rebuild describes, passing what it did with
+// each to done. It stops early when ctx is cancelled.
+func rebuild(ctx context.Context, from, to string, done func(rebuildResult)) error {
+	records, err := ReadSheetData(ctx)
+	if err != nil {
+		return fmt.Errorf("Unable to read %s: %v", SheetName, err)
+	}
+	recorded := map[string]bool{}
+	for _, record := range records {
+		recorded[record.ID] = true
+	}
+
+	files, err := getFilesFromFolder(foldersFrom(ctx).Processed, false)
+	if err != nil {
+		return fmt.Errorf("Unable to list %s: %v", ProcessedFolderName, err)
+	}
+
+	for _, file := range files {
+		// Processed also holds the OCR documents of each screenshot
+		if file.MimeType == DocumentMimeType || file.MimeType == FolderMimeType {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		result := rebuildFile(ctx, file, from, to, recorded)
+		if result.Err == nil && result.Skipped == "" {
+			recorded[result.Checksum] = true
+		}
+		done(result)
+	}
+	return nil
+}
+
+// rebuildFile appends the donation of a screenshot unless recorded already has its checksum
+func rebuildFile(ctx context.Context, file *drive.File, from, to string, recorded map[string]bool) rebuildResult {
+	result := rebuildResult{File: file}
+	ctx, cancel := context.WithTimeout(ctx, config.ProcessTimeout)
+	defer cancel()
+
+	record, err := reextract(ctx, file)
+	if err != nil {
+		log.Printf("Unable to rebuild from %s: %v", file.Title, err)
+		result.Err = err
+		return result
+	}
+	result.Checksum = record.Checksum
+
+	if (from != "" && record.Date[:10] < from) || (to != "" && record.Date[:10] > to) {
+		result.Skipped = rebuildOutOfRange
+		return result
+	}
+
+	if recorded[record.Checksum] {
+		result.Skipped = rebuildDuplicate
+		return result
+	}
+
+	if !config.DryRun {
+		record.SourceFileID = file.Id
+		record.Link = file.AlternateLink
+		result.RowID, err = appendDataToSheet(ctx, record, rowExtras{Uploader: uploaderOf(file)})
+		if err != nil {
+			log.Printf("Unable to append %s: %v", file.Title, err)
+			result.Err = err
+			return result
+		}
+	}
+	return result
+}
+
+// reextract OCRs a processed screenshot again through a temporary document
+func reextract(ctx context.Context, file *drive.File) (Record, error) {
+	img, err := cropImage(ctx, file)
+	if err != nil {
+		return Record{}, err
+	}
+
+	f := &drive.File{Title: file.Title + "_rebuild", MimeType: DocumentMimeType}
+	f.Parents = []*drive.ParentReference{{Id: foldersFrom(ctx).Processed}}
+	doc, err := driveService.Files.Insert(f).Media(img, googleapi.ContentType(img.MimeType())).Context(ctx).Do()
+	if err != nil {
+		return Record{}, fmt.Errorf("Failed to create document: %v", err)
+	}
+	defer func() {
+		if err := driveService.Files.Delete(doc.Id).Context(ctx).Do(); err != nil {
+			log.Printf("Unable to delete rebuild document %s: %v", doc.Id, err)
+		}
+	}()
+
+	textDoc, err := exportAsPlainText(ctx, doc.Id)
+	if err != nil {
+		return Record{}, fmt.Errorf("Failed to download document: %v", err)
+	}
+	defer textDoc.Close()
+
+	return extractData(textDoc)
+}
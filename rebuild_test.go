@@ -0,0 +1,98 @@
+package trimark
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestRebuildRequiresAdminToken(t *testing.T) {
+	NewTestServiceContext(t, WithConfig(func(c *Config) { c.AdminToken = "secret" }))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", http.StatusUnauthorized},
+		// Authorized, so it gets as far as refusing the range
+		{"admin token", "Bearer secret", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/rebuild?from=June", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		Rebuild(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: Rebuild responded %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestRebuildFromProcessedScreenshots(t *testing.T) {
+	recorded := newRecord("2020-06-01 09:00:00", "Pilot Two", "500")
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) {
+			c.AdminToken = "secret"
+			c.Serial = true
+		}),
+		WithExistingSheetRows([][]interface{}{{recorded.Checksum, "06-01-2020 10:00:00", "2020-06-01 09:00:00", "Pilot Two", "500", ""}}),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "processed-1", Title: "2-recorded.png", MimeType: "image/png", Parents: parentRefs(testProcessedFolderID)},
+			{Id: "processed-2", Title: "3-lost.png", MimeType: "image/png", Parents: parentRefs(testProcessedFolderID)},
+			{Id: "processed-3", Title: "4-july.png", MimeType: "image/png", Parents: parentRefs(testProcessedFolderID)},
+			{Id: "processed-doc", Title: "3-lost.png_results", MimeType: DocumentMimeType, Parents: parentRefs(testProcessedFolderID)},
+		}))
+	for _, id := range []string{"processed-1", "processed-2", "processed-3"} {
+		fakeDrive.SetContent(id, testPNG(t))
+	}
+	fakeDrive.SetOCRText("2-recorded.png_rebuild", donationText("2020-06-01 09:00:00", "Pilot Two", "500"))
+	fakeDrive.SetOCRText("3-lost.png_rebuild", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+	fakeDrive.SetOCRText("4-july.png_rebuild", donationText("2020-07-02 08:00:00", "Pilot Three", "250"))
+
+	r := httptest.NewRequest(http.MethodPost, "/rebuild?from=2020-06-01&to=2020-06-30", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	Rebuild(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Rebuild responded %d: %s", w.Code, w.Body)
+	}
+
+	var report RebuildReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if want := (RebuildReport{Appended: 1, Duplicates: 1, OutOfRange: 1}); report != want {
+		t.Errorf("Rebuild report = %+v, want %+v", report, want)
+	}
+
+	rows := fakeSheets.Values(testSheetID, "Sheet1")
+	if len(rows) != 3 || rows[2][nameColumn] != "Pilot One" {
+		t.Fatalf("Report = %q, want the lost donation of Pilot One appended", rows)
+	}
+	if want := newRecord("2020-06-18 12:34:56", "Pilot One", "1,000").Checksum; rows[2][idColumn] != want {
+		t.Errorf("Appended row ID = %v, want the checksum %s", rows[2][idColumn], want)
+	}
+
+	// The temporary documents are deleted and the screenshots stay where they are
+	if n := len(fakeDrive.FilesIn(testProcessedFolderID)); n != 4 {
+		t.Errorf("Processed holds %d files, want the 3 screenshots and the document it had", n)
+	}
+
+	// A second rebuild finds everything recorded
+	w = httptest.NewRecorder()
+	Rebuild(w, r)
+	report = RebuildReport{}
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Appended != 0 || report.Duplicates != 2 {
+		t.Errorf("Second rebuild report = %+v, want both June donations duplicates", report)
+	}
+}
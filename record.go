@@ -0,0 +1,64 @@
+package trimark
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+)
+
+// Record is a donation as it moves through the pipeline, from extraction to its report row
+type Record struct {
+	// Date, Username and Quantity are as extracted from the screenshot
+	Date     string
+	Username string
+	Quantity string
+
+	// Member is Username normalized, for comparing names
+	Member string
+
+	// QuantityCandidates are the different quantities the patterns matched, when they disagreed
+	QuantityCandidates []string
+
+	// Checksum identifies the donation, it is the ID column of its row
+	Checksum string
+
+	// SourceFileID is the upload the donation was extracted from, Link is what its row links to
+	SourceFileID string
+	Link         string
+}
+
+// newRecord builds the Record of an extracted donation
+func newRecord(date, username, quantity string) Record {
+	return Record{
+		Date:     date,
+		Username: username,
+		Quantity: quantity,
+		Member:   normalizeUsername(username),
+		Checksum: donationChecksum(date, username, quantity),
+	}
+}
+
+// donationChecksum identifies a donation by its extracted fields
+func donationChecksum(date, name, amount string) string {
+	cs := md5.Sum([]byte(date + name + amount))
+	return hex.EncodeToString(cs[:])
+}
+
+// reportAmount is the Quantity as written to the Amount column, scaled by AmountMultiplierEnv
+// and rounded by AmountDecimalsEnv
+func (r Record) reportAmount() (string, error) {
+	amount := r.Quantity
+	var err error
+	if config.AmountMultiplier != 1 {
+		amount, err = scaleAmount(amount, config.AmountMultiplier)
+		if err != nil {
+			return "", err
+		}
+	}
+	if config.AmountDecimals >= 0 {
+		amount, err = formatAmount(amount, config.AmountDecimals)
+		if err != nil {
+			return "", err
+		}
+	}
+	return amount, nil
+}
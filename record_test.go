@@ -0,0 +1,79 @@
+package trimark
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestExtractDataRecord(t *testing.T) {
+	text := donationText("2020-06-18 12:34:56", "Pilot  ONE", "1,000")
+	record, err := extractData(ioutil.NopCloser(strings.NewReader(text)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Record{
+		Date:     "2020-06-18 12:34:56",
+		Username: "Pilot  ONE",
+		Quantity: "1,000",
+		Member:   "pilot one",
+		Checksum: donationChecksum("2020-06-18 12:34:56", "Pilot  ONE", "1,000"),
+	}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("extractData = %+v, want %+v", record, want)
+	}
+}
+
+func TestRecordReportAmount(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	tests := []struct {
+		quantity   string
+		multiplier float64
+		decimals   int
+		want       string
+	}{
+		{"1,000", 1, -1, "1,000"},
+		{"1,000", 2.5, -1, "2500"},
+		{"1,234.567", 1, 2, "1234.57"},
+		{"3", 0.5, 0, "2"},
+	}
+	for _, tt := range tests {
+		config.AmountMultiplier, config.AmountDecimals = tt.multiplier, tt.decimals
+		got, err := newRecord("2020-06-18 12:34:56", "Pilot One", tt.quantity).reportAmount()
+		if err != nil || got != tt.want {
+			t.Errorf("reportAmount(%q) with multiplier %v and %d decimals = %q, %v, want %q", tt.quantity, tt.multiplier, tt.decimals, got, err, tt.want)
+		}
+	}
+}
+
+func TestRecordedRowLinksToDocument(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "screenshot.png", MimeType: "image/png"}}))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetOCRText("screenshot.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || results[0].err != nil || results[0].result.Error != "" {
+		t.Fatalf("processBatch results = %+v, want a recorded row", results)
+	}
+	var doc *drive.File
+	for _, f := range fakeDrive.FilesIn(testProcessedFolderID) {
+		if f.MimeType == DocumentMimeType {
+			doc = f
+		}
+	}
+	if doc == nil {
+		t.Fatal("No OCR document in Processed")
+	}
+
+	rows := fakeSheets.Values(testSheetID, "Sheet1!A2:F")
+	checksum := donationChecksum("2020-06-18 12:34:56", "Pilot One", "1,000")
+	if len(rows) != 1 || rows[0][idColumn] != checksum || rows[0][5] != doc.DefaultOpenWithLink {
+		t.Errorf("Report rows = %v, want %s linking to %s", rows, checksum, doc.DefaultOpenWithLink)
+	}
+}
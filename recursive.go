@@ -0,0 +1,92 @@
+package trimark
+
+import (
+	"context"
+	"log"
+	"path"
+
+	"google.golang.org/api/drive/v2"
+)
+
+// maxUploadDepth is how many levels of subfolders RecursiveUploadEnv descends into below the Upload folder
+const maxUploadDepth = 5
+
+// uploadFolder is the Upload folder, or one of its subfolders, an upload was listed in
+type uploadFolder struct {
+	ID string
+	// Path is the subfolder's path below the Upload folder, empty for the Upload folder itself
+	Path  string
+	Depth int
+}
+
+type uploadFolderKey struct{}
+
+// withUploadFolder tags a file's context with the folder it was listed in
+func withUploadFolder(ctx context.Context, folder uploadFolder) context.Context {
+	return context.WithValue(ctx, uploadFolderKey{}, folder)
+}
+
+// uploadFolderFrom is the folder a file's context was tagged with, the Upload folder by default
+func uploadFolderFrom(ctx context.Context) uploadFolder {
+	if folder, ok := ctx.Value(uploadFolderKey{}).(uploadFolder); ok && folder.ID != "" {
+		return folder
+	}
+	return uploadFolder{ID: foldersFrom(ctx).Upload}
+}
+
+// listUploadPages lists the Upload folder a page at a time, as listFolderPages does. With
+// RecursiveUploadEnv it also lists its subfolders, breadth first and up to maxUploadDepth deep,
+// leaving the subfolders themselves out of the pages. Each folder is listed once, so a folder
+// which also sits inside its own subtree isn't a cycle.
+func listUploadPages(ctx context.Context) <-chan filePage {
+	uploadFolderID := foldersFrom(ctx).Upload
+	if !config.RecursiveUpload {
+		return listFolderPages(ctx, uploadFolderID, false)
+	}
+
+	pages := make(chan filePage, 1)
+	go func() {
+		defer close(pages)
+
+		queue := []uploadFolder{{ID: uploadFolderID}}
+		seen := map[string]bool{uploadFolderID: true}
+		for len(queue) > 0 {
+			folder := queue[0]
+			queue = queue[1:]
+
+			for page := range listFolderPages(ctx, folder.ID, false) {
+				page.folder = folder
+				if page.err == nil {
+					var files []*drive.File
+					for _, f := range page.files {
+						if f.MimeType != FolderMimeType {
+							files = append(files, f)
+							continue
+						}
+						sub := uploadFolder{ID: f.Id, Path: path.Join(folder.Path, f.Title), Depth: folder.Depth + 1}
+						switch {
+						case seen[f.Id]:
+							debugf("Skipping folder %s, it has already been listed", sub.Path)
+						case sub.Depth > maxUploadDepth:
+							log.Printf("WARN: skipping folder %s, it is more than %d folders below %s", sub.Path, maxUploadDepth, UploadFolderName)
+						default:
+							seen[f.Id] = true
+							queue = append(queue, sub)
+						}
+					}
+					page.files = files
+				}
+
+				select {
+				case pages <- page:
+				case <-ctx.Done():
+					return
+				}
+				if page.err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return pages
+}
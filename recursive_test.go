@@ -0,0 +1,100 @@
+package trimark
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestRecursiveUpload(t *testing.T) {
+	folder := func(id, parent string) *drive.File {
+		return &drive.File{Id: id, Title: id, MimeType: FolderMimeType, Parents: parentRefs(parent)}
+	}
+	upload := func(id, parent string) *drive.File {
+		return &drive.File{Id: id, Title: id + ".txt", MimeType: "text/plain", Parents: parentRefs(parent)}
+	}
+	files := []*drive.File{
+		upload("root", testUploadFolderID),
+		folder("event-a", testUploadFolderID),
+		upload("in-event", "event-a"),
+		folder("day-1", "event-a"),
+		upload("in-day", "day-1"),
+	}
+	// A chain of folders deeper than maxUploadDepth, with an upload at the bottom
+	parent := testUploadFolderID
+	for depth := 1; depth <= maxUploadDepth+1; depth++ {
+		id := fmt.Sprintf("level-%d", depth)
+		files = append(files, folder(id, parent))
+		parent = id
+	}
+	files = append(files, upload("too-deep", parent))
+
+	tests := []struct {
+		name      string
+		recursive bool
+		// want are the subfolders of the uploads recorded, by name
+		want map[string]string
+	}{
+		{"off", false, map[string]string{"Pilot root": ""}},
+		{"on", true, map[string]string{"Pilot root": "", "Pilot in-event": "event-a", "Pilot in-day": "event-a/day-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+				WithConfig(func(c *Config) {
+					c.RecursiveUpload = tt.recursive
+					c.SubfolderColumn = true
+				}),
+				WithPreloadedFiles(files))
+			for _, f := range files {
+				if f.MimeType != FolderMimeType {
+					id := strings.TrimSuffix(f.Title, ".txt")
+					fakeDrive.SetContent(f.Id, []byte(donationText("2020-06-18 12:34:56", "Pilot "+id, "1,000")))
+				}
+			}
+			// The Upload folder also sits inside its own subtree, which isn't listed again
+			if _, err := driveService.Files.Update(testUploadFolderID, &drive.File{}).AddParents("day-1").Do(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, r := range runBatch(t, sc) {
+				if r.err != nil {
+					t.Errorf("%s: %v", r.result.FileName, r.err)
+				}
+			}
+
+			rows := fakeSheets.Values(testSheetID, "Sheet1")
+			column := -1
+			for i, header := range rows[0] {
+				if header == "Subfolder" {
+					column = i
+				}
+			}
+			if len(rows)-1 != len(tt.want) {
+				t.Errorf("Report rows = %v, want each of %d uploads once", rows[1:], len(tt.want))
+			}
+			got := map[string]string{}
+			for _, row := range rows[1:] {
+				subfolder := ""
+				if column < len(row) && row[column] != nil {
+					subfolder = fmt.Sprint(row[column])
+				}
+				got[fmt.Sprint(row[nameColumn])] = subfolder
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Recorded %v, want %v", got, tt.want)
+			}
+			for name, subfolder := range tt.want {
+				id := strings.TrimPrefix(name, "Pilot ")
+				if f := fakeDrive.File(id); !inFolder(f, testProcessedFolderID) {
+					t.Errorf("%s in %s was not moved to Processed", id, subfolder)
+				}
+			}
+			if f := fakeDrive.File("too-deep"); !inFolder(f, parent) {
+				t.Error("An upload deeper than maxUploadDepth was moved")
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package trimark
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dataRange is every data row of the report, below the header. It's set by setReportRanges.
+var dataRange = "Sheet1!A2:H"
+
+// echoesDateLayouts are the formats an Echoes date may be stored as text in, when Sheets didn't
+// recognise it as a date
+var echoesDateLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "1/2/2006 15:04:05", "1/2/2006 15:04"}
+
+// importDateLayout is the format appendDataToSheet writes the Import Date in
+const importDateLayout = "01-02-2006 15:04:05"
+
+// sheetsEpoch is day zero of the serial numbers Sheets stores dates as
+var sheetsEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// DonationRecord is a row of the report sheet
+type DonationRecord struct {
+	ID         string    `json:"id"`
+	ImportDate string    `json:"importDate"`
+	EchoesDate time.Time `json:"echoesDate"`
+	Name       string    `json:"name"`
+	Amount     float64   `json:"amount"`
+	Link       string    `json:"link"`
+
+	// ImageFileSizeBytes and ImageDimensionsStr are only read with ImageInfoColumnsEnv, and are
+	// empty for rows written before it was set
+	ImageFileSizeBytes int64  `json:"imageFileSizeBytes,omitempty"`
+	ImageDimensionsStr string `json:"imageDimensions,omitempty"`
+}
+
+// ReadSheetData reads every donation recorded in the report sheet
+func ReadSheetData(ctx context.Context) ([]DonationRecord, error) {
+	Initialize()
+
+	spreadsheetIDs, err := reportSpreadsheetIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []DonationRecord{}
+	for _, spreadsheetID := range spreadsheetIDs {
+		rows, err := readStoredValues(ctx, spreadsheetID, dataRange)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if isSummaryRow(row) {
+				continue
+			}
+			records = append(records, parseRecord(row))
+		}
+	}
+	return records, nil
+}
+
+// readStoredValues reads a range as stored rather than as displayed, numbers as float64 and dates
+// as serial numbers, so parseRecord reads them whatever AmountFormatEnv and DateFormatEnv show
+func readStoredValues(ctx context.Context, spreadsheetID string, a1 string) ([][]interface{}, error) {
+	vr, err := sheetService.Spreadsheets.Values.Get(spreadsheetID, a1).ValueRenderOption("UNFORMATTED_VALUE").DateTimeRenderOption("SERIAL_NUMBER").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return vr.Values, nil
+}
+
+// parseRecord converts a row read by readStoredValues, leaving fields it can't parse empty
+func parseRecord(row []interface{}) DonationRecord {
+	cell := func(i int) string {
+		if i >= len(row) {
+			return ""
+		}
+		if f, ok := row[i].(float64); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return fmt.Sprint(row[i])
+	}
+	serial := func(i int) (float64, bool) {
+		if i >= len(row) {
+			return 0, false
+		}
+		f, ok := row[i].(float64)
+		return f, ok
+	}
+
+	record := DonationRecord{
+		ID:         cell(0),
+		ImportDate: cell(1),
+		Name:       cell(3),
+		Link:       cell(5),
+	}
+	if s, ok := serial(1); ok {
+		record.ImportDate = serialTime(s).Format(importDateLayout)
+	}
+
+	if s, ok := serial(2); ok {
+		record.EchoesDate = serialTime(s)
+	} else {
+		for _, layout := range echoesDateLayouts {
+			if t, err := time.Parse(layout, cell(2)); err == nil {
+				record.EchoesDate = t
+				break
+			}
+		}
+	}
+
+	if amount, ok := serial(4); ok {
+		record.Amount = amount
+	} else if amount, err := parseAmountText(cell(4)); err == nil {
+		record.Amount = amount
+	}
+	if config.ImageInfoColumns {
+		if size, err := strconv.ParseInt(cell(6), 10, 64); err == nil {
+			record.ImageFileSizeBytes = size
+		}
+		record.ImageDimensionsStr = cell(7)
+	}
+	return record
+}
+
+// serialTime converts a Sheets date serial number, the days since sheetsEpoch, to the nearest second
+func serialTime(serial float64) time.Time {
+	seconds := math.Round(serial * 24 * 60 * 60)
+	return sheetsEpoch.Add(time.Duration(seconds) * time.Second)
+}
+
+// parseAmountText parses an amount Sheets stored as text, such as "1,234.50 ISK" or "(1,234)"
+func parseAmountText(s string) (float64, error) {
+	v := strings.TrimSpace(s)
+	if strings.HasSuffix(strings.ToUpper(v), strings.TrimSpace(iskSuffix)) {
+		v = strings.TrimSpace(v[:len(v)-len(strings.TrimSpace(iskSuffix))])
+	}
+	negative, v := splitQuantitySign(v)
+	amount, err := strconv.ParseFloat(strings.Replace(v, ",", "", -1), 64)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// monthlyReportTTL is how long a monthly report is served from cache
+const monthlyReportTTL = 5 * time.Minute
+
+// topContributorCount is the number of contributors listed in a MonthlyReport
+const topContributorCount = 10
+
+// ContributorStat is the donation total of a single member
+type ContributorStat struct {
+	Name         string `json:"name"`
+	TotalISK     int64  `json:"totalIsk"`
+	Transactions int    `json:"transactions"`
+}
+
+// MonthlyReport summarises the donations of a calendar month
+type MonthlyReport struct {
+	TotalTransactions  int               `json:"totalTransactions"`
+	TotalISK           int64             `json:"totalIsk"`
+	UniqueContributors int               `json:"uniqueContributors"`
+	DailyBreakdown     map[string]int64  `json:"dailyBreakdown"`
+	TopContributors    []ContributorStat `json:"topContributors"`
+}
+
+type cachedMonthlyReport struct {
+	report  MonthlyReport
+	expires time.Time
+}
+
+var monthlyReportCache = map[string]cachedMonthlyReport{}
+var monthlyReportCacheMu sync.Mutex
+
+// buildMonthlyReport aggregates the records dated in the given month. Amounts are rounded to
+// whole ISK as they're read, so the totals don't pick up float error.
+func buildMonthlyReport(records []DonationRecord, year int, month time.Month) MonthlyReport {
+	report := MonthlyReport{
+		DailyBreakdown:  map[string]int64{},
+		TopContributors: []ContributorStat{},
+	}
+
+	contributors := map[string]*ContributorStat{}
+	for _, record := range records {
+		if record.EchoesDate.Year() != year || record.EchoesDate.Month() != month {
+			continue
+		}
+
+		amount := int64(math.Round(record.Amount))
+		report.TotalTransactions++
+		report.TotalISK += amount
+		report.DailyBreakdown[record.EchoesDate.Format("2006-01-02")] += amount
+
+		stat, ok := contributors[record.Name]
+		if !ok {
+			stat = &ContributorStat{Name: record.Name}
+			contributors[record.Name] = stat
+		}
+		stat.TotalISK += amount
+		stat.Transactions++
+	}
+
+	report.UniqueContributors = len(contributors)
+	for _, stat := range contributors {
+		report.TopContributors = append(report.TopContributors, *stat)
+	}
+	sort.Slice(report.TopContributors, func(i, j int) bool {
+		if report.TopContributors[i].TotalISK != report.TopContributors[j].TotalISK {
+			return report.TopContributors[i].TotalISK > report.TopContributors[j].TotalISK
+		}
+		return report.TopContributors[i].Name < report.TopContributors[j].Name
+	})
+	if len(report.TopContributors) > topContributorCount {
+		report.TopContributors = report.TopContributors[:topContributorCount]
+	}
+	return report
+}
+
+// HandleMonthlyReport returns a JSON summary of the donations in ?year=2024&month=1,
+// defaulting to the current month
+func HandleMonthlyReport(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+	if !requireWarmup(w, r) {
+		return
+	}
+
+	r = r.WithContext(withFolders(r.Context(), snapshotFolders()))
+
+	now := time.Now()
+	year, month := now.Year(), int(now.Month())
+
+	var err error
+	if v := r.URL.Query().Get("year"); v != "" {
+		year, err = strconv.Atoi(v)
+		if err != nil || year < 2020 || year > 2100 {
+			http.Error(w, "year must be between 2020 and 2100", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("month"); v != "" {
+		month, err = strconv.Atoi(v)
+		if err != nil || month < 1 || month > 12 {
+			http.Error(w, "month must be between 1 and 12", http.StatusBadRequest)
+			return
+		}
+	}
+
+	key := fmt.Sprintf("%04d-%02d", year, month)
+	monthlyReportCacheMu.Lock()
+	cached, ok := monthlyReportCache[key]
+	monthlyReportCacheMu.Unlock()
+
+	report := cached.report
+	if !ok || now.After(cached.expires) {
+		records, err := ReadSheetData(r.Context())
+		if err != nil {
+			log.Printf("Unable to read sheet data: %v", err)
+			http.Error(w, "Unable to read sheet data", http.StatusInternalServerError)
+			return
+		}
+		report = buildMonthlyReport(records, year, time.Month(month))
+
+		monthlyReportCacheMu.Lock()
+		monthlyReportCache[key] = cachedMonthlyReport{report: report, expires: now.Add(monthlyReportTTL)}
+		monthlyReportCacheMu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Unable to write monthly report: %v", err)
+	}
+}
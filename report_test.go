@@ -0,0 +1,149 @@
+package trimark
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRecord(t *testing.T) {
+	echoes := time.Date(2020, 6, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		row  []interface{}
+		want DonationRecord
+	}{
+		{
+			name: "unformatted values",
+			row:  []interface{}{"id1", 44000.5, 44000.5, "Pilot", 1234.5, "https://drive"},
+			want: DonationRecord{ID: "id1", ImportDate: "06-18-2020 12:00:00", EchoesDate: echoes, Name: "Pilot", Amount: 1234.5, Link: "https://drive"},
+		},
+		{
+			name: "text values",
+			row:  []interface{}{"id2", "06-18-2020 12:00:00", "2020-06-18 12:00", "Pilot", "1,234.50 ISK"},
+			want: DonationRecord{ID: "id2", ImportDate: "06-18-2020 12:00:00", EchoesDate: echoes, Name: "Pilot", Amount: 1234.5},
+		},
+		{
+			name: "accounting negative",
+			row:  []interface{}{"id3", "", "6/18/2020 12:00", "Pilot", "(1,000)"},
+			want: DonationRecord{ID: "id3", EchoesDate: echoes, Name: "Pilot", Amount: -1000},
+		},
+		{
+			name: "minus sign",
+			row:  []interface{}{"id4", "", "", "Pilot", "−250 ISK"},
+			want: DonationRecord{ID: "id4", Name: "Pilot", Amount: -250},
+		},
+		{
+			name: "unparseable fields left empty",
+			row:  []interface{}{"id5", "", "yesterday", "Pilot", "lots"},
+			want: DonationRecord{ID: "id5", Name: "Pilot"},
+		},
+		{
+			name: "short row",
+			row:  []interface{}{"id6"},
+			want: DonationRecord{ID: "id6"},
+		},
+	}
+	for _, tt := range tests {
+		got := parseRecord(tt.row)
+		if !got.EchoesDate.Equal(tt.want.EchoesDate) {
+			t.Errorf("%s: EchoesDate = %v, want %v", tt.name, got.EchoesDate, tt.want.EchoesDate)
+		}
+		got.EchoesDate = tt.want.EchoesDate
+		if got != tt.want {
+			t.Errorf("%s: parseRecord = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildMonthlyReportRoundsAmounts(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 6, d, 12, 0, 0, 0, time.UTC) }
+	records := []DonationRecord{
+		{Name: "Pilot", EchoesDate: day(1), Amount: 0.1},
+		{Name: "Pilot", EchoesDate: day(1), Amount: 0.2},
+		{Name: "Pilot", EchoesDate: day(1), Amount: 1000.6},
+		{Name: "Other", EchoesDate: day(2), Amount: 250},
+		{Name: "Other", EchoesDate: time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC), Amount: 99},
+	}
+
+	report := buildMonthlyReport(records, 2020, time.June)
+	if report.TotalTransactions != 4 || report.TotalISK != 1251 {
+		t.Errorf("TotalTransactions, TotalISK = %d, %d, want 4, 1251", report.TotalTransactions, report.TotalISK)
+	}
+	if got := report.DailyBreakdown["2020-06-01"]; got != 1001 {
+		t.Errorf("DailyBreakdown[2020-06-01] = %d, want 1001", got)
+	}
+	if len(report.TopContributors) != 2 || report.TopContributors[0].Name != "Pilot" || report.TopContributors[0].TotalISK != 1001 {
+		t.Errorf("TopContributors = %+v, want Pilot first with 1001", report.TopContributors)
+	}
+}
+
+func TestHandleMonthlyReport(t *testing.T) {
+	NewTestServiceContext(t, WithExistingSheetRows([][]interface{}{
+		{"id1", "06-18-2020 12:00:00", "2020-06-01 09:00:00", "Pilot One", "1,000", ""},
+		{"id2", "06-18-2020 12:00:00", "2020-06-01 10:00:00", "Pilot Two", "500", ""},
+		{"id3", "06-18-2020 12:00:00", "2020-06-15 11:00:00", "Pilot One", "2,000", ""},
+		{"id4", "07-01-2020 12:00:00", "2020-07-01 11:00:00", "Pilot Three", "9,999", ""},
+	}))
+
+	get := func(query string) (int, MonthlyReport) {
+		w := httptest.NewRecorder()
+		HandleMonthlyReport(w, httptest.NewRequest(http.MethodGet, "/report/monthly?"+query, nil))
+		var report MonthlyReport
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatalf("Decoding %q: %v", w.Body.String(), err)
+			}
+		}
+		return w.Code, report
+	}
+
+	code, report := get("year=2020&month=6")
+	if code != http.StatusOK {
+		t.Fatalf("June report responded %d", code)
+	}
+	if report.TotalTransactions != 3 || report.TotalISK != 3500 || report.UniqueContributors != 2 {
+		t.Errorf("June report = %+v, want 3 transactions of 3500 ISK from 2 contributors", report)
+	}
+	if want := map[string]int64{"2020-06-01": 1500, "2020-06-15": 2000}; !reflect.DeepEqual(report.DailyBreakdown, want) {
+		t.Errorf("DailyBreakdown = %v, want %v", report.DailyBreakdown, want)
+	}
+	if len(report.TopContributors) != 2 || report.TopContributors[0] != (ContributorStat{Name: "Pilot One", TotalISK: 3000, Transactions: 2}) {
+		t.Errorf("TopContributors = %+v, want Pilot One first", report.TopContributors)
+	}
+
+	// A month without donations is empty rather than missing
+	code, report = get("year=2021&month=1")
+	if code != http.StatusOK || report.TotalTransactions != 0 || report.TotalISK != 0 || report.UniqueContributors != 0 {
+		t.Errorf("January report = %d %+v, want 200 with zero values", code, report)
+	}
+
+	for _, query := range []string{"year=2019&month=6", "year=2020&month=13", "year=2020&month=June"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s responded %d, want 400", query, code)
+		}
+	}
+}
+
+func TestHandleMonthlyReportIsCached(t *testing.T) {
+	_, _, fakeSheets := NewTestServiceContext(t, WithExistingSheetRows([][]interface{}{
+		{"id1", "06-18-2020 12:00:00", "2020-06-01 09:00:00", "Pilot One", "1,000", ""},
+	}))
+
+	HandleMonthlyReport(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/report/monthly?year=2020&month=6", nil))
+	fakeSheets.resetRequests()
+	w := httptest.NewRecorder()
+	HandleMonthlyReport(w, httptest.NewRequest(http.MethodGet, "/report/monthly?year=2020&month=6", nil))
+
+	if requests := fakeSheets.Requests(); len(requests) != 0 {
+		t.Errorf("The second report sent %q, want it served from the cache", requests)
+	}
+	var report MonthlyReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || report.TotalISK != 1000 {
+		t.Errorf("Cached report = %q, %v, want 1000 ISK", w.Body.String(), err)
+	}
+}
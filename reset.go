@@ -0,0 +1,172 @@
+package trimark
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// ResetConfirmation must be passed as ?confirm= for HandleReset to do anything
+const ResetConfirmation = "RESET_ALL_DATA"
+
+// ResetReport is the JSON body returned by HandleReset
+type ResetReport struct {
+	FilesDeleted int `json:"filesDeleted"`
+	RowsCleared  int `json:"rowsCleared"`
+
+	// ChecksumsCleared is the number of checksums forgotten by the DedupStoreEnv store
+	ChecksumsCleared int `json:"checksumsCleared"`
+}
+
+// ResetAllowed reports whether AllowResetEnv is set, HandleReset must not be registered otherwise
+func ResetAllowed() bool {
+	Initialize()
+	return config.AllowReset
+}
+
+// authorizeAdmin reports whether a request carries the AdminTokenEnv bearer token, responding
+// 401 when it doesn't. Each admin handler calls it itself, as they may be deployed as functions
+// of their own.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !adminAuthorized(r.Header.Get("Authorization")) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// adminAuthorized reports whether an Authorization value, from a header or gRPC metadata,
+// is the AdminTokenEnv bearer token. Nothing is authorized when the token is unset.
+func adminAuthorized(authorization string) bool {
+	token := config.AdminToken
+	given := strings.TrimPrefix(authorization, "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// HandleReset permanently deletes everything in the Processed and Failed folders, clears the
+// report below its header and forgets the checksums in the DedupStoreEnv store. It is only for
+// test environments, see ResetAllowed, and requires the AdminTokenEnv bearer token.
+func HandleReset(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if !requireWarmup(w, r) {
+		return
+	}
+
+	r = r.WithContext(withFolders(r.Context(), snapshotFolders()))
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ResetAllowed() {
+		http.Error(w, "Reset is disabled", http.StatusForbidden)
+		return
+	}
+	if r.URL.Query().Get("confirm") != ResetConfirmation {
+		http.Error(w, fmt.Sprintf("confirm=%s is required", ResetConfirmation), http.StatusBadRequest)
+		return
+	}
+
+	var report ResetReport
+	folders := foldersFrom(r.Context())
+	for _, folderID := range []string{folders.Processed, folders.Failed} {
+		deleted, err := deleteFolderContents(r.Context(), folderID)
+		report.FilesDeleted += deleted
+		if err != nil {
+			log.Printf("Reset stopped after deleting %d files: %v", report.FilesDeleted, err)
+			http.Error(w, "Unable to delete files", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	rows, err := clearSheetData(r.Context())
+	if err != nil {
+		log.Printf("Reset deleted %d files but couldn't clear the sheet: %v", report.FilesDeleted, err)
+		http.Error(w, "Unable to clear sheet", http.StatusInternalServerError)
+		return
+	}
+	report.RowsCleared = rows
+
+	// Donations recorded before the reset would otherwise be skipped as duplicates
+	if dedupStore != nil {
+		cleared, err := dedupStore.Clear(r.Context())
+		if err != nil {
+			log.Printf("Reset cleared the sheet but couldn't clear %s after %d checksums: %v", config.DedupStore, cleared, err)
+			http.Error(w, "Unable to clear checksums", http.StatusInternalServerError)
+			return
+		}
+		report.ChecksumsCleared = cleared
+	}
+
+	monthlyReportCacheMu.Lock()
+	monthlyReportCache = map[string]cachedMonthlyReport{}
+	monthlyReportCacheMu.Unlock()
+
+	log.Printf("Reset deleted %d files and cleared %d rows and %d checksums", report.FilesDeleted, report.RowsCleared, report.ChecksumsCleared)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Unable to write reset report: %v", err)
+	}
+}
+
+// deleteFolderContents permanently deletes, bypassing the trash, every file in a folder
+func deleteFolderContents(ctx context.Context, folderID string) (int, error) {
+	files, err := getFilesFromFolder(folderID, false)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, f := range files {
+		err = driveService.Files.Delete(f.Id).Context(ctx).Do()
+		if err != nil {
+			return deleted, fmt.Errorf("Unable to delete %s: %v", f.Title, err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// clearSheetData empties every row of the report after the header, in every month's spreadsheet
+// with MonthlySheetsEnv, returning how many had data
+func clearSheetData(ctx context.Context) (int, error) {
+	spreadsheetIDs, err := reportSpreadsheetIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rows := 0
+	for _, spreadsheetID := range spreadsheetIDs {
+		vr, err := sheetService.Spreadsheets.Values.Get(spreadsheetID, dataRange).Context(ctx).Do()
+		if err != nil {
+			return rows, err
+		}
+
+		_, err = sheetService.Spreadsheets.Values.Clear(spreadsheetID, fmt.Sprintf("Sheet1!A%d:Z", headerRowIndex()+2), &sheets.ClearValuesRequest{}).Context(ctx).Do()
+		if err != nil {
+			return rows, err
+		}
+
+		for _, row := range vr.Values {
+			if !isSummaryRow(row) {
+				rows++
+			}
+		}
+
+		// A bottom summary row was among the cleared rows
+		if err := addSummaryRow(ctx, spreadsheetID, "Sheet1"); err != nil {
+			log.Printf("WARN: unable to restore the summary row of %s: %v", spreadsheetID, err)
+		}
+	}
+	return rows, nil
+}
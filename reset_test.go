@@ -0,0 +1,62 @@
+package trimark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestHandleResetRequiresAdminToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", http.StatusUnauthorized},
+		{"admin token", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, fakeDrive, fakeSheets := NewTestServiceContext(t,
+				WithConfig(func(c *Config) {
+					c.AllowReset = true
+					c.AdminToken = "secret"
+				}),
+				WithPreloadedFiles([]*drive.File{{Id: "processed-1", Title: "done.png", Parents: parentRefs(testProcessedFolderID)}}),
+				WithExistingSheetRows([][]interface{}{{"id1", "06-18-2020 12:00:00", "2020-06-18 12:00:00", "Pilot One", "1,000", ""}}))
+
+			r := httptest.NewRequest(http.MethodPost, "/admin/reset?confirm="+ResetConfirmation, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			HandleReset(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("HandleReset responded %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusOK {
+				if fakeDrive.File("processed-1") != nil {
+					t.Error("The authorized reset left the processed file")
+				}
+				return
+			}
+
+			// Nothing is touched for a request which isn't authorized
+			if fakeDrive.File("processed-1") == nil {
+				t.Error("The processed file was deleted")
+			}
+			for _, request := range append(fakeDrive.Requests(), fakeSheets.Requests()...) {
+				if !strings.HasPrefix(request, http.MethodGet+" ") {
+					t.Errorf("An unauthorized reset sent %s", request)
+				}
+			}
+			if rows := fakeSheets.Values(testSheetID, "Sheet1"); len(rows) != 2 {
+				t.Errorf("Report has %d rows, want the header and the donation", len(rows))
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+var lastFolderValidation time.Time
+
+var folderValidationMu sync.Mutex
+
+// folderIDsMu guards the folder IDs, SheetID and LastSetupReport. Setup holds it to write them,
+// handlers only while they take a snapshotFolders.
+var folderIDsMu sync.RWMutex
+
+// folderSnapshot is the folder and sheet IDs as a handler found them when it started
+type folderSnapshot struct {
+	Upload, Processed, Failed, Report, OCRArchive, Backup string
+	Sheet                                                 string
+}
+
+type foldersKey struct{}
+
+// snapshotFolders copies the folder and sheet IDs. Handlers take one when they start and pass it
+// down with withFolders, so folders set up again meanwhile don't change under their work. It must
+// not be called with folderIDsMu held.
+func snapshotFolders() folderSnapshot {
+	folderIDsMu.RLock()
+	defer folderIDsMu.RUnlock()
+	return folderSnapshot{
+		Upload:     UploadFolderID,
+		Processed:  ProcessedFolderID,
+		Failed:     FailedFolderID,
+		Report:     ReportFolderID,
+		OCRArchive: OCRArchiveFolderID,
+		Backup:     BackupFolderID,
+		Sheet:      SheetID,
+	}
+}
+
+// withFolders makes the folders a handler started with the ones its work uses
+func withFolders(ctx context.Context, folders folderSnapshot) context.Context {
+	return context.WithValue(ctx, foldersKey{}, folders)
+}
+
+// foldersFrom is the snapshot a handler's context was given, or the current folders outside one
+func foldersFrom(ctx context.Context) folderSnapshot {
+	if folders, ok := ctx.Value(foldersKey{}).(folderSnapshot); ok {
+		return folders
+	}
+	return snapshotFolders()
+}
+
+// revalidateFolderIDs checks the folders found at startup still exist, running setupFolders
+// again to recreate any which were deleted. Warm instances only check once per
+// FolderRevalidateIntervalEnv.
+func revalidateFolderIDs(ctx context.Context) error {
+	folderValidationMu.Lock()
+	defer folderValidationMu.Unlock()
+
+	if time.Since(lastFolderValidation) < config.FolderRevalidateInterval {
+		return nil
+	}
+
+	current := snapshotFolders()
+	folders := map[string]string{
+		UploadFolderName:    current.Upload,
+		ProcessedFolderName: current.Processed,
+		FailedFolderName:    current.Failed,
+		ReportFolderName:    current.Report,
+	}
+	if config.QuarantineOCRDocs {
+		folders[OCRArchiveFolderName] = current.OCRArchive
+	}
+	if config.CSVBackup {
+		folders[BackupFolderName] = current.Backup
+	}
+
+	for name, id := range folders {
+		_, err := driveService.Files.Get(id).Fields("id").Context(ctx).Do()
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+			log.Printf("WARN: folder %s (%s) no longer exists, setting up folders again", name, id)
+			folderIDsMu.Lock()
+			err := setupFoldersAgain()
+			folderIDsMu.Unlock()
+			if err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to check folder %s: %v", name, err)
+		}
+	}
+
+	lastFolderValidation = time.Now()
+	return nil
+}
+
+// setupFoldersAgain runs setupFolders after a folder was deleted. A recreated Report folder is
+// empty, so the report sheet is set up again in it, and the sheets and backups cached from the
+// old folders are forgotten. It must be called with folderIDsMu held.
+func setupFoldersAgain() error {
+	oldReportFolderID, oldBackupFolderID := ReportFolderID, BackupFolderID
+
+	report, err := setupFolders(config.FolderID)
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: folder setup found %d and created %d folders: %v", report.Found, report.Created, report.FolderIDs)
+
+	if ReportFolderID != oldReportFolderID {
+		monthlySheetIDsMu.Lock()
+		monthlySheetIDs = map[string]string{}
+		monthlySheetIDsMu.Unlock()
+
+		if err := setupSheet(ReportFolderID); err != nil {
+			return fmt.Errorf("Unable to set up %s again: %v", SheetName, err)
+		}
+		log.Printf("INFO: %s set up again in the new %s folder", SheetName, ReportFolderName)
+	}
+	if BackupFolderID != oldBackupFolderID {
+		backupMu.Lock()
+		backupFileIDs = map[string]string{}
+		backupMu.Unlock()
+	}
+	return nil
+}
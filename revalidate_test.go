@@ -0,0 +1,86 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestRevalidateRecreatesDeletedFolder(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t)
+	if err := driveService.Files.Delete(testProcessedFolderID).Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Within the interval the deleted folder isn't noticed
+	if err := revalidateFolderIDs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ProcessedFolderID != testProcessedFolderID {
+		t.Fatalf("Folders were checked again within %s", config.FolderRevalidateInterval)
+	}
+
+	lastFolderValidation = time.Time{}
+	if err := revalidateFolderIDs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ProcessedFolderID == testProcessedFolderID {
+		t.Fatal("ProcessedFolderID still names the deleted folder")
+	}
+	folder := fakeDrive.File(ProcessedFolderID)
+	if folder == nil || folder.Title != ProcessedFolderName || folder.MimeType != FolderMimeType || !inFolder(folder, testMasterFolderID) {
+		t.Errorf("Recreated folder = %+v, want a %s folder in the master folder", folder, ProcessedFolderName)
+	}
+	if UploadFolderID != testUploadFolderID || FailedFolderID != testFailedFolderID || ReportFolderID != testReportFolderID {
+		t.Errorf("Folders which still exist changed: %s, %s, %s", UploadFolderID, FailedFolderID, ReportFolderID)
+	}
+}
+
+// Run with -race: one run sets the folders up again while the others are still processing
+func TestConcurrentMainRuns(t *testing.T) {
+	var uploads []*drive.File
+	for i := 0; i < 12; i++ {
+		uploads = append(uploads, &drive.File{Id: fmt.Sprintf("upload-%d", i), Title: fmt.Sprintf("%d.txt", i), MimeType: "text/plain"})
+	}
+	_, fakeDrive, _ := NewTestServiceContext(t, WithPreloadedFiles(uploads))
+	for i, f := range uploads {
+		fakeDrive.SetContent(f.Id, []byte(donationText("2020-06-18 12:34:56", fmt.Sprintf("Pilot %d", i), "1,000")))
+	}
+	fakeDrive.SetLatency(time.Millisecond)
+	if err := driveService.Files.Delete(testFailedFolderID).Do(); err != nil {
+		t.Fatal(err)
+	}
+	lastFolderValidation = time.Time{}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 4)
+	for i := range codes {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			Main(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			codes[i] = w.Code
+		}()
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Run %d responded %d", i, code)
+		}
+	}
+	if FailedFolderID == testFailedFolderID {
+		t.Error("FailedFolderID still names the deleted folder")
+	}
+	if left := fakeDrive.FilesIn(testUploadFolderID); len(left) != 0 {
+		t.Errorf("%d uploads left in the Upload folder", len(left))
+	}
+}
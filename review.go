@@ -0,0 +1,103 @@
+package trimark
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Heuristics which flag an extraction for review, named as they are listed in NeedsReviewEnv
+const (
+	ReviewLowOCRQuality    = "ocr"
+	ReviewAmountOutOfRange = "amount"
+	ReviewPartialFields    = "partial"
+)
+
+// ReviewConfig is the set of heuristics which flag a row in the Needs Review column
+type ReviewConfig struct {
+	LowOCRQuality    bool
+	AmountOutOfRange bool
+	PartialFields    bool
+
+	// QuantityDisagreement is set by QuantityAgreementEnv rather than NeedsReviewEnv
+	QuantityDisagreement bool
+
+	// SecondaryOCR is set by SecondaryOCRURLEnv, flagging rows the second engine read differently
+	SecondaryOCR bool
+
+	// MaxAmount is 0 when only zero amounts are out of range
+	MaxAmount int64
+}
+
+// Enabled reports whether the Needs Review column is written
+func (c ReviewConfig) Enabled() bool {
+	return c.LowOCRQuality || c.AmountOutOfRange || c.PartialFields || c.QuantityDisagreement || c.SecondaryOCR
+}
+
+// parseReviewHeuristics reads a comma separated list of heuristics, such as "ocr,amount"
+func parseReviewHeuristics(v string) (ReviewConfig, error) {
+	var c ReviewConfig
+	for _, name := range strings.Split(v, ",") {
+		switch strings.TrimSpace(name) {
+		case ReviewLowOCRQuality:
+			c.LowOCRQuality = true
+		case ReviewAmountOutOfRange:
+			c.AmountOutOfRange = true
+		case ReviewPartialFields:
+			c.PartialFields = true
+		default:
+			return c, fmt.Errorf("must list %s, %s or %s, got %q", ReviewLowOCRQuality, ReviewAmountOutOfRange, ReviewPartialFields, name)
+		}
+	}
+	return c, nil
+}
+
+// memberNameRegex matches the characters and length EVE allows in character names,
+// anything else in a name was misread by the OCR
+var memberNameRegex = regexp.MustCompile(`^[A-Za-z0-9' -]{3,37}$`)
+
+// quantityGroupingRegex matches a quantity grouped in thousands, or not grouped at all,
+// negative when NegativeAmountsEnv recorded a withdrawal
+var quantityGroupingRegex = regexp.MustCompile(`^-?(\d{1,3}(,\d{3})*|\d+)$`)
+
+// reviewReasons lists the enabled heuristics an extraction trips. The row is still recorded,
+// the reasons only mark it for a person to check.
+func reviewReasons(c ReviewConfig, record Record) []string {
+	date, name, quantity := record.Date, record.Username, record.Quantity
+	var reasons []string
+
+	if c.LowOCRQuality {
+		if name != "" && !memberNameRegex.MatchString(strings.TrimSpace(name)) {
+			reasons = append(reasons, fmt.Sprintf("%s: name %q", ReviewLowOCRQuality, name))
+		}
+		if !quantityGroupingRegex.MatchString(quantity) {
+			reasons = append(reasons, fmt.Sprintf("%s: quantity %q", ReviewLowOCRQuality, quantity))
+		}
+	}
+
+	if c.AmountOutOfRange {
+		amount, err := strconv.ParseInt(strings.Replace(quantity, ",", "", -1), 10, 64)
+		if err == nil && (amount == 0 || (c.MaxAmount > 0 && amount > c.MaxAmount)) {
+			reasons = append(reasons, fmt.Sprintf("%s: %d", ReviewAmountOutOfRange, amount))
+		}
+	}
+
+	if c.PartialFields {
+		var missing []string
+		if strings.TrimSpace(date) == "" {
+			missing = append(missing, "date")
+		}
+		if strings.TrimSpace(name) == "" {
+			missing = append(missing, "name")
+		}
+		if len(missing) > 0 {
+			reasons = append(reasons, fmt.Sprintf("%s: no %s", ReviewPartialFields, strings.Join(missing, " or ")))
+		}
+	}
+
+	if c.QuantityDisagreement && len(record.QuantityCandidates) > 1 {
+		reasons = append(reasons, fmt.Sprintf("quantities disagree: %s", strings.Join(record.QuantityCandidates, " or ")))
+	}
+	return reasons
+}
@@ -0,0 +1,79 @@
+package trimark
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestReviewReasons(t *testing.T) {
+	good := Record{Date: "2020-06-18 12:34:56", Username: "Pilot One", Quantity: "1,000"}
+
+	tests := []struct {
+		name   string
+		config ReviewConfig
+		record Record
+		// want are the prefixes of the reasons, in order
+		want []string
+	}{
+		{"clean extraction", ReviewConfig{LowOCRQuality: true, AmountOutOfRange: true, PartialFields: true, MaxAmount: 5000}, good, nil},
+		{"misread name", ReviewConfig{LowOCRQuality: true}, Record{Date: good.Date, Username: "Pil#t", Quantity: "1,000"}, []string{"ocr: name"}},
+		{"misgrouped quantity", ReviewConfig{LowOCRQuality: true}, Record{Date: good.Date, Username: "Pilot One", Quantity: "1,00"}, []string{"ocr: quantity"}},
+		{"ocr off", ReviewConfig{}, Record{Date: good.Date, Username: "Pil#t", Quantity: "1,00"}, nil},
+		{"zero amount", ReviewConfig{AmountOutOfRange: true}, Record{Date: good.Date, Username: "Pilot One", Quantity: "0"}, []string{"amount: 0"}},
+		{"over the maximum", ReviewConfig{AmountOutOfRange: true, MaxAmount: 500}, good, []string{"amount: 1000"}},
+		{"no maximum", ReviewConfig{AmountOutOfRange: true}, good, nil},
+		{"amount off", ReviewConfig{MaxAmount: 500}, good, nil},
+		{"missing fields", ReviewConfig{PartialFields: true}, Record{Quantity: "1,000"}, []string{"partial: no date or name"}},
+		{"partial off", ReviewConfig{}, Record{Quantity: "1,000"}, nil},
+		{"disagreeing quantities", ReviewConfig{QuantityDisagreement: true}, Record{Date: good.Date, Username: "Pilot One", Quantity: "1,000", QuantityCandidates: []string{"1,000", "10,000"}}, []string{"quantities disagree"}},
+	}
+	for _, tt := range tests {
+		got := reviewReasons(tt.config, tt.record)
+		ok := len(got) == len(tt.want)
+		for i := 0; ok && i < len(got); i++ {
+			ok = strings.HasPrefix(got[i], tt.want[i])
+		}
+		if !ok {
+			t.Errorf("%s: reviewReasons = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNeedsReviewColumn(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.Review = ReviewConfig{AmountOutOfRange: true, MaxAmount: 1500} }),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: "one.txt", MimeType: "text/plain"},
+			{Id: "upload-2", Title: "two.txt", MimeType: "text/plain"},
+		}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.SetContent("upload-2", []byte(donationText("2020-06-19 12:34:56", "Pilot Two", "2,000")))
+	config.Serial = true
+
+	for _, r := range runBatch(t, sc) {
+		if r.err != nil || r.result.RowID == "" {
+			t.Fatalf("processBatch result = %+v, %v, want the upload recorded", r.result, r.err)
+		}
+	}
+
+	rows := fakeSheets.Values(testSheetID, "Sheet1")
+	column := -1
+	for i, h := range rows[0] {
+		if h == "Needs Review" {
+			column = i
+		}
+	}
+	if column < 0 {
+		t.Fatalf("Header = %q, want a Needs Review column", rows[0])
+	}
+	got := map[string]string{}
+	for _, row := range rows[1:] {
+		got[fmt.Sprint(row[3])] = fmt.Sprint(row[column])
+	}
+	if got["Pilot One"] != "FALSE" || got["Pilot Two"] != "TRUE" {
+		t.Errorf("Needs Review = %v, want only Pilot Two's 2,000 flagged", got)
+	}
+}
@@ -0,0 +1,31 @@
+package trimark
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+)
+
+type runIDKey struct{}
+
+// newRunID generates a random version 4 UUID identifying one Main run
+func newRunID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// withRunID tags a file's context with the run processing it
+func withRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// runIDFrom is the run a file's context was tagged with, empty outside Main
+func runIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,62 @@
+package trimark
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestRunIDColumn(t *testing.T) {
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.RunIDColumn = true }),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: "one.txt", MimeType: "text/plain"},
+			{Id: "upload-2", Title: "two.txt", MimeType: "text/plain"},
+		}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.SetContent("upload-2", []byte(donationText("2020-06-19 12:34:56", "Pilot Two", "2,000")))
+
+	run := func() string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		Main(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		var summary ProcessingSummary
+		if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("Main responded %d: %s", w.Code, w.Body)
+		}
+		return summary.RunID
+	}
+	first := run()
+	fakeDrive.AddFile(&drive.File{Id: "upload-3", Title: "three.txt", MimeType: "text/plain", Parents: parentRefs(testUploadFolderID)},
+		[]byte(donationText("2020-06-20 12:34:56", "Pilot Three", "3,000")))
+	second := run()
+
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuid.MatchString(first) || !uuid.MatchString(second) || first == second {
+		t.Fatalf("Run IDs = %q, %q, want a different UUID for each run", first, second)
+	}
+
+	rows := fakeSheets.Values(testSheetID, "Sheet1")
+	column := -1
+	for i, header := range rows[0] {
+		if header == "Run ID" {
+			column = i
+		}
+	}
+	if column < 0 {
+		t.Fatalf("Header = %v, want a Run ID column", rows[0])
+	}
+	want := map[interface{}]string{"Pilot One": first, "Pilot Two": first, "Pilot Three": second}
+	if len(rows) != len(want)+1 {
+		t.Fatalf("Report rows = %v, want a row for each upload", rows[1:])
+	}
+	for _, row := range rows[1:] {
+		if len(row) <= column || row[column] != want[row[nameColumn]] {
+			t.Errorf("Row %v, want run ID %s", row, want[row[nameColumn]])
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// secondaryOCRTimeout bounds a call to SecondaryOCRURLEnv, so a slow engine doesn't hold up the file
+const secondaryOCRTimeout = 30 * time.Second
+
+// SecondaryOCRStats counts how often SecondaryOCRURLEnv agreed with the Docs OCR
+type SecondaryOCRStats struct {
+	Agreed   int64 `json:"agreed"`
+	Diverged int64 `json:"diverged"`
+	Failed   int64 `json:"failed"`
+}
+
+// secondaryOCRStats is only updated atomically, use snapshot to read it
+var secondaryOCRStats SecondaryOCRStats
+
+func (s *SecondaryOCRStats) snapshot() SecondaryOCRStats {
+	return SecondaryOCRStats{
+		Agreed:   atomic.LoadInt64(&s.Agreed),
+		Diverged: atomic.LoadInt64(&s.Diverged),
+		Failed:   atomic.LoadInt64(&s.Failed),
+	}
+}
+
+var secondaryOCRClient = &http.Client{Timeout: secondaryOCRTimeout}
+
+// secondaryOCRText posts a cropped screenshot to SecondaryOCRURLEnv, which responds with its text.
+// Line endings are made \r\n to match the Docs export the patterns are written for.
+func secondaryOCRText(ctx context.Context, img *croppedImage) (string, error) {
+	img.Seek(0, io.SeekStart)
+	body, err := ioutil.ReadAll(img)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, config.SecondaryOCRURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", img.MimeType())
+	resp, err := secondaryOCRClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	text, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(text)))
+	}
+	return strings.Replace(strings.Replace(string(text), "\r\n", "\n", -1), "\n", "\r\n", -1), nil
+}
+
+// runSecondaryOCR OCRs a screenshot with SecondaryOCRURLEnv, returning nil when it failed.
+// A failure is logged and counted, it never stops the file being recorded.
+func runSecondaryOCR(ctx context.Context, title string, img *croppedImage) *string {
+	text, err := secondaryOCRText(ctx, img)
+	if err != nil {
+		atomic.AddInt64(&secondaryOCRStats.Failed, 1)
+		log.Printf("WARN: secondary OCR of %s failed: %v", title, err)
+		return nil
+	}
+	return &text
+}
+
+// compareSecondaryOCR extracts a donation from the secondary OCR text and lists the fields it
+// disagrees with the recorded donation on, as review reasons
+func compareSecondaryOCR(record Record, text string) []string {
+	secondary, err := extractData(ioutil.NopCloser(strings.NewReader(text)))
+	if err != nil {
+		atomic.AddInt64(&secondaryOCRStats.Diverged, 1)
+		return []string{fmt.Sprintf("secondary OCR: %v", err)}
+	}
+
+	var reasons []string
+	if secondary.Date != record.Date {
+		reasons = append(reasons, fmt.Sprintf("secondary OCR: date %q", secondary.Date))
+	}
+	if secondary.Member != record.Member {
+		reasons = append(reasons, fmt.Sprintf("secondary OCR: name %q", secondary.Username))
+	}
+	if !containsQuantity([]string{record.Quantity}, secondary.Quantity) {
+		reasons = append(reasons, fmt.Sprintf("secondary OCR: quantity %q", secondary.Quantity))
+	}
+
+	if len(reasons) > 0 {
+		atomic.AddInt64(&secondaryOCRStats.Diverged, 1)
+	} else {
+		atomic.AddInt64(&secondaryOCRStats.Agreed, 1)
+	}
+	return reasons
+}
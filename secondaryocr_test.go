@@ -0,0 +1,83 @@
+package trimark
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestSecondaryOCRComparison(t *testing.T) {
+	docsText := donationText("2020-06-18 12:34:56", "Pilot One", "1,000")
+
+	tests := []struct {
+		name string
+		// text is the second engine's response, with \n line endings as a service would give
+		text   string
+		status int
+		// want are the prefixes of the review reasons
+		want []string
+	}{
+		{"agrees", strings.Replace(docsText, "\r\n", "\n", -1), http.StatusOK, nil},
+		{"different quantity", strings.Replace(donationText("2020-06-18 12:34:56", "Pilot One", "7,000"), "\r\n", "\n", -1), http.StatusOK, []string{"secondary OCR: quantity"}},
+		{"different name and date", strings.Replace(donationText("2020-06-19 12:34:56", "Pilot 0ne", "1,000"), "\r\n", "\n", -1), http.StatusOK, []string{"secondary OCR: date", "secondary OCR: name"}},
+		{"engine failed", "overloaded", http.StatusServiceUnavailable, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var contentType string
+			engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contentType = r.Header.Get("Content-Type")
+				ioutil.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.text))
+			}))
+			defer engine.Close()
+
+			sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+				WithConfig(func(c *Config) {
+					c.SecondaryOCRURL = engine.URL
+					c.Review.SecondaryOCR = true
+				}),
+				WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "screenshot.png", MimeType: "image/png"}}))
+			fakeDrive.SetContent("upload-1", testPNG(t))
+			fakeDrive.SetOCRText("screenshot.png", docsText)
+			before := secondaryOCRStats.snapshot()
+
+			results := runBatch(t, sc)
+			if len(results) != 1 || results[0].err != nil || results[0].result.RowID == "" {
+				t.Fatalf("processBatch results = %+v, want the upload recorded whatever the second engine read", results)
+			}
+			if contentType != "image/png" {
+				t.Errorf("Second engine was sent %q, want the cropped screenshot", contentType)
+			}
+
+			got := results[0].result.ReviewReasons
+			ok := len(got) == len(tt.want)
+			for i := 0; ok && i < len(got); i++ {
+				ok = strings.HasPrefix(got[i], tt.want[i])
+			}
+			if !ok {
+				t.Errorf("ReviewReasons = %q, want %q", got, tt.want)
+			}
+			if rows := fakeSheets.Values(testSheetID, "Sheet1!A2:G"); len(rows) != 1 || rows[0][nameColumn] != "Pilot One" {
+				t.Errorf("Report rows = %v, want the Docs OCR's donation", rows)
+			}
+
+			after := secondaryOCRStats.snapshot()
+			counted := SecondaryOCRStats{Agreed: after.Agreed - before.Agreed, Diverged: after.Diverged - before.Diverged, Failed: after.Failed - before.Failed}
+			want := SecondaryOCRStats{Diverged: 1}
+			if tt.status != http.StatusOK {
+				want = SecondaryOCRStats{Failed: 1}
+			} else if tt.want == nil {
+				want = SecondaryOCRStats{Agreed: 1}
+			}
+			if counted != want {
+				t.Errorf("Comparisons counted %+v, want %+v", counted, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/Bourne-ID/trimark-demo/trimarkpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceContext is the processing behind the gRPC server's RPCs, the same pipeline Main and
+// Rebuild run
+type ServiceContext struct {
+	// processBatch processes up to maxFiles uploads, or all of them when it is 0, passing each
+	// file's result to done as it finishes. done is never called concurrently.
+	processBatch func(ctx context.Context, maxFiles int, done func(ExtractionResult, error)) error
+
+	// backfill rebuilds the report from Processed as Rebuild does, passing each file's outcome to done
+	backfill func(ctx context.Context, from, to string, done func(rebuildResult)) error
+}
+
+// NewServiceContext processes files with the Drive and Sheets clients set up by Initialize
+func NewServiceContext() *ServiceContext {
+	Initialize()
+	return newServiceContext()
+}
+
+// newServiceContext processes files with whichever Drive and Sheets clients are set up
+func newServiceContext() *ServiceContext {
+	return &ServiceContext{
+		processBatch: processBatch,
+		backfill: func(ctx context.Context, from, to string, done func(rebuildResult)) error {
+			return rebuild(withFolders(ctx, snapshotFolders()), from, to, done)
+		},
+	}
+}
+
+// WarmupComplete reports whether the folders and report sheet the RPCs use have been set up
+func (sc *ServiceContext) WarmupComplete() bool {
+	return WarmupComplete()
+}
+
+// processBatch processes the Upload folder as Main does, through the same processUploads loop
+func processBatch(ctx context.Context, maxFiles int, done func(ExtractionResult, error)) error {
+	if !config.DryRun {
+		if err := ensureSheetHeader(); err != nil {
+			return fmt.Errorf("Failed to verify sheet header: %v", err)
+		}
+	}
+	if err := revalidateFolderIDs(ctx); err != nil {
+		return fmt.Errorf("Failed to revalidate folders: %v", err)
+	}
+	folders := snapshotFolders()
+	ctx = withFolders(ctx, folders)
+
+	runID, err := newRunID()
+	if err != nil {
+		return fmt.Errorf("Failed to generate a run ID: %v", err)
+	}
+
+	stats, err := processUploads(ctx, batchOptions{
+		folders:  folders,
+		runID:    runID,
+		maxFiles: maxFiles,
+		done: func(title string, result ExtractionResult, err error) {
+			done(result, err)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if stats.ReadOnly != nil {
+		return fmt.Errorf("%w, %d files were not started", stats.ReadOnly, stats.NotStarted)
+	}
+	if stats.Failed > 0 {
+		log.Printf("%d of the files in %s failed and were left for the next run", stats.Failed, UploadFolderName)
+	}
+	return ctx.Err()
+}
+
+// GRPCServer serves the trimarkpb.Trimark RPCs. ProcessBatch and BackfillAll stream an event per
+// file as it finishes, so a long batch or backfill isn't bound by a request timeout.
+type GRPCServer struct {
+	service *ServiceContext
+}
+
+// NewGRPCServer serves the RPCs with a NewServiceContext
+func NewGRPCServer() *GRPCServer {
+	return &GRPCServer{service: NewServiceContext()}
+}
+
+func (s *GRPCServer) ProcessBatch(req *trimarkpb.ProcessBatchRequest, stream trimarkpb.Trimark_ProcessBatchServer) error {
+	if req.MaxFiles < 0 {
+		return status.Error(codes.InvalidArgument, "max_files can't be negative")
+	}
+	if err := awaitWarmupRPC(stream.Context()); err != nil {
+		return err
+	}
+
+	var sendErr error
+	err := s.service.processBatch(stream.Context(), int(req.MaxFiles), func(result ExtractionResult, err error) {
+		event := &trimarkpb.ProcessingEvent{
+			FileId:   result.FileID,
+			FileName: result.FileName,
+			RowId:    result.RowID,
+			Checksum: result.Checksum,
+			Success:  err == nil && result.Error == "",
+			Error:    result.Error,
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		// Files still finish once the caller has gone, their results just aren't sent
+		if sendErr == nil {
+			sendErr = stream.Send(event)
+		}
+	})
+	return rpcError(stream.Context(), sendErr, err)
+}
+
+func (s *GRPCServer) BackfillAll(req *trimarkpb.BackfillRequest, stream trimarkpb.Trimark_BackfillAllServer) error {
+	if err := validateRebuildRange(req.StartDate, req.EndDate); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := awaitWarmupRPC(stream.Context()); err != nil {
+		return err
+	}
+
+	var sendErr error
+	err := s.service.backfill(stream.Context(), req.StartDate, req.EndDate, func(result rebuildResult) {
+		event := &trimarkpb.BackfillEvent{
+			FileId:   result.File.Id,
+			FileName: result.File.Title,
+			RowId:    result.RowID,
+			Skipped:  result.Skipped != "",
+		}
+		if result.Err != nil {
+			event.Error = result.Err.Error()
+		}
+		if sendErr == nil {
+			sendErr = stream.Send(event)
+		}
+	})
+	return rpcError(stream.Context(), sendErr, err)
+}
+
+func (s *GRPCServer) GetStats(ctx context.Context, req *trimarkpb.StatsRequest) (*trimarkpb.StatsResponse, error) {
+	return &trimarkpb.StatsResponse{ApiDeprecationWarnings: atomic.LoadInt64(&apiDeprecationWarnings)}, nil
+}
+
+// AuthorizeUnary is a grpc.UnaryServerInterceptor refusing calls without the AdminTokenEnv
+// bearer token in their authorization metadata
+func AuthorizeUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := authorizeRPC(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// AuthorizeStream is the grpc.StreamServerInterceptor of AuthorizeUnary
+func AuthorizeStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := authorizeRPC(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func authorizeRPC(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, authorization := range md.Get("authorization") {
+		if adminAuthorized(authorization) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "Unauthorized")
+}
+
+// awaitWarmupRPC is requireWarmup for the RPCs, failing with Unavailable when warmup failed
+func awaitWarmupRPC(ctx context.Context) error {
+	if err := awaitWarmup(ctx); err != nil {
+		log.Printf("ERROR: not serving an RPC, warmup failed: %v", err)
+		return status.Error(codes.Unavailable, "Service is not ready")
+	}
+	return nil
+}
+
+// rpcError is the status a streaming RPC ends with, given the first failed send and the
+// error the processing returned
+func rpcError(ctx context.Context, sendErr error, err error) error {
+	switch {
+	case sendErr != nil:
+		return sendErr
+	case ctx.Err() != nil:
+		return status.Error(codes.Canceled, ctx.Err().Error())
+	case err != nil:
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,362 @@
+package trimark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Bourne-ID/trimark-demo/trimarkpb"
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialTestServer serves s over an in-memory connection until the returned func closes it
+func dialTestServer(t *testing.T, s *GRPCServer, opts ...grpc.ServerOption) (trimarkpb.TrimarkClient, func()) {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(opts...)
+	trimarkpb.RegisterTrimarkServer(server, s)
+	go server.Serve(lis)
+
+	conn, err := grpc.DialContext(context.Background(), "bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trimarkpb.NewTrimarkClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func TestProcessBatch(t *testing.T) {
+	// The RPCs wait for warmup, which the fixture has finished
+	NewTestServiceContext(t)
+
+	results := []struct {
+		result ExtractionResult
+		err    error
+	}{
+		{ExtractionResult{FileID: "1", FileName: "a.png", RowID: "12", Checksum: "c1"}, nil},
+		{ExtractionResult{FileID: "2", FileName: "b.png", RowID: "13", Error: "Quantity Not Found"}, nil},
+		{ExtractionResult{FileID: "3", FileName: "c.png"}, errors.New("Failed to create document")},
+	}
+	want := []*trimarkpb.ProcessingEvent{
+		{FileId: "1", FileName: "a.png", RowId: "12", Checksum: "c1", Success: true},
+		{FileId: "2", FileName: "b.png", RowId: "13", Error: "Quantity Not Found"},
+		{FileId: "3", FileName: "c.png", Error: "Failed to create document"},
+	}
+
+	tests := []struct {
+		name     string
+		maxFiles int32
+		err      error
+		code     codes.Code
+	}{
+		{name: "all files"},
+		{name: "limited", maxFiles: 3},
+		{name: "failed listing", err: errors.New("Failed to get files from folder"), code: codes.Internal},
+		{name: "negative limit", maxFiles: -1, code: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotMaxFiles int
+			service := &ServiceContext{processBatch: func(ctx context.Context, maxFiles int, done func(ExtractionResult, error)) error {
+				gotMaxFiles = maxFiles
+				for _, r := range results {
+					done(r.result, r.err)
+				}
+				return tt.err
+			}}
+			client, stop := dialTestServer(t, &GRPCServer{service: service})
+			defer stop()
+
+			stream, err := client.ProcessBatch(context.Background(), &trimarkpb.ProcessBatchRequest{MaxFiles: tt.maxFiles})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var events []*trimarkpb.ProcessingEvent
+			for {
+				event, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if status.Code(err) != tt.code {
+						t.Fatalf("ProcessBatch ended with %v, want %s", err, tt.code)
+					}
+					break
+				}
+				events = append(events, event)
+			}
+
+			if tt.code == codes.InvalidArgument {
+				if len(events) > 0 {
+					t.Errorf("%d events streamed for an invalid request", len(events))
+				}
+				return
+			}
+			if tt.code == codes.OK && int32(gotMaxFiles) != tt.maxFiles {
+				t.Errorf("maxFiles = %d, want %d", gotMaxFiles, tt.maxFiles)
+			}
+			if len(events) != len(want) {
+				t.Fatalf("%d events streamed, want %d", len(events), len(want))
+			}
+			for i := range want {
+				got := events[i]
+				if got.FileId != want[i].FileId || got.FileName != want[i].FileName || got.RowId != want[i].RowId ||
+					got.Checksum != want[i].Checksum || got.Success != want[i].Success || got.Error != want[i].Error {
+					t.Errorf("Event %d = %v, want %v", i, got, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBackfillAll(t *testing.T) {
+	// The RPCs wait for warmup, which the fixture has finished
+	NewTestServiceContext(t)
+
+	var gotRange []string
+	service := &ServiceContext{backfill: func(ctx context.Context, from, to string, done func(rebuildResult)) error {
+		gotRange = []string{from, to}
+		done(rebuildResult{File: &drive.File{Id: "1", Title: "a.png"}, Checksum: "c1", RowID: "20"})
+		done(rebuildResult{File: &drive.File{Id: "2", Title: "b.png"}, Checksum: "c1", Skipped: rebuildDuplicate})
+		done(rebuildResult{File: &drive.File{Id: "3", Title: "c.png"}, Err: errors.New("Date Not Found")})
+		return nil
+	}}
+	client, stop := dialTestServer(t, &GRPCServer{service: service})
+	defer stop()
+
+	stream, err := client.BackfillAll(context.Background(), &trimarkpb.BackfillRequest{StartDate: "2020-06-01", EndDate: "2020-06-30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s row=%q skipped=%t error=%q", event.FileId, event.FileName, event.RowId, event.Skipped, event.Error))
+	}
+	want := []string{
+		`1 a.png row="20" skipped=false error=""`,
+		`2 b.png row="" skipped=true error=""`,
+		`3 c.png row="" skipped=false error="Date Not Found"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Events = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(gotRange, []string{"2020-06-01", "2020-06-30"}) {
+		t.Errorf("Backfilled %v, want 2020-06-01 to 2020-06-30", gotRange)
+	}
+
+	stream, err = client.BackfillAll(context.Background(), &trimarkpb.BackfillRequest{StartDate: "June"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("BackfillAll of an invalid date ended with %v, want InvalidArgument", err)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	client, stop := dialTestServer(t, &GRPCServer{service: &ServiceContext{}})
+	defer stop()
+
+	atomic.AddInt64(&apiDeprecationWarnings, 2)
+	defer atomic.AddInt64(&apiDeprecationWarnings, -2)
+	stats, err := client.GetStats(context.Background(), &trimarkpb.StatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := atomic.LoadInt64(&apiDeprecationWarnings); stats.ApiDeprecationWarnings != want {
+		t.Errorf("ApiDeprecationWarnings = %d, want %d", stats.ApiDeprecationWarnings, want)
+	}
+}
+
+func TestProcessBatchRecordsUploads(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "donation.txt", MimeType: "text/plain"}}),
+		WithExistingSheetRows([][]interface{}{{"earlier-checksum", "06-01-2020 10:00:00", "2020-06-01 09:00:00", "Pilot Two", "500", ""}}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,234,567")))
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || results[0].err != nil || results[0].result.Error != "" {
+		t.Fatalf("processBatch results = %+v, want one recorded file", results)
+	}
+	if results[0].result.RowID != "3" {
+		t.Errorf("RowID = %q, want 3, below the existing row", results[0].result.RowID)
+	}
+
+	rows := fakeSheets.Values(testSheetID, "Sheet1")
+	if len(rows) != 3 {
+		t.Fatalf("Sheet has %d rows, want the header and 2 donations: %q", len(rows), rows)
+	}
+	record := newRecord("2020-06-18 12:34:56", "Pilot One", "1,234,567")
+	if got := rows[2]; got[0] != record.Checksum || got[3] != "Pilot One" || got[4] != "1,234,567" {
+		t.Errorf("Appended row = %q, want the donation of Pilot One", got)
+	}
+
+	processed := fakeDrive.FilesIn(testProcessedFolderID)
+	if len(processed) != 1 || processed[0].Id != "upload-1" {
+		t.Fatalf("Processed holds %d files, want the upload", len(processed))
+	}
+	if want := "3-donation.txt-" + record.Checksum; processed[0].Title != want {
+		t.Errorf("Processed upload is named %q, want %q", processed[0].Title, want)
+	}
+	if n := len(fakeDrive.FilesIn(testUploadFolderID)); n != 0 {
+		t.Errorf("%d files left in the Upload folder", n)
+	}
+}
+
+func TestProcessBatchDeadLettersAfterMaxAttempts(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.MaxAttempts = 1 }),
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "donation.txt", MimeType: "text/plain"}}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeSheets.Fail(http.MethodPost, ":append", &googleapi.Error{Code: http.StatusBadRequest, Message: "Invalid range"})
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || results[0].err == nil {
+		t.Fatalf("processBatch results = %+v, want the failed append streamed", results)
+	}
+	upload := fakeDrive.File("upload-1")
+	if !inFolder(upload, testFailedFolderID) || !hasProperty(upload, attemptsPropertyKey, "1") {
+		t.Errorf("Upload is in %v with properties %v, want it in Failed after its only attempt", upload.Parents, upload.Properties)
+	}
+}
+
+func TestProcessBatchStopsOnReadOnlySheet(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) {
+			c.Serial = true
+			c.MaxAttempts = 1
+		}),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: "first.txt", MimeType: "text/plain", CreatedDate: "2020-06-18T12:00:00Z"},
+			{Id: "upload-2", Title: "second.txt", MimeType: "text/plain", CreatedDate: "2020-06-18T13:00:00Z"},
+		}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.SetContent("upload-2", []byte(donationText("2020-06-18 12:35:56", "Pilot Two", "2,000")))
+	fakeSheets.Fail(http.MethodPost, ":append", &googleapi.Error{Code: http.StatusForbidden, Message: "The caller does not have permission"})
+
+	var results []batchResult
+	err := sc.processBatch(context.Background(), 0, func(result ExtractionResult, err error) {
+		results = append(results, batchResult{result, err})
+	})
+	if !errors.Is(err, ErrSheetReadOnly) {
+		t.Fatalf("processBatch = %v, want %v", err, ErrSheetReadOnly)
+	}
+	if len(results) != 1 {
+		t.Fatalf("processBatch results = %+v, want no file started after the read-only sheet", results)
+	}
+	// A read-only sheet isn't an attempt against the upload
+	for _, id := range []string{"upload-1", "upload-2"} {
+		if upload := fakeDrive.File(id); !inFolder(upload, testUploadFolderID) || hasProperty(upload, attemptsPropertyKey, "") {
+			t.Errorf("%s is in %v with properties %v, want it left in Upload unattempted", id, upload.Parents, upload.Properties)
+		}
+	}
+}
+
+func TestRPCsRequireAdminToken(t *testing.T) {
+	NewTestServiceContext(t, WithConfig(func(c *Config) { c.AdminToken = "secret" }))
+
+	var calls int32
+	service := &ServiceContext{processBatch: func(ctx context.Context, maxFiles int, done func(ExtractionResult, error)) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}}
+	client, stop := dialTestServer(t, &GRPCServer{service: service}, grpc.UnaryInterceptor(AuthorizeUnary), grpc.StreamInterceptor(AuthorizeStream))
+	defer stop()
+
+	tests := []struct {
+		name          string
+		authorization string
+		code          codes.Code
+	}{
+		{name: "no token", code: codes.Unauthenticated},
+		{name: "wrong token", authorization: "Bearer guess", code: codes.Unauthenticated},
+		{name: "admin token", authorization: "Bearer secret", code: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&calls, 0)
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", tt.authorization)
+			}
+
+			if _, err := client.GetStats(ctx, &trimarkpb.StatsRequest{}); status.Code(err) != tt.code {
+				t.Errorf("GetStats = %v, want %s", err, tt.code)
+			}
+
+			stream, err := client.ProcessBatch(ctx, &trimarkpb.ProcessBatchRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := stream.Recv(); err != io.EOF && status.Code(err) != tt.code {
+				t.Errorf("ProcessBatch ended with %v, want %s", err, tt.code)
+			}
+			if n := atomic.LoadInt32(&calls); (n == 1) != (tt.code == codes.OK) {
+				t.Errorf("processBatch ran %d times", n)
+			}
+		})
+	}
+}
+
+func TestRPCsRequireWarmup(t *testing.T) {
+	NewTestServiceContext(t)
+
+	var calls int32
+	service := &ServiceContext{
+		processBatch: func(ctx context.Context, maxFiles int, done func(ExtractionResult, error)) error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		},
+		backfill: func(ctx context.Context, from, to string, done func(rebuildResult)) error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		},
+	}
+	if !service.WarmupComplete() {
+		t.Fatal("WarmupComplete = false, want the fixture's warmup finished")
+	}
+	warmupErr = errors.New("Unable to set up ISK Import Report")
+
+	client, stop := dialTestServer(t, &GRPCServer{service: service})
+	defer stop()
+
+	batch, err := client.ProcessBatch(context.Background(), &trimarkpb.ProcessBatchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := batch.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("ProcessBatch ended with %v, want %s", err, codes.Unavailable)
+	}
+	backfill, err := client.BackfillAll(context.Background(), &trimarkpb.BackfillRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backfill.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("BackfillAll ended with %v, want %s", err, codes.Unavailable)
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Errorf("The service ran %d times before warmup", n)
+	}
+}
@@ -0,0 +1,130 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// Report columns which take a number format, zero-indexed
+const (
+	importDateColumn = 1
+	echoesDateColumn = 2
+	amountColumn     = 4
+)
+
+// applySheetFormats formats the date and amount columns of a report spreadsheet below the header with
+// DateFormatEnv and AmountFormatEnv. Whole columns are formatted, so appended rows are too.
+func applySheetFormats(ctx context.Context, spreadsheetID string) error {
+	if config.DateFormat == "" && config.AmountFormat == "" {
+		return nil
+	}
+
+	tabID, err := sheetTabID(ctx, spreadsheetID, "Sheet1")
+	if err != nil {
+		return err
+	}
+
+	batch := &sheets.BatchUpdateSpreadsheetRequest{}
+	if config.DateFormat != "" {
+		for _, col := range []int64{importDateColumn, echoesDateColumn} {
+			batch.Requests = append(batch.Requests, columnFormatRequest(tabID, col, &sheets.NumberFormat{Type: "DATE_TIME", Pattern: config.DateFormat}))
+		}
+	}
+	if config.AmountFormat != "" {
+		batch.Requests = append(batch.Requests, columnFormatRequest(tabID, amountColumn, &sheets.NumberFormat{Type: "NUMBER", Pattern: config.AmountFormat}))
+	}
+
+	_, err = sheetService.Spreadsheets.BatchUpdate(spreadsheetID, batch).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: applied %d column formats to %s", len(batch.Requests), spreadsheetID)
+	return nil
+}
+
+// columnFormatRequest sets the number format of a column, below the header row
+func columnFormatRequest(tabID int64, col int64, format *sheets.NumberFormat) *sheets.Request {
+	return &sheets.Request{RepeatCell: &sheets.RepeatCellRequest{
+		Range: &sheets.GridRange{
+			SheetId:          tabID,
+			StartRowIndex:    1,
+			StartColumnIndex: col,
+			EndColumnIndex:   col + 1,
+			ForceSendFields:  []string{"SheetId"},
+		},
+		Cell:   &sheets.CellData{UserEnteredFormat: &sheets.CellFormat{NumberFormat: format}},
+		Fields: "userEnteredFormat.numberFormat",
+	}}
+}
+
+// duplicateHighlight is the background of report rows whose ID appears more than once
+var duplicateHighlight = &sheets.Color{Red: 0.96, Green: 0.8, Blue: 0.8}
+
+// highlightDuplicateChecksums adds a conditional format to the ID column which highlights
+// checksums appearing more than once, so duplicates from manual edits are visible in the
+// sheet. Nothing is added when the rule is already there.
+func highlightDuplicateChecksums(ctx context.Context, spreadsheetID string) error {
+	if !config.HighlightDuplicates {
+		return nil
+	}
+
+	ss, err := sheetService.Spreadsheets.Get(spreadsheetID).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	var tab *sheets.Sheet
+	for _, sheet := range ss.Sheets {
+		if sheet.Properties != nil && sheet.Properties.Title == "Sheet1" {
+			tab = sheet
+			break
+		}
+	}
+	if tab == nil {
+		return fmt.Errorf("Tab %s not found", "Sheet1")
+	}
+
+	// Relative to the first data row, which the rule is applied from
+	firstRow := headerRowIndex() + 1
+	formula := fmt.Sprintf(`=AND($A%d<>"",COUNTIF($A:$A,$A%d)>1)`, firstRow+1, firstRow+1)
+	for _, rule := range tab.ConditionalFormats {
+		if rule.BooleanRule == nil || rule.BooleanRule.Condition == nil {
+			continue
+		}
+		for _, v := range rule.BooleanRule.Condition.Values {
+			if v.UserEnteredValue == formula {
+				return nil
+			}
+		}
+	}
+
+	add := &sheets.Request{AddConditionalFormatRule: &sheets.AddConditionalFormatRuleRequest{
+		Index: 0,
+		Rule: &sheets.ConditionalFormatRule{
+			Ranges: []*sheets.GridRange{{
+				SheetId:          tab.Properties.SheetId,
+				StartRowIndex:    firstRow,
+				StartColumnIndex: idColumn,
+				EndColumnIndex:   idColumn + 1,
+				ForceSendFields:  []string{"SheetId", "StartColumnIndex"},
+			}},
+			BooleanRule: &sheets.BooleanRule{
+				Condition: &sheets.BooleanCondition{
+					Type:   "CUSTOM_FORMULA",
+					Values: []*sheets.ConditionValue{{UserEnteredValue: formula}},
+				},
+				Format: &sheets.CellFormat{BackgroundColor: duplicateHighlight},
+			},
+		},
+		ForceSendFields: []string{"Index"},
+	}}
+	batch := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{add}}
+	_, err = sheetService.Spreadsheets.BatchUpdate(spreadsheetID, batch).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: highlighting duplicate IDs in %s", spreadsheetID)
+	return nil
+}
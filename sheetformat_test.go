@@ -0,0 +1,98 @@
+package trimark
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHighlightDuplicates(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		want    int
+	}{
+		{"enabled", true, 1},
+		{"disabled", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			NewTestServiceContext(t, WithConfig(func(c *Config) { c.HighlightDuplicates = tt.enabled }))
+
+			spreadsheetID, err := prepareSheet(testReportFolderID, "New Report")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Setting the sheet up again doesn't add the rule twice
+			if err := highlightDuplicateChecksums(context.Background(), spreadsheetID); err != nil {
+				t.Fatal(err)
+			}
+
+			ss, err := sheetService.Spreadsheets.Get(spreadsheetID).Do()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules := ss.Sheets[0].ConditionalFormats
+			if len(rules) != tt.want {
+				t.Fatalf("Conditional formats = %d, want %d", len(rules), tt.want)
+			}
+			if tt.want == 0 {
+				return
+			}
+
+			rule := rules[0]
+			if rng := rule.Ranges[0]; rng.StartRowIndex != 1 || rng.StartColumnIndex != idColumn || rng.EndColumnIndex != idColumn+1 {
+				t.Errorf("Rule range = %+v, want the ID column below the header", rng)
+			}
+			condition := rule.BooleanRule.Condition
+			if want := `=AND($A2<>"",COUNTIF($A:$A,$A2)>1)`; condition.Type != "CUSTOM_FORMULA" || condition.Values[0].UserEnteredValue != want {
+				t.Errorf("Rule condition = %s %q, want a custom formula %q", condition.Type, condition.Values[0].UserEnteredValue, want)
+			}
+			if rule.BooleanRule.Format.BackgroundColor == nil {
+				t.Error("Rule doesn't shade duplicate IDs")
+			}
+		})
+	}
+}
+
+func TestApplySheetFormats(t *testing.T) {
+	tests := []struct {
+		name         string
+		dateFormat   string
+		amountFormat string
+	}{
+		{"both", "yyyy-mm-dd hh:mm", "#,##0"},
+		{"amount only", "", "#,##0.00"},
+		{"none", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, fakeSheets := NewTestServiceContext(t, WithConfig(func(c *Config) {
+				c.DateFormat = tt.dateFormat
+				c.AmountFormat = tt.amountFormat
+			}))
+
+			spreadsheetID, err := prepareSheet(testReportFolderID, "New Report")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			check := func(col int64, formatType, pattern string) {
+				t.Helper()
+				format := fakeSheets.ColumnFormat(spreadsheetID, "Sheet1", col)
+				if pattern == "" {
+					if format != nil {
+						t.Errorf("Column %d is formatted %+v, want it left alone", col, format.NumberFormat)
+					}
+					return
+				}
+				if format == nil || format.NumberFormat == nil || format.NumberFormat.Type != formatType || format.NumberFormat.Pattern != pattern {
+					t.Errorf("Column %d format = %+v, want %s %q", col, format, formatType, pattern)
+				}
+			}
+			check(importDateColumn, "DATE_TIME", tt.dateFormat)
+			check(echoesDateColumn, "DATE_TIME", tt.dateFormat)
+			check(amountColumn, "NUMBER", tt.amountFormat)
+			check(nameColumn, "", "")
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/drive/v2"
+)
+
+// splitHalfRegex matches the name of half a split screenshot, such as "log-1.png" and "log-2.png"
+// or "log_top.png" and "log_bottom.png". Halves pair up when the rest of their names match.
+var splitHalfRegex = regexp.MustCompile(`(?i)^(.+?)[ _-](1|2|top|bottom)(\.[a-z0-9]+)?$`)
+
+// pairSplitScreenshots pairs up the halves of split screenshots in a page of uploads by name,
+// returning the bottom half of each top half's ID and the IDs of the bottom halves. Halves
+// listed on different pages, or without a partner, are processed on their own.
+func pairSplitScreenshots(files []*drive.File) (bottoms map[string]*drive.File, merged map[string]bool) {
+	tops := map[string]*drive.File{}
+	lower := map[string]*drive.File{}
+	for _, f := range files {
+		if !strings.HasPrefix(f.MimeType, "image/") && f.MimeType != octetStreamMimeType {
+			continue
+		}
+		m := splitHalfRegex.FindStringSubmatch(f.Title)
+		if m == nil {
+			continue
+		}
+		key := strings.ToLower(m[1] + m[3])
+		switch strings.ToLower(m[2]) {
+		case "1", "top":
+			tops[key] = f
+		case "2", "bottom":
+			lower[key] = f
+		}
+	}
+
+	bottoms = map[string]*drive.File{}
+	merged = map[string]bool{}
+	for key, top := range tops {
+		if bottom, ok := lower[key]; ok {
+			bottoms[top.Id] = bottom
+			merged[bottom.Id] = true
+		}
+	}
+	return bottoms, merged
+}
+
+type bottomHalfKey struct{}
+
+// withBottomHalf tags the context of a top half with the upload holding its bottom half
+func withBottomHalf(ctx context.Context, bottom *drive.File) context.Context {
+	return context.WithValue(ctx, bottomHalfKey{}, bottom)
+}
+
+// bottomHalfFrom is the bottom half a context was tagged with, nil for a whole screenshot
+func bottomHalfFrom(ctx context.Context) *drive.File {
+	bottom, _ := ctx.Value(bottomHalfKey{}).(*drive.File)
+	return bottom
+}
+
+// stitchImages decodes the halves of a split screenshot and stacks them, top above bottom,
+// as a single PNG. The narrower half is left aligned on a white background.
+func stitchImages(top, bottom []byte) ([]byte, error) {
+	topImg, _, err := image.Decode(bytes.NewReader(top))
+	if err != nil {
+		return nil, fmt.Errorf("%w: top half: %v", ErrUnsupportedImage, err)
+	}
+	bottomImg, _, err := image.Decode(bytes.NewReader(bottom))
+	if err != nil {
+		return nil, fmt.Errorf("%w: bottom half: %v", ErrUnsupportedImage, err)
+	}
+
+	tb, bb := topImg.Bounds(), bottomImg.Bounds()
+	width := tb.Dx()
+	if bb.Dx() > width {
+		width = bb.Dx()
+	}
+	out := image.NewRGBA(image.Rect(0, 0, width, tb.Dy()+bb.Dy()))
+	draw.Draw(out, out.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(out, image.Rect(0, 0, tb.Dx(), tb.Dy()), topImg, tb.Min, draw.Over)
+	draw.Draw(out, image.Rect(0, tb.Dy(), bb.Dx(), tb.Dy()+bb.Dy()), bottomImg, bb.Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		return nil, fmt.Errorf("png.Encode -> %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// stitchBottomHalf downloads the bottom half of a split screenshot and stacks it below the top
+func stitchBottomHalf(ctx context.Context, top []byte, bottom *drive.File) ([]byte, error) {
+	raw, err := downloadImage(ctx, bottom)
+	if err != nil {
+		return nil, err
+	}
+	return stitchImages(top, raw)
+}
@@ -0,0 +1,148 @@
+package trimark
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestPairSplitScreenshots(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       []*drive.File
+		wantBottoms map[string]string
+		wantMerged  map[string]bool
+	}{
+		{
+			name: "numbered halves",
+			files: []*drive.File{
+				{Id: "t", Title: "donation_1.png", MimeType: "image/png"},
+				{Id: "b", Title: "donation_2.png", MimeType: "image/png"},
+			},
+			wantBottoms: map[string]string{"t": "b"},
+			wantMerged:  map[string]bool{"b": true},
+		},
+		{
+			name: "named halves ignore case and separator",
+			files: []*drive.File{
+				{Id: "b", Title: "Donation-BOTTOM.jpg", MimeType: "image/jpeg"},
+				{Id: "t", Title: "donation top.JPG", MimeType: octetStreamMimeType},
+			},
+			wantBottoms: map[string]string{"t": "b"},
+			wantMerged:  map[string]bool{"b": true},
+		},
+		{
+			name: "extensions must match",
+			files: []*drive.File{
+				{Id: "t", Title: "donation_1.png", MimeType: "image/png"},
+				{Id: "b", Title: "donation_2.jpg", MimeType: "image/jpeg"},
+			},
+			wantBottoms: map[string]string{},
+			wantMerged:  map[string]bool{},
+		},
+		{
+			name: "lone half and text uploads",
+			files: []*drive.File{
+				{Id: "t", Title: "donation_1.png", MimeType: "image/png"},
+				{Id: "x", Title: "notes_1.txt", MimeType: "text/plain"},
+				{Id: "y", Title: "notes_2.txt", MimeType: "text/plain"},
+			},
+			wantBottoms: map[string]string{},
+			wantMerged:  map[string]bool{},
+		},
+	}
+	for _, tt := range tests {
+		bottoms, merged := pairSplitScreenshots(tt.files)
+		gotBottoms := map[string]string{}
+		for top, bottom := range bottoms {
+			gotBottoms[top] = bottom.Id
+		}
+		if !reflect.DeepEqual(gotBottoms, tt.wantBottoms) {
+			t.Errorf("%s: bottoms = %v, want %v", tt.name, gotBottoms, tt.wantBottoms)
+		}
+		if !reflect.DeepEqual(merged, tt.wantMerged) {
+			t.Errorf("%s: merged = %v, want %v", tt.name, merged, tt.wantMerged)
+		}
+	}
+}
+
+// solidPNG is a w by h PNG of one colour
+func solidPNG(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStitchImages(t *testing.T) {
+	red := color.RGBA{R: 0xff, A: 0xff}
+	blue := color.RGBA{B: 0xff, A: 0xff}
+	stitched, err := stitchImages(solidPNG(t, 6, 4, red), solidPNG(t, 4, 3, blue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(stitched))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds(); got != image.Rect(0, 0, 6, 7) {
+		t.Fatalf("Stitched bounds = %v, want the wider width and both heights", got)
+	}
+	tests := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{5, 3, red},
+		{0, 4, blue},
+		{3, 6, blue},
+		{5, 6, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+	}
+	for _, tt := range tests {
+		if got := color.RGBAModel.Convert(img.At(tt.x, tt.y)); got != tt.want {
+			t.Errorf("Pixel (%d, %d) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	if _, err := stitchImages(solidPNG(t, 2, 2, red), []byte("not an image")); err == nil {
+		t.Error("stitchImages accepted a bottom half which isn't an image")
+	}
+}
+
+func TestSplitScreenshotsMergeIntoOneExtraction(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.MergeSplitScreenshots = true }),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "top", Title: "donation_1.png", MimeType: "image/png"},
+			{Id: "bottom", Title: "donation_2.png", MimeType: "image/png"},
+		}))
+	fakeDrive.SetContent("top", testPNG(t))
+	fakeDrive.SetContent("bottom", testPNG(t))
+	// The OCR of the stitched screenshot, which is named after its top half
+	fakeDrive.SetOCRText("donation_1.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+
+	results := runBatch(t, sc)
+	if len(results) != 1 || results[0].err != nil || results[0].result.FileID != "top" || results[0].result.RowID == "" {
+		t.Fatalf("processBatch results = %+v, want the halves recorded as one upload", results)
+	}
+	if rows := fakeSheets.Values(testSheetID, "Sheet1!A2:G"); len(rows) != 1 || rows[0][nameColumn] != "Pilot One" {
+		t.Errorf("Report rows = %v, want one donation", rows)
+	}
+	for _, id := range []string{"top", "bottom"} {
+		if f := fakeDrive.File(id); !inFolder(f, testProcessedFolderID) {
+			t.Errorf("The %s half was not moved to Processed", id)
+		}
+	}
+}
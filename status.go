@@ -0,0 +1,76 @@
+package trimark
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// StatusReport is the JSON body returned by the Status function
+type StatusReport struct {
+	APIDeprecationWarnings int64             `json:"apiDeprecationWarnings"`
+	LastSetupReport        FolderSetupReport `json:"lastSetupReport"`
+	PatternStats           PatternStats      `json:"patternStats"`
+	SecondaryOCR           SecondaryOCRStats `json:"secondaryOcr"`
+
+	// StageDurations are the stage percentiles of the last run
+	StageDurations map[string]StagePercentiles `json:"stageDurations"`
+
+	// WarmupComplete is false while WarmupAsyncEnv setup is still running, WarmupError is set if it failed
+	WarmupComplete bool   `json:"warmupComplete"`
+	WarmupError    string `json:"warmupError,omitempty"`
+}
+
+// PatternStats counts which quantity pattern extractions were matched by
+type PatternStats struct {
+	ZeroPatternHits        int64 `json:"zeroPatternHits"`
+	FirstPatternHits       int64 `json:"firstPatternHits"`
+	SecondPatternHits      int64 `json:"secondPatternHits"`
+	EnvFallbackHits        int64 `json:"envFallbackHits"`
+	AllPatternsFailedCount int64 `json:"allPatternsFailedCount"`
+}
+
+// patternStats is only updated atomically, use snapshot to read it
+var patternStats PatternStats
+
+func (s *PatternStats) snapshot() PatternStats {
+	return PatternStats{
+		ZeroPatternHits:        atomic.LoadInt64(&s.ZeroPatternHits),
+		FirstPatternHits:       atomic.LoadInt64(&s.FirstPatternHits),
+		SecondPatternHits:      atomic.LoadInt64(&s.SecondPatternHits),
+		EnvFallbackHits:        atomic.LoadInt64(&s.EnvFallbackHits),
+		AllPatternsFailedCount: atomic.LoadInt64(&s.AllPatternsFailedCount),
+	}
+}
+
+// Status reports the runtime counters of this instance
+func Status(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+
+	lastStageDurationsMu.Lock()
+	stageDurations := lastStageDurations
+	lastStageDurationsMu.Unlock()
+
+	report := StatusReport{
+		APIDeprecationWarnings: atomic.LoadInt64(&apiDeprecationWarnings),
+		PatternStats:           patternStats.snapshot(),
+		SecondaryOCR:           secondaryOCRStats.snapshot(),
+		StageDurations:         stageDurations,
+		WarmupComplete:         WarmupComplete(),
+	}
+	// The setup report is written by warmup, so is only safe to read once it has finished
+	if report.WarmupComplete {
+		folderIDsMu.RLock()
+		report.LastSetupReport = LastSetupReport
+		folderIDsMu.RUnlock()
+		if err := WarmupError(); err != nil {
+			report.WarmupError = err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Unable to write status: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package trimark
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExtractionResult is the data extracted from a single uploaded file
+type ExtractionResult struct {
+	FileID   string `json:"fileId"`
+	FileName string `json:"fileName"`
+	Date     string `json:"date,omitempty"`
+	Username string `json:"username,omitempty"`
+	Quantity string `json:"quantity,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	// RowID is the row the upload was recorded in and Checksum the donation's ID, both empty
+	// until the row is written
+	RowID    string `json:"rowId,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+
+	// Ignored is set when the username is listed in IgnoreUsernamesEnv
+	Ignored bool `json:"ignored,omitempty"`
+
+	// Inconsistent is set when the row was written but the upload couldn't be moved to Processed
+	Inconsistent bool `json:"inconsistent,omitempty"`
+
+	// ReviewReasons are the NeedsReviewEnv heuristics the extraction tripped
+	ReviewReasons []string `json:"reviewReasons,omitempty"`
+
+	// Metadata is how long each stage of processing took
+	Metadata ProcessingMetadata `json:"metadata"`
+
+	// secondaryText is the SecondaryOCRURLEnv text of a screenshot, nil when it wasn't OCRed twice
+	secondaryText *string
+}
+
+// ProcessingMetadata is how long each stage of processing a file took. Upload is creating the
+// OCR document, which Drive OCRs as it's created, and OCR is exporting its text. Stitch and
+// SecondaryOCR are only timed with MergeSplitScreenshotsEnv and SecondaryOCRURLEnv.
+type ProcessingMetadata struct {
+	DownloadDuration     time.Duration `json:"downloadDuration"`
+	StitchDuration       time.Duration `json:"stitchDuration,omitempty"`
+	CropDuration         time.Duration `json:"cropDuration"`
+	SecondaryOCRDuration time.Duration `json:"secondaryOcrDuration,omitempty"`
+	UploadDuration       time.Duration `json:"uploadDuration"`
+	OCRDuration          time.Duration `json:"ocrDuration"`
+	ExtractDuration      time.Duration `json:"extractDuration"`
+	SheetDuration        time.Duration `json:"sheetDuration"`
+	MoveDuration         time.Duration `json:"moveDuration"`
+	TotalDuration        time.Duration `json:"totalDuration"`
+}
+
+// stages pairs the recordStage names with their ProcessingMetadata fields
+func (m *ProcessingMetadata) stages() map[string]*time.Duration {
+	return map[string]*time.Duration{
+		"download":      &m.DownloadDuration,
+		"stitch":        &m.StitchDuration,
+		"crop":          &m.CropDuration,
+		"secondary_ocr": &m.SecondaryOCRDuration,
+		"insert":        &m.UploadDuration,
+		"export":        &m.OCRDuration,
+		"extract":       &m.ExtractDuration,
+		"append":        &m.SheetDuration,
+		"move":          &m.MoveDuration,
+		"total":         &m.TotalDuration,
+	}
+}
+
+// recordStage adds the time since start to the duration of a processing stage,
+// stages repeated by a retry are summed. Each stage is also a span under the file's span in ctx,
+// except the total, which is the file's span itself.
+func (r *ExtractionResult) recordStage(ctx context.Context, stage string, start time.Time) {
+	d, ok := r.Metadata.stages()[stage]
+	if !ok {
+		return
+	}
+	end := time.Now()
+	*d += end.Sub(start)
+	if stage != "total" {
+		_, span := tracer.Start(ctx, stage, trace.WithTimestamp(start))
+		span.End(trace.WithTimestamp(end))
+	}
+}
+
+// FileTimings are the stage durations of one file of a run
+type FileTimings struct {
+	FileID   string             `json:"fileId"`
+	FileName string             `json:"fileName"`
+	Metadata ProcessingMetadata `json:"metadata"`
+}
+
+// StagePercentiles are the percentiles of a stage's duration across the files of a run, in milliseconds
+type StagePercentiles struct {
+	P50Ms int64 `json:"p50Ms"`
+	P95Ms int64 `json:"p95Ms"`
+	P99Ms int64 `json:"p99Ms"`
+}
+
+// stagePercentiles aggregates the stage durations of a run. Stages a file skipped, such as
+// cropping a text upload, are left out of that stage's percentiles.
+func stagePercentiles(files []FileTimings) map[string]StagePercentiles {
+	durations := map[string][]time.Duration{}
+	for i := range files {
+		for stage, d := range files[i].Metadata.stages() {
+			if *d > 0 {
+				durations[stage] = append(durations[stage], *d)
+			}
+		}
+	}
+
+	percentiles := map[string]StagePercentiles{}
+	for stage, ds := range durations {
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		// Nearest rank
+		rank := func(p int) int64 {
+			i := (p*len(ds)+99)/100 - 1
+			return int64(ds[i] / time.Millisecond)
+		}
+		percentiles[stage] = StagePercentiles{P50Ms: rank(50), P95Ms: rank(95), P99Ms: rank(99)}
+	}
+	return percentiles
+}
+
+// lastStageDurations are the stage percentiles of the most recent run, reported by Status
+var lastStageDurations map[string]StagePercentiles
+var lastStageDurationsMu sync.Mutex
+
+// ProcessingSummary is the JSON body returned by Main
+type ProcessingSummary struct {
+	// RunID tags the rows of this run when RunIDColumnEnv is set
+	RunID string `json:"runId"`
+
+	DryRun            bool               `json:"dryRun"`
+	DryRunExtractions []ExtractionResult `json:"dryRunExtractions,omitempty"`
+
+	// Files are the stage durations of each file, StageDurations aggregates them
+	Files          []FileTimings               `json:"files,omitempty"`
+	StageDurations map[string]StagePercentiles `json:"stageDurations,omitempty"`
+
+	// Cancelled is set when the request was cancelled mid-batch, NotStarted files were left for the next run
+	Cancelled  bool `json:"cancelled,omitempty"`
+	NotStarted int  `json:"notStarted,omitempty"`
+
+	// Failed files hit an error and were left in the Upload folder for the next run. Inconsistent
+	// files were recorded but couldn't be moved out of it, a later run skips them as duplicates.
+	Failed       int `json:"failed,omitempty"`
+	Inconsistent int `json:"inconsistent,omitempty"`
+
+	// OldestUploadAgeSeconds is the age of the oldest upload found in the Upload folder
+	OldestUploadAgeSeconds int64 `json:"oldestUploadAgeSeconds,omitempty"`
+}
+
+func writeSummary(w http.ResponseWriter, summary *ProcessingSummary) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		log.Printf("Unable to write summary: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package trimark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestStagePercentiles(t *testing.T) {
+	// timings gives a file the download durations, in ms
+	timings := func(ms ...int) []FileTimings {
+		var files []FileTimings
+		for _, d := range ms {
+			files = append(files, FileTimings{Metadata: ProcessingMetadata{DownloadDuration: time.Duration(d) * time.Millisecond}})
+		}
+		return files
+	}
+	hundred := make([]int, 100)
+	for i := range hundred {
+		hundred[i] = 100 - i
+	}
+
+	tests := []struct {
+		name  string
+		files []FileTimings
+		want  map[string]StagePercentiles
+	}{
+		{"no files", nil, map[string]StagePercentiles{}},
+		{"one file", timings(40), map[string]StagePercentiles{"download": {P50Ms: 40, P95Ms: 40, P99Ms: 40}}},
+		{"nearest rank", timings(30, 10, 20, 40), map[string]StagePercentiles{"download": {P50Ms: 20, P95Ms: 40, P99Ms: 40}}},
+		{"hundred files", timings(hundred...), map[string]StagePercentiles{"download": {P50Ms: 50, P95Ms: 95, P99Ms: 99}}},
+		{"skipped stages left out", timings(0, 10), map[string]StagePercentiles{"download": {P50Ms: 10, P95Ms: 10, P99Ms: 10}}},
+	}
+	for _, tt := range tests {
+		if got := stagePercentiles(tt.files); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: stagePercentiles = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMainReportsStageDurations(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "screenshot.png", MimeType: "image/png"}}))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetOCRText("screenshot.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+	fakeDrive.SetLatency(time.Millisecond)
+
+	w := httptest.NewRecorder()
+	Main(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Main responded %d: %s", w.Code, w.Body)
+	}
+	var summary ProcessingSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Files) != 1 {
+		t.Fatalf("Summary files = %+v, want 1", summary.Files)
+	}
+
+	m := summary.Files[0].Metadata
+	durations := map[string]time.Duration{
+		"download": m.DownloadDuration,
+		"crop":     m.CropDuration,
+		"insert":   m.UploadDuration,
+		"export":   m.OCRDuration,
+		"extract":  m.ExtractDuration,
+		"append":   m.SheetDuration,
+		"move":     m.MoveDuration,
+		"total":    m.TotalDuration,
+	}
+	for stage, d := range durations {
+		if d <= 0 {
+			t.Errorf("%s took %s, want a positive duration", stage, d)
+		}
+		if _, ok := summary.StageDurations[stage]; !ok {
+			t.Errorf("Summary has no %s percentiles", stage)
+		}
+	}
+
+	// Status reports the percentiles of the last run
+	w = httptest.NewRecorder()
+	Status(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var status struct {
+		StageDurations map[string]StagePercentiles `json:"stageDurations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(status.StageDurations, summary.StageDurations) {
+		t.Errorf("Status stageDurations = %v, want the run's %v", status.StageDurations, summary.StageDurations)
+	}
+}
+
+func TestMainProcessesOldestUploadsFirst(t *testing.T) {
+	now := time.Now()
+	uploaded := func(ago time.Duration) string { return now.Add(-ago).UTC().Format(time.RFC3339) }
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.Serial = true }),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "new", Title: "new.txt", MimeType: "text/plain", CreatedDate: uploaded(time.Hour)},
+			{Id: "oldest", Title: "oldest.txt", MimeType: "text/plain", CreatedDate: uploaded(72 * time.Hour)},
+			{Id: "old", Title: "old.txt", MimeType: "text/plain", CreatedDate: uploaded(30 * time.Hour)},
+		}))
+	for _, id := range []string{"new", "oldest", "old"} {
+		fakeDrive.SetContent(id, []byte(donationText("2020-06-18 12:34:56", "Pilot "+id, "1,000")))
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	w := httptest.NewRecorder()
+	Main(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Main responded %d: %s", w.Code, w.Body)
+	}
+	var summary ProcessingSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+
+	var order []interface{}
+	for _, row := range fakeSheets.Values(testSheetID, "Sheet1!A2:G") {
+		order = append(order, row[nameColumn])
+	}
+	if want := []interface{}{"Pilot oldest", "Pilot old", "Pilot new"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Rows were appended for %v, want %v", order, want)
+	}
+
+	if age := time.Duration(summary.OldestUploadAgeSeconds) * time.Second; age < 72*time.Hour || age > 73*time.Hour {
+		t.Errorf("OldestUploadAgeSeconds = %d, want 72 hours", summary.OldestUploadAgeSeconds)
+	}
+	for title, warned := range map[string]bool{"oldest.txt": true, "old.txt": true, "new.txt": false} {
+		if got := strings.Contains(logged.String(), "WARN: "+title+" was uploaded"); got != warned {
+			t.Errorf("Warned about %s = %v, want %v", title, got, warned)
+		}
+	}
+}
+
+func TestMainCancelledMidBatch(t *testing.T) {
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.Serial = true }),
+		WithPreloadedFiles([]*drive.File{
+			{Id: "upload-1", Title: "one.txt", MimeType: "text/plain"},
+			{Id: "upload-2", Title: "two.txt", MimeType: "text/plain"},
+			{Id: "upload-3", Title: "three.txt", MimeType: "text/plain"},
+		}))
+	for i, id := range []string{"upload-1", "upload-2", "upload-3"} {
+		fakeDrive.SetContent(id, []byte(donationText("2020-06-18 12:34:56", fmt.Sprintf("Pilot %d", i+1), "1,000")))
+	}
+
+	// The caller goes away while the first file is being recorded
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fakeSheets.RewriteAppends(func(values [][]interface{}) [][]interface{} {
+		cancel()
+		return values
+	})
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	w := httptest.NewRecorder()
+	Main(w, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	// The file in flight finishes to a consistent state, the rest aren't started
+	if rows := fakeSheets.Values(testSheetID, "Sheet1!A2:G"); len(rows) != 1 || rows[0][nameColumn] != "Pilot 1" {
+		t.Errorf("Report rows = %v, want only the file in flight recorded", rows)
+	}
+	if f := fakeDrive.File("upload-1"); !inFolder(f, testProcessedFolderID) {
+		t.Error("The file in flight was not moved to Processed")
+	}
+	for _, id := range []string{"upload-2", "upload-3"} {
+		if f := fakeDrive.File(id); !inFolder(f, testUploadFolderID) {
+			t.Errorf("%s was moved, want it left for the next run", id)
+		}
+	}
+
+	if w.Body.Len() != 0 {
+		t.Errorf("Summary was written to the cancelled request: %s", w.Body)
+	}
+	for _, want := range []string{"request cancelled", "2 were left for the next run", `"cancelled":true`, `"notStarted":2`} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("Logged %q, want %q", logged.String(), want)
+		}
+	}
+}
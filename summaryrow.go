@@ -0,0 +1,115 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// Placements of the report summary row, set with SummaryRowEnv
+const (
+	SummaryRowNone   = "none"
+	SummaryRowTop    = "top"
+	SummaryRowBottom = "bottom"
+)
+
+// summaryRowLabel marks the summary row in the ID column, which never holds a Drive file ID like it
+const summaryRowLabel = "Total"
+
+// setReportRanges moves the header and data ranges down a row when the summary row sits above the header
+func setReportRanges(policy string) {
+	if policy == SummaryRowTop {
+		headerRowRange = "Sheet1!2:2"
+		dataRange = "Sheet1!A3:H"
+		return
+	}
+	headerRowRange = "Sheet1!1:1"
+	dataRange = "Sheet1!A2:H"
+}
+
+// headerRowIndex is the zero-indexed row of the header
+func headerRowIndex() int64 {
+	if config.SummaryRowPolicy == SummaryRowTop {
+		return 1
+	}
+	return 0
+}
+
+// isSummaryRow reports whether a sheet row is the summary row rather than a donation
+func isSummaryRow(row []interface{}) bool {
+	return len(row) > 0 && fmt.Sprint(row[0]) == summaryRowLabel
+}
+
+// addSummaryRow adds a row totalling the Amount column to a tab, placed by SummaryRowEnv.
+// A top row is inserted above the header, shifting everything down. A bottom row is left one
+// blank row below the data, so appends see the data as a table of its own and insert above it.
+// Nothing is written when the tab already has a summary row.
+func addSummaryRow(ctx context.Context, spreadsheetID string, tabName string) error {
+	if config.SummaryRowPolicy == SummaryRowNone {
+		return nil
+	}
+
+	vr, err := sheetService.Spreadsheets.Values.Get(spreadsheetID, tabName+"!A:A").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	for _, row := range vr.Values {
+		if isSummaryRow(row) {
+			return nil
+		}
+	}
+
+	tabID, err := sheetTabID(ctx, spreadsheetID, tabName)
+	if err != nil {
+		return err
+	}
+
+	batch := &sheets.BatchUpdateSpreadsheetRequest{}
+	var rowIndex int64
+	var formula string
+	switch config.SummaryRowPolicy {
+	case SummaryRowTop:
+		batch.Requests = append(batch.Requests, &sheets.Request{InsertDimension: &sheets.InsertDimensionRequest{
+			Range: &sheets.DimensionRange{
+				SheetId:         tabID,
+				Dimension:       "ROWS",
+				StartIndex:      0,
+				EndIndex:        1,
+				ForceSendFields: []string{"SheetId", "StartIndex"},
+			},
+		}})
+		formula = "=SUM(E3:E)"
+	case SummaryRowBottom:
+		rowIndex = int64(len(vr.Values)) + 1
+		// Everything between the header and the blank row above, which rows appended later stay inside
+		formula = `=SUM(INDIRECT("E2:E"&(ROW()-1)))`
+	}
+
+	cells := make([]*sheets.CellData, amountColumn+1)
+	for i := range cells {
+		cells[i] = &sheets.CellData{}
+	}
+	label := summaryRowLabel
+	cells[0].UserEnteredValue = &sheets.ExtendedValue{StringValue: &label}
+	cells[amountColumn].UserEnteredValue = &sheets.ExtendedValue{FormulaValue: &formula}
+
+	batch.Requests = append(batch.Requests, &sheets.Request{UpdateCells: &sheets.UpdateCellsRequest{
+		Start: &sheets.GridCoordinate{
+			SheetId:         tabID,
+			RowIndex:        rowIndex,
+			ColumnIndex:     0,
+			ForceSendFields: []string{"SheetId", "RowIndex", "ColumnIndex"},
+		},
+		Rows:   []*sheets.RowData{{Values: cells}},
+		Fields: "userEnteredValue",
+	}})
+
+	_, err = sheetService.Spreadsheets.BatchUpdate(spreadsheetID, batch).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: added a %s summary row to %s", config.SummaryRowPolicy, tabName)
+	return nil
+}
@@ -0,0 +1,70 @@
+package trimark
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestSummaryRowTotalsAppendedRows(t *testing.T) {
+	tests := []struct {
+		policy string
+		// The rows of the report, by their ID column, and the Total row's amount
+		want  []string
+		total string
+	}{
+		{SummaryRowBottom, []string{"ID", "row 2", "row 3", "", summaryRowLabel}, "3000"},
+		{SummaryRowTop, []string{summaryRowLabel, "ID", "row 3", "row 4"}, "3000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+				WithConfig(func(c *Config) { c.SummaryRowPolicy = tt.policy }),
+				WithPreloadedFiles([]*drive.File{
+					{Id: "upload-1", Title: "one.txt", MimeType: "text/plain"},
+					{Id: "upload-2", Title: "two.txt", MimeType: "text/plain"},
+				}))
+			fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+			fakeDrive.SetContent("upload-2", []byte(donationText("2020-06-19 12:34:56", "Pilot Two", "2,000")))
+
+			spreadsheetID, err := prepareSheet(testReportFolderID, "New Report")
+			if err != nil {
+				t.Fatal(err)
+			}
+			SheetID = spreadsheetID
+			if err := ensureSheetHeader(); err != nil {
+				t.Fatal(err)
+			}
+			config.Serial = true
+			for _, r := range runBatch(t, sc) {
+				if r.err != nil || r.result.RowID == "" {
+					t.Fatalf("processBatch result = %+v, %v, want the upload recorded", r.result, r.err)
+				}
+			}
+
+			rows := fakeSheets.Values(spreadsheetID, "Sheet1")
+			var got []string
+			total := ""
+			for i, row := range rows {
+				id := ""
+				if len(row) > 0 {
+					id = fmt.Sprint(row[0])
+				}
+				switch {
+				case id == summaryRowLabel:
+					total = fmt.Sprint(row[amountColumn])
+				case id != "" && id != "ID":
+					id = fmt.Sprintf("row %d", i+1)
+				}
+				got = append(got, id)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Rows = %q, want %q", got, tt.want)
+			}
+			if total != tt.total {
+				t.Errorf("Total = %q, want %q", total, tt.total)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package trimark
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/cloudtrace/v2"
+	"google.golang.org/api/option"
+)
+
+// traceFlushTimeout bounds exporting the spans of a run once it has finished
+const traceFlushTimeout = 10 * time.Second
+
+// tracer starts the spans of runs, files and stages. They are dropped unless setupTracing installed
+// an exporter.
+var tracer = otel.Tracer("github.com/Bourne-ID/trimark-demo")
+
+// tracerProvider exports the spans to Cloud Trace, nil when TraceProjectEnv is unset
+var tracerProvider *sdktrace.TracerProvider
+
+// setupTracing exports spans to the Cloud Trace of TraceProjectEnv
+func setupTracing(jsonPath string) error {
+	if config.TraceProject == "" {
+		return nil
+	}
+	ctx := context.Background()
+	client, err := newHTTPClient(ctx, jsonPath, quotaAPITrace)
+	if err != nil {
+		return err
+	}
+	service, err := cloudtrace.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return err
+	}
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(&cloudTraceExporter{service: service, project: config.TraceProject}))
+	otel.SetTracerProvider(tracerProvider)
+	return nil
+}
+
+// flushTraces exports the spans still batched. An instance may be frozen once it has responded,
+// so a run's spans are sent before it does.
+func flushTraces() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancel()
+	if err := tracerProvider.ForceFlush(ctx); err != nil {
+		log.Printf("WARN: unable to export traces: %v", err)
+	}
+}
+
+// endSpan ends a span, marking it failed by err
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// cloudTraceExporter writes spans with the Cloud Trace API
+type cloudTraceExporter struct {
+	service *cloudtrace.Service
+	project string
+}
+
+func (e *cloudTraceExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	req := &cloudtrace.BatchWriteSpansRequest{}
+	for _, s := range spans {
+		req.Spans = append(req.Spans, cloudTraceSpan(e.project, s))
+	}
+	_, err := e.service.Projects.Traces.BatchWrite("projects/"+e.project, req).Context(ctx).Do()
+	return err
+}
+
+func (e *cloudTraceExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// cloudTraceSpan converts a finished span to the Cloud Trace representation
+func cloudTraceSpan(project string, s sdktrace.ReadOnlySpan) *cloudtrace.Span {
+	sc := s.SpanContext()
+	span := &cloudtrace.Span{
+		Name:        fmt.Sprintf("projects/%s/traces/%s/spans/%s", project, sc.TraceID(), sc.SpanID()),
+		SpanId:      sc.SpanID().String(),
+		DisplayName: &cloudtrace.TruncatableString{Value: s.Name()},
+		StartTime:   s.StartTime().UTC().Format(time.RFC3339Nano),
+		EndTime:     s.EndTime().UTC().Format(time.RFC3339Nano),
+	}
+	if s.Parent().IsValid() {
+		span.ParentSpanId = s.Parent().SpanID().String()
+	}
+
+	if attrs := s.Attributes(); len(attrs) > 0 {
+		span.Attributes = &cloudtrace.Attributes{AttributeMap: map[string]cloudtrace.AttributeValue{}}
+		for _, kv := range attrs {
+			var v cloudtrace.AttributeValue
+			switch kv.Value.Type() {
+			case attribute.BOOL:
+				v.BoolValue = kv.Value.AsBool()
+			case attribute.INT64:
+				v.IntValue = kv.Value.AsInt64()
+			default:
+				v.StringValue = &cloudtrace.TruncatableString{Value: kv.Value.Emit()}
+			}
+			span.Attributes.AttributeMap[string(kv.Key)] = v
+		}
+	}
+
+	// Cloud Trace uses the google.rpc.Code of the span's outcome, 2 is UNKNOWN
+	if s.Status().Code == codes.Error {
+		span.Status = &cloudtrace.Status{Code: 2, Message: s.Status().Description}
+	}
+	return span
+}
@@ -0,0 +1,109 @@
+package trimark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans has tracer export to an in-memory exporter until the returned func restores it
+func recordSpans() (*tracetest.InMemoryExporter, func()) {
+	exporter := tracetest.NewInMemoryExporter()
+	saved := tracer
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+	return exporter, func() { tracer = saved }
+}
+
+func TestRecordStageSpans(t *testing.T) {
+	exporter, restore := recordSpans()
+	defer restore()
+
+	ctx, file := tracer.Start(context.Background(), "file")
+	var result ExtractionResult
+	start := time.Now().Add(-time.Second)
+	for _, stage := range []string{"download", "crop", "export", "append", "total", "unknown"} {
+		result.recordStage(ctx, stage, start)
+	}
+	file.End()
+
+	spans := exporter.GetSpans()
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name)
+		if s.Name == "file" {
+			continue
+		}
+		if s.Parent.SpanID() != file.SpanContext().SpanID() {
+			t.Errorf("Span %s isn't a child of the file's span", s.Name)
+		}
+		if !s.StartTime.Equal(start) {
+			t.Errorf("Span %s starts at %s, want %s", s.Name, s.StartTime, start)
+		}
+		if d := s.EndTime.Sub(s.StartTime); d < time.Second {
+			t.Errorf("Span %s lasted %s, want at least 1s", s.Name, d)
+		}
+	}
+	// The total is the file's span, and unknown stages aren't recorded
+	want := []string{"download", "crop", "export", "append", "file"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("Spans = %v, want %v", names, want)
+	}
+	if result.Metadata.DownloadDuration < time.Second || result.Metadata.TotalDuration < time.Second {
+		t.Errorf("Stage durations weren't recorded: %+v", result.Metadata)
+	}
+}
+
+func TestCloudTraceSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	ctx, run := tp.Tracer("test").Start(context.Background(), "Main")
+	_, file := tp.Tracer("test").Start(ctx, "file", trace.WithAttributes(
+		attribute.String("file.name", "donation.png"),
+		attribute.Int("attempt", 2),
+		attribute.Bool("dry_run", true),
+		attribute.Float64("ratio", 0.5),
+	))
+	endSpan(file, errors.New("Quantity Not Found"))
+	endSpan(run, nil)
+
+	spans := exporter.GetSpans().Snapshots()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans exported, want 2", len(spans))
+	}
+	fileSpan, runSpan := spans[0], spans[1]
+
+	got := cloudTraceSpan("project", fileSpan)
+	sc := fileSpan.SpanContext()
+	if want := fmt.Sprintf("projects/project/traces/%s/spans/%s", sc.TraceID(), sc.SpanID()); got.Name != want {
+		t.Errorf("Name = %q, want %q", got.Name, want)
+	}
+	if got.SpanId != sc.SpanID().String() || got.ParentSpanId != runSpan.SpanContext().SpanID().String() {
+		t.Errorf("SpanId, ParentSpanId = %s, %s", got.SpanId, got.ParentSpanId)
+	}
+	if got.DisplayName.Value != "file" {
+		t.Errorf("DisplayName = %q, want file", got.DisplayName.Value)
+	}
+	if got.StartTime != fileSpan.StartTime().UTC().Format(time.RFC3339Nano) || got.EndTime != fileSpan.EndTime().UTC().Format(time.RFC3339Nano) {
+		t.Errorf("StartTime, EndTime = %s, %s", got.StartTime, got.EndTime)
+	}
+	attrs := got.Attributes.AttributeMap
+	if attrs["file.name"].StringValue.Value != "donation.png" || attrs["attempt"].IntValue != 2 || !attrs["dry_run"].BoolValue || attrs["ratio"].StringValue.Value != "0.5" {
+		t.Errorf("Attributes = %+v", attrs)
+	}
+	if got.Status == nil || got.Status.Code != 2 || got.Status.Message != "Quantity Not Found" {
+		t.Errorf("Status = %+v, want UNKNOWN: Quantity Not Found", got.Status)
+	}
+
+	root := cloudTraceSpan("project", runSpan)
+	if root.ParentSpanId != "" || root.Status != nil || root.Attributes != nil {
+		t.Errorf("Root span = %+v, want no parent, status or attributes", root)
+	}
+}
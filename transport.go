@@ -0,0 +1,71 @@
+package trimark
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"google.golang.org/api/cloudtrace/v2"
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/firestore/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	"google.golang.org/api/storage/v1"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// APIWarningHeader is the response header Google uses to announce upcoming API changes
+const APIWarningHeader = "X-Goog-Api-Warning"
+
+// apiDeprecationWarnings counts the API responses which carried an APIWarningHeader
+var apiDeprecationWarnings int64
+
+// apiWarningTransport logs deprecation warnings returned by the Google APIs, and keeps the
+// quota of API up to date in Quota
+type apiWarningTransport struct {
+	Base   http.RoundTripper
+	Logger *log.Logger
+	Quota  *QuotaMonitor
+	API    string
+}
+
+func (t *apiWarningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if t.Quota != nil {
+		t.Quota.update(t.API, resp)
+	}
+
+	warnings := resp.Header[http.CanonicalHeaderKey(APIWarningHeader)]
+	if len(warnings) > 0 {
+		atomic.AddInt64(&apiDeprecationWarnings, 1)
+		for _, warning := range warnings {
+			t.Logger.Printf("WARN: %s %s returned %s: %s", req.Method, req.URL.Path, APIWarningHeader, warning)
+		}
+	}
+	return resp, nil
+}
+
+// newHTTPClient builds an authenticated client for the Drive, Sheets, Cloud Storage, Firestore or
+// Cloud Trace service, one of the quotaAPI names, which its quota is tracked under
+func newHTTPClient(ctx context.Context, jsonPath string, api string) (*http.Client, error) {
+	base, err := htransport.NewTransport(ctx, http.DefaultTransport,
+		option.WithCredentialsFile(jsonPath),
+		option.WithScopes(drive.DriveScope, sheets.SpreadsheetsScope, storage.DevstorageReadWriteScope, firestore.DatastoreScope, cloudtrace.TraceAppendScope))
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &apiWarningTransport{
+			Base:   base,
+			Logger: log.New(os.Stderr, "", log.LstdFlags),
+			Quota:  quotaMonitor,
+			API:    api,
+		},
+	}, nil
+}
@@ -0,0 +1,40 @@
+package trimark
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAPIWarningTransport(t *testing.T) {
+	NewTestServiceContext(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deprecated" {
+			w.Header().Add(APIWarningHeader, "299 - \"Drive API v2 is deprecated\"")
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	var logged bytes.Buffer
+	client := &http.Client{Transport: &apiWarningTransport{Base: http.DefaultTransport, Logger: log.New(&logged, "", 0)}}
+	for _, path := range []string{"/current", "/deprecated", "/current"} {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if n := atomic.LoadInt64(&apiDeprecationWarnings); n != 1 {
+		t.Errorf("apiDeprecationWarnings = %d, want 1", n)
+	}
+	if got := logged.String(); !strings.Contains(got, "GET /deprecated") || !strings.Contains(got, "Drive API v2 is deprecated") {
+		t.Errorf("Logged %q, want the warning and the request it came with", got)
+	}
+}
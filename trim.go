@@ -0,0 +1,75 @@
+package trimark
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// TrimEdge is how much to trim off one edge of a screenshot, in pixels or as a ratio of its size
+type TrimEdge struct {
+	Pixels int
+	Ratio  float64
+}
+
+// parseTrimEdge reads a whole number of pixels, such as "96", or a ratio, such as "0.05" or "5%"
+func parseTrimEdge(v string) (TrimEdge, error) {
+	if strings.HasSuffix(v, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+		if err != nil || percent < 0 || percent >= 100 {
+			return TrimEdge{}, fmt.Errorf("must be a percentage from 0 up to 100, got %q", v)
+		}
+		return TrimEdge{Ratio: percent / 100}, nil
+	}
+	if strings.Contains(v, ".") {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil || ratio < 0 || ratio >= 1 {
+			return TrimEdge{}, fmt.Errorf("must be a ratio from 0 up to 1, got %q", v)
+		}
+		return TrimEdge{Ratio: ratio}, nil
+	}
+	pixels, err := strconv.Atoi(v)
+	if err != nil || pixels < 0 {
+		return TrimEdge{}, fmt.Errorf("must be a number of pixels, got %q", v)
+	}
+	return TrimEdge{Pixels: pixels}, nil
+}
+
+// of returns the pixels to trim from an edge of an image size pixels across
+func (e TrimEdge) of(size int) int {
+	if e.Ratio > 0 {
+		return int(e.Ratio * float64(size))
+	}
+	return e.Pixels
+}
+
+// TrimConfig is the border trimmed off screenshots before cropping, such as phone status bars
+type TrimConfig struct {
+	Top, Bottom, Left, Right TrimEdge
+}
+
+// Enabled reports whether any edge is trimmed
+func (t TrimConfig) Enabled() bool {
+	return t != TrimConfig{}
+}
+
+// trimBorders returns the image inside the trimmed edges, copied so its bounds start at 0,0
+// as cutter expects
+func trimBorders(img image.Image, t TrimConfig) (image.Image, error) {
+	b := img.Bounds()
+	r := image.Rect(
+		b.Min.X+t.Left.of(b.Dx()),
+		b.Min.Y+t.Top.of(b.Dy()),
+		b.Max.X-t.Right.of(b.Dx()),
+		b.Max.Y-t.Bottom.of(b.Dy()),
+	)
+	if r.Dx() <= 0 || r.Dy() <= 0 {
+		return nil, fmt.Errorf("trimming %v leaves nothing of a %dx%d image", t, b.Dx(), b.Dy())
+	}
+
+	trimmed := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(trimmed, trimmed.Bounds(), img, r.Min, draw.Src)
+	return trimmed, nil
+}
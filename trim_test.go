@@ -0,0 +1,30 @@
+package trimark
+
+import "testing"
+
+func TestParseTrimEdge(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    TrimEdge
+		wantErr bool
+	}{
+		{v: "96", want: TrimEdge{Pixels: 96}},
+		{v: "0", want: TrimEdge{}},
+		{v: "0.05", want: TrimEdge{Ratio: 0.05}},
+		{v: "5%", want: TrimEdge{Ratio: 0.05}},
+		{v: "-1", wantErr: true},
+		{v: "1.5", wantErr: true},
+		{v: "100%", wantErr: true},
+		{v: "top", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTrimEdge(tt.v)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTrimEdge(%q) error = %v, wantErr %v", tt.v, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseTrimEdge(%q) = %+v, want %+v", tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package trimark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// ErrWriteVerifyFailed is returned when a row read back from the report doesn't match what was appended
+var ErrWriteVerifyFailed = errors.New("Write verification failed")
+
+// ErrSheetWriteNotConfirmed is returned when an append reported success but its row doesn't hold the donation
+var ErrSheetWriteNotConfirmed = errors.New("Sheet write not confirmed")
+
+// ErrSheetReadOnly is returned when the report sheet, or the range appended to, is protected from the service account
+var ErrSheetReadOnly = errors.New("Report sheet is read-only")
+
+// isPermissionDenied reports whether an API call was refused for a lack of access, rather than
+// a rate limit, which is also a 403
+func isPermissionDenied(err error) bool {
+	e, ok := err.(*googleapi.Error)
+	if !ok || e.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range e.Errors {
+		if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyRowWritten reads back an appended row, checking its ID column holds the donation's checksum.
+// Sheets has been seen to accept appends to protected sheets without writing anything.
+func verifyRowWritten(ctx context.Context, spreadsheetID, rowRange string, expectedChecksum string) error {
+	vr, err := sheetService.Spreadsheets.Values.Get(spreadsheetID, rowRange).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to read back %s: %v", rowRange, err)
+	}
+
+	got := ""
+	if len(vr.Values) > 0 && len(vr.Values[0]) > 0 {
+		got = fmt.Sprint(vr.Values[0][0])
+	}
+	if got != expectedChecksum {
+		log.Printf("ERROR: donation %s was appended to %s but the row holds %q, it may not have been recorded", expectedChecksum, rowRange, got)
+		return fmt.Errorf("%w: %s holds %q", ErrSheetWriteNotConfirmed, rowRange, got)
+	}
+	return nil
+}
+
+// Columns read back by verifyAppendedRow, zero-indexed. The dates are left out as the sheet
+// parses and reformats them.
+const (
+	idColumn   = 0
+	nameColumn = 3
+)
+
+// verifyAppendedRow reads back a row of a report spreadsheet and compares the checksum, name and
+// amount with the values appended to it
+func verifyAppendedRow(ctx context.Context, spreadsheetID string, row int64, want []interface{}) error {
+	rowRange := fmt.Sprintf("Sheet1!%d:%d", row, row)
+	vr, err := sheetService.Spreadsheets.Values.Get(spreadsheetID, rowRange).ValueRenderOption("UNFORMATTED_VALUE").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to read back %s: %v", rowRange, err)
+	}
+	if len(vr.Values) != 1 {
+		return fmt.Errorf("%w: %s is empty", ErrWriteVerifyFailed, rowRange)
+	}
+	got := vr.Values[0]
+
+	cell := func(values []interface{}, i int) string {
+		if i < len(values) {
+			return fmt.Sprint(values[i])
+		}
+		return ""
+	}
+	for _, col := range []int{idColumn, nameColumn} {
+		if cell(got, col) != cell(want, col) {
+			return fmt.Errorf("%w: %s column %d is %q, wrote %q", ErrWriteVerifyFailed, rowRange, col, cell(got, col), cell(want, col))
+		}
+	}
+
+	gotAmount, gotErr := strconv.ParseFloat(cell(got, amountColumn), 64)
+	wantAmount, wantErr := strconv.ParseFloat(strings.Replace(cell(want, amountColumn), ",", "", -1), 64)
+	if gotErr != nil || wantErr != nil || gotAmount != wantAmount {
+		return fmt.Errorf("%w: %s amount is %q, wrote %q", ErrWriteVerifyFailed, rowRange, cell(got, amountColumn), cell(want, amountColumn))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package trimark
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestVerifyWriteMismatchGoesToFailed(t *testing.T) {
+	sc, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "screenshot.png", MimeType: "image/png"}}),
+		WithConfig(func(c *Config) { c.VerifyWrite = true }))
+	fakeDrive.SetContent("upload-1", testPNG(t))
+	fakeDrive.SetOCRText("screenshot.png", donationText("2020-06-18 12:34:56", "Pilot One", "1,000"))
+	fakeSheets.RewriteAppends(func(values [][]interface{}) [][]interface{} {
+		row := append([]interface{}(nil), values[0]...)
+		row[nameColumn] = "Someone Else"
+		return [][]interface{}{row}
+	})
+
+	results := runBatch(t, sc)
+	if len(results) != 1 {
+		t.Fatalf("processBatch results = %+v, want 1", results)
+	}
+	if got := results[0].result.Error; !strings.Contains(got, ErrWriteVerifyFailed.Error()) || !strings.Contains(got, "Someone Else") {
+		t.Errorf("Result error = %q, want %v naming the cell read back", got, ErrWriteVerifyFailed)
+	}
+	if results[0].result.RowID != "" {
+		t.Errorf("RowID = %q, want none for an unverified row", results[0].result.RowID)
+	}
+	if f := fakeDrive.File("upload-1"); !inFolder(f, testFailedFolderID) {
+		t.Error("Upload was not moved to Failed")
+	}
+	for _, f := range fakeDrive.FilesIn(testProcessedFolderID) {
+		t.Errorf("%s was moved to Processed", f.Title)
+	}
+
+	// A read-back which matches leaves the upload in Processed
+	fakeSheets.RewriteAppends(nil)
+	fakeDrive.AddFile(&drive.File{Id: "upload-2", Title: "screenshot2.png", MimeType: "image/png", Parents: parentRefs(testUploadFolderID)}, testPNG(t))
+	fakeDrive.SetOCRText("screenshot2.png", donationText("2020-06-19 12:34:56", "Pilot Two", "2,000"))
+	results = runBatch(t, sc)
+	if len(results) != 1 || results[0].err != nil || results[0].result.Error != "" {
+		t.Fatalf("processBatch results = %+v, want a verified row", results)
+	}
+	if f := fakeDrive.File("upload-2"); !inFolder(f, testProcessedFolderID) {
+		t.Error("Upload was not moved to Processed")
+	}
+}
+
+func TestVerifyRowWritten(t *testing.T) {
+	tests := []struct {
+		name    string
+		verify  bool
+		rewrite func(values [][]interface{}) [][]interface{}
+		wantErr error
+	}{
+		{"written", true, nil, nil},
+		{"nothing written", true, func([][]interface{}) [][]interface{} { return nil }, ErrSheetWriteNotConfirmed},
+		{"another checksum", true, func(values [][]interface{}) [][]interface{} {
+			row := append([]interface{}(nil), values[0]...)
+			row[idColumn] = "0123456789abcdef0123456789abcdef"
+			return [][]interface{}{row}
+		}, ErrSheetWriteNotConfirmed},
+		{"not verified", false, func([][]interface{}) [][]interface{} { return nil }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, fakeSheets := NewTestServiceContext(t, WithConfig(func(c *Config) { c.VerifySheetWrites = tt.verify }))
+			fakeSheets.RewriteAppends(tt.rewrite)
+
+			_, err := appendDataToSheet(context.Background(), newRecord("2020-06-18 12:34:56", "Pilot One", "1,000"), rowExtras{})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("appendDataToSheet error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package trimark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// warmupDone is closed once the folders and report sheet have been set up. warmupErr is
+// written before it closes, so it may only be read afterwards.
+var (
+	warmupDone = make(chan struct{})
+	warmupErr  error
+)
+
+// warmup finds or creates the folders and the report sheet, the Drive calls every handler relies on,
+// then runs the OnSetupComplete hooks
+func warmup() error {
+	info, err := setupReport()
+	if err != nil {
+		return err
+	}
+	return runSetupHooks(context.Background(), info)
+}
+
+// setupReport sets up the folders and report sheet, returning their IDs
+func setupReport() (SetupInfo, error) {
+	folderValidationMu.Lock()
+	defer folderValidationMu.Unlock()
+	folderIDsMu.Lock()
+	defer folderIDsMu.Unlock()
+
+	report, err := setupFolders(config.FolderID)
+	if errors.Is(err, ErrInvalidFolderID) {
+		return SetupInfo{}, fmt.Errorf("%s: %w", FolderIDEnv, err)
+	}
+	log.Printf("INFO: folder setup found %d and created %d folders: %v", report.Found, report.Created, report.FolderIDs)
+	lastFolderValidation = time.Now()
+
+	if err := setupSheet(ReportFolderID); err != nil {
+		return SetupInfo{}, fmt.Errorf("Unable to set up %s: %v", SheetName, err)
+	}
+	return currentSetupInfo(), nil
+}
+
+// startWarmup runs warmup in the background with WarmupAsyncEnv, otherwise before returning
+func startWarmup() {
+	if !config.WarmupAsync {
+		warmupErr = warmup()
+		close(warmupDone)
+		if errors.Is(warmupErr, ErrInvalidFolderID) {
+			log.Fatalf("%v", warmupErr)
+		}
+		if warmupErr != nil {
+			log.Printf("WARN: %v", warmupErr)
+			// Setting up the sheet has always been best effort, the header check in Main retries it
+			warmupErr = nil
+		}
+		return
+	}
+
+	go func() {
+		start := time.Now()
+		warmupErr = warmup()
+		close(warmupDone)
+		if warmupErr != nil {
+			log.Printf("ERROR: warmup failed: %v", warmupErr)
+			return
+		}
+		log.Printf("INFO: warmup finished in %s", time.Since(start).Round(time.Millisecond))
+	}()
+}
+
+// WarmupComplete reports whether the folders and report sheet have been set up
+func WarmupComplete() bool {
+	select {
+	case <-warmupDone:
+		return true
+	default:
+		return false
+	}
+}
+
+// WarmupError is the error warmup finished with, or nil while it is still running
+func WarmupError() error {
+	if !WarmupComplete() {
+		return nil
+	}
+	return warmupErr
+}
+
+// awaitWarmup waits for warmup to finish, returning its error
+func awaitWarmup(ctx context.Context) error {
+	select {
+	case <-warmupDone:
+		return warmupErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// requireWarmup waits for warmup, writing a 503 and returning false when it failed. Every
+// handler reading the folder IDs calls it first, as they're unset until warmup finishes.
+func requireWarmup(w http.ResponseWriter, r *http.Request) bool {
+	if err := awaitWarmup(r.Context()); err != nil {
+		log.Printf("ERROR: not serving %s, warmup failed: %v", r.URL.Path, err)
+		http.Error(w, "Service is not ready", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package trimark
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// restartWarmup starts warmup in the background again, as a cold start with WarmupAsyncEnv
+func restartWarmup(t *testing.T) {
+	t.Helper()
+	UploadFolderID, ProcessedFolderID, FailedFolderID, ReportFolderID, SheetID = "", "", "", "", ""
+	config.WarmupAsync = true
+	warmupDone = make(chan struct{})
+	startWarmup()
+	// Warmup writes the package state the next test resets
+	t.Cleanup(func() { <-warmupDone })
+}
+
+func TestAsyncWarmup(t *testing.T) {
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "one.txt", MimeType: "text/plain"}}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	fakeDrive.SetLatency(20 * time.Millisecond)
+
+	restartWarmup(t)
+	if WarmupComplete() {
+		t.Fatal("WarmupComplete before the folders were listed")
+	}
+
+	// Main waits for warmup rather than failing
+	w := httptest.NewRecorder()
+	Main(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Main responded %d: %s", w.Code, w.Body)
+	}
+	if !WarmupComplete() || WarmupError() != nil {
+		t.Errorf("WarmupComplete, WarmupError = %v, %v after Main, want true, nil", WarmupComplete(), WarmupError())
+	}
+	if UploadFolderID != testUploadFolderID || ReportFolderID != testReportFolderID || SheetID != testSheetID {
+		t.Errorf("Warmup found %s, %s and %s, want the existing folders and sheet", UploadFolderID, ReportFolderID, SheetID)
+	}
+	if rows := fakeSheets.Values(testSheetID, "Sheet1!A2:G"); len(rows) != 1 {
+		t.Errorf("Report rows = %v, want the upload recorded", rows)
+	}
+
+	w = httptest.NewRecorder()
+	Status(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var status StatusReport
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || !status.WarmupComplete {
+		t.Errorf("Status = %s, want warmup complete", w.Body)
+	}
+}
+
+func TestAsyncWarmupError(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t)
+	fakeDrive.Fail(http.MethodPost, "/drive/v2/files", &googleapi.Error{Code: http.StatusInternalServerError, Message: "Backend Error"})
+	// The folders are missing, and can't be created
+	for _, id := range []string{testUploadFolderID, testProcessedFolderID, testFailedFolderID, testReportFolderID} {
+		if err := driveService.Files.Delete(id).Do(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restartWarmup(t)
+	<-warmupDone
+	if WarmupError() == nil {
+		t.Fatal("WarmupError = nil, want the failed setup")
+	}
+
+	w := httptest.NewRecorder()
+	Main(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Main responded %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHandlersRequireWarmup(t *testing.T) {
+	NewTestServiceContext(t, WithConfig(func(c *Config) {
+		c.AdminToken = "secret"
+		c.AllowReset = true
+		c.WatchAddress = "https://example.com/watch/notify"
+		c.WatchToken = "watch-secret"
+	}))
+	warmupErr = errors.New("Unable to set up ISK Import Report")
+
+	handlers := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+	}{
+		{"Ingest", Ingest, "/ingest"},
+		{"Rebuild", Rebuild, "/rebuild"},
+		{"HandleExport", HandleExport, "/export"},
+		{"HandleReset", HandleReset, "/admin/reset?confirm=" + ResetConfirmation},
+		{"HandleCleanFailed", HandleCleanFailed, "/cleanup/failed?retentionDays=7"},
+		{"HandleAnnualArchive", HandleAnnualArchive, "/archive?year=2020"},
+		{"HandleMonthlyReport", HandleMonthlyReport, "/report"},
+		{"Watch", Watch, "/watch"},
+	}
+	for _, h := range handlers {
+		r := httptest.NewRequest(http.MethodPost, h.target, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.handler(w, r)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s responded %d after warmup failed, want %d", h.name, w.Code, http.StatusServiceUnavailable)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package trimark
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/drive/v2"
+)
+
+// watchChannel is the push notification channel registered for the Upload folder,
+// kept so the next Watch call can stop it when renewing
+var watchChannel *drive.Channel
+
+var watchMu sync.Mutex
+
+// watchRunning is set while a notification is processing the Upload folder. watchRerun is set
+// by notifications arriving meanwhile, so the folder is processed once more when it finishes
+// rather than by overlapping runs.
+var (
+	watchRunMu   sync.Mutex
+	watchRunning bool
+	watchRerun   bool
+)
+
+// watchNotification is the part of a Drive push notification we act on, Drive sends it as headers
+type watchNotification struct {
+	ChannelID     string
+	Token         string
+	ResourceID    string
+	ResourceState string
+	Changed       []string
+}
+
+// parseWatchNotification decodes the X-Goog-* headers of a Drive push notification
+func parseWatchNotification(h http.Header) watchNotification {
+	n := watchNotification{
+		ChannelID:     h.Get("X-Goog-Channel-ID"),
+		Token:         h.Get("X-Goog-Channel-Token"),
+		ResourceID:    h.Get("X-Goog-Resource-ID"),
+		ResourceState: h.Get("X-Goog-Resource-State"),
+	}
+	if changed := h.Get("X-Goog-Changed"); changed != "" {
+		for _, c := range strings.Split(changed, ",") {
+			n.Changed = append(n.Changed, strings.TrimSpace(c))
+		}
+	}
+	return n
+}
+
+// childrenChanged reports whether files were added to or removed from the watched folder
+func (n watchNotification) childrenChanged() bool {
+	for _, c := range n.Changed {
+		if c == "children" {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch registers a Drive push notification channel on the Upload folder, replacing any
+// channel this instance registered before. Drive expires channels, so call it periodically.
+func Watch(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+
+	if config.WatchAddress == "" || config.WatchToken == "" {
+		http.Error(w, fmt.Sprintf("%s and %s are required", WatchAddressEnv, WatchTokenEnv), http.StatusPreconditionFailed)
+		return
+	}
+
+	if !requireWarmup(w, r) {
+		return
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("Unable to generate a channel ID: %v", err)
+		http.Error(w, "Unable to watch", http.StatusInternalServerError)
+		return
+	}
+
+	channel, err := driveService.Files.Watch(snapshotFolders().Upload, &drive.Channel{
+		Id:      hex.EncodeToString(id),
+		Type:    "web_hook",
+		Address: config.WatchAddress,
+		Token:   config.WatchToken,
+	}).Context(r.Context()).Do()
+	if err != nil {
+		log.Printf("Unable to watch %s: %v", UploadFolderName, err)
+		http.Error(w, "Unable to watch", http.StatusInternalServerError)
+		return
+	}
+
+	watchMu.Lock()
+	previous := watchChannel
+	watchChannel = channel
+	watchMu.Unlock()
+
+	if previous != nil {
+		err = driveService.Channels.Stop(&drive.Channel{Id: previous.Id, ResourceId: previous.ResourceId}).Context(r.Context()).Do()
+		if err != nil {
+			// It expires by itself, notifications from it are still accepted until then
+			log.Printf("Unable to stop channel %s: %v", previous.Id, err)
+		}
+	}
+
+	log.Printf("Watching %s on channel %s (resource %s) until %d", UploadFolderName, channel.Id, channel.ResourceId, channel.Expiration)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(channel); err != nil {
+		log.Printf("Unable to write channel: %v", err)
+	}
+}
+
+// HandleWatchNotification receives Drive push notifications for the Upload folder and
+// processes new uploads when files are added to it. Notifications must carry WatchTokenEnv.
+// Drive doesn't say which file was added, so the whole folder is processed, by one run at a time.
+func HandleWatchNotification(w http.ResponseWriter, r *http.Request) {
+	Initialize()
+
+	n := parseWatchNotification(r.Header)
+
+	if config.WatchToken == "" || subtle.ConstantTimeCompare([]byte(n.Token), []byte(config.WatchToken)) != 1 {
+		log.Printf("Ignoring notification from channel %s with an unexpected token", n.ChannelID)
+		http.Error(w, "Invalid channel token", http.StatusForbidden)
+		return
+	}
+
+	// Drive sends a sync message when the channel is created
+	if n.ResourceState == "sync" {
+		debugf("Channel %s is ready", n.ChannelID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if !n.childrenChanged() {
+		debugf("Ignoring %s notification on channel %s, changed %v", n.ResourceState, n.ChannelID, n.Changed)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	watchRunMu.Lock()
+	if watchRunning {
+		watchRerun = true
+		watchRunMu.Unlock()
+		debugf("Upload folder changed during a run, notified on channel %s, it will be processed again", n.ChannelID)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	watchRunning = true
+	watchRunMu.Unlock()
+
+	log.Printf("Upload folder changed, notified on channel %s", n.ChannelID)
+	Main(w, r)
+
+	for {
+		watchRunMu.Lock()
+		if !watchRerun {
+			watchRunning = false
+			watchRunMu.Unlock()
+			return
+		}
+		watchRerun = false
+		watchRunMu.Unlock()
+
+		// The caller already has the first run's summary, a rerun's problems are logged by Main
+		log.Printf("Upload folder changed during the run, processing it again")
+		Main(discardResponseWriter{header: http.Header{}}, r)
+	}
+}
+
+// discardResponseWriter is the response of a run nobody is waiting for
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d discardResponseWriter) Header() http.Header         { return d.header }
+func (d discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardResponseWriter) WriteHeader(int)             {}
@@ -0,0 +1,129 @@
+package trimark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/drive/v2"
+)
+
+// notificationHeaders are the headers of a Drive push notification
+func notificationHeaders(token, state, changed string) http.Header {
+	h := http.Header{}
+	h.Set("X-Goog-Channel-ID", "channel-1")
+	h.Set("X-Goog-Resource-ID", "resource-1")
+	h.Set("X-Goog-Resource-State", state)
+	if token != "" {
+		h.Set("X-Goog-Channel-Token", token)
+	}
+	if changed != "" {
+		h.Set("X-Goog-Changed", changed)
+	}
+	return h
+}
+
+func TestParseWatchNotification(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   http.Header
+		want     watchNotification
+		children bool
+	}{
+		{
+			name:   "sync",
+			header: notificationHeaders("secret", "sync", ""),
+			want:   watchNotification{ChannelID: "channel-1", Token: "secret", ResourceID: "resource-1", ResourceState: "sync"},
+		},
+		{
+			name:     "children changed",
+			header:   notificationHeaders("secret", "update", "content, children"),
+			want:     watchNotification{ChannelID: "channel-1", Token: "secret", ResourceID: "resource-1", ResourceState: "update", Changed: []string{"content", "children"}},
+			children: true,
+		},
+		{
+			name:   "bad token",
+			header: notificationHeaders("guess", "update", "properties"),
+			want:   watchNotification{ChannelID: "channel-1", Token: "guess", ResourceID: "resource-1", ResourceState: "update", Changed: []string{"properties"}},
+		},
+	}
+	for _, tt := range tests {
+		n := parseWatchNotification(tt.header)
+		if !reflect.DeepEqual(n, tt.want) {
+			t.Errorf("%s: parseWatchNotification = %+v, want %+v", tt.name, n, tt.want)
+		}
+		if n.childrenChanged() != tt.children {
+			t.Errorf("%s: childrenChanged = %t, want %t", tt.name, n.childrenChanged(), tt.children)
+		}
+	}
+}
+
+func TestHandleWatchNotification(t *testing.T) {
+	tests := []struct {
+		name       string
+		watchToken string
+		header     http.Header
+		want       int
+		processed  bool
+	}{
+		{"sync", "secret", notificationHeaders("secret", "sync", ""), http.StatusOK, false},
+		{"children changed", "secret", notificationHeaders("secret", "update", "children"), http.StatusOK, true},
+		{"other change", "secret", notificationHeaders("secret", "update", "properties"), http.StatusOK, false},
+		{"bad token", "secret", notificationHeaders("guess", "update", "children"), http.StatusForbidden, false},
+		{"no token", "secret", notificationHeaders("", "update", "children"), http.StatusForbidden, false},
+		{"token unset", "", notificationHeaders("", "update", "children"), http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, fakeDrive, _ := NewTestServiceContext(t,
+				WithConfig(func(c *Config) { c.WatchToken = tt.watchToken }),
+				WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "donation.txt", MimeType: "text/plain"}}))
+			fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+
+			r := httptest.NewRequest(http.MethodPost, "/watch/notify", nil)
+			r.Header = tt.header
+			w := httptest.NewRecorder()
+			HandleWatchNotification(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("HandleWatchNotification responded %d, want %d", w.Code, tt.want)
+			}
+			if processed := inFolder(fakeDrive.File("upload-1"), testProcessedFolderID); processed != tt.processed {
+				t.Errorf("Upload processed = %t, want %t", processed, tt.processed)
+			}
+			if !tt.processed && len(fakeDrive.Requests()) > 0 {
+				t.Errorf("The notification sent %q, want no run", fakeDrive.Requests())
+			}
+		})
+	}
+}
+
+func TestWatchNotificationDuringRunIsCoalesced(t *testing.T) {
+	_, fakeDrive, _ := NewTestServiceContext(t,
+		WithConfig(func(c *Config) { c.WatchToken = "secret" }),
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "donation.txt", MimeType: "text/plain"}}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+
+	watchRunMu.Lock()
+	watchRunning = true
+	watchRunMu.Unlock()
+
+	r := httptest.NewRequest(http.MethodPost, "/watch/notify", nil)
+	r.Header = notificationHeaders("secret", "update", "children")
+	w := httptest.NewRecorder()
+	HandleWatchNotification(w, r)
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("A notification during a run responded %d, want %d", w.Code, http.StatusAccepted)
+	}
+	if len(fakeDrive.Requests()) > 0 {
+		t.Errorf("A notification during a run sent %q, want it left to the running one", fakeDrive.Requests())
+	}
+	watchRunMu.Lock()
+	rerun := watchRerun
+	watchRunMu.Unlock()
+	if !rerun {
+		t.Error("The running notification wasn't asked to process the folder again")
+	}
+}
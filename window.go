@@ -0,0 +1,53 @@
+package trimark
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProcessWindow is the time of day Main is allowed to run in, such as 02:00 to 06:00
+type ProcessWindow struct {
+	// Start and End are offsets from midnight, End before Start wraps past midnight
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+	set      bool
+}
+
+// Enabled reports whether processing is limited to a window
+func (pw ProcessWindow) Enabled() bool {
+	return pw.set
+}
+
+// Contains reports whether t falls in the window, any time does when it isn't enabled
+func (pw ProcessWindow) Contains(t time.Time) bool {
+	if !pw.set {
+		return true
+	}
+	t = t.In(pw.Location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if pw.Start <= pw.End {
+		return offset >= pw.Start && offset < pw.End
+	}
+	return offset >= pw.Start || offset < pw.End
+}
+
+// String formats the window as it's configured, such as 02:00-06:00 Europe/London
+func (pw ProcessWindow) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	return fmt.Sprintf("%s-%s %s", clock(pw.Start), clock(pw.End), pw.Location)
+}
+
+// parseClock reads a 24 hour time of day such as "02:00" as an offset from midnight
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("must be a time of day as HH:MM, got %q", v)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// clock is the time Main checks ProcessWindow against
+var clock = time.Now
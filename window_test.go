@@ -0,0 +1,84 @@
+package trimark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v2"
+)
+
+func TestProcessWindowContains(t *testing.T) {
+	at := func(hhmm string) time.Time {
+		c, err := parseClock(hhmm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC).Add(c)
+	}
+	tests := []struct {
+		name       string
+		start, end string
+		tz         string
+		now        string
+		want       bool
+	}{
+		{"inside", "02:00", "06:00", "", "03:30", true},
+		{"at the start", "02:00", "06:00", "", "02:00", true},
+		{"at the end", "02:00", "06:00", "", "06:00", false},
+		{"before", "02:00", "06:00", "", "01:59", false},
+		{"wrapping, before midnight", "22:00", "04:00", "", "23:00", true},
+		{"wrapping, after midnight", "22:00", "04:00", "", "03:00", true},
+		{"wrapping, outside", "22:00", "04:00", "", "12:00", false},
+		// 02:30 UTC is 03:30 in London in June
+		{"time zone", "03:00", "04:00", "Europe/London", "02:30", true},
+		{"time zone, outside", "02:00", "03:00", "Europe/London", "02:30", false},
+	}
+	for _, tt := range tests {
+		env := map[string]string{ProcessWindowStartEnv: tt.start, ProcessWindowEndEnv: tt.end, ProcessWindowTimezoneEnv: tt.tz}
+		c, err := LoadConfig(func(name string) string { return env[name] })
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := c.ProcessWindow.Contains(at(tt.now)); got != tt.want {
+			t.Errorf("%s: %s contains %s = %v, want %v", tt.name, c.ProcessWindow, tt.now, got, tt.want)
+		}
+	}
+
+	if !(ProcessWindow{}).Contains(at("12:00")) {
+		t.Error("No window doesn't allow every time")
+	}
+}
+
+func TestMainOutsideProcessWindow(t *testing.T) {
+	_, fakeDrive, fakeSheets := NewTestServiceContext(t,
+		WithPreloadedFiles([]*drive.File{{Id: "upload-1", Title: "one.txt", MimeType: "text/plain"}}),
+		WithConfig(func(c *Config) {
+			c.ProcessWindow = ProcessWindow{Start: 2 * time.Hour, End: 6 * time.Hour, Location: time.UTC, set: true}
+		}))
+	fakeDrive.SetContent("upload-1", []byte(donationText("2020-06-18 12:34:56", "Pilot One", "1,000")))
+	saved := clock
+	t.Cleanup(func() { clock = saved })
+
+	clock = func() time.Time { return time.Date(2020, 6, 18, 12, 0, 0, 0, time.UTC) }
+	w := httptest.NewRecorder()
+	Main(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Outside processing window") {
+		t.Errorf("Main responded %d %q, want the window skipped", w.Code, w.Body)
+	}
+	if requests := fakeDrive.Requests(); len(requests) > 0 {
+		t.Errorf("Outside the window Drive was called: %v", requests)
+	}
+
+	clock = func() time.Time { return time.Date(2020, 6, 18, 3, 0, 0, 0, time.UTC) }
+	w = httptest.NewRecorder()
+	Main(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Main responded %d: %s", w.Code, w.Body)
+	}
+	if rows := fakeSheets.Values(testSheetID, "Sheet1!A2:G"); len(rows) != 1 {
+		t.Errorf("Report rows = %v, want the upload recorded inside the window", rows)
+	}
+}